@@ -0,0 +1,70 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"librebucket/cmd/db"
+	"librebucket/cmd/git"
+)
+
+// createPrivateRepo creates a private repository with a commit on main and
+// returns the API token of its owner
+func createPrivateRepo(t *testing.T, owner, name string) string {
+	t.Helper()
+	token := owner + "-token"
+	if _, err := db.GetUserByUsername(owner); err != nil {
+		if _, err := db.CreateUser(owner, "secret", false, token); err != nil {
+			t.Fatalf("CreateUser failed: %v", err)
+		}
+	}
+	repoPath := filepath.Join("repos", owner, name+".git")
+	if err := git.CreateRepoWithOptions(repoPath, owner, false, git.InitOptions{AutoInit: true}); err != nil {
+		t.Fatalf("CreateRepo failed: %v", err)
+	}
+	return token
+}
+
+// serveAPI sends a GET request to the repository endpoints with an optional
+// API token and returns the status
+func serveAPI(t *testing.T, url, token string) int {
+	t.Helper()
+	mux := http.NewServeMux()
+	CommitHandler(mux)
+	TreeHandler(mux)
+	CompareHandler(mux)
+	BlameHandler(mux)
+	req := httptest.NewRequest(http.MethodGet, url, nil)
+	if token != "" {
+		req.Header.Set("X-Auth-Token", token)
+	}
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestPrivateRepoReads(t *testing.T) {
+	t.Chdir(t.TempDir())
+	token := createPrivateRepo(t, "amy", "secret")
+
+	for _, url := range []string{
+		"/api/v1/repos/amy/secret/commits",
+		"/api/v1/repos/amy/secret/commits/main",
+		"/api/v1/repos/amy/secret/commits/main/changes",
+		"/api/v1/repos/amy/secret/commits/main/diff",
+		"/api/v1/repos/amy/secret/blob/main/README.md",
+		"/api/v1/repos/amy/secret/tree/main",
+		"/api/v1/repos/amy/secret/compare/main...main",
+		"/api/v1/repos/amy/secret/blame/main/README.md",
+	} {
+		// Private repositories are hidden from others
+		if status := serveAPI(t, url, ""); status != http.StatusNotFound {
+			t.Errorf("GET %s anonymously returned %d, want 404", url, status)
+		}
+		if status := serveAPI(t, url, token); status == http.StatusNotFound || status >= 500 {
+			t.Errorf("GET %s as the owner returned %d", url, status)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"librebucket/cmd/git"
+)
+
+// BlameHandler handles the blame API endpoint
+func BlameHandler(mux *http.ServeMux) {
+	// Get the commit that last changed each line of a file at a ref
+	mux.HandleFunc("GET /api/v1/repos/{username}/{reponame}/blame/{ref}/{path...}", getBlame)
+}
+
+func getBlame(w http.ResponseWriter, r *http.Request) {
+	username := r.PathValue("username")
+	reponame := r.PathValue("reponame")
+	ref := r.PathValue("ref")
+	filePath := r.PathValue("path")
+
+	repoPath := getRepoPath(username, reponame)
+	if !authorizeRepo(w, r, repoPath, "pull") {
+		return
+	}
+	ranges, err := git.GetBlame(r.Context(), repoPath, ref, filePath)
+	if err != nil {
+		http.Error(w, "Failed to get blame: "+err.Error(), gitErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(ranges); err != nil {
+		http.Error(w, "Failed to encode response: "+err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -2,12 +2,23 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+
+	"librebucket/cmd/db"
 	"librebucket/cmd/git"
 )
 
@@ -22,36 +33,118 @@ func CommitHandler(mux *http.ServeMux) {
 	// Get file changes in a commit
 	mux.HandleFunc("GET /api/v1/repos/{username}/{reponame}/commits/{hash}/changes", getCommitChanges)
 
+	// Get the diff of a commit as a unified patch or structured hunks
+	mux.HandleFunc("GET /api/v1/repos/{username}/{reponame}/commits/{hash}/diff", getCommitDiff)
+
 	// Get file content at a specific commit
 	mux.HandleFunc("GET /api/v1/repos/{username}/{reponame}/blob/{hash}/{filepath...}", getFileAtCommit) // fix for the getFileAtCommit
 }
 
+// getCommitHistory returns a page of the commit history. It accepts the ref,
+// path, author, committer, since, until (RFC 3339) and first_parent filters and
+// paginates with per_page plus either page or the after cursor. The URL of the
+// next page is sent in a Link header.
 func getCommitHistory(w http.ResponseWriter, r *http.Request) {
 	username := r.PathValue("username")
 	reponame := r.PathValue("reponame")
 
+	opts, err := parseHistoryOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	repoPath := getRepoPath(username, reponame)
-	commits, err := git.GetCommitHistory(repoPath)
+	if !authorizeRepo(w, r, repoPath, "pull") {
+		return
+	}
+	page, err := git.GetCommitHistoryPage(repoPath, opts)
 	if err != nil {
-		http.Error(w, "Failed to get commit history: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Failed to get commit history: "+err.Error(), gitErrorStatus(err))
 		return
 	}
 
+	if page.NextCursor != "" {
+		next := *r.URL
+		query := next.Query()
+		if query.Has("page") {
+			query.Set("page", strconv.Itoa(opts.Skip/opts.Limit+2))
+		} else {
+			query.Set("after", page.NextCursor)
+		}
+		next.RawQuery = query.Encode()
+		w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", next.RequestURI()))
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(commits); err != nil {
+	if err := json.NewEncoder(w).Encode(page.Commits); err != nil {
 		http.Error(w, "Failed to encode response: "+err.Error(), http.StatusInternalServerError)
 	}
 }
 
+// parseHistoryOptions reads the history filters and pagination from the query string
+func parseHistoryOptions(r *http.Request) (git.HistoryOptions, error) {
+	query := r.URL.Query()
+	opts := git.HistoryOptions{
+		From:      query.Get("ref"),
+		Path:      query.Get("path"),
+		Author:    query.Get("author"),
+		Committer: query.Get("committer"),
+		After:     query.Get("after"),
+		Limit:     git.DefaultHistoryLimit,
+	}
+
+	if value := query.Get("per_page"); value != "" {
+		perPage, err := strconv.Atoi(value)
+		if err != nil || perPage < 1 || perPage > git.MaxHistoryLimit {
+			return opts, fmt.Errorf("invalid per_page %q: must be between 1 and %d", value, git.MaxHistoryLimit)
+		}
+		opts.Limit = perPage
+	}
+	if value := query.Get("page"); value != "" {
+		page, err := strconv.Atoi(value)
+		if err != nil || page < 1 {
+			return opts, fmt.Errorf("invalid page %q: must be a positive number", value)
+		}
+		if opts.After != "" {
+			return opts, errors.New("page and after cannot be combined")
+		}
+		opts.Skip = (page - 1) * opts.Limit
+	}
+	for name, dst := range map[string]*time.Time{"since": &opts.Since, "until": &opts.Until} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return opts, fmt.Errorf("invalid %s %q: must be an RFC 3339 time", name, value)
+		}
+		*dst = t
+	}
+	if value := query.Get("first_parent"); value != "" {
+		firstParent, err := strconv.ParseBool(value)
+		if err != nil {
+			return opts, fmt.Errorf("invalid first_parent %q: must be a boolean", value)
+		}
+		opts.FirstParent = firstParent
+	}
+
+	return opts, nil
+}
+
 func getCommit(w http.ResponseWriter, r *http.Request) {
 	username := r.PathValue("username")
 	reponame := r.PathValue("reponame")
 	hash := r.PathValue("hash")
 
 	repoPath := getRepoPath(username, reponame)
+	if !authorizeRepo(w, r, repoPath, "pull") {
+		return
+	}
 	commit, err := git.GetCommitByHash(repoPath, hash)
 	if err != nil {
-		http.Error(w, "Failed to get commit: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Failed to get commit: "+err.Error(), gitErrorStatus(err))
 		return
 	}
 
@@ -66,10 +159,19 @@ func getCommitChanges(w http.ResponseWriter, r *http.Request) {
 	reponame := r.PathValue("reponame")
 	hash := r.PathValue("hash")
 
+	parent, err := parseParent(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	repoPath := getRepoPath(username, reponame)
-	changes, err := git.GetCommitChanges(repoPath, hash)
+	if !authorizeRepo(w, r, repoPath, "pull") {
+		return
+	}
+	changes, err := git.GetCommitChangesAgainst(repoPath, hash, parent)
 	if err != nil {
-		http.Error(w, "Failed to get commit changes: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Failed to get commit changes: "+err.Error(), gitErrorStatus(err))
 		return
 	}
 
@@ -79,6 +181,57 @@ func getCommitChanges(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// getCommitDiff returns the diff of a commit as text/x-diff, or as JSON hunks
+// when requested with ?format=json or an application/json Accept header
+func getCommitDiff(w http.ResponseWriter, r *http.Request) {
+	username := r.PathValue("username")
+	reponame := r.PathValue("reponame")
+	hash := r.PathValue("hash")
+
+	parent, err := parseParent(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	repoPath := getRepoPath(username, reponame)
+	if !authorizeRepo(w, r, repoPath, "pull") {
+		return
+	}
+	diffs, err := git.GetCommitDiff(repoPath, hash, parent)
+	if err != nil {
+		http.Error(w, "Failed to get commit diff: "+err.Error(), gitErrorStatus(err))
+		return
+	}
+
+	if r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(diffs); err != nil {
+			http.Error(w, "Failed to encode response: "+err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/x-diff; charset=utf-8")
+	if err := git.WriteUnifiedDiff(w, diffs); err != nil {
+		log.Printf("Failed to write diff of %s: %v", hash, err)
+	}
+}
+
+// parseParent reads the 1-based ?parent= query parameter selecting which parent
+// of a (merge) commit to diff against. It defaults to the first parent.
+func parseParent(r *http.Request) (int, error) {
+	value := r.URL.Query().Get("parent")
+	if value == "" {
+		return 1, nil
+	}
+	parent, err := strconv.Atoi(value)
+	if err != nil || parent < 1 {
+		return 0, fmt.Errorf("invalid parent %q: must be a positive number", value)
+	}
+	return parent, nil
+}
+
 // detectContentType determines the content type of a file based on its extension and/or content
 func detectContentType(filePath string) string {
 	// Get file extension
@@ -147,9 +300,12 @@ func getFileAtCommit(w http.ResponseWriter, r *http.Request) {
 	filepathA := r.PathValue("filepath")
 
 	repoPath := getRepoPath(username, reponame)
+	if !authorizeRepo(w, r, repoPath, "pull") {
+		return
+	}
 	content, err := git.GetFileAtCommit(repoPath, filepathA, hash)
 	if err != nil {
-		http.Error(w, "Failed to get file at commit: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Failed to get file at commit: "+err.Error(), gitErrorStatus(err))
 		return
 	}
 
@@ -162,6 +318,39 @@ func getFileAtCommit(w http.ResponseWriter, r *http.Request) {
 // Helper function to construct repository path
 func getRepoPath(username, reponame string) string {
 	// This should come from your configuration
-	repoRoot := "./repos" // Default, should be configurable
-	return repoRoot + "/" + username + "/" + reponame
+	repoRoot := "repos" // Default, should be configurable
+	// Repositories are stored as bare "{reponame}.git" directories
+	return filepath.Join(repoRoot, username, strings.TrimSuffix(reponame, ".git")+".git")
+}
+
+// gitErrorStatus maps errors returned by the git package to HTTP status codes.
+// Invalid and ambiguous revisions are client errors, unknown ones are not found.
+func gitErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, git.ErrInvalidRevision), errors.Is(err, git.ErrAmbiguousRevision),
+		errors.Is(err, git.ErrInvalidCursor), errors.Is(err, git.ErrNotFork),
+		errors.Is(err, git.ErrInvalidRepoName), errors.Is(err, git.ErrInvalidSettings),
+		errors.Is(err, git.ErrTemplateNotFound), errors.Is(err, git.ErrNotTemplate),
+		errors.Is(err, git.ErrNotMirror), errors.Is(err, git.ErrInvalidMirrorURL),
+		errors.Is(err, plumbing.ErrInvalidReferenceName):
+		return http.StatusBadRequest
+	case errors.Is(err, git.ErrRevisionNotFound), errors.Is(err, git.ErrBranchNotFound),
+		errors.Is(err, git.ErrTagNotFound), errors.Is(err, gogit.ErrRepositoryNotExists),
+		errors.Is(err, plumbing.ErrReferenceNotFound), errors.Is(err, plumbing.ErrObjectNotFound),
+		errors.Is(err, object.ErrFileNotFound), errors.Is(err, object.ErrDirectoryNotFound),
+		errors.Is(err, git.ErrNoMergeBase), errors.Is(err, db.ErrRepositoryNotFound),
+		errors.Is(err, db.ErrPushMirrorNotFound), errors.Is(err, db.ErrWebhookNotFound),
+		errors.Is(err, db.ErrWebhookDeliveryNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, git.ErrBranchExists), errors.Is(err, git.ErrTagExists), errors.Is(err, git.ErrDefaultBranch),
+		errors.Is(err, git.ErrRepoExists), errors.Is(err, gogit.ErrRepositoryAlreadyExists),
+		errors.Is(err, git.ErrNotFastForward):
+		return http.StatusConflict
+	case errors.Is(err, git.ErrFileTooLarge):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
 }
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"librebucket/cmd/git"
+)
+
+// CompareHandler handles the compare API endpoint
+func CompareHandler(mux *http.ServeMux) {
+	// Compare two revisions given as {base}...{head} or {base}..{head}
+	mux.HandleFunc("GET /api/v1/repos/{username}/{reponame}/compare/{spec...}", compareRevisions)
+}
+
+func compareRevisions(w http.ResponseWriter, r *http.Request) {
+	username := r.PathValue("username")
+	reponame := r.PathValue("reponame")
+	spec := r.PathValue("spec")
+
+	base, head, threeDot, ok := parseCompareSpec(spec)
+	if !ok {
+		http.Error(w, "Invalid compare spec: expected {base}...{head} or {base}..{head}", http.StatusBadRequest)
+		return
+	}
+
+	repoPath := getRepoPath(username, reponame)
+	if !authorizeRepo(w, r, repoPath, "pull") {
+		return
+	}
+	cmp, err := git.CompareRevisions(r.Context(), repoPath, base, head, threeDot)
+	if err != nil {
+		http.Error(w, "Failed to compare revisions: "+err.Error(), gitErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(cmp); err != nil {
+		http.Error(w, "Failed to encode response: "+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// parseCompareSpec splits "base...head" (three-dot) or "base..head" (two-dot)
+func parseCompareSpec(spec string) (base, head string, threeDot, ok bool) {
+	if base, head, found := strings.Cut(spec, "..."); found {
+		return base, head, true, base != "" && head != ""
+	}
+	if base, head, found := strings.Cut(spec, ".."); found {
+		return base, head, false, base != "" && head != ""
+	}
+	return "", "", false, false
+}
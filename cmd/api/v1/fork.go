@@ -0,0 +1,140 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"librebucket/cmd/git"
+)
+
+// forkResponse is the JSON representation of a git.ForkInfo
+type forkResponse struct {
+	FullName  string    `json:"full_name"`
+	Owner     string    `json:"owner"`
+	Public    bool      `json:"public"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ForkHandler handles fork API endpoints
+func ForkHandler(mux *http.ServeMux) {
+	// Fork a repository into the caller's namespace and list its forks
+	mux.HandleFunc("POST /api/v1/repos/{username}/{reponame}/forks", createFork)
+	mux.HandleFunc("GET /api/v1/repos/{username}/{reponame}/forks", listForks)
+
+	// Fast-forward a branch of a fork to its upstream
+	mux.HandleFunc("POST /api/v1/repos/{username}/{reponame}/sync-upstream", syncFork)
+}
+
+func createFork(w http.ResponseWriter, r *http.Request) {
+	reponame := strings.TrimSuffix(r.PathValue("reponame"), ".git")
+	repoPath := getRepoPath(r.PathValue("username"), reponame)
+	if !authorizeRepo(w, r, repoPath, "pull") {
+		return
+	}
+	user, ok := RequestUser(r)
+	if !ok {
+		writeJSONError(w, http.StatusUnauthorized, "Authentication required to fork a repository")
+		return
+	}
+
+	// The fork keeps the name of its parent unless another one is given
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	if req.Name == "" {
+		req.Name = reponame
+	}
+	if !git.ValidRepoName(req.Name) {
+		writeJSONError(w, http.StatusBadRequest, "Invalid repository name")
+		return
+	}
+
+	forkPath := getRepoPath(user.Username, req.Name)
+	if err := git.ForkRepo(repoPath, forkPath, user.Username); err != nil {
+		writeJSONError(w, gitErrorStatus(err), err.Error())
+		return
+	}
+
+	meta, err := git.LoadRepoMeta(forkPath)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	fullName := git.RepoFullName(forkPath)
+	writeJSON(w, http.StatusCreated, map[string]any{
+		"full_name":   fullName,
+		"owner":       meta.Owner,
+		"public":      meta.Public,
+		"forked_from": meta.ForkedFrom,
+		"clone_url":   fmt.Sprintf("http://%s/%s.git", r.Host, fullName),
+	})
+}
+
+func listForks(w http.ResponseWriter, r *http.Request) {
+	repoPath := getRepoPath(r.PathValue("username"), r.PathValue("reponame"))
+	if !authorizeRepo(w, r, repoPath, "pull") {
+		return
+	}
+
+	forks, err := git.ListForks(repoPath)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to list forks: "+err.Error())
+		return
+	}
+
+	// Private forks are only listed for their owner
+	user, _ := RequestUser(r)
+	resp := make([]forkResponse, 0, len(forks))
+	for _, f := range forks {
+		if !f.Public && f.Owner != user.Username {
+			continue
+		}
+		resp = append(resp, forkResponse{
+			FullName:  f.FullName,
+			Owner:     f.Owner,
+			Public:    f.Public,
+			CreatedAt: f.CreatedAt,
+		})
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func syncFork(w http.ResponseWriter, r *http.Request) {
+	repoPath := getRepoPath(r.PathValue("username"), r.PathValue("reponame"))
+	if !authorizeRepo(w, r, repoPath, "push") {
+		return
+	}
+
+	// Syncs the default branch unless another one is given
+	var req struct {
+		Branch string `json:"branch"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	if req.Branch == "" {
+		meta, err := git.LoadRepoMeta(repoPath)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		req.Branch = meta.DefaultBranch
+	}
+
+	hash, err := git.SyncFork(repoPath, req.Branch)
+	if err != nil {
+		writeJSONError(w, gitErrorStatus(err), err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"branch": req.Branch, "commit": hash})
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+
+	"github.com/go-git/go-git/v5/plumbing/object"
+
+	"librebucket/cmd/git"
+)
+
+// GenerateHandler handles generating repositories from template repositories
+func GenerateHandler(mux *http.ServeMux) {
+	// Create a repository of the caller with the files of a template
+	mux.HandleFunc("POST /api/v1/repos/{username}/{reponame}/generate", generateRepo)
+}
+
+func generateRepo(w http.ResponseWriter, r *http.Request) {
+	templatePath := getRepoPath(r.PathValue("username"), r.PathValue("reponame"))
+	if !authorizeRepo(w, r, templatePath, "pull") {
+		return
+	}
+	user, ok := RequestUser(r)
+	if !ok {
+		writeJSONError(w, http.StatusUnauthorized, "Authentication required to generate a repository")
+		return
+	}
+
+	// New repositories are private unless requested otherwise
+	var req struct {
+		Name               string `json:"name"`
+		Description        string `json:"description"`
+		Public             bool   `json:"public"`
+		IncludeAllBranches bool   `json:"include_all_branches"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	if !git.ValidRepoName(req.Name) {
+		writeJSONError(w, http.StatusBadRequest, "Invalid repository name")
+		return
+	}
+
+	// The initial commits are authored as the caller
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+	newPath := getRepoPath(user.Username, req.Name)
+	err = git.GenerateRepo(templatePath, newPath, user.Username, git.GenerateOptions{
+		Public:             req.Public,
+		Description:        req.Description,
+		IncludeAllBranches: req.IncludeAllBranches,
+		Author:             object.Signature{Name: user.Username, Email: user.Username + "@users.noreply." + host},
+	})
+	if err != nil {
+		writeJSONError(w, gitErrorStatus(err), err.Error())
+		return
+	}
+	writeRepo(w, http.StatusCreated, newPath)
+}
@@ -0,0 +1,137 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"librebucket/cmd/db"
+	"librebucket/cmd/git"
+	"librebucket/cmd/worker"
+)
+
+// migrationResponse is the status of a repository migration
+type migrationResponse struct {
+	ID        int64     `json:"id"`
+	FullName  string    `json:"full_name"`
+	CloneURL  string    `json:"clone_url"`
+	State     string    `json:"state"` // queued, running, done or failed
+	Progress  string    `json:"progress,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func toMigrationResponse(m db.RepoMigration) migrationResponse {
+	return migrationResponse{
+		ID:        m.ID,
+		FullName:  m.Owner + "/" + m.Name,
+		CloneURL:  m.RemoteURL,
+		State:     m.State,
+		Progress:  m.Progress,
+		Error:     m.Error,
+		CreatedAt: m.CreatedAt,
+		UpdatedAt: m.UpdatedAt,
+	}
+}
+
+// MigrateHandler handles importing repositories from other git servers. Imports
+// run as background jobs whose status is polled.
+func MigrateHandler(mux *http.ServeMux) {
+	// Import {clone_url, username, password, repo_name, description, public} as a repository of the caller
+	mux.HandleFunc("POST /api/v1/repos/migrate", migrateRepo)
+	// Status of an import, only visible to the user who started it
+	mux.HandleFunc("GET /api/v1/repos/migrate/{id}", getMigration)
+}
+
+func migrateRepo(w http.ResponseWriter, r *http.Request) {
+	user, ok := RequestUser(r)
+	if !ok {
+		writeJSONError(w, http.StatusUnauthorized, "Authentication required to migrate a repository")
+		return
+	}
+	// Imported repositories are private unless requested otherwise
+	var req struct {
+		CloneURL    string `json:"clone_url"`
+		Username    string `json:"username"`
+		Password    string `json:"password"`
+		RepoName    string `json:"repo_name"`
+		Description string `json:"description"`
+		Public      bool   `json:"public"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.CloneURL == "" {
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON or missing clone_url")
+		return
+	}
+	if !git.ValidRepoName(req.RepoName) {
+		writeJSONError(w, http.StatusBadRequest, "Invalid repository name")
+		return
+	}
+	if err := git.ValidateMirrorURL(req.CloneURL, req.Username, req.Password); err != nil {
+		writeJSONError(w, gitErrorStatus(err), err.Error())
+		return
+	}
+	// Checked again by the job, this only fails early
+	if _, err := db.GetRepository(user.Username, req.RepoName); err == nil {
+		writeJSONError(w, http.StatusConflict, fmt.Sprintf("%s: %s/%s", git.ErrRepoExists, user.Username, req.RepoName))
+		return
+	}
+
+	m := db.RepoMigration{
+		Owner:     user.Username,
+		Name:      req.RepoName,
+		RemoteURL: git.RedactMirrorURL(req.CloneURL),
+	}
+	if err := db.CreateRepoMigration(&m); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to save migration: "+err.Error())
+		return
+	}
+	job := &worker.MigrateJob{
+		MigrationID: m.ID,
+		CloneURL:    req.CloneURL,
+		RepoPath:    getRepoPath(user.Username, req.RepoName),
+		Owner:       user.Username,
+		Opts: git.MigrateOptions{
+			Username:    req.Username,
+			Password:    req.Password,
+			Public:      req.Public,
+			Description: req.Description,
+		},
+	}
+	if SubmitJob == nil || !SubmitJob(job) {
+		m.State = db.MigrationFailed
+		m.Error = "job queue full"
+		db.UpdateRepoMigration(&m)
+		writeJSONError(w, http.StatusServiceUnavailable, "Job queue full, try again later")
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/repos/migrate/%d", m.ID))
+	writeJSON(w, http.StatusAccepted, toMigrationResponse(m))
+}
+
+func getMigration(w http.ResponseWriter, r *http.Request) {
+	user, ok := RequestUser(r)
+	if !ok {
+		writeJSONError(w, http.StatusUnauthorized, "Authentication required")
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid migration ID")
+		return
+	}
+	m, err := db.GetRepoMigration(id)
+	// Migrations of other users are reported as missing
+	if errors.Is(err, db.ErrRepoMigrationNotFound) || err == nil && m.Owner != user.Username {
+		writeJSONError(w, http.StatusNotFound, db.ErrRepoMigrationNotFound.Error())
+		return
+	} else if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, toMigrationResponse(m))
+}
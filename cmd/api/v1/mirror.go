@@ -0,0 +1,86 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"librebucket/cmd/git"
+	"librebucket/cmd/worker"
+)
+
+// SubmitJob queues background work on the server's worker pool. It is set by the
+// server; without it, follow-up work such as refreshing statistics is skipped.
+var SubmitJob func(job worker.Job) bool
+
+// mirrorResponse is the sync status of a pull mirror
+type mirrorResponse struct {
+	MirrorURL string     `json:"mirror_url"` // Without the password
+	Interval  string     `json:"interval"`
+	SyncedAt  *time.Time `json:"synced_at"` // Null until the first sync
+	Error     string     `json:"error,omitempty"`
+}
+
+// MirrorHandler handles the status and manual syncs of pull mirrors
+func MirrorHandler(mux *http.ServeMux) {
+	// Get the remote and the result of the last sync
+	mux.HandleFunc("GET /api/v1/repos/{username}/{reponame}/mirror", getMirror)
+	// Queue a sync of a mirror now instead of waiting for its interval
+	mux.HandleFunc("POST /api/v1/repos/{username}/{reponame}/mirror-sync", syncMirror)
+}
+
+func getMirror(w http.ResponseWriter, r *http.Request) {
+	repoPath := getRepoPath(r.PathValue("username"), r.PathValue("reponame"))
+	if !authorizeRepo(w, r, repoPath, "pull") {
+		return
+	}
+	writeMirror(w, http.StatusOK, repoPath)
+}
+
+func syncMirror(w http.ResponseWriter, r *http.Request) {
+	repoPath := getRepoPath(r.PathValue("username"), r.PathValue("reponame"))
+	if !authorizeRepo(w, r, repoPath, "push") {
+		return
+	}
+
+	meta, err := git.LoadRepoMeta(repoPath)
+	if err != nil {
+		writeJSONError(w, gitErrorStatus(err), err.Error())
+		return
+	}
+	if meta.MirrorURL == "" {
+		writeJSONError(w, http.StatusBadRequest, git.ErrNotMirror.Error())
+		return
+	}
+
+	// The sync runs in the background, its result is reported by GET .../mirror
+	if SubmitJob == nil || !SubmitJob(&worker.MirrorSyncJob{RepoPath: repoPath}) {
+		writeJSONError(w, http.StatusServiceUnavailable, "Job queue full, try again later")
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/repos/%s/mirror", git.RepoFullName(repoPath)))
+	writeMirror(w, http.StatusAccepted, repoPath)
+}
+
+// writeMirror responds with the sync status of a mirror
+func writeMirror(w http.ResponseWriter, status int, repoPath string) {
+	meta, err := git.LoadRepoMeta(repoPath)
+	if err != nil {
+		writeJSONError(w, gitErrorStatus(err), err.Error())
+		return
+	}
+	if meta.MirrorURL == "" {
+		writeJSONError(w, http.StatusNotFound, "Repository is not a mirror")
+		return
+	}
+
+	resp := mirrorResponse{
+		MirrorURL: git.RedactMirrorURL(meta.MirrorURL),
+		Interval:  meta.MirrorInterval.String(),
+		Error:     meta.MirrorError,
+	}
+	if !meta.MirrorSyncedAt.IsZero() {
+		resp.SyncedAt = &meta.MirrorSyncedAt
+	}
+	writeJSON(w, status, resp)
+}
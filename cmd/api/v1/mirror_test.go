@@ -0,0 +1,60 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"librebucket/cmd/git"
+	"librebucket/cmd/worker"
+)
+
+func TestSyncMirrorQueuesJob(t *testing.T) {
+	t.Chdir(t.TempDir())
+	token := createPrivateRepo(t, "dov", "plain")
+	mirrorPath := filepath.Join("repos", "dov", "mirror.git")
+	if err := git.CreateMirror(mirrorPath, "dov", false, "https://203.0.113.1/upstream.git", 0); err != nil {
+		t.Fatalf("CreateMirror failed: %v", err)
+	}
+
+	var submitted []worker.Job
+	accept := true
+	prev := SubmitJob
+	SubmitJob = func(job worker.Job) bool {
+		if accept {
+			submitted = append(submitted, job)
+		}
+		return accept
+	}
+	t.Cleanup(func() { SubmitJob = prev })
+
+	mux := http.NewServeMux()
+	MirrorHandler(mux)
+	post := func(url string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, url, nil)
+		req.Header.Set("X-Auth-Token", token)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := post("/api/v1/repos/dov/mirror/mirror-sync")
+	if rec.Code != http.StatusAccepted || rec.Header().Get("Location") != "/api/v1/repos/dov/mirror/mirror" {
+		t.Errorf("Expected 202 with the status location, got %d %q: %s", rec.Code, rec.Header().Get("Location"), rec.Body)
+	}
+	if len(submitted) != 1 {
+		t.Fatalf("Expected one job, got %d", len(submitted))
+	}
+	if job, ok := submitted[0].(*worker.MirrorSyncJob); !ok || job.RepoPath != mirrorPath {
+		t.Errorf("Expected a sync job for %s, got %#v", mirrorPath, submitted[0])
+	}
+
+	if rec := post("/api/v1/repos/dov/plain/mirror-sync"); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a repository that is not a mirror, got %d", rec.Code)
+	}
+	accept = false
+	if rec := post("/api/v1/repos/dov/mirror/mirror-sync"); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 when the queue is full, got %d", rec.Code)
+	}
+}
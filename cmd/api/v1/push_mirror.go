@@ -0,0 +1,165 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"librebucket/cmd/db"
+	"librebucket/cmd/git"
+	"librebucket/cmd/worker"
+)
+
+// pushMirrorResponse is a push mirror with the status of its last push. The
+// password is never returned.
+type pushMirrorResponse struct {
+	ID            int64      `json:"id"`
+	RemoteURL     string     `json:"remote_url"`
+	Username      string     `json:"username,omitempty"`
+	Interval      string     `json:"interval"`
+	CreatedAt     time.Time  `json:"created_at"`
+	LastSyncAt    *time.Time `json:"last_sync_at"` // Null until the first successful push
+	LastError     string     `json:"last_error,omitempty"`
+	Failures      int        `json:"failures"`
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"` // Retry after a failure
+}
+
+func toPushMirrorResponse(m db.PushMirror) pushMirrorResponse {
+	resp := pushMirrorResponse{
+		ID:        m.ID,
+		RemoteURL: m.RemoteURL,
+		Username:  m.Username,
+		Interval:  m.Interval.String(),
+		CreatedAt: m.CreatedAt,
+		LastError: m.LastError,
+		Failures:  m.Failures,
+	}
+	if !m.LastSyncAt.IsZero() {
+		resp.LastSyncAt = &m.LastSyncAt
+	}
+	if !m.NextAttemptAt.IsZero() {
+		resp.NextAttemptAt = &m.NextAttemptAt
+	}
+	return resp
+}
+
+// PushMirrorHandler handles the remotes a repository is replicated to. Only the
+// owner can see and change them, since they hold credentials.
+func PushMirrorHandler(mux *http.ServeMux) {
+	// List push mirrors
+	mux.HandleFunc("GET /api/v1/repos/{username}/{reponame}/push-mirrors", listPushMirrors)
+	// Add a push mirror {remote_url, username, password, interval} and push to it
+	mux.HandleFunc("POST /api/v1/repos/{username}/{reponame}/push-mirrors", addPushMirror)
+	// Remove a push mirror
+	mux.HandleFunc("DELETE /api/v1/repos/{username}/{reponame}/push-mirrors/{id}", removePushMirror)
+	// Push to a mirror now, e.g. after fixing its credentials on the remote
+	mux.HandleFunc("POST /api/v1/repos/{username}/{reponame}/push-mirrors/{id}/sync", syncPushMirror)
+}
+
+func listPushMirrors(w http.ResponseWriter, r *http.Request) {
+	repoPath := getRepoPath(r.PathValue("username"), r.PathValue("reponame"))
+	if !authorizeRepo(w, r, repoPath, "push") {
+		return
+	}
+	mirrors, err := git.ListPushMirrors(repoPath)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	resp := make([]pushMirrorResponse, len(mirrors))
+	for i, m := range mirrors {
+		resp[i] = toPushMirrorResponse(m)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func addPushMirror(w http.ResponseWriter, r *http.Request) {
+	repoPath := getRepoPath(r.PathValue("username"), r.PathValue("reponame"))
+	if !authorizeRepo(w, r, repoPath, "push") {
+		return
+	}
+	var req struct {
+		RemoteURL string `json:"remote_url"`
+		Username  string `json:"username"`
+		Password  string `json:"password"`
+		Interval  string `json:"interval"` // e.g. "8h", the default
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RemoteURL == "" {
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON or missing remote_url")
+		return
+	}
+	var interval time.Duration
+	if req.Interval != "" {
+		var err error
+		if interval, err = time.ParseDuration(req.Interval); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid mirror interval")
+			return
+		}
+	}
+
+	m, err := git.AddPushMirror(repoPath, req.RemoteURL, req.Username, req.Password, interval)
+	if err != nil {
+		writeJSONError(w, gitErrorStatus(err), err.Error())
+		return
+	}
+	if SubmitJob != nil {
+		SubmitJob(&worker.PushMirrorJob{RepoPath: repoPath, MirrorID: m.ID})
+	}
+	writeJSON(w, http.StatusCreated, toPushMirrorResponse(m))
+}
+
+func removePushMirror(w http.ResponseWriter, r *http.Request) {
+	repoPath := getRepoPath(r.PathValue("username"), r.PathValue("reponame"))
+	if !authorizeRepo(w, r, repoPath, "push") {
+		return
+	}
+	id, ok := pushMirrorID(w, r)
+	if !ok {
+		return
+	}
+	if err := git.RemovePushMirror(repoPath, id); err != nil {
+		writeJSONError(w, gitErrorStatus(err), err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func syncPushMirror(w http.ResponseWriter, r *http.Request) {
+	repoPath := getRepoPath(r.PathValue("username"), r.PathValue("reponame"))
+	if !authorizeRepo(w, r, repoPath, "push") {
+		return
+	}
+	id, ok := pushMirrorID(w, r)
+	if !ok {
+		return
+	}
+
+	// The error of a failed push is recorded and returned with the status
+	err := git.PushToMirror(r.Context(), repoPath, id)
+	if errors.Is(err, db.ErrPushMirrorNotFound) {
+		writeJSONError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	status := http.StatusOK
+	if err != nil {
+		status = http.StatusBadGateway
+	}
+	m, err := db.GetPushMirror(git.RepoFullName(repoPath), id)
+	if err != nil {
+		writeJSONError(w, gitErrorStatus(err), err.Error())
+		return
+	}
+	writeJSON(w, status, toPushMirrorResponse(m))
+}
+
+// pushMirrorID parses the {id} path parameter
+func pushMirrorID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid push mirror ID")
+		return 0, false
+	}
+	return id, true
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"librebucket/cmd/git"
+)
+
+// pushPolicyJSON is a push policy with all fields, including empty ones
+type pushPolicyJSON struct {
+	ProtectedBranches     []string `json:"protected_branches"`
+	MaxFileSize           int64    `json:"max_file_size"`
+	ForbiddenPaths        []string `json:"forbidden_paths"`
+	RequireSignedCommits  bool     `json:"require_signed_commits"`
+	CommitMessagePatterns []string `json:"commit_message_patterns"`
+}
+
+func toPushPolicyJSON(p git.PushPolicy) pushPolicyJSON {
+	resp := pushPolicyJSON(p)
+	for _, list := range []*[]string{&resp.ProtectedBranches, &resp.ForbiddenPaths, &resp.CommitMessagePatterns} {
+		if *list == nil {
+			*list = []string{}
+		}
+	}
+	return resp
+}
+
+// PushPolicyHandler handles the rules pushes to a repository must follow.
+// Anyone who can read a repository can see them, only the owner can change them.
+func PushPolicyHandler(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/repos/{username}/{reponame}/push-policy", getPushPolicy)
+	// Replace the policy, e.g. {"protected_branches": ["main"], "max_file_size": 10485760}.
+	// An empty object allows every push again.
+	mux.HandleFunc("PUT /api/v1/repos/{username}/{reponame}/push-policy", setPushPolicy)
+}
+
+func getPushPolicy(w http.ResponseWriter, r *http.Request) {
+	repoPath := getRepoPath(r.PathValue("username"), r.PathValue("reponame"))
+	if !authorizeRepo(w, r, repoPath, "pull") {
+		return
+	}
+	meta, err := git.LoadRepoMeta(repoPath)
+	if err != nil {
+		writeJSONError(w, gitErrorStatus(err), err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, toPushPolicyJSON(meta.PushPolicy))
+}
+
+func setPushPolicy(w http.ResponseWriter, r *http.Request) {
+	repoPath := getRepoPath(r.PathValue("username"), r.PathValue("reponame"))
+	if !authorizeRepo(w, r, repoPath, "push") {
+		return
+	}
+	var req pushPolicyJSON
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	policy, err := git.SetPushPolicy(repoPath, git.PushPolicy(req))
+	if err != nil {
+		writeJSONError(w, gitErrorStatus(err), err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, toPushPolicyJSON(policy))
+}
@@ -0,0 +1,171 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/object"
+
+	"librebucket/cmd/git"
+)
+
+// RefsHandler handles branch and tag API endpoints
+func RefsHandler(mux *http.ServeMux) {
+	// List, create and delete branches
+	mux.HandleFunc("GET /api/v1/repos/{username}/{reponame}/branches", listBranches)
+	mux.HandleFunc("POST /api/v1/repos/{username}/{reponame}/branches", createBranch)
+	mux.HandleFunc("DELETE /api/v1/repos/{username}/{reponame}/branches/{branch...}", deleteBranch)
+
+	// List, create and delete tags
+	mux.HandleFunc("GET /api/v1/repos/{username}/{reponame}/tags", listTags)
+	mux.HandleFunc("POST /api/v1/repos/{username}/{reponame}/tags", createTag)
+	mux.HandleFunc("DELETE /api/v1/repos/{username}/{reponame}/tags/{tag...}", deleteTag)
+
+	// Get and set the default branch HEAD follows
+	mux.HandleFunc("GET /api/v1/repos/{username}/{reponame}/default-branch", getDefaultBranch)
+	mux.HandleFunc("PUT /api/v1/repos/{username}/{reponame}/default-branch", setDefaultBranch)
+}
+
+func listBranches(w http.ResponseWriter, r *http.Request) {
+	repoPath := getRepoPath(r.PathValue("username"), r.PathValue("reponame"))
+	if !authorizeRepo(w, r, repoPath, "pull") {
+		return
+	}
+
+	branches, err := git.ListBranches(repoPath)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to list branches: "+err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, branches)
+}
+
+func createBranch(w http.ResponseWriter, r *http.Request) {
+	repoPath := getRepoPath(r.PathValue("username"), r.PathValue("reponame"))
+	if !authorizeRepo(w, r, repoPath, "push") {
+		return
+	}
+
+	var req struct {
+		Name string `json:"name"`
+		From string `json:"from"` // Commit, branch or tag to branch off
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" || req.From == "" {
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON or missing fields")
+		return
+	}
+
+	branch, err := git.CreateBranch(repoPath, req.Name, req.From)
+	if err != nil {
+		writeJSONError(w, gitErrorStatus(err), err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, branch)
+}
+
+func deleteBranch(w http.ResponseWriter, r *http.Request) {
+	repoPath := getRepoPath(r.PathValue("username"), r.PathValue("reponame"))
+	if !authorizeRepo(w, r, repoPath, "push") {
+		return
+	}
+
+	if err := git.DeleteBranch(repoPath, r.PathValue("branch")); err != nil {
+		writeJSONError(w, gitErrorStatus(err), err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func listTags(w http.ResponseWriter, r *http.Request) {
+	repoPath := getRepoPath(r.PathValue("username"), r.PathValue("reponame"))
+	if !authorizeRepo(w, r, repoPath, "pull") {
+		return
+	}
+
+	tags, err := git.ListTags(repoPath)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to list tags: "+err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, tags)
+}
+
+func createTag(w http.ResponseWriter, r *http.Request) {
+	repoPath := getRepoPath(r.PathValue("username"), r.PathValue("reponame"))
+	if !authorizeRepo(w, r, repoPath, "push") {
+		return
+	}
+
+	var req struct {
+		Name    string `json:"name"`
+		From    string `json:"from"`    // Commit, branch or tag to tag
+		Message string `json:"message"` // Creates an annotated tag when set
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" || req.From == "" {
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON or missing fields")
+		return
+	}
+
+	user, _ := RequestUser(r)
+	tagger := &object.Signature{
+		Name:  user.Username,
+		Email: user.Username + "@librebucket",
+		When:  time.Now(),
+	}
+
+	tag, err := git.CreateTag(repoPath, req.Name, req.From, req.Message, tagger)
+	if err != nil {
+		writeJSONError(w, gitErrorStatus(err), err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, tag)
+}
+
+func deleteTag(w http.ResponseWriter, r *http.Request) {
+	repoPath := getRepoPath(r.PathValue("username"), r.PathValue("reponame"))
+	if !authorizeRepo(w, r, repoPath, "push") {
+		return
+	}
+
+	if err := git.DeleteTag(repoPath, r.PathValue("tag")); err != nil {
+		writeJSONError(w, gitErrorStatus(err), err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func getDefaultBranch(w http.ResponseWriter, r *http.Request) {
+	repoPath := getRepoPath(r.PathValue("username"), r.PathValue("reponame"))
+	if !authorizeRepo(w, r, repoPath, "pull") {
+		return
+	}
+
+	meta, err := git.LoadRepoMeta(repoPath)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"default_branch": meta.DefaultBranch})
+}
+
+func setDefaultBranch(w http.ResponseWriter, r *http.Request) {
+	repoPath := getRepoPath(r.PathValue("username"), r.PathValue("reponame"))
+	if !authorizeRepo(w, r, repoPath, "push") {
+		return
+	}
+
+	var req struct {
+		DefaultBranch string `json:"default_branch"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.DefaultBranch == "" {
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON or missing fields")
+		return
+	}
+
+	if err := git.SetDefaultBranch(repoPath, req.DefaultBranch); err != nil {
+		writeJSONError(w, gitErrorStatus(err), err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"default_branch": req.DefaultBranch})
+}
@@ -3,12 +3,18 @@ package api
 import (
 	"encoding/json"
 	"fmt"
+	"log"
+	"net"
 	"net/http"
 	"path/filepath"
 	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/object"
 
 	"librebucket/cmd/db"
 	"librebucket/cmd/git"
+	"librebucket/cmd/worker"
 )
 
 // APICreateRepoHandler handles POST /api/v1/git/create
@@ -21,12 +27,24 @@ func APICreateRepoHandler(w http.ResponseWriter, r *http.Request) {
 		Username string `json:"username"`
 		RepoName string `json:"reponame"`
 		Public   bool   `json:"public"`
+		// Optional initial commit, see git.InitOptions
+		AutoInit          bool   `json:"auto_init"`
+		GitignoreTemplate string `json:"gitignore_template"`
+		LicenseTemplate   string `json:"license_template"`
+		DefaultBranch     string `json:"default_branch"`
+		// Optional pull mirror of a remote, synced every interval like "8h"
+		MirrorURL      string `json:"mirror_url"`
+		MirrorInterval string `json:"mirror_interval"`
 	}
 	dec := json.NewDecoder(r.Body)
 	if err := dec.Decode(&req); err != nil || req.Username == "" || req.RepoName == "" {
 		writeJSONError(w, http.StatusBadRequest, "Invalid JSON or missing fields")
 		return
 	}
+	if !git.ValidRepoName(req.RepoName) {
+		writeJSONError(w, http.StatusBadRequest, "Invalid repository name")
+		return
+	}
 
 	// Authenticate the user trying to create the repo (get user by the token)
 	token := r.Header.Get("Authorization")
@@ -53,9 +71,26 @@ func APICreateRepoHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The initial commit is authored as the creating user
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+	opts := git.InitOptions{
+		AutoInit:          req.AutoInit,
+		GitignoreTemplate: req.GitignoreTemplate,
+		LicenseTemplate:   req.LicenseTemplate,
+		DefaultBranch:     req.DefaultBranch,
+		Author:            object.Signature{Name: user.Username, Email: user.Username + "@users.noreply." + host},
+	}
+
 	repoPath := filepath.Join("repos", req.Username, req.RepoName+".git")
-	if err := git.CreateRepo(repoPath, req.Username, req.Public); err != nil {
-		writeJSONError(w, http.StatusInternalServerError, err.Error())
+	if req.MirrorURL != "" {
+		if !createMirror(w, req.MirrorURL, req.MirrorInterval, repoPath, req.Username, req.Public, opts) {
+			return
+		}
+	} else if err := git.CreateRepoWithOptions(repoPath, req.Username, req.Public, opts); err != nil {
+		writeJSONError(w, gitErrorStatus(err), err.Error())
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -66,6 +101,42 @@ func APICreateRepoHandler(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// createMirror creates a pull mirror and queues its first sync. Mirrors get all
+// their refs from the remote, so they cannot have an initial commit.
+func createMirror(w http.ResponseWriter, mirrorURL, interval, repoPath, owner string, public bool, opts git.InitOptions) bool {
+	if opts.AutoInit || opts.GitignoreTemplate != "" || opts.LicenseTemplate != "" || opts.DefaultBranch != "" {
+		writeJSONError(w, http.StatusBadRequest, "A mirror cannot have an initial commit or default branch")
+		return false
+	}
+	var every time.Duration
+	if interval != "" {
+		var err error
+		if every, err = time.ParseDuration(interval); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid mirror interval")
+			return false
+		}
+	}
+	if err := git.CreateMirror(repoPath, owner, public, mirrorURL, every); err != nil {
+		writeJSONError(w, gitErrorStatus(err), err.Error())
+		return false
+	}
+	if SubmitJob != nil && !SubmitJob(&worker.MirrorSyncJob{RepoPath: repoPath}) {
+		log.Printf("Job queue full, %s is synced on schedule", repoPath)
+	}
+	return true
+}
+
+// TemplatesHandler handles GET /api/v1/templates/{kind}, listing the gitignore
+// or license templates that can be used when creating a repository
+func TemplatesHandler(w http.ResponseWriter, r *http.Request) {
+	names, err := git.ListTemplates(r.PathValue("kind"))
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, names)
+}
+
 // --- Helpers (copied from user.go if not used elsewhere) ---
 // Only include if not already present in user.go or other shared location
 // func writeJSONError ...
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"librebucket/cmd/db"
+)
+
+func TestCreateRepoInvalidName(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if _, err := db.GetUserByUsername("cy"); err != nil {
+		if _, err := db.CreateUser("cy", "secret", false, "cy-token"); err != nil {
+			t.Fatalf("CreateUser failed: %v", err)
+		}
+	}
+
+	for _, body := range []string{
+		`{"username": "cy", "reponame": "../amy/app"}`,
+		`{"username": "cy", "reponame": "app.git"}`,
+		`{"username": "cy", "reponame": ".."}`,
+		`{"username": "cy", "reponame": "a/b", "mirror_url": "https://example.com/a/b.git"}`,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/git/create", strings.NewReader(body))
+		req.Header.Set("X-Auth-Token", "cy-token")
+		rec := httptest.NewRecorder()
+		APICreateRepoHandler(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d: %s", body, rec.Code, rec.Body)
+		}
+	}
+	if _, err := os.Stat("repos"); !os.IsNotExist(err) {
+		t.Errorf("Expected no repository to be created, got %v", err)
+	}
+}
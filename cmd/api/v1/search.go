@@ -0,0 +1,174 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"librebucket/cmd/db"
+	"librebucket/cmd/git"
+)
+
+const (
+	// defaultReposPerPage is the page size of repository lists without per_page
+	defaultReposPerPage = 30
+	// maxReposPerPage is the largest accepted per_page of repository lists
+	maxReposPerPage = 100
+)
+
+// repoResponse is the JSON representation of a repository in lists
+type repoResponse struct {
+	FullName      string             `json:"full_name"`
+	Owner         string             `json:"owner"`
+	Name          string             `json:"name"`
+	Public        bool               `json:"public"`
+	Description   string             `json:"description"`
+	Website       string             `json:"website"`
+	Topics        []string           `json:"topics"`
+	IsTemplate    bool               `json:"is_template"`
+	StarsCount    int                `json:"stars_count"`
+	ForksCount    int                `json:"forks_count"`
+	Languages     map[string]float64 `json:"languages"`
+	CreatedAt     time.Time          `json:"created_at"`
+	UpdatedAt     time.Time          `json:"updated_at"`
+	LastCommit    string             `json:"last_commit"`
+	DefaultBranch string             `json:"default_branch"`
+	ForkedFrom    string             `json:"forked_from,omitempty"`
+	MirrorURL     string             `json:"mirror_url,omitempty"` // Without the password
+	Size          int64              `json:"size"`                 // Bytes on disk including LFS objects
+	LFSSize       int64              `json:"lfs_size"`
+}
+
+// SearchHandler handles the repository search endpoint
+func SearchHandler(mux *http.ServeMux) {
+	// Search repositories by name, e.g. ?q=lib&sort=stars&page=2
+	mux.HandleFunc("GET /api/v1/repos/search", searchRepos)
+}
+
+func searchRepos(w http.ResponseWriter, r *http.Request) {
+	search, err := parseRepoSearch(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	search.Query = r.URL.Query().Get("q")
+
+	repos, total, ok := findRepos(w, r, search)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"total_count": total, "items": repos})
+}
+
+// UserReposHandler lists the repositories of a user that the caller can see
+func UserReposHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := db.GetUserByUsername(r.PathValue("username"))
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	search, err := parseRepoSearch(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	search.Owner = user.Username
+
+	repos, _, ok := findRepos(w, r, search)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, repos)
+}
+
+// parseRepoSearch reads the sort order and pagination of a repository list from
+// the query string: sort (stars, created or updated), order (asc or desc),
+// per_page and page
+func parseRepoSearch(r *http.Request) (db.RepositorySearch, error) {
+	query := r.URL.Query()
+	search := db.RepositorySearch{Sort: query.Get("sort"), Limit: defaultReposPerPage}
+
+	switch search.Sort {
+	case "", "stars", "created", "updated":
+	default:
+		return search, fmt.Errorf("invalid sort %q: must be stars, created or updated", search.Sort)
+	}
+	switch order := query.Get("order"); order {
+	case "", "desc":
+	case "asc":
+		search.Asc = true
+	default:
+		return search, fmt.Errorf("invalid order %q: must be asc or desc", order)
+	}
+
+	if value := query.Get("per_page"); value != "" {
+		perPage, err := strconv.Atoi(value)
+		if err != nil || perPage < 1 || perPage > maxReposPerPage {
+			return search, fmt.Errorf("invalid per_page %q: must be between 1 and %d", value, maxReposPerPage)
+		}
+		search.Limit = perPage
+	}
+	if value := query.Get("page"); value != "" {
+		page, err := strconv.Atoi(value)
+		if err != nil || page < 1 {
+			return search, fmt.Errorf("invalid page %q: must be a positive number", value)
+		}
+		search.Offset = (page - 1) * search.Limit
+	}
+
+	return search, nil
+}
+
+// findRepos runs a repository search as the caller, sets the Link header of the
+// next page and converts the results. It writes an error response on failure.
+func findRepos(w http.ResponseWriter, r *http.Request, search db.RepositorySearch) ([]repoResponse, int, bool) {
+	if user, ok := RequestUser(r); ok {
+		search.Viewer = user.Username
+	}
+
+	repos, total, err := db.SearchRepositories(search)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to list repositories: "+err.Error())
+		return nil, 0, false
+	}
+
+	if search.Offset+len(repos) < total {
+		next := *r.URL
+		query := next.Query()
+		query.Set("page", strconv.Itoa(search.Offset/search.Limit+2))
+		next.RawQuery = query.Encode()
+		w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", next.RequestURI()))
+	}
+
+	resp := make([]repoResponse, 0, len(repos))
+	for _, repo := range repos {
+		resp = append(resp, toRepoResponse(repo))
+	}
+	return resp, total, true
+}
+
+func toRepoResponse(r db.Repository) repoResponse {
+	return repoResponse{
+		FullName:      r.FullName(),
+		Owner:         r.Owner,
+		Name:          r.Name,
+		Public:        r.Public,
+		Description:   r.Description,
+		Website:       r.Website,
+		Topics:        r.Topics,
+		IsTemplate:    r.IsTemplate,
+		StarsCount:    r.StarsCount,
+		ForksCount:    r.ForksCount,
+		Languages:     r.Languages,
+		CreatedAt:     r.CreatedAt,
+		UpdatedAt:     r.UpdatedAt,
+		LastCommit:    r.LastCommit,
+		DefaultBranch: r.DefaultBranch,
+		ForkedFrom:    r.ForkedFrom,
+		MirrorURL:     git.RedactMirrorURL(r.MirrorURL),
+		Size:          r.Size + r.LFSSize,
+		LFSSize:       r.LFSSize,
+	}
+}
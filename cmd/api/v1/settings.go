@@ -0,0 +1,233 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"librebucket/cmd/db"
+	"librebucket/cmd/git"
+	"librebucket/cmd/worker"
+)
+
+// PublishRepoEvent announces changes to the settings, name or owner of a
+// repository, e.g. to webhooks. It is set by the server.
+var PublishRepoEvent func(e worker.RepoEvent)
+
+// SettingsHandler handles the endpoints that read, change, move and delete a repository
+func SettingsHandler(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/repos/{username}/{reponame}", getRepo)
+
+	// Change settings and rename, e.g. {"public": false, "topics": ["git"], "name": "new-name"}
+	mux.HandleFunc("PATCH /api/v1/repos/{username}/{reponame}", updateRepo)
+
+	// Move the repository to another owner
+	mux.HandleFunc("POST /api/v1/repos/{username}/{reponame}/transfer", transferRepo)
+
+	// Delete the repository, the body must confirm its full name
+	mux.HandleFunc("DELETE /api/v1/repos/{username}/{reponame}", deleteRepo)
+}
+
+func getRepo(w http.ResponseWriter, r *http.Request) {
+	repoPath := getRepoPath(r.PathValue("username"), r.PathValue("reponame"))
+	if !authorizeRepo(w, r, repoPath, "pull") {
+		return
+	}
+	writeRepo(w, http.StatusOK, repoPath)
+}
+
+func updateRepo(w http.ResponseWriter, r *http.Request) {
+	repoPath := getRepoPath(r.PathValue("username"), r.PathValue("reponame"))
+	if !authorizeRepo(w, r, repoPath, "push") {
+		return
+	}
+
+	// Omitted fields are left unchanged
+	var req struct {
+		Name          *string  `json:"name"`
+		Public        *bool    `json:"public"`
+		Description   *string  `json:"description"`
+		Website       *string  `json:"website"`
+		Topics        []string `json:"topics"`
+		DefaultBranch *string  `json:"default_branch"`
+		IsTemplate    *bool    `json:"is_template"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	// Refuse a bad new name before any setting is applied
+	newPath := repoPath
+	if req.Name != nil {
+		if !git.ValidRepoName(*req.Name) {
+			writeJSONError(w, http.StatusBadRequest, "Invalid repository name")
+			return
+		}
+		newPath = getRepoPath(r.PathValue("username"), *req.Name)
+		if err := git.CheckMoveTarget(repoPath, newPath); err != nil {
+			writeJSONError(w, gitErrorStatus(err), err.Error())
+			return
+		}
+	}
+
+	err := git.UpdateRepoSettings(repoPath, git.RepoSettings{
+		Public:        req.Public,
+		Description:   req.Description,
+		Website:       req.Website,
+		Topics:        req.Topics,
+		DefaultBranch: req.DefaultBranch,
+		IsTemplate:    req.IsTemplate,
+	})
+	if err != nil {
+		writeJSONError(w, gitErrorStatus(err), err.Error())
+		return
+	}
+
+	if newPath != repoPath {
+		if err := git.MoveRepo(repoPath, newPath); err != nil {
+			writeJSONError(w, gitErrorStatus(err), err.Error())
+			return
+		}
+		oldFullName := git.RepoFullName(repoPath)
+		repoPath = newPath
+		publishRepoEvent(r, repoPath, "renamed", oldFullName)
+	} else {
+		publishRepoEvent(r, repoPath, "edited", "")
+	}
+	writeRepo(w, http.StatusOK, repoPath)
+}
+
+func transferRepo(w http.ResponseWriter, r *http.Request) {
+	repoPath := getRepoPath(r.PathValue("username"), r.PathValue("reponame"))
+	if !authorizeRepo(w, r, repoPath, "push") {
+		return
+	}
+
+	// The repository keeps its name unless another one is given
+	var req struct {
+		NewOwner string `json:"new_owner"`
+		NewName  string `json:"new_name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.NewOwner == "" {
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON or missing new_owner")
+		return
+	}
+	if req.NewName == "" {
+		req.NewName = strings.TrimSuffix(r.PathValue("reponame"), ".git")
+	}
+	if !git.ValidRepoName(req.NewName) {
+		writeJSONError(w, http.StatusBadRequest, "Invalid repository name")
+		return
+	}
+	newOwner, err := db.GetUserByUsername(req.NewOwner)
+	if err != nil {
+		writeJSONError(w, http.StatusUnprocessableEntity, "User not found: "+req.NewOwner)
+		return
+	}
+
+	newPath := getRepoPath(newOwner.Username, req.NewName)
+	if err := git.MoveRepo(repoPath, newPath); err != nil {
+		writeJSONError(w, gitErrorStatus(err), err.Error())
+		return
+	}
+	publishRepoEvent(r, newPath, "transferred", git.RepoFullName(repoPath))
+	writeRepo(w, http.StatusOK, newPath)
+}
+
+func deleteRepo(w http.ResponseWriter, r *http.Request) {
+	repoPath := getRepoPath(r.PathValue("username"), r.PathValue("reponame"))
+	if !authorizeRepo(w, r, repoPath, "push") {
+		return
+	}
+
+	// Guard against deleting the wrong repository by accident
+	var req struct {
+		Confirm string `json:"confirm"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	fullName := git.RepoFullName(repoPath)
+	if req.Confirm != fullName {
+		writeJSONError(w, http.StatusBadRequest, `Confirm the deletion with {"confirm": "`+fullName+`"}`)
+		return
+	}
+
+	if err := git.DeleteRepo(repoPath); err != nil {
+		writeJSONError(w, gitErrorStatus(err), err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// publishRepoEvent publishes a change to a repository by the user of r
+func publishRepoEvent(r *http.Request, repoPath, action, oldFullName string) {
+	if PublishRepoEvent == nil {
+		return
+	}
+	sender, _ := RequestUser(r)
+	PublishRepoEvent(worker.RepoEvent{
+		RepoPath:    repoPath,
+		Action:      action,
+		OldFullName: oldFullName,
+		Sender:      sender.Username,
+		ChangedAt:   time.Now(),
+	})
+}
+
+// writeRepo writes the current metadata of a repository
+func writeRepo(w http.ResponseWriter, status int, repoPath string) {
+	repo, err := db.GetRepository(splitFullName(git.RepoFullName(repoPath)))
+	if err != nil {
+		writeJSONError(w, gitErrorStatus(err), err.Error())
+		return
+	}
+	writeJSON(w, status, toRepoResponse(repo))
+}
+
+// RepoRedirects redirects repository API requests for the old name of a renamed
+// or transferred repository to its new name. GET and HEAD requests get a 301,
+// other methods a 307 so clients repeat them with the same body.
+func RepoRedirects(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rest, _ := strings.CutPrefix(r.URL.Path, "/api/v1/repos/")
+		parts := strings.SplitN(rest, "/", 3)
+		if len(parts) < 2 || !isSafeComponent(parts[0]) || !isSafeComponent(parts[1]) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		repoPath := getRepoPath(parts[0], parts[1])
+		if _, err := git.LoadRepoMeta(repoPath); err == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// The new name of a private repository is only revealed to its owner
+		newPath, ok := git.ResolveRepoRedirect(repoPath)
+		if !ok || !CheckRepoAuth(r, newPath, "pull") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		location := *r.URL
+		location.Path = "/api/v1/repos/" + git.RepoFullName(newPath)
+		if len(parts) == 3 {
+			location.Path += "/" + parts[2]
+		}
+		status := http.StatusTemporaryRedirect
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			status = http.StatusMovedPermanently
+		}
+		http.Redirect(w, r, location.RequestURI(), status)
+	})
+}
+
+// isSafeComponent reports whether s can be used as a single path component
+func isSafeComponent(s string) bool {
+	return s != "" && !strings.ContainsAny(s, `/\`) && !strings.Contains(s, "..")
+}
@@ -0,0 +1,143 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+
+	"librebucket/cmd/db"
+)
+
+// sshKeyResponse is a public key of a user with its fingerprint
+type sshKeyResponse struct {
+	ID          int64      `json:"id"`
+	Name        string     `json:"name"`
+	Key         string     `json:"key"`
+	Fingerprint string     `json:"fingerprint"`
+	CreatedAt   time.Time  `json:"created_at"`
+	LastUsedAt  *time.Time `json:"last_used_at"` // Null until the key is first used
+}
+
+func toSSHKeyResponse(k db.SSHKey) sshKeyResponse {
+	resp := sshKeyResponse{
+		ID:          k.ID,
+		Name:        k.Name,
+		Key:         k.PublicKey,
+		Fingerprint: k.Fingerprint,
+		CreatedAt:   k.CreatedAt,
+	}
+	if !k.LastUsedAt.IsZero() {
+		resp.LastUsedAt = &k.LastUsedAt
+	}
+	return resp
+}
+
+// ListSSHKeysHandler handles GET /api/v1/users/{username}/keys. Users only see
+// their own keys.
+func ListSSHKeysHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := authorizeSelf(w, r)
+	if !ok {
+		return
+	}
+	keys, err := db.ListSSHKeys(user.ID)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to list SSH keys: "+err.Error())
+		return
+	}
+	resp := make([]sshKeyResponse, len(keys))
+	for i, k := range keys {
+		resp[i] = toSSHKeyResponse(k)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// AddSSHKeyHandler handles POST /api/v1/users/{username}/keys with {name, key},
+// where key is a line of an authorized_keys file. The name defaults to the
+// comment of the key.
+func AddSSHKeyHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := authorizeSelf(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		Name string `json:"name"`
+		Key  string `json:"key"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Key) == "" {
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON or missing key")
+		return
+	}
+	pub, comment, _, rest, err := gossh.ParseAuthorizedKey([]byte(req.Key))
+	if err != nil || strings.TrimSpace(string(rest)) != "" {
+		writeJSONError(w, http.StatusBadRequest, "Invalid SSH public key, expected a single authorized_keys line")
+		return
+	}
+	if _, isCert := pub.(*gossh.Certificate); isCert {
+		writeJSONError(w, http.StatusBadRequest, "SSH certificates are not supported")
+		return
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		name = comment
+	}
+	if name == "" {
+		name = pub.Type()
+	}
+	k := db.SSHKey{
+		UserID:      user.ID,
+		Username:    user.Username,
+		Name:        name,
+		Fingerprint: gossh.FingerprintSHA256(pub),
+		PublicKey:   strings.TrimSpace(string(gossh.MarshalAuthorizedKey(pub))),
+	}
+	if err := db.CreateSSHKey(&k); errors.Is(err, db.ErrSSHKeyExists) {
+		writeJSONError(w, http.StatusConflict, "SSH key is already in use")
+		return
+	} else if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to save SSH key: "+err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, toSSHKeyResponse(k))
+}
+
+// DeleteSSHKeyHandler handles DELETE /api/v1/users/{username}/keys/{id}
+func DeleteSSHKeyHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := authorizeSelf(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid SSH key ID")
+		return
+	}
+	if err := db.DeleteSSHKey(user.ID, id); errors.Is(err, db.ErrSSHKeyNotFound) {
+		writeJSONError(w, http.StatusNotFound, "SSH key not found")
+		return
+	} else if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to delete SSH key: "+err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authorizeSelf checks that a request is authenticated as the user in the path
+// and writes a JSON error if not
+func authorizeSelf(w http.ResponseWriter, r *http.Request) (db.User, bool) {
+	user, ok := RequestUser(r)
+	if !ok {
+		writeJSONError(w, http.StatusUnauthorized, "Authentication required")
+		return db.User{}, false
+	}
+	if user.Username != r.PathValue("username") {
+		writeJSONError(w, http.StatusForbidden, "Only the user can manage their SSH keys")
+		return db.User{}, false
+	}
+	return user, true
+}
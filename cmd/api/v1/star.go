@@ -0,0 +1,149 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"librebucket/cmd/db"
+	"librebucket/cmd/git"
+)
+
+// starResponse is the JSON representation of a db.Star
+type starResponse struct {
+	Username  string    `json:"username"`
+	Repo      string    `json:"repo"`
+	StarredAt time.Time `json:"starred_at"`
+}
+
+// StarHandler handles starring API endpoints
+func StarHandler(mux *http.ServeMux) {
+	// Check, add and remove the caller's star on a repository
+	mux.HandleFunc("GET /api/v1/repos/{username}/{reponame}/star", getStar)
+	mux.HandleFunc("PUT /api/v1/repos/{username}/{reponame}/star", starRepo)
+	mux.HandleFunc("DELETE /api/v1/repos/{username}/{reponame}/star", unstarRepo)
+
+	// List the users who starred a repository
+	mux.HandleFunc("GET /api/v1/repos/{username}/{reponame}/stargazers", listStargazers)
+}
+
+// starUser authorizes a star request and returns the caller and the repository
+func starUser(w http.ResponseWriter, r *http.Request) (user db.User, repoPath string, ok bool) {
+	repoPath = getRepoPath(r.PathValue("username"), r.PathValue("reponame"))
+	if !authorizeRepo(w, r, repoPath, "pull") {
+		return db.User{}, "", false
+	}
+	user, ok = RequestUser(r)
+	if !ok {
+		writeJSONError(w, http.StatusUnauthorized, "Authentication required")
+	}
+	return user, repoPath, ok
+}
+
+func getStar(w http.ResponseWriter, r *http.Request) {
+	user, repoPath, ok := starUser(w, r)
+	if !ok {
+		return
+	}
+
+	starred, err := db.IsStarred(user.ID, git.RepoFullName(repoPath))
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to check star: "+err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"starred": starred})
+}
+
+func starRepo(w http.ResponseWriter, r *http.Request) {
+	user, repoPath, ok := starUser(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := db.StarRepo(user.ID, git.RepoFullName(repoPath)); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to star repository: "+err.Error())
+		return
+	}
+	writeStarCount(w, repoPath, true)
+}
+
+func unstarRepo(w http.ResponseWriter, r *http.Request) {
+	user, repoPath, ok := starUser(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := db.UnstarRepo(user.ID, git.RepoFullName(repoPath)); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to unstar repository: "+err.Error())
+		return
+	}
+	writeStarCount(w, repoPath, false)
+}
+
+// writeStarCount recounts the stars of a repository into its metadata and writes the result
+func writeStarCount(w http.ResponseWriter, repoPath string, starred bool) {
+	fullName := git.RepoFullName(repoPath)
+	var count int
+	err := git.RefreshStars(repoPath, func() (int, error) {
+		var err error
+		count, err = db.CountStars(fullName)
+		return count, err
+	})
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"starred": starred, "stars_count": count})
+}
+
+func listStargazers(w http.ResponseWriter, r *http.Request) {
+	repoPath := getRepoPath(r.PathValue("username"), r.PathValue("reponame"))
+	if !authorizeRepo(w, r, repoPath, "pull") {
+		return
+	}
+
+	stars, err := db.ListStargazers(git.RepoFullName(repoPath))
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to list stargazers: "+err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, toStarResponses(stars, nil))
+}
+
+// UserStarredHandler lists the repositories a user starred that the caller can see
+func UserStarredHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := db.GetUserByUsername(r.PathValue("username"))
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	stars, err := db.ListStarredRepos(user.ID)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to list starred repositories: "+err.Error())
+		return
+	}
+
+	// Skip repositories that were deleted or are private to someone else
+	writeJSON(w, http.StatusOK, toStarResponses(stars, func(s db.Star) bool {
+		return CheckRepoAuth(r, getRepoPath(splitFullName(s.Repo)), "pull")
+	}))
+}
+
+// toStarResponses converts the stars accepted by keep (all if nil) to responses
+func toStarResponses(stars []db.Star, keep func(db.Star) bool) []starResponse {
+	resp := make([]starResponse, 0, len(stars))
+	for _, s := range stars {
+		if keep != nil && !keep(s) {
+			continue
+		}
+		resp = append(resp, starResponse{Username: s.Username, Repo: s.Repo, StarredAt: s.CreatedAt})
+	}
+	return resp
+}
+
+// splitFullName splits "{owner}/{name}" into owner and name
+func splitFullName(fullName string) (owner, name string) {
+	owner, name, _ = strings.Cut(fullName, "/")
+	return owner, name
+}
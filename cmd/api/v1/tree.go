@@ -0,0 +1,63 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"librebucket/cmd/git"
+)
+
+// treeEntryResponse is the JSON representation of a git.TreeEntry
+type treeEntryResponse struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+	Mode string `json:"mode"`
+	Type string `json:"type"`
+	Hash string `json:"hash"`
+	Size int64  `json:"size"`
+}
+
+// TreeHandler handles tree browsing API endpoints
+func TreeHandler(mux *http.ServeMux) {
+	// List a directory of a repository at a ref as {ref}/{path}, or the root
+	// as {ref}. Refs may contain slashes, e.g. tree/feature/x/docs.
+	mux.HandleFunc("GET /api/v1/repos/{username}/{reponame}/tree/{refpath...}", getTree)
+}
+
+func getTree(w http.ResponseWriter, r *http.Request) {
+	username := r.PathValue("username")
+	reponame := r.PathValue("reponame")
+	refPath := r.PathValue("refpath")
+
+	repoPath := getRepoPath(username, reponame)
+	if !authorizeRepo(w, r, repoPath, "pull") {
+		return
+	}
+	ref, treePath, err := git.SplitRefPath(repoPath, refPath)
+	if err != nil {
+		http.Error(w, "Failed to get tree: "+err.Error(), gitErrorStatus(err))
+		return
+	}
+	entries, err := git.GetTree(repoPath, ref, treePath)
+	if err != nil {
+		http.Error(w, "Failed to get tree: "+err.Error(), gitErrorStatus(err))
+		return
+	}
+
+	resp := make([]treeEntryResponse, 0, len(entries))
+	for _, e := range entries {
+		resp = append(resp, treeEntryResponse{
+			Name: e.Name,
+			Path: e.Path,
+			Mode: e.Mode,
+			Type: e.Type,
+			Hash: e.Hash.String(),
+			Size: e.Size,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		http.Error(w, "Failed to encode response: "+err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,43 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"librebucket/cmd/git"
+)
+
+func TestTreeSlashBranch(t *testing.T) {
+	t.Chdir(t.TempDir())
+	token := createPrivateRepo(t, "bo", "project")
+	repoPath := filepath.Join("repos", "bo", "project.git")
+	if _, err := git.CreateBranch(repoPath, "feature/x", "main"); err != nil {
+		t.Fatalf("CreateBranch failed: %v", err)
+	}
+	mux := http.NewServeMux()
+	TreeHandler(mux)
+
+	get := func(url string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		req.Header.Set("X-Auth-Token", token)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get("/api/v1/repos/bo/project/tree/feature/x")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 for the feature/x branch, got %d: %s", rec.Code, rec.Body)
+	}
+	var entries []treeEntryResponse
+	if err := json.NewDecoder(rec.Body).Decode(&entries); err != nil || len(entries) != 1 || entries[0].Name != "README.md" {
+		t.Errorf("Unexpected entries %+v, %v", entries, err)
+	}
+
+	if rec := get("/api/v1/repos/bo/project/tree/feature/y"); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown branch, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,298 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"librebucket/cmd/db"
+	"librebucket/cmd/git"
+)
+
+// webhookResponse is a webhook without its secret, which is never returned
+type webhookResponse struct {
+	ID          int64     `json:"id"`
+	URL         string    `json:"url"`
+	ContentType string    `json:"content_type"`
+	Events      []string  `json:"events"`
+	Active      bool      `json:"active"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+func toWebhookResponse(h db.Webhook) webhookResponse {
+	return webhookResponse{
+		ID:          h.ID,
+		URL:         h.URL,
+		ContentType: h.ContentType,
+		Events:      h.Events,
+		Active:      h.Active,
+		CreatedAt:   h.CreatedAt,
+		UpdatedAt:   h.UpdatedAt,
+	}
+}
+
+// webhookDeliveryResponse is a delivery of a webhook. The request and
+// response are only included when a single delivery is requested.
+type webhookDeliveryResponse struct {
+	ID          int64             `json:"id"`
+	GUID        string            `json:"guid"`
+	Event       string            `json:"event"`
+	Attempt     int               `json:"attempt"`
+	Redelivery  bool              `json:"redelivery"`
+	StatusCode  int               `json:"status_code"` // 0 if no response was received
+	Error       string            `json:"error,omitempty"`
+	DurationMs  int64             `json:"duration_ms"`
+	DeliveredAt time.Time         `json:"delivered_at"`
+	Request     *deliveryRequest  `json:"request,omitempty"`
+	Response    *deliveryResponse `json:"response,omitempty"`
+}
+
+// deliveryRequest is the request of a webhook delivery
+type deliveryRequest struct {
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"` // The JSON payload, also for form webhooks
+}
+
+// deliveryResponse is the response to a webhook delivery
+type deliveryResponse struct {
+	Body string `json:"body"` // The first 4 KiB
+}
+
+func toWebhookDeliveryResponse(d db.WebhookDelivery, details bool) webhookDeliveryResponse {
+	resp := webhookDeliveryResponse{
+		ID:          d.ID,
+		GUID:        d.GUID,
+		Event:       d.Event,
+		Attempt:     d.Attempt,
+		Redelivery:  d.Redelivery,
+		StatusCode:  d.StatusCode,
+		Error:       d.Error,
+		DurationMs:  d.Duration.Milliseconds(),
+		DeliveredAt: d.DeliveredAt,
+	}
+	if details {
+		resp.Request = &deliveryRequest{Headers: d.RequestHeaders, Body: d.RequestBody}
+		resp.Response = &deliveryResponse{Body: d.ResponseBody}
+	}
+	return resp
+}
+
+// webhookRequest holds the settings of a webhook to add or change
+type webhookRequest struct {
+	URL         *string  `json:"url"`
+	Secret      *string  `json:"secret"`
+	ContentType *string  `json:"content_type"`
+	Events      []string `json:"events"`
+	Active      *bool    `json:"active"`
+}
+
+func (req webhookRequest) settings() git.WebhookSettings {
+	return git.WebhookSettings(req)
+}
+
+// WebhookHandler handles the URLs events of a repository are posted to and
+// the log of their deliveries. Only the owner can see and change them, since
+// they hold secrets.
+func WebhookHandler(mux *http.ServeMux) {
+	// List webhooks
+	mux.HandleFunc("GET /api/v1/repos/{username}/{reponame}/hooks", listWebhooks)
+	// Add a webhook {url, secret, content_type, events, active}, e.g.
+	// {"url": "https://ci.example.com/hook", "secret": "...", "events": ["push"]}
+	mux.HandleFunc("POST /api/v1/repos/{username}/{reponame}/hooks", addWebhook)
+	mux.HandleFunc("GET /api/v1/repos/{username}/{reponame}/hooks/{id}", getWebhook)
+	// Change a webhook, omitted fields are left unchanged
+	mux.HandleFunc("PATCH /api/v1/repos/{username}/{reponame}/hooks/{id}", updateWebhook)
+	mux.HandleFunc("DELETE /api/v1/repos/{username}/{reponame}/hooks/{id}", removeWebhook)
+	// List the latest deliveries, newest first
+	mux.HandleFunc("GET /api/v1/repos/{username}/{reponame}/hooks/{id}/deliveries", listWebhookDeliveries)
+	// Get a delivery with its request and response
+	mux.HandleFunc("GET /api/v1/repos/{username}/{reponame}/hooks/{id}/deliveries/{delivery}", getWebhookDelivery)
+	// Send the payload of a delivery again, e.g. after fixing the receiver
+	mux.HandleFunc("POST /api/v1/repos/{username}/{reponame}/hooks/{id}/deliveries/{delivery}/redeliver", redeliverWebhook)
+}
+
+func listWebhooks(w http.ResponseWriter, r *http.Request) {
+	repoPath := getRepoPath(r.PathValue("username"), r.PathValue("reponame"))
+	if !authorizeRepo(w, r, repoPath, "push") {
+		return
+	}
+	hooks, err := git.ListWebhooks(repoPath)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	resp := make([]webhookResponse, len(hooks))
+	for i, h := range hooks {
+		resp[i] = toWebhookResponse(h)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func addWebhook(w http.ResponseWriter, r *http.Request) {
+	repoPath := getRepoPath(r.PathValue("username"), r.PathValue("reponame"))
+	if !authorizeRepo(w, r, repoPath, "push") {
+		return
+	}
+	var req webhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.URL == nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON or missing url")
+		return
+	}
+	h, err := git.AddWebhook(repoPath, req.settings())
+	if err != nil {
+		writeJSONError(w, gitErrorStatus(err), err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, toWebhookResponse(h))
+}
+
+func getWebhook(w http.ResponseWriter, r *http.Request) {
+	repoPath := getRepoPath(r.PathValue("username"), r.PathValue("reponame"))
+	if !authorizeRepo(w, r, repoPath, "push") {
+		return
+	}
+	id, ok := webhookID(w, r)
+	if !ok {
+		return
+	}
+	h, err := git.GetWebhook(repoPath, id)
+	if err != nil {
+		writeJSONError(w, gitErrorStatus(err), err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, toWebhookResponse(h))
+}
+
+func updateWebhook(w http.ResponseWriter, r *http.Request) {
+	repoPath := getRepoPath(r.PathValue("username"), r.PathValue("reponame"))
+	if !authorizeRepo(w, r, repoPath, "push") {
+		return
+	}
+	id, ok := webhookID(w, r)
+	if !ok {
+		return
+	}
+	var req webhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	h, err := git.UpdateWebhook(repoPath, id, req.settings())
+	if err != nil {
+		writeJSONError(w, gitErrorStatus(err), err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, toWebhookResponse(h))
+}
+
+func removeWebhook(w http.ResponseWriter, r *http.Request) {
+	repoPath := getRepoPath(r.PathValue("username"), r.PathValue("reponame"))
+	if !authorizeRepo(w, r, repoPath, "push") {
+		return
+	}
+	id, ok := webhookID(w, r)
+	if !ok {
+		return
+	}
+	if err := git.RemoveWebhook(repoPath, id); err != nil {
+		writeJSONError(w, gitErrorStatus(err), err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func listWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	repoPath := getRepoPath(r.PathValue("username"), r.PathValue("reponame"))
+	if !authorizeRepo(w, r, repoPath, "push") {
+		return
+	}
+	id, ok := webhookID(w, r)
+	if !ok {
+		return
+	}
+	deliveries, err := git.ListWebhookDeliveries(repoPath, id)
+	if err != nil {
+		writeJSONError(w, gitErrorStatus(err), err.Error())
+		return
+	}
+	resp := make([]webhookDeliveryResponse, len(deliveries))
+	for i, d := range deliveries {
+		resp[i] = toWebhookDeliveryResponse(d, false)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func getWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	repoPath := getRepoPath(r.PathValue("username"), r.PathValue("reponame"))
+	if !authorizeRepo(w, r, repoPath, "push") {
+		return
+	}
+	id, ok := webhookID(w, r)
+	if !ok {
+		return
+	}
+	deliveryID, ok := webhookDeliveryID(w, r)
+	if !ok {
+		return
+	}
+	d, err := git.GetWebhookDelivery(repoPath, id, deliveryID)
+	if err != nil {
+		writeJSONError(w, gitErrorStatus(err), err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, toWebhookDeliveryResponse(d, true))
+}
+
+func redeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	repoPath := getRepoPath(r.PathValue("username"), r.PathValue("reponame"))
+	if !authorizeRepo(w, r, repoPath, "push") {
+		return
+	}
+	id, ok := webhookID(w, r)
+	if !ok {
+		return
+	}
+	deliveryID, ok := webhookDeliveryID(w, r)
+	if !ok {
+		return
+	}
+
+	// A failed delivery is recorded and returned with the status
+	d, err := git.RedeliverWebhook(r.Context(), repoPath, id, deliveryID)
+	if errors.Is(err, db.ErrWebhookNotFound) || errors.Is(err, db.ErrWebhookDeliveryNotFound) {
+		writeJSONError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if d.ID == 0 {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	status := http.StatusCreated
+	if err != nil {
+		status = http.StatusBadGateway
+	}
+	writeJSON(w, status, toWebhookDeliveryResponse(d, true))
+}
+
+// webhookID parses the {id} path parameter
+func webhookID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid webhook ID")
+		return 0, false
+	}
+	return id, true
+}
+
+// webhookDeliveryID parses the {delivery} path parameter
+func webhookDeliveryID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(r.PathValue("delivery"), 10, 64)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid delivery ID")
+		return 0, false
+	}
+	return id, true
+}
@@ -0,0 +1,161 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrLFSObjectNotFound is returned when a repository has no LFS object with an OID
+	ErrLFSObjectNotFound = errors.New("LFS object not found")
+	// ErrLFSLockNotFound is returned when no LFS lock matches
+	ErrLFSLockNotFound = errors.New("LFS lock not found")
+	// ErrLFSLockExists is returned when locking a path that is already locked
+	ErrLFSLockExists = errors.New("path is already locked")
+)
+
+// LFSLock is a lock on a file path of a repository, taken so nobody else edits
+// a file that cannot be merged. Locks apply to all branches.
+type LFSLock struct {
+	ID        int64
+	Repo      string // "{owner}/{name}"
+	Path      string
+	Owner     string // Username of the user who holds the lock
+	CreatedAt time.Time
+}
+
+// LFSLockFilter selects the locks returned by ListLFSLocks
+type LFSLockFilter struct {
+	ID      int64  // Only the lock with this ID, all if zero
+	Path    string // Only the lock of this path, all if empty
+	AfterID int64  // Locks after this ID, for pagination
+	Limit   int
+}
+
+const lfsLockColumns = `id, repo, path, owner, created_at`
+
+// createLFSTables creates the lfs_objects and lfs_locks tables. The objects
+// themselves are stored on disk, a row links an object to a repository that
+// may read it.
+func createLFSTables() error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS lfs_objects (
+		repo TEXT NOT NULL,
+		oid TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (repo, oid)
+	)`)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS lfs_locks (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		repo TEXT NOT NULL,
+		path TEXT NOT NULL,
+		owner TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		UNIQUE (repo, path)
+	)`)
+	return err
+}
+
+// AddLFSObject links an LFS object to a repository
+func AddLFSObject(repo, oid string, size int64) error {
+	_, err := db.Exec(`INSERT OR IGNORE INTO lfs_objects (repo, oid, size, created_at) VALUES (?, ?, ?, ?)`,
+		repo, oid, size, time.Now().UTC())
+	return err
+}
+
+// GetLFSObjectSize returns the size of an LFS object of a repository
+func GetLFSObjectSize(repo, oid string) (int64, error) {
+	var size int64
+	err := db.QueryRow(`SELECT size FROM lfs_objects WHERE repo = ? AND oid = ?`, repo, oid).Scan(&size)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrLFSObjectNotFound
+	}
+	return size, err
+}
+
+// CopyLFSObjects links all LFS objects of src to dst, e.g. when forking
+func CopyLFSObjects(src, dst string) error {
+	_, err := db.Exec(`INSERT OR IGNORE INTO lfs_objects (repo, oid, size, created_at)
+		SELECT ?, oid, size, ? FROM lfs_objects WHERE repo = ?`, dst, time.Now().UTC(), src)
+	return err
+}
+
+// CreateLFSLock stores a new lock and sets its ID and CreatedAt. It returns
+// ErrLFSLockExists if the path is already locked.
+func CreateLFSLock(l *LFSLock) error {
+	l.CreatedAt = time.Now()
+	res, err := db.Exec(`INSERT INTO lfs_locks (repo, path, owner, created_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (repo, path) DO NOTHING`,
+		l.Repo, l.Path, l.Owner, l.CreatedAt.UTC())
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrLFSLockExists
+	}
+	l.ID, err = res.LastInsertId()
+	return err
+}
+
+// ListLFSLocks returns the locks of a repository matching f, ordered by ID
+func ListLFSLocks(repo string, f LFSLockFilter) ([]LFSLock, error) {
+	query := `SELECT ` + lfsLockColumns + ` FROM lfs_locks WHERE repo = ? AND id > ?`
+	args := []any{repo, f.AfterID}
+	if f.ID != 0 {
+		query += ` AND id = ?`
+		args = append(args, f.ID)
+	}
+	if f.Path != "" {
+		query += ` AND path = ?`
+		args = append(args, f.Path)
+	}
+	query += ` ORDER BY id LIMIT ?`
+	args = append(args, f.Limit)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	locks := []LFSLock{}
+	for rows.Next() {
+		var l LFSLock
+		if err := rows.Scan(&l.ID, &l.Repo, &l.Path, &l.Owner, &l.CreatedAt); err != nil {
+			return nil, err
+		}
+		locks = append(locks, l)
+	}
+	return locks, rows.Err()
+}
+
+// GetLFSLock returns a lock of a repository by ID
+func GetLFSLock(repo string, id int64) (LFSLock, error) {
+	var l LFSLock
+	err := db.QueryRow(`SELECT `+lfsLockColumns+` FROM lfs_locks WHERE repo = ? AND id = ?`, repo, id).Scan(
+		&l.ID, &l.Repo, &l.Path, &l.Owner, &l.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return LFSLock{}, ErrLFSLockNotFound
+	}
+	return l, err
+}
+
+// DeleteLFSLock removes a lock of a repository
+func DeleteLFSLock(repo string, id int64) error {
+	res, err := db.Exec(`DELETE FROM lfs_locks WHERE repo = ? AND id = ?`, repo, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrLFSLockNotFound
+	}
+	return nil
+}
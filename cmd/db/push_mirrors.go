@@ -0,0 +1,149 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrPushMirrorNotFound is returned when no push mirror matches
+var ErrPushMirrorNotFound = errors.New("push mirror not found")
+
+// PushMirror is a remote a repository is replicated to. Password is stored
+// encrypted with the secret key, see LoadSecretKey.
+type PushMirror struct {
+	ID            int64
+	Repo          string // "{owner}/{name}"
+	RemoteURL     string // Without credentials
+	Username      string
+	Password      string
+	Interval      time.Duration
+	CreatedAt     time.Time
+	LastSyncAt    time.Time // Time of the last successful push
+	LastError     string    // Error of the last attempt, empty if it succeeded
+	Failures      int       // Consecutive failed attempts
+	NextAttemptAt time.Time // Earliest retry after a failure
+}
+
+const pushMirrorColumns = `id, repo, remote_url, username, password, interval, created_at, last_sync_at, last_error, failures, next_attempt_at`
+
+// createPushMirrorsTable creates the push_mirrors table
+func createPushMirrorsTable() error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS push_mirrors (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		repo TEXT NOT NULL,
+		remote_url TEXT NOT NULL,
+		username TEXT NOT NULL DEFAULT '',
+		password TEXT NOT NULL DEFAULT '',
+		interval INTEGER NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		last_sync_at TIMESTAMP,
+		last_error TEXT NOT NULL DEFAULT '',
+		failures INTEGER NOT NULL DEFAULT 0,
+		next_attempt_at TIMESTAMP
+	)`)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS push_mirrors_repo ON push_mirrors (repo)`)
+	return err
+}
+
+func scanPushMirror(row rowScanner) (PushMirror, error) {
+	var m PushMirror
+	var password string
+	var interval int64
+	var lastSyncAt, nextAttemptAt sql.NullTime
+	err := row.Scan(&m.ID, &m.Repo, &m.RemoteURL, &m.Username, &password, &interval, &m.CreatedAt,
+		&lastSyncAt, &m.LastError, &m.Failures, &nextAttemptAt)
+	if err != nil {
+		return PushMirror{}, err
+	}
+	if m.Password, err = decryptSecret(password); err != nil {
+		return PushMirror{}, err
+	}
+	m.Interval = time.Duration(interval) * time.Second
+	m.LastSyncAt = lastSyncAt.Time
+	m.NextAttemptAt = nextAttemptAt.Time
+	return m, nil
+}
+
+// CreatePushMirror stores a new push mirror and sets its ID and CreatedAt
+func CreatePushMirror(m *PushMirror) error {
+	password, err := encryptSecret(m.Password)
+	if err != nil {
+		return err
+	}
+	m.CreatedAt = time.Now()
+	res, err := db.Exec(`INSERT INTO push_mirrors (repo, remote_url, username, password, interval, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		m.Repo, m.RemoteURL, m.Username, password, int64(m.Interval/time.Second), m.CreatedAt.UTC())
+	if err != nil {
+		return err
+	}
+	m.ID, err = res.LastInsertId()
+	return err
+}
+
+// GetPushMirror returns a push mirror of a repository by ID
+func GetPushMirror(repo string, id int64) (PushMirror, error) {
+	m, err := scanPushMirror(db.QueryRow(`SELECT `+pushMirrorColumns+` FROM push_mirrors WHERE repo = ? AND id = ?`, repo, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return PushMirror{}, ErrPushMirrorNotFound
+	}
+	return m, err
+}
+
+// ListPushMirrors returns the push mirrors of a repository, oldest first
+func ListPushMirrors(repo string) ([]PushMirror, error) {
+	return queryPushMirrors(`SELECT `+pushMirrorColumns+` FROM push_mirrors WHERE repo = ? ORDER BY id`, repo)
+}
+
+// ListAllPushMirrors returns the push mirrors of all repositories
+func ListAllPushMirrors() ([]PushMirror, error) {
+	return queryPushMirrors(`SELECT ` + pushMirrorColumns + ` FROM push_mirrors ORDER BY id`)
+}
+
+func queryPushMirrors(query string, args ...any) ([]PushMirror, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	mirrors := []PushMirror{}
+	for rows.Next() {
+		m, err := scanPushMirror(rows)
+		if err != nil {
+			return nil, err
+		}
+		mirrors = append(mirrors, m)
+	}
+	return mirrors, rows.Err()
+}
+
+// UpdatePushMirrorStatus records the outcome of a push to a mirror
+func UpdatePushMirrorStatus(m PushMirror) error {
+	_, err := db.Exec(`UPDATE push_mirrors SET last_sync_at = ?, last_error = ?, failures = ?, next_attempt_at = ? WHERE id = ?`,
+		nullTime(m.LastSyncAt), m.LastError, m.Failures, nullTime(m.NextAttemptAt), m.ID)
+	return err
+}
+
+// DeletePushMirror removes a push mirror of a repository
+func DeletePushMirror(repo string, id int64) error {
+	res, err := db.Exec(`DELETE FROM push_mirrors WHERE repo = ? AND id = ?`, repo, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrPushMirrorNotFound
+	}
+	return nil
+}
+
+// nullTime stores zero times as NULL
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t.UTC(), Valid: !t.IsZero()}
+}
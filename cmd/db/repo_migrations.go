@@ -0,0 +1,97 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrRepoMigrationNotFound is returned when no migration matches
+var ErrRepoMigrationNotFound = errors.New("migration not found")
+
+// States of a repository migration
+const (
+	MigrationQueued  = "queued"
+	MigrationRunning = "running"
+	MigrationDone    = "done"
+	MigrationFailed  = "failed"
+)
+
+// RepoMigration is the status of a repository imported from another git
+// server. Credentials for the remote are never stored.
+type RepoMigration struct {
+	ID        int64
+	Owner     string
+	Name      string
+	RemoteURL string // Without credentials
+	State     string
+	Progress  string // Last progress line of the remote
+	Error     string // Set when State is MigrationFailed
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+const repoMigrationColumns = `id, owner, name, remote_url, state, progress, error, created_at, updated_at`
+
+// createRepoMigrationsTable creates the repo_migrations table
+func createRepoMigrationsTable() error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS repo_migrations (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		owner TEXT NOT NULL,
+		name TEXT NOT NULL,
+		remote_url TEXT NOT NULL,
+		state TEXT NOT NULL,
+		progress TEXT NOT NULL DEFAULT '',
+		error TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP NOT NULL,
+		updated_at TIMESTAMP NOT NULL
+	)`)
+	return err
+}
+
+// CreateRepoMigration stores a new queued migration and sets its ID and times
+func CreateRepoMigration(m *RepoMigration) error {
+	m.State = MigrationQueued
+	m.CreatedAt = time.Now()
+	m.UpdatedAt = m.CreatedAt
+	res, err := db.Exec(`INSERT INTO repo_migrations (owner, name, remote_url, state, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		m.Owner, m.Name, m.RemoteURL, m.State, m.CreatedAt.UTC(), m.UpdatedAt.UTC())
+	if err != nil {
+		return err
+	}
+	m.ID, err = res.LastInsertId()
+	return err
+}
+
+// GetRepoMigration returns a migration by ID
+func GetRepoMigration(id int64) (RepoMigration, error) {
+	var m RepoMigration
+	err := db.QueryRow(`SELECT `+repoMigrationColumns+` FROM repo_migrations WHERE id = ?`, id).Scan(
+		&m.ID, &m.Owner, &m.Name, &m.RemoteURL, &m.State, &m.Progress, &m.Error, &m.CreatedAt, &m.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return RepoMigration{}, ErrRepoMigrationNotFound
+	}
+	return m, err
+}
+
+// UpdateRepoMigration saves the state, progress and error of a migration and
+// sets its UpdatedAt
+func UpdateRepoMigration(m *RepoMigration) error {
+	m.UpdatedAt = time.Now()
+	_, err := db.Exec(`UPDATE repo_migrations SET state = ?, progress = ?, error = ?, updated_at = ? WHERE id = ?`,
+		m.State, m.Progress, m.Error, m.UpdatedAt.UTC(), m.ID)
+	return err
+}
+
+// FailInterruptedMigrations marks the migrations that were queued or running
+// when the server stopped as failed, since their jobs are gone. It returns the
+// number of migrations marked.
+func FailInterruptedMigrations() (int64, error) {
+	res, err := db.Exec(`UPDATE repo_migrations SET state = ?, error = ?, updated_at = ? WHERE state IN (?, ?)`,
+		MigrationFailed, "interrupted by a server restart", time.Now().UTC(), MigrationQueued, MigrationRunning)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,459 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrRepositoryNotFound is returned when no repository row matches
+var ErrRepositoryNotFound = errors.New("repository not found")
+
+// Repository is the metadata of a repository, keyed by owner and name
+type Repository struct {
+	ID            int
+	Owner         string
+	Name          string
+	Public        bool
+	Description   string
+	Website       string
+	Topics        []string
+	IsTemplate    bool // New repositories can be generated from it
+	StarsCount    int
+	ForksCount    int
+	LastCommit    string
+	Languages     map[string]float64 // Language name to percent
+	CreatedAt     time.Time
+	UpdatedAt     time.Time // Time of the last push
+	DefaultBranch string
+	ForkedFrom    string // "{owner}/{name}" of the parent of a fork
+	// Pull mirrors fetch MirrorURL every MirrorInterval. MirrorURL holds no
+	// credentials, MirrorPassword is stored encrypted with the secret key.
+	MirrorURL      string
+	MirrorUsername string
+	MirrorPassword string
+	MirrorInterval time.Duration
+	MirrorSyncedAt time.Time
+	MirrorError    string // Error of the last sync, empty if it succeeded
+	Size           int64  // Bytes of the repository directory
+	LFSSize        int64  // Bytes of the LFS objects, computed from lfs_objects
+	PushPolicy     string // JSON of the rules pushes must follow, empty if none
+}
+
+// FullName returns "{owner}/{name}"
+func (r Repository) FullName() string {
+	return r.Owner + "/" + r.Name
+}
+
+const repositoryColumns = `id, owner, name, public, description, website, topics, is_template, stars_count, forks_count, last_commit, languages, created_at, updated_at, default_branch, forked_from,
+	mirror_url, mirror_username, mirror_password, mirror_interval, mirror_synced_at, mirror_error, size, push_policy,
+	(SELECT COALESCE(SUM(lfs_objects.size), 0) FROM lfs_objects WHERE lfs_objects.repo = repositories.owner || '/' || repositories.name)`
+
+// createRepositoriesTable creates the repositories table
+func createRepositoriesTable() error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS repositories (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		owner TEXT NOT NULL,
+		name TEXT NOT NULL,
+		public INTEGER NOT NULL DEFAULT 0,
+		description TEXT NOT NULL DEFAULT '',
+		website TEXT NOT NULL DEFAULT '',
+		topics TEXT NOT NULL DEFAULT '[]',
+		is_template INTEGER NOT NULL DEFAULT 0,
+		stars_count INTEGER NOT NULL DEFAULT 0,
+		forks_count INTEGER NOT NULL DEFAULT 0,
+		last_commit TEXT NOT NULL DEFAULT '',
+		languages TEXT NOT NULL DEFAULT '{}',
+		created_at TIMESTAMP NOT NULL,
+		updated_at TIMESTAMP NOT NULL,
+		default_branch TEXT NOT NULL DEFAULT '',
+		forked_from TEXT NOT NULL DEFAULT '',
+		mirror_url TEXT NOT NULL DEFAULT '',
+		mirror_username TEXT NOT NULL DEFAULT '',
+		mirror_password TEXT NOT NULL DEFAULT '',
+		mirror_interval INTEGER NOT NULL DEFAULT 0,
+		mirror_synced_at TIMESTAMP,
+		mirror_error TEXT NOT NULL DEFAULT '',
+		size INTEGER NOT NULL DEFAULT 0,
+		push_policy TEXT NOT NULL DEFAULT '',
+		UNIQUE (owner, name)
+	)`)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS repositories_forked_from ON repositories (forked_from)`)
+	if err != nil {
+		return err
+	}
+	// Old names of renamed and transferred repositories, pointing to the current "{owner}/{name}"
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS repo_redirects (
+		owner TEXT NOT NULL,
+		name TEXT NOT NULL,
+		target TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (owner, name)
+	)`)
+	return err
+}
+
+// rowScanner is implemented by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanRepository(row rowScanner) (Repository, error) {
+	var r Repository
+	var public, isTemplate int
+	var topics, languages, mirrorPassword string
+	var mirrorInterval int64
+	var mirrorSyncedAt sql.NullTime
+	err := row.Scan(&r.ID, &r.Owner, &r.Name, &public, &r.Description, &r.Website, &topics, &isTemplate, &r.StarsCount, &r.ForksCount,
+		&r.LastCommit, &languages, &r.CreatedAt, &r.UpdatedAt, &r.DefaultBranch, &r.ForkedFrom,
+		&r.MirrorURL, &r.MirrorUsername, &mirrorPassword, &mirrorInterval, &mirrorSyncedAt, &r.MirrorError, &r.Size, &r.PushPolicy, &r.LFSSize)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Repository{}, ErrRepositoryNotFound
+	}
+	if err != nil {
+		return Repository{}, err
+	}
+	r.Public = public != 0
+	r.IsTemplate = isTemplate != 0
+	r.MirrorInterval = time.Duration(mirrorInterval) * time.Second
+	r.MirrorSyncedAt = mirrorSyncedAt.Time
+	if r.MirrorPassword, err = decryptSecret(mirrorPassword); err != nil {
+		return Repository{}, fmt.Errorf("invalid mirror password of %s: %w", r.FullName(), err)
+	}
+	if err := json.Unmarshal([]byte(topics), &r.Topics); err != nil {
+		return Repository{}, fmt.Errorf("invalid topics of %s: %w", r.FullName(), err)
+	}
+	if err := json.Unmarshal([]byte(languages), &r.Languages); err != nil {
+		return Repository{}, fmt.Errorf("invalid languages of %s: %w", r.FullName(), err)
+	}
+	return r, nil
+}
+
+// GetRepository returns the repository with the given owner and name
+func GetRepository(owner, name string) (Repository, error) {
+	row := db.QueryRow(`SELECT `+repositoryColumns+` FROM repositories WHERE owner = ? AND name = ?`, owner, name)
+	return scanRepository(row)
+}
+
+// SaveRepository inserts a repository or replaces the row with the same owner and name
+func SaveRepository(r Repository) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := saveRepository(tx, r); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// execer is implemented by *sql.DB and *sql.Tx
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// statement is a query with its arguments
+type statement struct {
+	query string
+	args  []any
+}
+
+// execStatements executes statements in order and stops at the first error
+func execStatements(e execer, statements []statement) error {
+	for _, s := range statements {
+		if _, err := e.Exec(s.query, s.args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func saveRepository(e execer, r Repository) error {
+	if r.Topics == nil {
+		r.Topics = []string{}
+	}
+	topics, err := json.Marshal(r.Topics)
+	if err != nil {
+		return err
+	}
+	if r.Languages == nil {
+		r.Languages = make(map[string]float64)
+	}
+	languages, err := json.Marshal(r.Languages)
+	if err != nil {
+		return err
+	}
+	mirrorPassword, err := encryptSecret(r.MirrorPassword)
+	if err != nil {
+		return err
+	}
+	_, err = e.Exec(`INSERT INTO repositories
+		(owner, name, public, description, website, topics, is_template, stars_count, forks_count, last_commit, languages, created_at, updated_at, default_branch, forked_from,
+			mirror_url, mirror_username, mirror_password, mirror_interval, mirror_synced_at, mirror_error, size, push_policy)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (owner, name) DO UPDATE SET
+			public = excluded.public,
+			description = excluded.description,
+			website = excluded.website,
+			topics = excluded.topics,
+			is_template = excluded.is_template,
+			stars_count = excluded.stars_count,
+			forks_count = excluded.forks_count,
+			last_commit = excluded.last_commit,
+			languages = excluded.languages,
+			created_at = excluded.created_at,
+			updated_at = excluded.updated_at,
+			default_branch = excluded.default_branch,
+			forked_from = excluded.forked_from,
+			mirror_url = excluded.mirror_url,
+			mirror_username = excluded.mirror_username,
+			mirror_password = excluded.mirror_password,
+			mirror_interval = excluded.mirror_interval,
+			mirror_synced_at = excluded.mirror_synced_at,
+			mirror_error = excluded.mirror_error,
+			size = excluded.size,
+			push_policy = excluded.push_policy`,
+		r.Owner, r.Name, boolToInt(r.Public), r.Description, r.Website, string(topics), boolToInt(r.IsTemplate), r.StarsCount, r.ForksCount,
+		r.LastCommit, string(languages), r.CreatedAt.UTC(), r.UpdatedAt.UTC(), r.DefaultBranch, r.ForkedFrom,
+		r.MirrorURL, r.MirrorUsername, mirrorPassword, int64(r.MirrorInterval/time.Second), nullTime(r.MirrorSyncedAt), r.MirrorError, r.Size, r.PushPolicy)
+	if err != nil {
+		return err
+	}
+	// A name that is taken again no longer redirects
+	_, err = e.Exec(`DELETE FROM repo_redirects WHERE owner = ? AND name = ?`, r.Owner, r.Name)
+	return err
+}
+
+// UpdateRepository loads a repository, applies update and saves it in one
+// transaction, so concurrent updates of the same repository cannot lose writes
+func UpdateRepository(owner, name string, update func(r *Repository) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	row := tx.QueryRow(`SELECT `+repositoryColumns+` FROM repositories WHERE owner = ? AND name = ?`, owner, name)
+	r, err := scanRepository(row)
+	if err != nil {
+		return err
+	}
+	if err := update(&r); err != nil {
+		return err
+	}
+	// The key cannot be changed by an update
+	r.Owner, r.Name = owner, name
+	if err := saveRepository(tx, r); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RenameRepository moves a repository to a new owner and name in one transaction.
+// Stars, forks, push mirrors, webhooks, LFS objects, LFS locks and redirects follow it, and the old name redirects to the new one.
+func RenameRepository(owner, name, newOwner, newName string) error {
+	oldFullName, newFullName := owner+"/"+name, newOwner+"/"+newName
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Callers make sure nothing exists at the new name, so a row there is left
+	// over from a repository whose directory is gone
+	err = execStatements(tx, []statement{
+		{`DELETE FROM repositories WHERE owner = ? AND name = ?`, []any{newOwner, newName}},
+		{`DELETE FROM push_mirrors WHERE repo = ?`, []any{newFullName}},
+		{`DELETE FROM webhook_deliveries WHERE webhook_id IN (SELECT id FROM webhooks WHERE repo = ?)`, []any{newFullName}},
+		{`DELETE FROM webhooks WHERE repo = ?`, []any{newFullName}},
+		{`DELETE FROM lfs_objects WHERE repo = ?`, []any{newFullName}},
+		{`DELETE FROM lfs_locks WHERE repo = ?`, []any{newFullName}},
+	})
+	if err != nil {
+		return err
+	}
+	res, err := tx.Exec(`UPDATE repositories SET owner = ?, name = ? WHERE owner = ? AND name = ?`, newOwner, newName, owner, name)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrRepositoryNotFound
+	}
+
+	err = execStatements(tx, []statement{
+		{`UPDATE repositories SET forked_from = ? WHERE forked_from = ?`, []any{newFullName, oldFullName}},
+		{`UPDATE OR REPLACE stars SET repo = ? WHERE repo = ?`, []any{newFullName, oldFullName}},
+		{`UPDATE push_mirrors SET repo = ? WHERE repo = ?`, []any{newFullName, oldFullName}},
+		{`UPDATE webhooks SET repo = ? WHERE repo = ?`, []any{newFullName, oldFullName}},
+		{`UPDATE lfs_objects SET repo = ? WHERE repo = ?`, []any{newFullName, oldFullName}},
+		{`UPDATE lfs_locks SET repo = ? WHERE repo = ?`, []any{newFullName, oldFullName}},
+		// Older names point straight to the new one instead of chaining
+		{`UPDATE repo_redirects SET target = ? WHERE target = ?`, []any{newFullName, oldFullName}},
+		{`DELETE FROM repo_redirects WHERE owner = ? AND name = ?`, []any{newOwner, newName}},
+		{`INSERT OR REPLACE INTO repo_redirects (owner, name, target, created_at) VALUES (?, ?, ?, ?)`,
+			[]any{owner, name, newFullName, time.Now().UTC()}},
+	})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteRepository removes a repository with its stars, push mirrors, webhooks,
+// LFS objects, LFS locks and redirects. Its forks are detached and the forks count of its
+// parent is decremented.
+func DeleteRepository(owner, name string) error {
+	fullName := owner + "/" + name
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var forkedFrom string
+	err = tx.QueryRow(`SELECT forked_from FROM repositories WHERE owner = ? AND name = ?`, owner, name).Scan(&forkedFrom)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrRepositoryNotFound
+	} else if err != nil {
+		return err
+	}
+
+	err = execStatements(tx, []statement{
+		{`DELETE FROM repositories WHERE owner = ? AND name = ?`, []any{owner, name}},
+		{`DELETE FROM stars WHERE repo = ?`, []any{fullName}},
+		{`DELETE FROM push_mirrors WHERE repo = ?`, []any{fullName}},
+		{`DELETE FROM webhook_deliveries WHERE webhook_id IN (SELECT id FROM webhooks WHERE repo = ?)`, []any{fullName}},
+		{`DELETE FROM webhooks WHERE repo = ?`, []any{fullName}},
+		{`DELETE FROM lfs_objects WHERE repo = ?`, []any{fullName}},
+		{`DELETE FROM lfs_locks WHERE repo = ?`, []any{fullName}},
+		{`DELETE FROM repo_redirects WHERE target = ?`, []any{fullName}},
+		{`UPDATE repositories SET forked_from = '' WHERE forked_from = ?`, []any{fullName}},
+		{`UPDATE repositories SET forks_count = MAX(forks_count - 1, 0) WHERE owner || '/' || name = ?`, []any{forkedFrom}},
+	})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetRepoRedirect returns the "{owner}/{name}" a renamed or transferred
+// repository moved to, if it was moved after since
+func GetRepoRedirect(owner, name string, since time.Time) (string, error) {
+	var target string
+	err := db.QueryRow(`SELECT target FROM repo_redirects WHERE owner = ? AND name = ? AND created_at > ?`,
+		owner, name, since.UTC()).Scan(&target)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrRepositoryNotFound
+	}
+	return target, err
+}
+
+// ListMirrors returns all pull mirrors
+func ListMirrors() ([]Repository, error) {
+	return queryRepositories(`SELECT ` + repositoryColumns + ` FROM repositories WHERE mirror_url != '' ORDER BY id`)
+}
+
+// ListForkedRepositories returns the direct forks of a repository, oldest first
+func ListForkedRepositories(forkedFrom string) ([]Repository, error) {
+	return queryRepositories(`SELECT `+repositoryColumns+` FROM repositories WHERE forked_from = ? ORDER BY created_at, id`, forkedFrom)
+}
+
+func queryRepositories(query string, args ...any) ([]Repository, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	repos := []Repository{}
+	for rows.Next() {
+		r, err := scanRepository(rows)
+		if err != nil {
+			return nil, err
+		}
+		repos = append(repos, r)
+	}
+	return repos, rows.Err()
+}
+
+// RepositorySearch selects and orders the repositories returned by SearchRepositories
+type RepositorySearch struct {
+	Query  string // Case-insensitive substring of "{owner}/{name}", all if empty
+	Owner  string // Only repositories of this owner, all if empty
+	Viewer string // Private repositories of this user are included
+	Sort   string // "stars", "created" or "updated" (default)
+	Asc    bool   // Oldest or least starred first
+	Limit  int
+	Offset int
+}
+
+// repositorySortColumns maps RepositorySearch.Sort to columns
+var repositorySortColumns = map[string]string{
+	"stars":   "stars_count",
+	"created": "created_at",
+	"updated": "updated_at",
+	"":        "updated_at",
+}
+
+// SearchRepositories returns a page of the repositories matching s and the total
+// number of matches. Private repositories are only included for their owner.
+func SearchRepositories(s RepositorySearch) ([]Repository, int, error) {
+	column, ok := repositorySortColumns[s.Sort]
+	if !ok {
+		return nil, 0, fmt.Errorf("invalid sort %q", s.Sort)
+	}
+	order := "DESC"
+	if s.Asc {
+		order = "ASC"
+	}
+
+	where := `(public = 1 OR owner = ?)`
+	args := []any{s.Viewer}
+	if s.Owner != "" {
+		where += ` AND owner = ?`
+		args = append(args, s.Owner)
+	}
+	if s.Query != "" {
+		where += ` AND owner || '/' || name LIKE ? ESCAPE '\'`
+		args = append(args, "%"+escapeLike(s.Query)+"%")
+	}
+
+	var total int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM repositories WHERE `+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := db.Query(`SELECT `+repositoryColumns+` FROM repositories WHERE `+where+
+		` ORDER BY `+column+` `+order+`, id `+order+` LIMIT ? OFFSET ?`, append(args, s.Limit, s.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	repos := []Repository{}
+	for rows.Next() {
+		r, err := scanRepository(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		repos = append(repos, r)
+	}
+	return repos, total, rows.Err()
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern with a backslash
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
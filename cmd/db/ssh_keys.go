@@ -0,0 +1,127 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrSSHKeyNotFound is returned when no SSH key matches
+	ErrSSHKeyNotFound = errors.New("SSH key not found")
+	// ErrSSHKeyExists is returned when adding a key that is already added, by any user
+	ErrSSHKeyExists = errors.New("SSH key is already in use")
+)
+
+// SSHKey is a public key a user authenticates with to the SSH server
+type SSHKey struct {
+	ID          int64
+	UserID      int
+	Username    string // Filled from the users table
+	Name        string
+	Fingerprint string // SHA256 fingerprint, e.g. "SHA256:..."
+	PublicKey   string // In authorized_keys format without comment
+	CreatedAt   time.Time
+	LastUsedAt  time.Time // Zero if never used
+}
+
+const sshKeyColumns = `k.id, k.user_id, u.username, k.name, k.fingerprint, k.public_key, k.created_at, k.last_used_at`
+
+// createSSHKeysTable creates the ssh_keys table. A key identifies its user, so
+// fingerprints are unique across all users.
+func createSSHKeysTable() error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS ssh_keys (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		name TEXT NOT NULL,
+		fingerprint TEXT UNIQUE NOT NULL,
+		public_key TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		last_used_at TIMESTAMP
+	)`)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS ssh_keys_user ON ssh_keys (user_id)`)
+	return err
+}
+
+func scanSSHKey(row rowScanner) (SSHKey, error) {
+	var k SSHKey
+	var lastUsedAt sql.NullTime
+	err := row.Scan(&k.ID, &k.UserID, &k.Username, &k.Name, &k.Fingerprint, &k.PublicKey, &k.CreatedAt, &lastUsedAt)
+	if err != nil {
+		return SSHKey{}, err
+	}
+	k.LastUsedAt = lastUsedAt.Time
+	return k, nil
+}
+
+// CreateSSHKey stores a new key and sets its ID and CreatedAt. It returns
+// ErrSSHKeyExists if a key with the same fingerprint was already added.
+func CreateSSHKey(k *SSHKey) error {
+	k.CreatedAt = time.Now()
+	res, err := db.Exec(`INSERT INTO ssh_keys (user_id, name, fingerprint, public_key, created_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (fingerprint) DO NOTHING`,
+		k.UserID, k.Name, k.Fingerprint, k.PublicKey, k.CreatedAt.UTC())
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrSSHKeyExists
+	}
+	k.ID, err = res.LastInsertId()
+	return err
+}
+
+// ListSSHKeys returns the keys of a user, oldest first
+func ListSSHKeys(userID int) ([]SSHKey, error) {
+	rows, err := db.Query(`SELECT `+sshKeyColumns+` FROM ssh_keys k JOIN users u ON u.id = k.user_id
+		WHERE k.user_id = ? ORDER BY k.id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []SSHKey{}
+	for rows.Next() {
+		k, err := scanSSHKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// GetSSHKeyByFingerprint returns the key with a fingerprint and its user
+func GetSSHKeyByFingerprint(fingerprint string) (SSHKey, error) {
+	k, err := scanSSHKey(db.QueryRow(`SELECT `+sshKeyColumns+` FROM ssh_keys k JOIN users u ON u.id = k.user_id
+		WHERE k.fingerprint = ?`, fingerprint))
+	if errors.Is(err, sql.ErrNoRows) {
+		return SSHKey{}, ErrSSHKeyNotFound
+	}
+	return k, err
+}
+
+// TouchSSHKey records that a key was used to authenticate
+func TouchSSHKey(id int64) error {
+	_, err := db.Exec(`UPDATE ssh_keys SET last_used_at = ? WHERE id = ?`, time.Now().UTC(), id)
+	return err
+}
+
+// DeleteSSHKey removes a key of a user
+func DeleteSSHKey(userID int, id int64) error {
+	res, err := db.Exec(`DELETE FROM ssh_keys WHERE user_id = ? AND id = ?`, userID, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrSSHKeyNotFound
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package db
+
+import (
+	"time"
+)
+
+// Star is a user's star on a repository
+type Star struct {
+	Username  string
+	Repo      string // "{owner}/{name}"
+	CreatedAt time.Time
+}
+
+// StarRepo stars a repository for a user. It reports false if it was already starred.
+func StarRepo(userID int, repo string) (bool, error) {
+	res, err := db.Exec(`INSERT OR IGNORE INTO stars (user_id, repo, created_at) VALUES (?, ?, ?)`, userID, repo, time.Now().UTC())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// UnstarRepo removes a user's star from a repository. It reports false if it was not starred.
+func UnstarRepo(userID int, repo string) (bool, error) {
+	res, err := db.Exec(`DELETE FROM stars WHERE user_id = ? AND repo = ?`, userID, repo)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// IsStarred reports whether a user starred a repository
+func IsStarred(userID int, repo string) (bool, error) {
+	var n int
+	err := db.QueryRow(`SELECT COUNT(*) FROM stars WHERE user_id = ? AND repo = ?`, userID, repo).Scan(&n)
+	return n > 0, err
+}
+
+// CountStars returns the number of users who starred a repository
+func CountStars(repo string) (int, error) {
+	var n int
+	err := db.QueryRow(`SELECT COUNT(*) FROM stars WHERE repo = ?`, repo).Scan(&n)
+	return n, err
+}
+
+// ListStargazers returns the stars of a repository, newest first
+func ListStargazers(repo string) ([]Star, error) {
+	return queryStars(`SELECT u.username, s.repo, s.created_at FROM stars s JOIN users u ON u.id = s.user_id
+		WHERE s.repo = ? ORDER BY s.created_at DESC`, repo)
+}
+
+// ListStarredRepos returns the stars of a user, newest first
+func ListStarredRepos(userID int) ([]Star, error) {
+	return queryStars(`SELECT u.username, s.repo, s.created_at FROM stars s JOIN users u ON u.id = s.user_id
+		WHERE s.user_id = ? ORDER BY s.created_at DESC`, userID)
+}
+
+func queryStars(query string, args ...any) ([]Star, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stars := []Star{}
+	for rows.Next() {
+		var s Star
+		if err := rows.Scan(&s.Username, &s.Repo, &s.CreatedAt); err != nil {
+			return nil, err
+		}
+		stars = append(stars, s)
+	}
+	return stars, rows.Err()
+}
@@ -3,6 +3,7 @@ package db
 import (
 	"database/sql"
 	"errors"
+	"strings"
 
 	_ "github.com/mattn/go-sqlite3"
 	"golang.org/x/crypto/bcrypt"
@@ -12,6 +13,14 @@ var db *sql.DB
 
 // InitDB initializes the SQLite database and creates tables if needed
 func InitDB(dataSourceName string) error {
+	// Wait for locks instead of failing, and take the write lock when a
+	// transaction begins so read-modify-write transactions cannot deadlock
+	sep := "?"
+	if strings.Contains(dataSourceName, "?") {
+		sep = "&"
+	}
+	dataSourceName += sep + "_busy_timeout=5000&_txlock=immediate"
+
 	var err error
 	db, err = sql.Open("sqlite3", dataSourceName)
 	if err != nil {
@@ -28,7 +37,36 @@ func InitDB(dataSourceName string) error {
 	if err != nil {
 		return err
 	}
-	return err
+	// Create stars table, one row per user and starred repository ("{owner}/{name}")
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS stars (
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		repo TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (user_id, repo)
+	)`)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS stars_repo ON stars (repo)`)
+	if err != nil {
+		return err
+	}
+	if err := createRepositoriesTable(); err != nil {
+		return err
+	}
+	if err := createPushMirrorsTable(); err != nil {
+		return err
+	}
+	if err := createLFSTables(); err != nil {
+		return err
+	}
+	if err := createRepoMigrationsTable(); err != nil {
+		return err
+	}
+	if err := createSSHKeysTable(); err != nil {
+		return err
+	}
+	return createWebhooksTable()
 }
 
 // User represents a user account
@@ -81,6 +119,18 @@ func GetUserByToken(token string) (User, error) {
 	return u, nil
 }
 
+// GetUserByUsername returns a user by username
+func GetUserByUsername(username string) (User, error) {
+	var u User
+	row := db.QueryRow(`SELECT id, username, password_hash, token, is_admin FROM users WHERE username = ?`, username)
+	var isAdminInt int
+	if err := row.Scan(&u.ID, &u.Username, &u.PasswordHash, &u.Token, &isAdminInt); err != nil {
+		return User{}, errors.New("user not found")
+	}
+	u.IsAdmin = isAdminInt != 0
+	return u, nil
+}
+
 // GetUserByBearerToken checks for Bearer token in Authorization header
 func GetUserByBearerToken(authHeader string) (User, error) {
 	if len(authHeader) < 8 || authHeader[:7] != "Bearer " {
@@ -0,0 +1,282 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrWebhookNotFound is returned when no webhook matches
+	ErrWebhookNotFound = errors.New("webhook not found")
+	// ErrWebhookDeliveryNotFound is returned when no delivery of a webhook matches
+	ErrWebhookDeliveryNotFound = errors.New("webhook delivery not found")
+)
+
+// maxWebhookDeliveries is the number of deliveries kept per webhook, older
+// ones are removed when new ones are recorded
+const maxWebhookDeliveries = 100
+
+// Webhook is a URL that events of a repository are posted to. Secret signs
+// the payloads and is stored encrypted with the secret key, see LoadSecretKey.
+type Webhook struct {
+	ID          int64
+	Repo        string // "{owner}/{name}"
+	URL         string
+	Secret      string
+	ContentType string   // "json" or "form"
+	Events      []string // e.g. "push", "create", "delete", "repository"
+	Active      bool
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// WebhookDelivery records one attempt to post an event to a webhook
+type WebhookDelivery struct {
+	ID             int64
+	WebhookID      int64
+	GUID           string // Shared by the retries and redeliveries of an event
+	Event          string
+	Attempt        int  // 1 for the first try of an event or a redelivery
+	Redelivery     bool // Sent by hand instead of for an event
+	RequestHeaders map[string]string
+	RequestBody    string
+	StatusCode     int    // 0 if no response was received
+	ResponseBody   string // The start of the response only
+	Error          string // Empty if the receiver answered with a 2xx status
+	Duration       time.Duration
+	DeliveredAt    time.Time
+}
+
+const webhookColumns = `id, repo, url, secret, content_type, events, active, created_at, updated_at`
+
+const webhookDeliveryColumns = `id, webhook_id, guid, event, attempt, redelivery, request_headers, request_body,
+	status_code, response_body, error, duration_ms, delivered_at`
+
+// createWebhooksTable creates the webhooks and webhook_deliveries tables
+func createWebhooksTable() error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS webhooks (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		repo TEXT NOT NULL,
+		url TEXT NOT NULL,
+		secret TEXT NOT NULL DEFAULT '',
+		content_type TEXT NOT NULL DEFAULT 'json',
+		events TEXT NOT NULL DEFAULT '',
+		active BOOLEAN NOT NULL DEFAULT 1,
+		created_at TIMESTAMP NOT NULL,
+		updated_at TIMESTAMP NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+	if _, err = db.Exec(`CREATE INDEX IF NOT EXISTS webhooks_repo ON webhooks (repo)`); err != nil {
+		return err
+	}
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		webhook_id INTEGER NOT NULL,
+		guid TEXT NOT NULL,
+		event TEXT NOT NULL,
+		attempt INTEGER NOT NULL DEFAULT 1,
+		redelivery BOOLEAN NOT NULL DEFAULT 0,
+		request_headers TEXT NOT NULL DEFAULT '{}',
+		request_body TEXT NOT NULL DEFAULT '',
+		status_code INTEGER NOT NULL DEFAULT 0,
+		response_body TEXT NOT NULL DEFAULT '',
+		error TEXT NOT NULL DEFAULT '',
+		duration_ms INTEGER NOT NULL DEFAULT 0,
+		delivered_at TIMESTAMP NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook ON webhook_deliveries (webhook_id, id)`)
+	return err
+}
+
+func scanWebhook(row rowScanner) (Webhook, error) {
+	var h Webhook
+	var secret, events string
+	err := row.Scan(&h.ID, &h.Repo, &h.URL, &secret, &h.ContentType, &events, &h.Active, &h.CreatedAt, &h.UpdatedAt)
+	if err != nil {
+		return Webhook{}, err
+	}
+	if h.Secret, err = decryptSecret(secret); err != nil {
+		return Webhook{}, err
+	}
+	h.Events = []string{}
+	if events != "" {
+		h.Events = strings.Split(events, ",")
+	}
+	return h, nil
+}
+
+// CreateWebhook stores a new webhook and sets its ID, CreatedAt and UpdatedAt
+func CreateWebhook(h *Webhook) error {
+	secret, err := encryptSecret(h.Secret)
+	if err != nil {
+		return err
+	}
+	h.CreatedAt = time.Now()
+	h.UpdatedAt = h.CreatedAt
+	res, err := db.Exec(`INSERT INTO webhooks (repo, url, secret, content_type, events, active, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		h.Repo, h.URL, secret, h.ContentType, strings.Join(h.Events, ","), h.Active, h.CreatedAt.UTC(), h.UpdatedAt.UTC())
+	if err != nil {
+		return err
+	}
+	h.ID, err = res.LastInsertId()
+	return err
+}
+
+// GetWebhook returns a webhook of a repository by ID
+func GetWebhook(repo string, id int64) (Webhook, error) {
+	h, err := scanWebhook(db.QueryRow(`SELECT `+webhookColumns+` FROM webhooks WHERE repo = ? AND id = ?`, repo, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Webhook{}, ErrWebhookNotFound
+	}
+	return h, err
+}
+
+// ListWebhooks returns the webhooks of a repository, oldest first
+func ListWebhooks(repo string) ([]Webhook, error) {
+	rows, err := db.Query(`SELECT `+webhookColumns+` FROM webhooks WHERE repo = ? ORDER BY id`, repo)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hooks := []Webhook{}
+	for rows.Next() {
+		h, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, h)
+	}
+	return hooks, rows.Err()
+}
+
+// UpdateWebhook saves the settings of a webhook and sets its UpdatedAt
+func UpdateWebhook(h *Webhook) error {
+	secret, err := encryptSecret(h.Secret)
+	if err != nil {
+		return err
+	}
+	h.UpdatedAt = time.Now()
+	res, err := db.Exec(`UPDATE webhooks SET url = ?, secret = ?, content_type = ?, events = ?, active = ?, updated_at = ?
+		WHERE repo = ? AND id = ?`,
+		h.URL, secret, h.ContentType, strings.Join(h.Events, ","), h.Active, h.UpdatedAt.UTC(), h.Repo, h.ID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrWebhookNotFound
+	}
+	return nil
+}
+
+// DeleteWebhook removes a webhook of a repository with its deliveries
+func DeleteWebhook(repo string, id int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`DELETE FROM webhooks WHERE repo = ? AND id = ?`, repo, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrWebhookNotFound
+	}
+	if _, err := tx.Exec(`DELETE FROM webhook_deliveries WHERE webhook_id = ?`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func scanWebhookDelivery(row rowScanner) (WebhookDelivery, error) {
+	var d WebhookDelivery
+	var headers string
+	var durationMs int64
+	err := row.Scan(&d.ID, &d.WebhookID, &d.GUID, &d.Event, &d.Attempt, &d.Redelivery, &headers, &d.RequestBody,
+		&d.StatusCode, &d.ResponseBody, &d.Error, &durationMs, &d.DeliveredAt)
+	if err != nil {
+		return WebhookDelivery{}, err
+	}
+	if err := json.Unmarshal([]byte(headers), &d.RequestHeaders); err != nil {
+		return WebhookDelivery{}, err
+	}
+	d.Duration = time.Duration(durationMs) * time.Millisecond
+	return d, nil
+}
+
+// CreateWebhookDelivery records a delivery and sets its ID. Only the latest
+// maxWebhookDeliveries deliveries of each webhook are kept.
+func CreateWebhookDelivery(d *WebhookDelivery) error {
+	headers, err := json.Marshal(d.RequestHeaders)
+	if err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`INSERT INTO webhook_deliveries (webhook_id, guid, event, attempt, redelivery, request_headers,
+		request_body, status_code, response_body, error, duration_ms, delivered_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		d.WebhookID, d.GUID, d.Event, d.Attempt, d.Redelivery, string(headers), d.RequestBody,
+		d.StatusCode, d.ResponseBody, d.Error, d.Duration.Milliseconds(), d.DeliveredAt.UTC())
+	if err != nil {
+		return err
+	}
+	if d.ID, err = res.LastInsertId(); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM webhook_deliveries WHERE webhook_id = ? AND id NOT IN
+		(SELECT id FROM webhook_deliveries WHERE webhook_id = ? ORDER BY id DESC LIMIT ?)`,
+		d.WebhookID, d.WebhookID, maxWebhookDeliveries)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetWebhookDelivery returns a delivery of a webhook by ID
+func GetWebhookDelivery(webhookID, id int64) (WebhookDelivery, error) {
+	d, err := scanWebhookDelivery(db.QueryRow(`SELECT `+webhookDeliveryColumns+` FROM webhook_deliveries
+		WHERE webhook_id = ? AND id = ?`, webhookID, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return WebhookDelivery{}, ErrWebhookDeliveryNotFound
+	}
+	return d, err
+}
+
+// ListWebhookDeliveries returns the deliveries of a webhook, newest first
+func ListWebhookDeliveries(webhookID int64) ([]WebhookDelivery, error) {
+	rows, err := db.Query(`SELECT `+webhookDeliveryColumns+` FROM webhook_deliveries
+		WHERE webhook_id = ? ORDER BY id DESC`, webhookID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		d, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
@@ -0,0 +1,204 @@
+package git
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// Supported archive formats
+const (
+	ArchiveTarGz = "tar.gz"
+	ArchiveTar   = "tar"
+	ArchiveZip   = "zip"
+)
+
+// ErrUnsupportedArchiveFormat is returned for archive formats other than tar.gz, tar and zip
+var ErrUnsupportedArchiveFormat = errors.New("unsupported archive format")
+
+// archiveWriter adds the files of a tree to an archive
+type archiveWriter interface {
+	addDir(name string, modTime time.Time) error
+	addFile(name string, mode filemode.FileMode, size int64, modTime time.Time, content io.Reader) error
+	Close() error
+}
+
+// SplitArchiveName splits "{ref}.{format}" into the ref and a supported format
+func SplitArchiveName(name string) (ref, format string, err error) {
+	for _, format := range []string{ArchiveTarGz, ArchiveTar, ArchiveZip} {
+		if ref, ok := strings.CutSuffix(name, "."+format); ok && ref != "" {
+			return ref, format, nil
+		}
+	}
+	return "", "", fmt.Errorf("%w: %s", ErrUnsupportedArchiveFormat, name)
+}
+
+// WriteArchive streams the tree of a revision to w as an archive in the given
+// format. All entries are placed below prefix, which should end with a slash.
+// File contents are copied blob by blob, so the archive is never held in memory.
+func WriteArchive(w io.Writer, repoPath, ref, format, prefix string) error {
+	r, err := openRepo(repoPath)
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	c, err := resolveRevision(r, ref)
+	if err != nil {
+		return err
+	}
+	tree, err := c.Tree()
+	if err != nil {
+		return fmt.Errorf("failed to get tree for commit %s: %w", c.Hash, err)
+	}
+
+	var aw archiveWriter
+	switch format {
+	case ArchiveTarGz:
+		aw = newTarArchive(w, true)
+	case ArchiveTar:
+		aw = newTarArchive(w, false)
+	case ArchiveZip:
+		aw = &zipArchive{zw: zip.NewWriter(w)}
+	default:
+		return fmt.Errorf("%w: %s", ErrUnsupportedArchiveFormat, format)
+	}
+
+	// Like git archive, every entry carries the commit time
+	modTime := c.Committer.When
+	if prefix != "" {
+		if err := aw.addDir(prefix, modTime); err != nil {
+			return err
+		}
+	}
+	err = tree.Files().ForEach(func(f *object.File) error {
+		reader, err := f.Reader()
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", f.Name, err)
+		}
+		defer reader.Close()
+		return aw.addFile(prefix+f.Name, f.Mode, f.Size, modTime, reader)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+
+	return aw.Close()
+}
+
+// tarArchive writes a tar archive, optionally gzip compressed
+type tarArchive struct {
+	tw *tar.Writer
+	gz *gzip.Writer
+}
+
+func newTarArchive(w io.Writer, compress bool) *tarArchive {
+	a := &tarArchive{}
+	if compress {
+		a.gz = gzip.NewWriter(w)
+		w = a.gz
+	}
+	a.tw = tar.NewWriter(w)
+	return a
+}
+
+func (a *tarArchive) addDir(name string, modTime time.Time) error {
+	return a.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     name,
+		Mode:     0755,
+		ModTime:  modTime,
+	})
+}
+
+func (a *tarArchive) addFile(name string, mode filemode.FileMode, size int64, modTime time.Time, content io.Reader) error {
+	hdr := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0644,
+		Size:     size,
+		ModTime:  modTime,
+	}
+	switch mode {
+	case filemode.Executable:
+		hdr.Mode = 0755
+	case filemode.Symlink:
+		// The blob holds the link target
+		target, err := io.ReadAll(content)
+		if err != nil {
+			return err
+		}
+		hdr.Typeflag = tar.TypeSymlink
+		hdr.Mode = 0777
+		hdr.Size = 0
+		hdr.Linkname = string(target)
+		return a.tw.WriteHeader(hdr)
+	}
+
+	if err := a.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := io.Copy(a.tw, content)
+	return err
+}
+
+func (a *tarArchive) Close() error {
+	if err := a.tw.Close(); err != nil {
+		return err
+	}
+	if a.gz != nil {
+		return a.gz.Close()
+	}
+	return nil
+}
+
+// zipArchive writes a zip archive
+type zipArchive struct {
+	zw *zip.Writer
+}
+
+func (a *zipArchive) addDir(name string, modTime time.Time) error {
+	hdr := &zip.FileHeader{Name: name, Modified: modTime}
+	hdr.SetMode(os.ModeDir | 0755)
+	_, err := a.zw.CreateHeader(hdr)
+	return err
+}
+
+func (a *zipArchive) addFile(name string, mode filemode.FileMode, size int64, modTime time.Time, content io.Reader) error {
+	hdr := &zip.FileHeader{Name: name, Modified: modTime, Method: zip.Deflate}
+	switch mode {
+	case filemode.Executable:
+		hdr.SetMode(0755)
+	case filemode.Symlink:
+		// Zip stores symlinks as entries containing the link target
+		hdr.SetMode(os.ModeSymlink | 0777)
+		hdr.Method = zip.Store
+	default:
+		hdr.SetMode(0644)
+	}
+
+	w, err := a.zw.CreateHeader(hdr)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, content)
+	return err
+}
+
+func (a *zipArchive) Close() error {
+	return a.zw.Close()
+}
+
+// ArchivePrefix returns the "{repo}-{ref}/" directory archives are placed in.
+// Slashes in the ref are replaced, so feature/x becomes repo-feature-x/.
+func ArchivePrefix(repoName, ref string) string {
+	return repoName + "-" + strings.ReplaceAll(ref, "/", "-") + "/"
+}
@@ -0,0 +1,101 @@
+package git
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteArchive(t *testing.T) {
+	t.Chdir(t.TempDir())
+	repoPath := filepath.Join("repos", "judy", "archive.git")
+	if err := CreateRepo(repoPath, "judy", true); err != nil {
+		t.Fatalf("CreateRepo failed: %v", err)
+	}
+	files := map[string]string{"README.md": "hello\n", "src/main.go": "package main\n"}
+	commitFiles(t, repoPath, "main", files)
+	prefix := ArchivePrefix("archive", "feature/x")
+	if prefix != "archive-feature-x/" {
+		t.Fatalf("Unexpected prefix %q", prefix)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteArchive(&buf, repoPath, "main", ArchiveTarGz, prefix); err != nil {
+		t.Fatalf("WriteArchive(tar.gz) failed: %v", err)
+	}
+	gz, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("gzip.NewReader failed: %v", err)
+	}
+	got := make(map[string]string)
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Reading tar failed: %v", err)
+		}
+		content, _ := io.ReadAll(tr)
+		got[hdr.Name] = string(content)
+	}
+	for name, content := range files {
+		if got[prefix+name] != content {
+			t.Errorf("tar.gz: %s = %q, want %q", prefix+name, got[prefix+name], content)
+		}
+	}
+	if _, ok := got[prefix]; !ok {
+		t.Errorf("tar.gz: missing prefix directory %s", prefix)
+	}
+
+	buf.Reset()
+	if err := WriteArchive(&buf, repoPath, "main", ArchiveZip, prefix); err != nil {
+		t.Fatalf("WriteArchive(zip) failed: %v", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("zip.NewReader failed: %v", err)
+	}
+	for name, content := range files {
+		f, err := zr.Open(prefix + name)
+		if err != nil {
+			t.Errorf("zip: missing %s: %v", prefix+name, err)
+			continue
+		}
+		data, _ := io.ReadAll(f)
+		f.Close()
+		if string(data) != content {
+			t.Errorf("zip: %s = %q, want %q", prefix+name, data, content)
+		}
+	}
+
+	if err := WriteArchive(io.Discard, repoPath, "main", "rar", prefix); !errors.Is(err, ErrUnsupportedArchiveFormat) {
+		t.Errorf("Expected ErrUnsupportedArchiveFormat, got %v", err)
+	}
+}
+
+func TestSplitArchiveName(t *testing.T) {
+	tests := map[string][2]string{
+		"main.tar.gz":      {"main", ArchiveTarGz},
+		"v1.0.tar":         {"v1.0", ArchiveTar},
+		"feature/x.zip":    {"feature/x", ArchiveZip},
+		"release.1.tar.gz": {"release.1", ArchiveTarGz},
+	}
+	for name, want := range tests {
+		ref, format, err := SplitArchiveName(name)
+		if err != nil || ref != want[0] || format != want[1] {
+			t.Errorf("SplitArchiveName(%q) = %q, %q, %v; want %q, %q", name, ref, format, err, want[0], want[1])
+		}
+	}
+	for _, name := range []string{"main", "main.rar", ".zip"} {
+		if _, _, err := SplitArchiveName(name); !errors.Is(err, ErrUnsupportedArchiveFormat) {
+			t.Errorf("SplitArchiveName(%q): expected ErrUnsupportedArchiveFormat, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// MaxBlameSize is the largest file (in bytes) GetBlame accepts
+	MaxBlameSize = 1 << 20
+	// blameTimeout bounds how long a single blame may run
+	blameTimeout = 30 * time.Second
+)
+
+// ErrFileTooLarge is returned when a file exceeds a size limit
+var ErrFileTooLarge = errors.New("file too large")
+
+// BlameRange is a run of consecutive lines last changed by the same commit
+type BlameRange struct {
+	Commit       string
+	Author       string
+	AuthorEmail  string
+	AuthoredAt   time.Time
+	Summary      string   // First line of the commit message
+	StartLine    int      // First line (1-based) in the file at the blamed revision
+	OriginalLine int      // First line (1-based) in the file at Commit
+	OriginalPath string   // Path of the file at Commit, differs after renames
+	Lines        []string // Line contents without the trailing newline
+}
+
+// blameCommit holds the commit details git blame prints once per commit
+type blameCommit struct {
+	author, email, summary, path string
+	authoredAt                   time.Time
+}
+
+// GetBlame returns the line ranges of a file at a revision with the commit that
+// last changed them. Files larger than MaxBlameSize are rejected with ErrFileTooLarge.
+func GetBlame(ctx context.Context, repoPath, ref, filePath string) ([]BlameRange, error) {
+	r, err := openRepo(repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	c, err := resolveRevision(r, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := c.File(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("file %s not found in commit %s: %w", filePath, ref, err)
+	}
+	if f.Size > MaxBlameSize {
+		return nil, fmt.Errorf("%w: %s is %d bytes, blame is limited to %d", ErrFileTooLarge, filePath, f.Size, MaxBlameSize)
+	}
+
+	// go-git's blame does not report original line numbers, so use git itself
+	ctx, cancel := context.WithTimeout(ctx, blameTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "git", "--git-dir", repoPath, "blame", "--porcelain", c.Hash.String(), "--", filePath)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("failed to blame %s: %w", filePath, ctx.Err())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to blame %s: %w: %s", filePath, err, strings.TrimSpace(stderr.String()))
+	}
+
+	return parseBlamePorcelain(out)
+}
+
+// parseBlamePorcelain parses the output of git blame --porcelain into ranges
+func parseBlamePorcelain(out []byte) ([]BlameRange, error) {
+	commits := make(map[string]*blameCommit)
+	var ranges []BlameRange
+	var current *blameCommit
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	scanner.Buffer(make([]byte, 64*1024), MaxBlameSize+1)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		// Line contents are prefixed with a tab
+		if content, ok := strings.CutPrefix(line, "\t"); ok {
+			if len(ranges) == 0 {
+				return nil, errors.New("unexpected blame line before header")
+			}
+			last := &ranges[len(ranges)-1]
+			last.Lines = append(last.Lines, content)
+			continue
+		}
+
+		key, value, _ := strings.Cut(line, " ")
+		if len(key) == 40 && isHexString(key) {
+			// <hash> <original line> <final line> [<lines in group>]
+			fields := strings.Fields(value)
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("invalid blame header %q", line)
+			}
+			current = commits[key]
+			if current == nil {
+				current = &blameCommit{}
+				commits[key] = current
+			}
+			if len(fields) == 3 {
+				orig, err1 := strconv.Atoi(fields[0])
+				final, err2 := strconv.Atoi(fields[1])
+				if err1 != nil || err2 != nil {
+					return nil, fmt.Errorf("invalid blame header %q", line)
+				}
+				ranges = append(ranges, BlameRange{Commit: key, StartLine: final, OriginalLine: orig})
+			}
+			continue
+		}
+		if current == nil {
+			return nil, fmt.Errorf("unexpected blame line %q", line)
+		}
+
+		switch key {
+		case "author":
+			current.author = value
+		case "author-mail":
+			current.email = strings.TrimSuffix(strings.TrimPrefix(value, "<"), ">")
+		case "author-time":
+			if sec, err := strconv.ParseInt(value, 10, 64); err == nil {
+				current.authoredAt = time.Unix(sec, 0).UTC()
+			}
+		case "summary":
+			current.summary = value
+		case "filename":
+			// A commit touching several paths prints the path for each group
+			current.path = value
+			if len(ranges) > 0 {
+				ranges[len(ranges)-1].OriginalPath = value
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read blame output: %w", err)
+	}
+
+	// Commit details are only printed the first time a commit appears
+	for i := range ranges {
+		c := commits[ranges[i].Commit]
+		ranges[i].Author = c.author
+		ranges[i].AuthorEmail = c.email
+		ranges[i].AuthoredAt = c.authoredAt
+		ranges[i].Summary = c.summary
+		if ranges[i].OriginalPath == "" {
+			ranges[i].OriginalPath = c.path
+		}
+	}
+
+	return ranges, nil
+}
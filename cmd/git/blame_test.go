@@ -0,0 +1,73 @@
+package git
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+func TestGetBlame(t *testing.T) {
+	t.Chdir(t.TempDir())
+	repoPath := filepath.Join("repos", "ivan", "blame.git")
+	if err := CreateRepo(repoPath, "ivan", true); err != nil {
+		t.Fatalf("CreateRepo failed: %v", err)
+	}
+
+	r, err := git.PlainOpen(repoPath)
+	if err != nil {
+		t.Fatalf("PlainOpen failed: %v", err)
+	}
+	t0 := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	commit := func(name string, hours int, files map[string]string, parents ...plumbing.Hash) plumbing.Hash {
+		sig := object.Signature{Name: name, Email: name + "@example.com", When: t0.Add(time.Duration(hours) * time.Hour)}
+		return writeTestCommitAs(t, r, sig, writeTestTree(t, r, files), parents...)
+	}
+
+	c1 := commit("alice", 0, map[string]string{"a.txt": "one\ntwo\nthree\n"})
+	c2 := commit("bob", 1, map[string]string{"a.txt": "one\nTWO\nthree\nfour\n"}, c1)
+	c3 := commit("carol", 2, map[string]string{"b.txt": "one\nTWO\nthree\nfour\n"}, c2)
+	if err := r.Storer.SetReference(plumbing.NewHashReference("refs/heads/main", c3)); err != nil {
+		t.Fatalf("SetReference failed: %v", err)
+	}
+
+	ranges, err := GetBlame(context.Background(), repoPath, "main", "b.txt")
+	if err != nil {
+		t.Fatalf("GetBlame failed: %v", err)
+	}
+	want := []BlameRange{
+		{Commit: c1.String(), Author: "alice", StartLine: 1, OriginalLine: 1, Lines: []string{"one"}},
+		{Commit: c2.String(), Author: "bob", StartLine: 2, OriginalLine: 2, Lines: []string{"TWO"}},
+		{Commit: c1.String(), Author: "alice", StartLine: 3, OriginalLine: 3, Lines: []string{"three"}},
+		{Commit: c2.String(), Author: "bob", StartLine: 4, OriginalLine: 4, Lines: []string{"four"}},
+	}
+	if len(ranges) != len(want) {
+		t.Fatalf("Expected %d ranges, got %+v", len(want), ranges)
+	}
+	for i, w := range want {
+		got := ranges[i]
+		if got.Commit != w.Commit || got.Author != w.Author || got.StartLine != w.StartLine ||
+			got.OriginalLine != w.OriginalLine || !slices.Equal(got.Lines, w.Lines) {
+			t.Errorf("Range %d: got %+v, want %+v", i, got, w)
+		}
+		if got.OriginalPath != "a.txt" || got.AuthorEmail != w.Author+"@example.com" || got.AuthoredAt.IsZero() {
+			t.Errorf("Range %d: unexpected details %+v", i, got)
+		}
+	}
+
+	if _, err := GetBlame(context.Background(), repoPath, "main", "missing.txt"); !errors.Is(err, object.ErrFileNotFound) {
+		t.Errorf("Expected ErrFileNotFound, got %v", err)
+	}
+
+	large := commit("alice", 3, map[string]string{"big.txt": strings.Repeat("x\n", MaxBlameSize/2+1)}, c3)
+	if _, err := GetBlame(context.Background(), repoPath, large.String(), "big.txt"); !errors.Is(err, ErrFileTooLarge) {
+		t.Errorf("Expected ErrFileTooLarge, got %v", err)
+	}
+}
@@ -3,7 +3,6 @@ package git
 import (
 	"fmt"
 
-	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
 )
 
@@ -24,7 +23,7 @@ type BlobSizeResult struct {
 // GetBlobSize returns the size of a blob in bytes given its hash
 func GetBlobSize(repoPath string, blobHash plumbing.Hash) (*BlobSizeResult, error) {
 	// Open the repository
-	r, err := git.PlainOpen(repoPath)
+	r, err := openRepo(repoPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open repository: %w", err)
 	}
@@ -44,16 +43,15 @@ func GetBlobSize(repoPath string, blobHash plumbing.Hash) (*BlobSizeResult, erro
 // GetFileBlobSize returns the size of a file at a specific commit
 func GetFileBlobSize(repoPath, filePath, commitHash string) (*BlobSizeResult, error) {
 	// Open the repository
-	r, err := git.PlainOpen(repoPath)
+	r, err := openRepo(repoPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open repository: %w", err)
 	}
 
-	// Get the commit
-	hash := plumbing.NewHash(commitHash)
-	commit, err := r.CommitObject(hash)
+	// Resolve the revision to a commit
+	commit, err := resolveRevision(r, commitHash)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get commit: %w", err)
+		return nil, err
 	}
 
 	// Get the file in the commit
@@ -71,7 +69,7 @@ func GetFileBlobSize(repoPath, filePath, commitHash string) (*BlobSizeResult, er
 
 // ReadBlob reads the content and size of a blob by its hash from the repository
 func ReadBlob(repoPath string, blobHash plumbing.Hash) (*Blob, error) {
-	r, err := git.PlainOpen(repoPath)
+	r, err := openRepo(repoPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open repository: %w", err)
 	}
@@ -2,14 +2,14 @@
 package git
 
 import (
+	"errors"
 	"fmt"
-	"mime"
-	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/go-git/go-git/v5"
-	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
 )
 
 // Commit represents a git commit with metadata
@@ -29,13 +29,18 @@ type Commit struct {
 // CommitFile represents a file changed in a commit
 type CommitFile struct {
 	Path        string
-	ChangeType  string // e.g., "Added", "Modified", "Deleted"
+	ChangeType  string // "added", "modified", "deleted", "renamed" or "copied"
 	ContentType string
+	OldPath     string // Source path of renamed and copied files
+	Similarity  int    // Similarity to OldPath in percent
+	Additions   int    // Number of added lines
+	Deletions   int    // Number of deleted lines
+	Binary      bool
 }
 
 // GetCommitHistory returns the commit history for a repository
 func GetCommitHistory(repoPath string) ([]*Commit, error) {
-	r, err := git.PlainOpen(repoPath)
+	r, err := openRepo(repoPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open repository: %w", err)
 	}
@@ -58,17 +63,7 @@ func GetCommitHistory(repoPath string) ([]*Commit, error) {
 
 	var commits []*Commit
 	err = cIter.ForEach(func(c *object.Commit) error {
-		commits = append(commits, &Commit{
-			Hash:           c.Hash.String(),
-			Author:         c.Author.Name,
-			AuthorEmail:    c.Author.Email,
-			Message:        c.Message,
-			Committer:      c.Committer.Name,
-			CommitterEmail: c.Committer.Email,
-			AuthoredAt:     c.Author.When,
-			CommittedAt:    c.Committer.When,
-			Parents:        getCommitParents(c),
-		})
+		commits = append(commits, newCommit(c))
 		return nil
 	})
 	if err != nil {
@@ -78,145 +73,229 @@ func GetCommitHistory(repoPath string) ([]*Commit, error) {
 	return commits, nil
 }
 
-// GetCommitByHash retrieves a specific commit by its hash
-func GetCommitByHash(repoPath, hash string) (*Commit, error) {
-	r, err := git.PlainOpen(repoPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open repository: %w", err)
-	}
-
-	h := plumbing.NewHash(hash)
+const (
+	// DefaultHistoryLimit is the page size used when HistoryOptions.Limit is 0
+	DefaultHistoryLimit = 30
+	// MaxHistoryLimit is the largest page size GetCommitHistoryPage returns
+	MaxHistoryLimit = 100
+)
 
-	c, err := r.CommitObject(h)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get commit object: %w", err)
-	}
+// ErrInvalidCursor is returned when a history cursor is not part of the walked history
+var ErrInvalidCursor = errors.New("invalid history cursor")
+
+// HistoryOptions filters and paginates the commit history
+type HistoryOptions struct {
+	From        string    // Revision to start from, HEAD when empty
+	Path        string    // Only commits touching this file or directory
+	Author      string    // Case-insensitive substring of the author name or email
+	Committer   string    // Case-insensitive substring of the committer name or email
+	Since       time.Time // Only commits committed at or after this time
+	Until       time.Time // Only commits committed at or before this time
+	FirstParent bool      // Only follow the first parent of merge commits
+	After       string    // Cursor: hash of the last commit of the previous page
+	Skip        int       // Number of matching commits to skip, for page-based pagination
+	Limit       int       // Page size, DefaultHistoryLimit when 0
+}
 
-	return &Commit{
-		Hash:           c.Hash.String(),
-		Author:         c.Author.Name,
-		AuthorEmail:    c.Author.Email,
-		Message:        c.Message,
-		Committer:      c.Committer.Name,
-		CommitterEmail: c.Committer.Email,
-		AuthoredAt:     c.Author.When,
-		CommittedAt:    c.Committer.When,
-		Parents:        getCommitParents(c),
-	}, nil
+// HistoryPage is a page of the commit history
+type HistoryPage struct {
+	Commits    []*Commit
+	NextCursor string // Pass as HistoryOptions.After to get the next page, empty on the last page
 }
 
-// GetCommitChanges returns the files changed in a specific commit
-func GetCommitChanges(repoPath, hash string) ([]CommitFile, error) {
-	r, err := git.PlainOpen(repoPath)
+// GetCommitHistoryPage returns one page of the filtered commit history, newest first
+func GetCommitHistoryPage(repoPath string, opts HistoryOptions) (*HistoryPage, error) {
+	r, err := openRepo(repoPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open repository: %w", err)
 	}
 
-	// Parse the hash
-	h := plumbing.NewHash(hash)
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = DefaultHistoryLimit
+	}
+	limit = min(limit, MaxHistoryLimit)
 
-	// Get the commit object
-	c, err := r.CommitObject(h)
+	from := opts.From
+	if from == "" {
+		if _, err := r.Head(); err != nil {
+			// If there's no HEAD, the repo is likely empty
+			return &HistoryPage{Commits: []*Commit{}}, nil
+		}
+		from = "HEAD"
+	}
+	start, err := resolveRevision(r, from)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get commit %s: %w", hash, err)
+		return nil, err
 	}
 
-	// Get parent commit to compare with
-	var parentCommit *object.Commit
-	if len(c.ParentHashes) > 0 {
-		parentCommit, err = c.Parent(0)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get parent commit: %w", err)
+	logOpts := &git.LogOptions{
+		From:  start.Hash,
+		Order: git.LogOrderCommitterTime,
+	}
+	if opts.Path != "" {
+		logOpts.PathFilter = pathMatcher(opts.Path)
+	}
+	if !opts.Since.IsZero() {
+		logOpts.Since = &opts.Since
+	}
+	if !opts.Until.IsZero() {
+		logOpts.Until = &opts.Until
+	}
+
+	page := &HistoryPage{Commits: []*Commit{}}
+	foundCursor := opts.After == ""
+	skipped := 0
+	err = walkHistory(r, start, logOpts, opts.FirstParent, func(c *object.Commit) error {
+		if !matchesSignature(c.Author, opts.Author) || !matchesSignature(c.Committer, opts.Committer) {
+			return nil
+		}
+		if !foundCursor {
+			foundCursor = c.Hash.String() == opts.After
+			return nil
+		}
+		if skipped < opts.Skip {
+			skipped++
+			return nil
 		}
+		if len(page.Commits) == limit {
+			// There is at least one more commit, so there is a next page
+			page.NextCursor = page.Commits[limit-1].Hash
+			return storer.ErrStop
+		}
+		page.Commits = append(page.Commits, newCommit(c))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to iterate commit history: %w", err)
+	}
+	if !foundCursor {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidCursor, opts.After)
 	}
 
-	var changes []CommitFile
-	if parentCommit == nil {
-		// For the first commit, get all files
-		fileIter, err := c.Files()
+	return page, nil
+}
+
+// walkHistory calls fn for each commit of the history selected by logOpts. In
+// first-parent mode only the first parents are followed and the filters of
+// logOpts are applied to each commit, since go-git's log cannot do that itself.
+func walkHistory(r *git.Repository, start *object.Commit, logOpts *git.LogOptions, firstParent bool, fn func(*object.Commit) error) error {
+	if !firstParent {
+		cIter, err := r.Log(logOpts)
 		if err != nil {
-			return nil, fmt.Errorf("failed to get files: %w", err)
+			return err
 		}
+		defer cIter.Close()
+		return cIter.ForEach(fn)
+	}
 
-		err = fileIter.ForEach(func(f *object.File) error {
-			contentType := mime.TypeByExtension(filepath.Ext(f.Name))
-			if contentType == "" {
-				contentType = "application/octet-stream"
+	for c := start; c != nil; {
+		var parent *object.Commit
+		if len(c.ParentHashes) > 0 {
+			p, err := r.CommitObject(c.ParentHashes[0])
+			if err != nil {
+				return err
 			}
-			changes = append(changes, CommitFile{
-				Path:        f.Name,
-				ChangeType:  "added",
-				ContentType: contentType,
-			})
-			return nil
-		})
-		if err != nil {
-			return nil, fmt.Errorf("error iterating files: %w", err)
-		}
-	} else {
-		// Get changes between this commit and parent
-		patch, err := parentCommit.Patch(c)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create patch: %w", err)
+			parent = p
 		}
 
-		for _, filePatch := range patch.FilePatches() {
-			from, to := filePatch.Files()
-
-			var path string
-			var changeType string
-			var contentType string
-
-			if from == nil {
-				// File was added
-				path = to.Path()
-				changeType = "added"
-				contentType = mime.TypeByExtension(filepath.Ext(path))
-			} else if to == nil {
-				// File was deleted
-				path = from.Path()
-				changeType = "deleted"
-				contentType = mime.TypeByExtension(filepath.Ext(path))
-			} else {
-				// File was modified
-				path = from.Path()
-				changeType = "modified"
-				contentType = mime.TypeByExtension(filepath.Ext(path))
+		when := c.Committer.When
+		include := (logOpts.Since == nil || !when.Before(*logOpts.Since)) &&
+			(logOpts.Until == nil || !when.After(*logOpts.Until))
+		if include && logOpts.PathFilter != nil {
+			touched, err := touchesPath(c, parent, logOpts.PathFilter)
+			if err != nil {
+				return err
 			}
-			if contentType == "" {
-				contentType = "application/octet-stream"
+			include = touched
+		}
+		if include {
+			if err := fn(c); err == storer.ErrStop {
+				return nil
+			} else if err != nil {
+				return err
 			}
+		}
 
-			// TODO: Calculate additions and deletions
-			// filePatch.Chunks() contains hunk information
-			// Need to iterate through chunks and count lines based on type
+		c = parent
+	}
+	return nil
+}
 
-			changes = append(changes, CommitFile{
-				Path:        path,
-				ChangeType:  changeType,
-				ContentType: contentType,
-				// Additions and Deletions would go here
-			})
+// touchesPath reports whether any file changed between parent and c matches the filter
+func touchesPath(c, parent *object.Commit, filter func(string) bool) (bool, error) {
+	tree, err := c.Tree()
+	if err != nil {
+		return false, err
+	}
+	var parentTree *object.Tree
+	if parent != nil {
+		if parentTree, err = parent.Tree(); err != nil {
+			return false, err
 		}
 	}
 
-	return changes, nil
+	changes, err := object.DiffTree(parentTree, tree)
+	if err != nil {
+		return false, err
+	}
+	for _, change := range changes {
+		if filter(change.From.Name) || filter(change.To.Name) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// pathMatcher matches a file path and everything below it when it is a directory
+func pathMatcher(path string) func(string) bool {
+	path = strings.Trim(path, "/")
+	return func(p string) bool {
+		return p != "" && (p == path || strings.HasPrefix(p, path+"/"))
+	}
+}
+
+// matchesSignature reports whether a signature's name or email contains the query
+func matchesSignature(sig object.Signature, query string) bool {
+	if query == "" {
+		return true
+	}
+	query = strings.ToLower(query)
+	return strings.Contains(strings.ToLower(sig.Name), query) ||
+		strings.Contains(strings.ToLower(sig.Email), query)
+}
+
+// GetCommitByHash retrieves a specific commit by its hash
+func GetCommitByHash(repoPath, hash string) (*Commit, error) {
+	r, err := openRepo(repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	c, err := resolveRevision(r, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	return newCommit(c), nil
+}
+
+// GetCommitChanges returns the files changed in a specific commit compared to its first parent
+func GetCommitChanges(repoPath, hash string) ([]CommitFile, error) {
+	return GetCommitChangesAgainst(repoPath, hash, 1)
 }
 
 // GetFileAtCommit returns the content of a file at a specific commit
 func GetFileAtCommit(repoPath, filePath, commitHash string) ([]byte, error) {
-	r, err := git.PlainOpen(repoPath)
+	r, err := openRepo(repoPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open repository: %w", err)
 	}
 
-	// Parse the hash
-	h := plumbing.NewHash(commitHash)
-
-	// Get the commit object
-	c, err := r.CommitObject(h)
+	// Resolve the revision to a commit
+	c, err := resolveRevision(r, commitHash)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get commit %s: %w", commitHash, err)
+		return nil, err
 	}
 
 	// Get the file from the commit tree
@@ -234,6 +313,21 @@ func GetFileAtCommit(repoPath, filePath, commitHash string) ([]byte, error) {
 	return []byte(content), nil
 }
 
+// newCommit converts a go-git commit object to a Commit without file changes
+func newCommit(c *object.Commit) *Commit {
+	return &Commit{
+		Hash:           c.Hash.String(),
+		Author:         c.Author.Name,
+		AuthorEmail:    c.Author.Email,
+		Message:        c.Message,
+		Committer:      c.Committer.Name,
+		CommitterEmail: c.Committer.Email,
+		AuthoredAt:     c.Author.When,
+		CommittedAt:    c.Committer.When,
+		Parents:        getCommitParents(c),
+	}
+}
+
 // getCommitParents extracts parent hashes from a commit object
 func getCommitParents(c *object.Commit) []string {
 	var parents []string
@@ -1,12 +1,15 @@
 package git
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
+	"slices"
 	"testing"
 	"time"
 
 	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/object"
 )
 
@@ -53,6 +56,103 @@ func TestGetCommitHistory(t *testing.T) {
 	}
 }
 
+func TestGetCommitHistoryPage(t *testing.T) {
+	t.Chdir(t.TempDir())
+	repoPath := filepath.Join("repos", "grace", "history.git")
+	if err := CreateRepo(repoPath, "grace", true); err != nil {
+		t.Fatalf("CreateRepo failed: %v", err)
+	}
+
+	page, err := GetCommitHistoryPage(repoPath, HistoryOptions{})
+	if err != nil || len(page.Commits) != 0 {
+		t.Fatalf("Expected empty history for empty repo, got %+v, %v", page, err)
+	}
+
+	r, err := git.PlainOpen(repoPath)
+	if err != nil {
+		t.Fatalf("PlainOpen failed: %v", err)
+	}
+	t0 := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	commit := func(name string, hours int, files map[string]string, parents ...plumbing.Hash) plumbing.Hash {
+		sig := object.Signature{Name: name, Email: name + "@example.com", When: t0.Add(time.Duration(hours) * time.Hour)}
+		return writeTestCommitAs(t, r, sig, writeTestTree(t, r, files), parents...)
+	}
+
+	// c1 - c2 - c4 - merge
+	//        \        /
+	//         c3 (topic)
+	c1 := commit("alice", 0, map[string]string{"a.txt": "1", "docs/x.md": "1"})
+	c2 := commit("bob", 1, map[string]string{"a.txt": "1", "docs/x.md": "2"}, c1)
+	c3 := commit("carol", 2, map[string]string{"a.txt": "1", "docs/x.md": "2", "b.txt": "b"}, c2)
+	c4 := commit("alice", 3, map[string]string{"a.txt": "4", "docs/x.md": "2"}, c2)
+	merge := commit("bob", 4, map[string]string{"a.txt": "4", "docs/x.md": "2", "b.txt": "b"}, c4, c3)
+	for name, hash := range map[string]plumbing.Hash{"main": merge, "topic": c3} {
+		if err := r.Storer.SetReference(plumbing.NewHashReference(plumbing.NewBranchReferenceName(name), hash)); err != nil {
+			t.Fatalf("SetReference failed: %v", err)
+		}
+	}
+
+	hashes := func(page *HistoryPage) []plumbing.Hash {
+		var out []plumbing.Hash
+		for _, c := range page.Commits {
+			out = append(out, plumbing.NewHash(c.Hash))
+		}
+		return out
+	}
+
+	tests := []struct {
+		name string
+		opts HistoryOptions
+		want []plumbing.Hash
+	}{
+		{"all", HistoryOptions{}, []plumbing.Hash{merge, c4, c3, c2, c1}},
+		{"from ref", HistoryOptions{From: "topic"}, []plumbing.Hash{c3, c2, c1}},
+		{"directory", HistoryOptions{Path: "docs"}, []plumbing.Hash{c2, c1}},
+		{"file", HistoryOptions{Path: "a.txt"}, []plumbing.Hash{c4, c1}},
+		{"author", HistoryOptions{Author: "ALICE"}, []plumbing.Hash{c4, c1}},
+		{"committer email", HistoryOptions{Committer: "bob@example"}, []plumbing.Hash{merge, c2}},
+		{"time range", HistoryOptions{Since: t0.Add(time.Hour), Until: t0.Add(3 * time.Hour)}, []plumbing.Hash{c4, c3, c2}},
+		{"first parent", HistoryOptions{FirstParent: true}, []plumbing.Hash{merge, c4, c2, c1}},
+		{"first parent path", HistoryOptions{FirstParent: true, Path: "b.txt"}, []plumbing.Hash{merge}},
+		{"skip", HistoryOptions{Skip: 2, Limit: 2}, []plumbing.Hash{c3, c2}},
+	}
+	for _, tt := range tests {
+		page, err := GetCommitHistoryPage(repoPath, tt.opts)
+		if err != nil {
+			t.Errorf("%s: GetCommitHistoryPage failed: %v", tt.name, err)
+			continue
+		}
+		if got := hashes(page); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// Follow the cursor through all pages
+	var all []plumbing.Hash
+	opts := HistoryOptions{Limit: 2}
+	for pages := 0; ; pages++ {
+		if pages > 3 {
+			t.Fatalf("Too many pages, cursor is not advancing")
+		}
+		page, err := GetCommitHistoryPage(repoPath, opts)
+		if err != nil {
+			t.Fatalf("GetCommitHistoryPage failed: %v", err)
+		}
+		all = append(all, hashes(page)...)
+		if page.NextCursor == "" {
+			break
+		}
+		opts.After = page.NextCursor
+	}
+	if want := []plumbing.Hash{merge, c4, c3, c2, c1}; !slices.Equal(all, want) {
+		t.Errorf("Paginated history: got %v, want %v", all, want)
+	}
+
+	if _, err := GetCommitHistoryPage(repoPath, HistoryOptions{After: "deadbeef"}); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("Expected ErrInvalidCursor, got %v", err)
+	}
+}
+
 func TestGetCommitByHash(t *testing.T) {
 	dir := t.TempDir()
 	repoPath, hash := createTestRepoWithCommit(t, dir)
@@ -0,0 +1,163 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// MaxCompareCommits caps the number of commits listed in a Comparison
+const MaxCompareCommits = 250
+
+// compareTimeout bounds the history walks of a comparison
+const compareTimeout = 30 * time.Second
+
+// ErrNoMergeBase is returned by a three-dot comparison of unrelated histories
+var ErrNoMergeBase = errors.New("no merge base")
+
+// Comparison is the result of comparing two revisions
+type Comparison struct {
+	Base      string // Resolved base commit hash
+	Head      string // Resolved head commit hash
+	MergeBase string // Best common ancestor, empty for unrelated histories
+	AheadBy   int    // Number of commits reachable from head but not from base
+	BehindBy  int    // Number of commits reachable from base but not from head
+	Commits   []*Commit
+	Files     []CommitFile
+}
+
+// CompareRevisions compares head to base. Commits lists the commits reachable
+// from head but not from base, newest first and at most MaxCompareCommits. With
+// threeDot the files are diffed against the merge base (base...head), otherwise
+// directly against base (base..head). Counting and listing the commits is
+// bounded by ctx and compareTimeout.
+func CompareRevisions(ctx context.Context, repoPath, base, head string, threeDot bool) (*Comparison, error) {
+	r, err := openRepo(repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	baseCommit, err := resolveRevision(r, base)
+	if err != nil {
+		return nil, err
+	}
+	headCommit, err := resolveRevision(r, head)
+	if err != nil {
+		return nil, err
+	}
+
+	cmp := &Comparison{
+		Base:    baseCommit.Hash.String(),
+		Head:    headCommit.Hash.String(),
+		Commits: []*Commit{},
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, compareTimeout)
+	defer cancel()
+
+	mergeBase, err := findMergeBase(ctx, repoPath, baseCommit.Hash, headCommit.Hash)
+	if err != nil {
+		return nil, err
+	}
+	if !mergeBase.IsZero() {
+		cmp.MergeBase = mergeBase.String()
+	}
+
+	// git stops walking at the merge base, unlike go-git's MergeBase
+	counts, err := revList(ctx, repoPath, "--left-right", "--count", cmp.Base+"..."+cmp.Head)
+	if err != nil {
+		return nil, err
+	}
+	if len(counts) != 2 {
+		return nil, fmt.Errorf("failed to count commits: unexpected output %q", counts)
+	}
+	if cmp.BehindBy, err = strconv.Atoi(counts[0]); err != nil {
+		return nil, fmt.Errorf("failed to count commits: %w", err)
+	}
+	if cmp.AheadBy, err = strconv.Atoi(counts[1]); err != nil {
+		return nil, fmt.Errorf("failed to count commits: %w", err)
+	}
+
+	hashes, err := revList(ctx, repoPath, fmt.Sprintf("--max-count=%d", MaxCompareCommits), cmp.Head, "^"+cmp.Base)
+	if err != nil {
+		return nil, err
+	}
+	for _, hash := range hashes {
+		c, err := r.CommitObject(plumbing.NewHash(hash))
+		if err != nil {
+			return nil, fmt.Errorf("failed to get commit %s: %w", hash, err)
+		}
+		cmp.Commits = append(cmp.Commits, newCommit(c))
+	}
+
+	from := baseCommit
+	if threeDot {
+		if mergeBase.IsZero() {
+			return nil, fmt.Errorf("%w between %s and %s", ErrNoMergeBase, base, head)
+		}
+		if from, err = r.CommitObject(mergeBase); err != nil {
+			return nil, fmt.Errorf("failed to get commit %s: %w", mergeBase, err)
+		}
+	}
+	fromTree, err := from.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tree for commit %s: %w", from.Hash, err)
+	}
+	toTree, err := headCommit.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tree for commit %s: %w", headCommit.Hash, err)
+	}
+
+	diffs, err := diffTrees(fromTree, toTree)
+	if err != nil {
+		return nil, err
+	}
+	cmp.Files = make([]CommitFile, len(diffs))
+	for i, d := range diffs {
+		cmp.Files[i] = d.CommitFile
+	}
+
+	return cmp, nil
+}
+
+// findMergeBase returns the best common ancestor of a and b, or the zero hash
+// for unrelated histories
+func findMergeBase(ctx context.Context, repoPath string, a, b plumbing.Hash) (plumbing.Hash, error) {
+	cmd := exec.CommandContext(ctx, "git", "--git-dir", repoPath, "merge-base", a.String(), b.String())
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if ctx.Err() != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to find merge base: %w", ctx.Err())
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 && stderr.Len() == 0 {
+		return plumbing.ZeroHash, nil
+	}
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to find merge base: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return plumbing.NewHash(strings.TrimSpace(string(out))), nil
+}
+
+// revList runs git rev-list with args and returns the fields of its output
+func revList(ctx context.Context, repoPath string, args ...string) ([]string, error) {
+	cmd := exec.CommandContext(ctx, "git", append([]string{"--git-dir", repoPath, "rev-list"}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("failed to list commits: %w", ctx.Err())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list commits: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.Fields(string(out)), nil
+}
@@ -0,0 +1,78 @@
+package git
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+func TestCompareRevisions(t *testing.T) {
+	t.Chdir(t.TempDir())
+	repoPath := filepath.Join("repos", "heidi", "compare.git")
+	if err := CreateRepo(repoPath, "heidi", true); err != nil {
+		t.Fatalf("CreateRepo failed: %v", err)
+	}
+
+	// c1 - c2 - c4 (main)
+	//        \
+	//         c3 - c5 (feature)
+	commitFiles(t, repoPath, "main", map[string]string{"a.txt": "1"})
+	c2 := commitFiles(t, repoPath, "main", map[string]string{"a.txt": "2"})
+	if _, err := CreateBranch(repoPath, "feature", c2); err != nil {
+		t.Fatalf("CreateBranch failed: %v", err)
+	}
+	c3 := commitFiles(t, repoPath, "feature", map[string]string{"a.txt": "2", "b.txt": "b"})
+	c5 := commitFiles(t, repoPath, "feature", map[string]string{"a.txt": "2", "b.txt": "b", "c.txt": "c"})
+	commitFiles(t, repoPath, "main", map[string]string{"a.txt": "2", "main.txt": "main"})
+
+	cmp, err := CompareRevisions(context.Background(), repoPath, "main", "feature", true)
+	if err != nil {
+		t.Fatalf("CompareRevisions failed: %v", err)
+	}
+	if cmp.MergeBase != c2 || cmp.AheadBy != 2 || cmp.BehindBy != 1 {
+		t.Errorf("Unexpected comparison: merge base %s, ahead %d, behind %d", cmp.MergeBase, cmp.AheadBy, cmp.BehindBy)
+	}
+	if len(cmp.Commits) != 2 || cmp.Commits[0].Hash != c5 || cmp.Commits[1].Hash != c3 {
+		t.Errorf("Expected commits [%s %s], got %+v", c5, c3, cmp.Commits)
+	}
+	if len(cmp.Files) != 2 || cmp.Files[0].Path != "b.txt" || cmp.Files[1].Path != "c.txt" {
+		t.Errorf("Three-dot: expected b.txt and c.txt, got %+v", cmp.Files)
+	}
+
+	// Two-dot also shows main.txt as deleted, since it is not in feature
+	cmp, err = CompareRevisions(context.Background(), repoPath, "main", "feature", false)
+	if err != nil {
+		t.Fatalf("CompareRevisions failed: %v", err)
+	}
+	if len(cmp.Files) != 3 || cmp.Files[1].Path != "c.txt" || cmp.Files[2].Path != "main.txt" || cmp.Files[2].ChangeType != "deleted" {
+		t.Errorf("Two-dot: unexpected files %+v", cmp.Files)
+	}
+
+	// An unrelated history has no merge base
+	r, err := git.PlainOpen(repoPath)
+	if err != nil {
+		t.Fatalf("PlainOpen failed: %v", err)
+	}
+	orphan := writeTestCommit(t, r, writeTestTree(t, r, map[string]string{"z.txt": "z"}))
+	if err := r.Storer.SetReference(plumbing.NewHashReference("refs/heads/orphan", orphan)); err != nil {
+		t.Fatalf("SetReference failed: %v", err)
+	}
+	if _, err := CompareRevisions(context.Background(), repoPath, "main", "orphan", true); !errors.Is(err, ErrNoMergeBase) {
+		t.Errorf("Expected ErrNoMergeBase, got %v", err)
+	}
+	cmp, err = CompareRevisions(context.Background(), repoPath, "main", "orphan", false)
+	if err != nil {
+		t.Fatalf("Two-dot compare of unrelated histories failed: %v", err)
+	}
+	if cmp.MergeBase != "" || cmp.AheadBy != 1 || cmp.BehindBy != 3 {
+		t.Errorf("Unexpected unrelated comparison: %+v", cmp)
+	}
+
+	if _, err := CompareRevisions(context.Background(), repoPath, "main", "nope", true); !errors.Is(err, ErrRevisionNotFound) {
+		t.Errorf("Expected ErrRevisionNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,514 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	fdiff "github.com/go-git/go-git/v5/plumbing/format/diff"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/utils/diff"
+	"github.com/go-git/go-git/v5/utils/merkletrie"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+const (
+	// similarityThreshold is the minimum similarity (in percent) of a rename or copy
+	similarityThreshold = 50
+	// renameLimit caps the number of files compared when detecting renames
+	renameLimit = 1000
+	// maxCopyCandidateSize is the largest file considered as the source of an inexact copy
+	maxCopyCandidateSize = 1 << 20
+	// contextLines is the number of unchanged lines around each hunk
+	contextLines = 3
+)
+
+// emptyBlobHash is the hash of an empty file, which git never treats as a copy
+var emptyBlobHash = plumbing.ComputeHash(plumbing.BlobObject, nil)
+
+// Line types of a DiffLine
+const (
+	LineContext = "context"
+	LineAdd     = "add"
+	LineDelete  = "delete"
+)
+
+// DiffLine is a single line of a hunk
+type DiffLine struct {
+	Type      string // "context", "add" or "delete"
+	Content   string // Line content without the trailing newline
+	OldLine   int    // Line number in the old file, 0 for added lines
+	NewLine   int    // Line number in the new file, 0 for deleted lines
+	NoNewline bool   // Whether the line is the last one and has no trailing newline
+}
+
+// Hunk is a group of changed lines with their context
+type Hunk struct {
+	OldStart int
+	OldLines int
+	NewStart int
+	NewLines int
+	Lines    []DiffLine
+}
+
+// FileDiff is the diff of a single file with its change summary
+type FileDiff struct {
+	CommitFile
+	OldMode string // Octal mode of the old file, empty for added files
+	NewMode string // Octal mode of the new file, empty for deleted files
+	OldHash string // Blob hash of the old file, empty for added files
+	NewHash string // Blob hash of the new file, empty for deleted files
+	Hunks   []Hunk
+}
+
+// GetCommitChangesAgainst returns the files changed in a commit compared to its
+// parent-th parent (1-based, like rev^N). Root commits are compared to an empty tree.
+func GetCommitChangesAgainst(repoPath, hash string, parent int) ([]CommitFile, error) {
+	diffs, err := GetCommitDiff(repoPath, hash, parent)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make([]CommitFile, len(diffs))
+	for i, d := range diffs {
+		changes[i] = d.CommitFile
+	}
+	return changes, nil
+}
+
+// GetCommitDiff returns the per-file diff of a commit against its parent-th parent
+// (1-based, like rev^N). Root commits are compared to an empty tree.
+func GetCommitDiff(repoPath, hash string, parent int) ([]FileDiff, error) {
+	r, err := openRepo(repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	c, err := resolveRevision(r, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	var fromTree *object.Tree
+	if len(c.ParentHashes) > 0 || parent > 1 {
+		p, err := nthParent(r, c, parent, hash)
+		if err != nil {
+			return nil, err
+		}
+		if fromTree, err = p.Tree(); err != nil {
+			return nil, fmt.Errorf("failed to get parent tree: %w", err)
+		}
+	}
+
+	toTree, err := c.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tree for commit %s: %w", c.Hash, err)
+	}
+
+	return diffTrees(fromTree, toTree)
+}
+
+// diffTrees compares two trees with rename and copy detection. A nil tree is empty.
+func diffTrees(fromTree, toTree *object.Tree) ([]FileDiff, error) {
+	changes, err := object.DiffTreeWithOptions(context.Background(), fromTree, toTree, &object.DiffTreeOptions{
+		DetectRenames: true,
+		RenameScore:   similarityThreshold,
+		RenameLimit:   renameLimit,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to diff trees: %w", err)
+	}
+
+	copies, err := detectCopies(changes)
+	if err != nil {
+		return nil, err
+	}
+
+	diffs := make([]FileDiff, 0, len(changes))
+	for _, change := range changes {
+		changeType := ""
+		action, err := change.Action()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get change action: %w", err)
+		}
+		switch {
+		case action == merkletrie.Insert && copies[change] != nil:
+			change = copies[change]
+			changeType = "copied"
+		case action == merkletrie.Insert:
+			changeType = "added"
+		case action == merkletrie.Delete:
+			changeType = "deleted"
+		case change.From.Name != change.To.Name:
+			changeType = "renamed"
+		default:
+			changeType = "modified"
+		}
+
+		d, err := newFileDiff(change, changeType)
+		if err != nil {
+			return nil, err
+		}
+		diffs = append(diffs, *d)
+	}
+
+	return diffs, nil
+}
+
+// detectCopies finds added files that are copies of files modified in the same
+// diff, like git's -C; only --find-copies-harder also considers unchanged files.
+// Empty files are never copies, and inexact copies are only searched while the
+// added files times the modified ones stay within renameLimit. It maps the
+// original insert changes to changes from the source.
+func detectCopies(changes object.Changes) (map[*object.Change]*object.Change, error) {
+	copies := make(map[*object.Change]*object.Change)
+
+	var inserts, modified []*object.Change
+	for _, change := range changes {
+		switch {
+		case change.From.Name == "":
+			inserts = append(inserts, change)
+		case change.From.Name == change.To.Name:
+			modified = append(modified, change)
+		}
+	}
+	if len(inserts) == 0 || len(modified) == 0 {
+		return copies, nil
+	}
+
+	// Index the old versions of the modified files by blob hash for exact copies
+	sources := make(map[plumbing.Hash]*object.Change)
+	for _, m := range modified {
+		if _, ok := sources[m.From.TreeEntry.Hash]; !ok {
+			sources[m.From.TreeEntry.Hash] = m
+		}
+	}
+
+	var candidates []*object.Change
+	for _, insert := range inserts {
+		if insert.To.TreeEntry.Hash == emptyBlobHash {
+			continue
+		}
+		if m, ok := sources[insert.To.TreeEntry.Hash]; ok {
+			copies[insert] = &object.Change{From: m.From, To: insert.To}
+			continue
+		}
+		candidates = append(candidates, insert)
+	}
+
+	// Inexact copies compare every added file with every modified one, which is
+	// skipped for large diffs like git does beyond diff.renameLimit
+	if len(candidates) == 0 || len(candidates)*len(modified) > renameLimit {
+		return copies, nil
+	}
+
+	// Load the old content of each modified file once
+	type copySource struct {
+		change  *object.Change
+		content string
+	}
+	var contents []copySource
+	for _, m := range modified {
+		from, _, err := m.Files()
+		if err != nil || from == nil || from.Size > maxCopyCandidateSize {
+			continue
+		}
+		content, err := from.Contents()
+		if err != nil {
+			continue
+		}
+		contents = append(contents, copySource{m, content})
+	}
+
+	for _, insert := range candidates {
+		_, to, err := insert.Files()
+		if err != nil || to == nil || to.Size > maxCopyCandidateSize {
+			continue
+		}
+		toContent, err := to.Contents()
+		if err != nil {
+			continue
+		}
+
+		best, bestScore := (*object.Change)(nil), similarityThreshold-1
+		for _, src := range contents {
+			// Files of very different sizes cannot reach the threshold
+			if min(len(src.content), len(toContent))*100 < bestScore*max(len(src.content), len(toContent)) {
+				continue
+			}
+			if score := similarity(diff.Do(src.content, toContent), len(src.content), len(toContent)); score > bestScore {
+				best, bestScore = src.change, score
+			}
+		}
+		if best != nil {
+			copies[insert] = &object.Change{From: best.From, To: insert.To}
+		}
+	}
+
+	return copies, nil
+}
+
+// similarity is the share of unchanged bytes relative to the larger file, in percent
+func similarity(diffs []diffmatchpatch.Diff, oldSize, newSize int) int {
+	size := max(oldSize, newSize)
+	if size == 0 {
+		return 100
+	}
+	equal := 0
+	for _, d := range diffs {
+		if d.Type == diffmatchpatch.DiffEqual {
+			equal += len(d.Text)
+		}
+	}
+	return equal * 100 / size
+}
+
+// newFileDiff builds the FileDiff of a single change
+func newFileDiff(change *object.Change, changeType string) (*FileDiff, error) {
+	patch, err := change.Patch()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create patch: %w", err)
+	}
+
+	d := &FileDiff{CommitFile: CommitFile{ChangeType: changeType}}
+	if change.From.Name != "" {
+		d.OldMode = fmt.Sprintf("%06o", uint32(change.From.TreeEntry.Mode))
+		d.OldHash = change.From.TreeEntry.Hash.String()
+	}
+	if change.To.Name != "" {
+		d.Path = change.To.Name
+		d.NewMode = fmt.Sprintf("%06o", uint32(change.To.TreeEntry.Mode))
+		d.NewHash = change.To.TreeEntry.Hash.String()
+	} else {
+		d.Path = change.From.Name
+	}
+	if changeType == "renamed" || changeType == "copied" {
+		d.OldPath = change.From.Name
+	}
+	d.ContentType = contentTypeByExtension(d.Path)
+
+	filePatches := patch.FilePatches()
+	if len(filePatches) == 0 {
+		return d, nil
+	}
+	fp := filePatches[0]
+	if fp.IsBinary() {
+		d.Binary = true
+		if d.OldPath != "" && d.OldHash == d.NewHash {
+			d.Similarity = 100
+		}
+		return d, nil
+	}
+
+	d.Hunks = buildHunks(fp.Chunks())
+	oldSize, newSize, equal := 0, 0, 0
+	for _, chunk := range fp.Chunks() {
+		n := len(chunk.Content())
+		switch chunk.Type() {
+		case fdiff.Equal:
+			equal += n
+			oldSize += n
+			newSize += n
+		case fdiff.Add:
+			d.Additions += countLines(chunk.Content())
+			newSize += n
+		case fdiff.Delete:
+			d.Deletions += countLines(chunk.Content())
+			oldSize += n
+		}
+	}
+	if d.OldPath != "" {
+		if size := max(oldSize, newSize); size > 0 {
+			d.Similarity = equal * 100 / size
+		} else {
+			d.Similarity = 100
+		}
+	}
+
+	return d, nil
+}
+
+// contentTypeByExtension guesses the MIME type of a path from its extension
+func contentTypeByExtension(path string) string {
+	contentType := mime.TypeByExtension(filepath.Ext(path))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	return contentType
+}
+
+// countLines counts the lines of a chunk, including a last line without newline
+func countLines(s string) int {
+	n := strings.Count(s, "\n")
+	if s != "" && !strings.HasSuffix(s, "\n") {
+		n++
+	}
+	return n
+}
+
+// buildHunks groups the lines of a file patch into hunks with context lines
+func buildHunks(chunks []fdiff.Chunk) []Hunk {
+	var lines []DiffLine
+	oldLine, newLine := 1, 1
+	for _, chunk := range chunks {
+		content := chunk.Content()
+		for content != "" {
+			text, rest, found := strings.Cut(content, "\n")
+			content = rest
+			line := DiffLine{Content: text, NoNewline: !found}
+			switch chunk.Type() {
+			case fdiff.Equal:
+				line.Type, line.OldLine, line.NewLine = LineContext, oldLine, newLine
+				oldLine++
+				newLine++
+			case fdiff.Add:
+				line.Type, line.NewLine = LineAdd, newLine
+				newLine++
+			case fdiff.Delete:
+				line.Type, line.OldLine = LineDelete, oldLine
+				oldLine++
+			}
+			lines = append(lines, line)
+		}
+	}
+
+	var hunks []Hunk
+	for i := 0; i < len(lines); i++ {
+		if lines[i].Type == LineContext {
+			continue
+		}
+
+		// Extend the hunk while the next change is within two context windows
+		start := max(0, i-contextLines)
+		end := i
+		for j := i; j < len(lines); j++ {
+			if lines[j].Type == LineContext {
+				continue
+			}
+			if j-end > 2*contextLines {
+				break
+			}
+			end = j
+		}
+		end = min(len(lines), end+contextLines+1)
+
+		hunks = append(hunks, newHunk(lines[start:end]))
+		i = end - 1
+	}
+	return hunks
+}
+
+// newHunk computes the ranges of a hunk from its lines
+func newHunk(lines []DiffLine) Hunk {
+	h := Hunk{Lines: lines}
+	for _, l := range lines {
+		if l.Type != LineAdd {
+			if h.OldLines == 0 {
+				h.OldStart = l.OldLine
+			}
+			h.OldLines++
+		}
+		if l.Type != LineDelete {
+			if h.NewLines == 0 {
+				h.NewStart = l.NewLine
+			}
+			h.NewLines++
+		}
+	}
+
+	// With context lines a side is only empty if the whole file is, git then
+	// reports the range as starting at line 0, which is the zero value
+	return h
+}
+
+// WriteUnifiedDiff writes file diffs in git's unified diff format
+func WriteUnifiedDiff(w io.Writer, diffs []FileDiff) error {
+	for _, d := range diffs {
+		oldPath, newPath := d.Path, d.Path
+		if d.OldPath != "" {
+			oldPath = d.OldPath
+		}
+
+		var b strings.Builder
+		fmt.Fprintf(&b, "diff --git a/%s b/%s\n", oldPath, newPath)
+		switch {
+		case d.ChangeType == "added":
+			fmt.Fprintf(&b, "new file mode %s\n", d.NewMode)
+		case d.ChangeType == "deleted":
+			fmt.Fprintf(&b, "deleted file mode %s\n", d.OldMode)
+		case d.OldMode != d.NewMode:
+			fmt.Fprintf(&b, "old mode %s\nnew mode %s\n", d.OldMode, d.NewMode)
+		}
+		switch d.ChangeType {
+		case "renamed":
+			fmt.Fprintf(&b, "similarity index %d%%\nrename from %s\nrename to %s\n", d.Similarity, oldPath, newPath)
+		case "copied":
+			fmt.Fprintf(&b, "similarity index %d%%\ncopy from %s\ncopy to %s\n", d.Similarity, oldPath, newPath)
+		}
+
+		if d.OldHash != d.NewHash {
+			fmt.Fprintf(&b, "index %s..%s", shortHash(d.OldHash), shortHash(d.NewHash))
+			if d.OldMode == d.NewMode {
+				fmt.Fprintf(&b, " %s", d.NewMode)
+			}
+			b.WriteString("\n")
+
+			from, to := "a/"+oldPath, "b/"+newPath
+			if d.ChangeType == "added" {
+				from = "/dev/null"
+			}
+			if d.ChangeType == "deleted" {
+				to = "/dev/null"
+			}
+			if d.Binary {
+				fmt.Fprintf(&b, "Binary files %s and %s differ\n", from, to)
+			} else if len(d.Hunks) > 0 {
+				fmt.Fprintf(&b, "--- %s\n+++ %s\n", from, to)
+			}
+		}
+
+		for _, h := range d.Hunks {
+			fmt.Fprintf(&b, "@@ -%s +%s @@\n", hunkRange(h.OldStart, h.OldLines), hunkRange(h.NewStart, h.NewLines))
+			for _, l := range h.Lines {
+				switch l.Type {
+				case LineAdd:
+					b.WriteByte('+')
+				case LineDelete:
+					b.WriteByte('-')
+				default:
+					b.WriteByte(' ')
+				}
+				b.WriteString(l.Content)
+				b.WriteByte('\n')
+				if l.NoNewline {
+					b.WriteString("\\ No newline at end of file\n")
+				}
+			}
+		}
+
+		if _, err := io.WriteString(w, b.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hunkRange formats one side of a hunk header, omitting a count of 1 like git
+func hunkRange(start, lines int) string {
+	if lines == 1 {
+		return fmt.Sprintf("%d", start)
+	}
+	return fmt.Sprintf("%d,%d", start, lines)
+}
+
+// shortHash abbreviates a blob hash for index lines, empty hashes are all zeros
+func shortHash(hash string) string {
+	if hash == "" {
+		hash = plumbing.ZeroHash.String()
+	}
+	return hash[:7]
+}
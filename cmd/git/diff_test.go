@@ -0,0 +1,184 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+const diffTestText = "one\ntwo\nthree\nfour\nfive\nsix\nseven\neight\nnine\nten\n"
+
+func TestGetCommitDiff(t *testing.T) {
+	t.Chdir(t.TempDir())
+	repoPath := filepath.Join("repos", "frank", "diffs.git")
+	if err := CreateRepo(repoPath, "frank", true); err != nil {
+		t.Fatalf("CreateRepo failed: %v", err)
+	}
+
+	commitFiles(t, repoPath, "main", map[string]string{
+		"a.txt":    diffTestText,
+		"b.txt":    "to be renamed\n",
+		"old.txt":  "to be deleted\n",
+		"img.bin":  "\x00\x01\x02",
+		"keep.txt": "",
+		"same.txt": "unchanged\n",
+	})
+	hash := commitFiles(t, repoPath, "main", map[string]string{
+		"a.txt":    strings.Replace(diffTestText, "five\n", "FIVE\n", 1),
+		"c.txt":    "to be renamed\n",
+		"copy.txt": strings.Replace(diffTestText, "ten\n", "TEN\n", 1),
+		"img.bin":  "\x00\x01\x03",
+		"new.txt":  "brand new\nno newline",
+		"keep.txt": "",
+		"same.txt": "unchanged\n",
+		// Neither an empty file nor a copy of an unchanged file is a copy
+		"empty.txt": "",
+		"dup.txt":   "unchanged\n",
+	})
+
+	changes, err := GetCommitChanges(repoPath, hash)
+	if err != nil {
+		t.Fatalf("GetCommitChanges failed: %v", err)
+	}
+	byPath := make(map[string]CommitFile)
+	for _, c := range changes {
+		byPath[c.Path] = c
+	}
+
+	if a := byPath["a.txt"]; a.ChangeType != "modified" || a.Additions != 1 || a.Deletions != 1 {
+		t.Errorf("Unexpected a.txt change: %+v", a)
+	}
+	if c := byPath["c.txt"]; c.ChangeType != "renamed" || c.OldPath != "b.txt" || c.Similarity != 100 {
+		t.Errorf("Unexpected c.txt change: %+v", c)
+	}
+	if c := byPath["copy.txt"]; c.ChangeType != "copied" || c.OldPath != "a.txt" || c.Similarity < 50 || c.Additions != 1 || c.Deletions != 1 {
+		t.Errorf("Unexpected copy.txt change: %+v", c)
+	}
+	if c := byPath["old.txt"]; c.ChangeType != "deleted" || c.Deletions != 1 {
+		t.Errorf("Unexpected old.txt change: %+v", c)
+	}
+	for _, path := range []string{"empty.txt", "dup.txt"} {
+		if c := byPath[path]; c.ChangeType != "added" || c.OldPath != "" {
+			t.Errorf("Unexpected %s change: %+v", path, c)
+		}
+	}
+	if c := byPath["new.txt"]; c.ChangeType != "added" || c.Additions != 2 {
+		t.Errorf("Unexpected new.txt change: %+v", c)
+	}
+	if c := byPath["img.bin"]; !c.Binary || c.Additions != 0 {
+		t.Errorf("Unexpected img.bin change: %+v", c)
+	}
+
+	diffs, err := GetCommitDiff(repoPath, "main", 1)
+	if err != nil {
+		t.Fatalf("GetCommitDiff failed: %v", err)
+	}
+	for _, d := range diffs {
+		if d.Path != "a.txt" {
+			continue
+		}
+		if len(d.Hunks) != 1 {
+			t.Fatalf("Expected 1 hunk for a.txt, got %d", len(d.Hunks))
+		}
+		h := d.Hunks[0]
+		if h.OldStart != 2 || h.OldLines != 7 || h.NewStart != 2 || h.NewLines != 7 {
+			t.Errorf("Unexpected hunk range: %+v", h)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := WriteUnifiedDiff(&buf, diffs); err != nil {
+		t.Fatalf("WriteUnifiedDiff failed: %v", err)
+	}
+	patch := buf.String()
+	for _, want := range []string{
+		"diff --git a/a.txt b/a.txt\n",
+		"@@ -2,7 +2,7 @@\n two\n three\n four\n-five\n+FIVE\n six\n",
+		"rename from b.txt\nrename to c.txt\n",
+		"copy from a.txt\ncopy to copy.txt\n",
+		"deleted file mode 100644\n",
+		"--- /dev/null\n+++ b/new.txt\n@@ -0,0 +1,2 @@\n+brand new\n+no newline\n\\ No newline at end of file\n",
+		"Binary files a/img.bin and b/img.bin differ\n",
+	} {
+		if !strings.Contains(patch, want) {
+			t.Errorf("Patch is missing %q:\n%s", want, patch)
+		}
+	}
+}
+
+func TestDetectCopiesLimit(t *testing.T) {
+	t.Chdir(t.TempDir())
+	repoPath := filepath.Join("repos", "gil", "many.git")
+	if err := CreateRepo(repoPath, "gil", true); err != nil {
+		t.Fatalf("CreateRepo failed: %v", err)
+	}
+	commitFiles(t, repoPath, "main", map[string]string{"a.txt": diffTestText, "b.txt": "b\n"})
+
+	// Too many added files for the inexact search, exact copies are still found
+	files := map[string]string{
+		"a.txt":     strings.Replace(diffTestText, "five\n", "FIVE\n", 1),
+		"b.txt":     "b changed\n",
+		"exact.txt": diffTestText,
+		"close.txt": strings.Replace(diffTestText, "ten\n", "TEN\n", 1),
+	}
+	for i := range renameLimit / 2 {
+		files[fmt.Sprintf("gen/%d.txt", i)] = fmt.Sprintf("file %d\n", i)
+	}
+	hash := commitFiles(t, repoPath, "main", files)
+
+	changes, err := GetCommitChanges(repoPath, hash)
+	if err != nil {
+		t.Fatalf("GetCommitChanges failed: %v", err)
+	}
+	byPath := make(map[string]CommitFile)
+	for _, c := range changes {
+		byPath[c.Path] = c
+	}
+	if c := byPath["exact.txt"]; c.ChangeType != "copied" || c.OldPath != "a.txt" || c.Similarity != 100 {
+		t.Errorf("Unexpected exact.txt change: %+v", c)
+	}
+	if c := byPath["close.txt"]; c.ChangeType != "added" {
+		t.Errorf("Expected close.txt to be added beyond the limit, got %+v", c)
+	}
+}
+
+func TestGetCommitDiffMergeParent(t *testing.T) {
+	t.Chdir(t.TempDir())
+	repoPath := filepath.Join("repos", "frank", "merge.git")
+	if err := CreateRepo(repoPath, "frank", true); err != nil {
+		t.Fatalf("CreateRepo failed: %v", err)
+	}
+
+	base := commitFiles(t, repoPath, "main", map[string]string{"a.txt": "a\n"})
+	if _, err := CreateBranch(repoPath, "topic", base); err != nil {
+		t.Fatalf("CreateBranch failed: %v", err)
+	}
+	first := commitFiles(t, repoPath, "main", map[string]string{"a.txt": "a\n", "main.txt": "main\n"})
+	second := commitFiles(t, repoPath, "topic", map[string]string{"a.txt": "a\n", "topic.txt": "topic\n"})
+
+	r, err := git.PlainOpen(repoPath)
+	if err != nil {
+		t.Fatalf("PlainOpen failed: %v", err)
+	}
+	tree := writeTestTree(t, r, map[string]string{"a.txt": "a\n", "main.txt": "main\n", "topic.txt": "topic\n"})
+	merge := writeTestCommit(t, r, tree, plumbing.NewHash(first), plumbing.NewHash(second)).String()
+
+	for parent, want := range map[int]string{1: "topic.txt", 2: "main.txt"} {
+		changes, err := GetCommitChangesAgainst(repoPath, merge, parent)
+		if err != nil {
+			t.Fatalf("GetCommitChangesAgainst(%d) failed: %v", parent, err)
+		}
+		if len(changes) != 1 || changes[0].Path != want {
+			t.Errorf("Against parent %d: expected only %s, got %+v", parent, want, changes)
+		}
+	}
+
+	if _, err := GetCommitChangesAgainst(repoPath, merge, 3); err == nil {
+		t.Errorf("Expected error for missing parent 3")
+	}
+}
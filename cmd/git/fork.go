@@ -0,0 +1,253 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+
+	"librebucket/cmd/db"
+)
+
+var (
+	// ErrRepoExists is returned when a repository path is already taken
+	ErrRepoExists = errors.New("repository already exists")
+	// ErrNotFork is returned when syncing a repository that is not a fork
+	ErrNotFork = errors.New("repository is not a fork")
+	// ErrNotFastForward is returned when a branch has diverged from its upstream
+	ErrNotFastForward = errors.New("branch has diverged from upstream")
+)
+
+// ForkInfo describes a fork of a repository
+type ForkInfo struct {
+	FullName  string // "{owner}/{name}"
+	Owner     string
+	Public    bool
+	CreatedAt time.Time
+}
+
+// RepoFullName returns the "{owner}/{name}" of a repository path like repos/{owner}/{name}.git
+func RepoFullName(repoPath string) string {
+	owner := filepath.Base(filepath.Dir(repoPath))
+	name := strings.TrimSuffix(filepath.Base(repoPath), ".git")
+	return owner + "/" + name
+}
+
+// repoPathFromFullName returns the repository path of an "{owner}/{name}"
+func repoPathFromFullName(fullName string) string {
+	return filepath.Join(safeRepoBaseDir, filepath.FromSlash(fullName)+".git")
+}
+
+// ForkRepo creates a bare fork of srcPath at dstPath owned by owner. The fork
+// shares the objects of its parent through objects/info/alternates instead of
+// copying them, starts with the parent's branches, tags and LFS objects, and
+// records the parent in RepoMeta.ForkedFrom. The parent's ForksCount is incremented.
+func ForkRepo(srcPath, dstPath, owner string) error {
+	safeSrcPath, err := resolveSafePath(safeRepoBaseDir, srcPath)
+	if err != nil {
+		return fmt.Errorf("invalid repo path: %w", err)
+	}
+	safeDstPath, err := resolveSafePath(safeRepoBaseDir, dstPath)
+	if err != nil {
+		return fmt.Errorf("invalid repo path: %w", err)
+	}
+
+	srcMeta, err := LoadRepoMeta(srcPath)
+	if err != nil {
+		return err
+	}
+	src, err := openRepo(safeSrcPath)
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+	if _, err := os.Stat(safeDstPath); err == nil {
+		return fmt.Errorf("%w: %s", ErrRepoExists, RepoFullName(dstPath))
+	}
+
+	// Build the fork next to the final path, so the rename below cannot cross
+	// filesystems and a concurrent fork to the same path is never removed
+	ownerDir := filepath.Dir(safeDstPath)
+	if err := os.MkdirAll(ownerDir, 0755); err != nil {
+		return fmt.Errorf("failed to create owner directory: %w", err)
+	}
+	tmpDir, err := os.MkdirTemp(ownerDir, "."+filepath.Base(safeDstPath)+".fork-")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	defaultBranch := srcMeta.DefaultBranch
+	if defaultBranch == "" {
+		defaultBranch = defaultBranchName
+	}
+	if err := createFork(src, safeSrcPath, tmpDir, defaultBranch); err != nil {
+		return err
+	}
+
+	// Renaming fails if another repository took the path in the meantime
+	if err := os.Rename(tmpDir, safeDstPath); err != nil {
+		if _, statErr := os.Stat(safeDstPath); statErr == nil {
+			return fmt.Errorf("%w: %s", ErrRepoExists, RepoFullName(dstPath))
+		}
+		return fmt.Errorf("failed to move repository into place: %w", err)
+	}
+	if err := saveFork(srcMeta, safeDstPath, RepoFullName(srcPath), owner, defaultBranch); err != nil {
+		os.RemoveAll(safeDstPath)
+		return err
+	}
+
+	return updateRepoMeta(srcPath, func(meta *RepoMeta) error {
+		meta.ForksCount++
+		return nil
+	})
+}
+
+// createFork initializes the fork repository and its refs in dstPath
+func createFork(src *git.Repository, srcPath, dstPath, defaultBranch string) error {
+	dst, err := git.PlainInit(dstPath, true)
+	if err != nil {
+		return fmt.Errorf("failed to create repo: %w", err)
+	}
+
+	// A fork of a fork also needs the object directories its parent borrows from,
+	// since go-git does not follow alternates recursively
+	objectDirs := filepath.Join(srcPath, "objects") + "\n"
+	if inherited, err := os.ReadFile(filepath.Join(srcPath, "objects", "info", "alternates")); err == nil {
+		objectDirs += string(inherited)
+	}
+	alternates := filepath.Join(dstPath, "objects", "info", "alternates")
+	if err := os.MkdirAll(filepath.Dir(alternates), 0755); err != nil {
+		return fmt.Errorf("failed to create alternates: %w", err)
+	}
+	if err := os.WriteFile(alternates, []byte(objectDirs), 0644); err != nil {
+		return fmt.Errorf("failed to create alternates: %w", err)
+	}
+
+	refs, err := src.References()
+	if err != nil {
+		return fmt.Errorf("failed to list references: %w", err)
+	}
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference || !(ref.Name().IsBranch() || ref.Name().IsTag()) {
+			return nil
+		}
+		return dst.Storer.SetReference(ref)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to copy references: %w", err)
+	}
+
+	head := plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.NewBranchReferenceName(defaultBranch))
+	if err := dst.Storer.SetReference(head); err != nil {
+		return fmt.Errorf("failed to set HEAD: %w", err)
+	}
+	return nil
+}
+
+// saveFork records the LFS objects and metadata of a fork moved into place
+func saveFork(srcMeta RepoMeta, dstPath, upstream, owner, defaultBranch string) error {
+	// The fork may read the LFS objects of its parent
+	if err := db.CopyLFSObjects(upstream, RepoFullName(dstPath)); err != nil {
+		return fmt.Errorf("failed to copy LFS objects: %w", err)
+	}
+
+	now := time.Now()
+	return SaveRepoMeta(dstPath, RepoMeta{
+		Owner:         owner,
+		Public:        srcMeta.Public,
+		LastCommit:    srcMeta.LastCommit,
+		Languages:     srcMeta.Languages,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+		DefaultBranch: defaultBranch,
+		ForkedFrom:    upstream,
+	})
+}
+
+// ListForks returns the direct forks of a repository, oldest first
+func ListForks(repoPath string) ([]ForkInfo, error) {
+	owner, name, err := repoKey(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	repos, err := db.ListForkedRepositories(owner + "/" + name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list forks: %w", err)
+	}
+
+	forks := make([]ForkInfo, 0, len(repos))
+	for _, r := range repos {
+		forks = append(forks, ForkInfo{
+			FullName:  r.FullName(),
+			Owner:     r.Owner,
+			Public:    r.Public,
+			CreatedAt: r.CreatedAt,
+		})
+	}
+	return forks, nil
+}
+
+// SyncFork fast-forwards a branch of a fork to the same branch of its upstream
+// and returns the new branch tip. The branch is created if the fork lacks it.
+// Since a fork shares the objects of its upstream, only the ref has to move.
+func SyncFork(forkPath, branch string) (string, error) {
+	meta, err := LoadRepoMeta(forkPath)
+	if err != nil {
+		return "", err
+	}
+	if meta.ForkedFrom == "" {
+		return "", fmt.Errorf("%w: %s", ErrNotFork, RepoFullName(forkPath))
+	}
+
+	upstream, err := openRepo(repoPathFromFullName(meta.ForkedFrom))
+	if err != nil {
+		return "", fmt.Errorf("failed to open upstream %s: %w", meta.ForkedFrom, err)
+	}
+	fork, err := openRepo(forkPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	refName := plumbing.NewBranchReferenceName(branch)
+	upstreamRef, err := upstream.Reference(refName, false)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s in %s", ErrBranchNotFound, branch, meta.ForkedFrom)
+	}
+	upstreamTip, err := fork.CommitObject(upstreamRef.Hash())
+	if err != nil {
+		return "", fmt.Errorf("failed to get upstream commit: %w", err)
+	}
+
+	forkRef, err := fork.Reference(refName, false)
+	switch {
+	case errors.Is(err, plumbing.ErrReferenceNotFound):
+		forkRef = nil
+	case err != nil:
+		return "", fmt.Errorf("failed to get branch %s: %w", branch, err)
+	}
+
+	if forkRef != nil {
+		forkTip, err := fork.CommitObject(forkRef.Hash())
+		if err != nil {
+			return "", fmt.Errorf("failed to get branch commit: %w", err)
+		}
+		isAncestor, err := forkTip.IsAncestor(upstreamTip)
+		if err != nil {
+			return "", fmt.Errorf("failed to compare with upstream: %w", err)
+		}
+		if forkTip.Hash != upstreamTip.Hash && !isAncestor {
+			return "", fmt.Errorf("%w: %s", ErrNotFastForward, branch)
+		}
+	}
+
+	newRef := plumbing.NewHashReference(refName, upstreamTip.Hash)
+	if err := fork.Storer.CheckAndSetReference(newRef, forkRef); err != nil {
+		return "", fmt.Errorf("failed to update branch %s: %w", branch, err)
+	}
+	return upstreamTip.Hash.String(), nil
+}
@@ -0,0 +1,125 @@
+package git
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestForkRepo(t *testing.T) {
+	t.Chdir(t.TempDir())
+	upstreamPath := filepath.Join("repos", "leo", "project.git")
+	if err := CreateRepo(upstreamPath, "leo", true); err != nil {
+		t.Fatalf("CreateRepo failed: %v", err)
+	}
+	first := commitFiles(t, upstreamPath, "main", map[string]string{"a.txt": "1"})
+
+	forkPath := filepath.Join("repos", "mia", "project.git")
+	if err := ForkRepo(upstreamPath, forkPath, "mia"); err != nil {
+		t.Fatalf("ForkRepo failed: %v", err)
+	}
+	if err := ForkRepo(upstreamPath, forkPath, "mia"); !errors.Is(err, ErrRepoExists) {
+		t.Errorf("Expected ErrRepoExists, got %v", err)
+	}
+
+	// The fork reads the upstream objects without copying them
+	if c, err := GetCommitByHash(forkPath, "main"); err != nil || c.Hash != first {
+		t.Fatalf("Expected fork main at %s, got %+v, %v", first, c, err)
+	}
+	packs, _ := filepath.Glob(filepath.Join(forkPath, "objects", "??", "*"))
+	if len(packs) != 0 {
+		t.Errorf("Expected no objects in the fork, found %v", packs)
+	}
+
+	meta, err := LoadRepoMeta(forkPath)
+	if err != nil || meta.ForkedFrom != "leo/project" || meta.Owner != "mia" || meta.DefaultBranch != "main" {
+		t.Errorf("Unexpected fork metadata %+v, %v", meta, err)
+	}
+	if meta, _ := LoadRepoMeta(upstreamPath); meta.ForksCount != 1 {
+		t.Errorf("Expected ForksCount 1, got %d", meta.ForksCount)
+	}
+
+	// A fork of the fork also sees the upstream objects
+	nestedPath := filepath.Join("repos", "ned", "project.git")
+	if err := ForkRepo(forkPath, nestedPath, "ned"); err != nil {
+		t.Fatalf("ForkRepo of fork failed: %v", err)
+	}
+	if _, err := GetCommitByHash(nestedPath, first); err != nil {
+		t.Errorf("Fork of fork cannot read upstream commit: %v", err)
+	}
+
+	forks, err := ListForks(upstreamPath)
+	if err != nil || len(forks) != 1 || forks[0].FullName != "mia/project" || forks[0].Owner != "mia" {
+		t.Errorf("Unexpected forks %+v, %v", forks, err)
+	}
+
+	// Sync a fast-forward from upstream, including a new branch
+	second := commitFiles(t, upstreamPath, "main", map[string]string{"a.txt": "2"})
+	if _, err := CreateBranch(upstreamPath, "next", second); err != nil {
+		t.Fatalf("CreateBranch failed: %v", err)
+	}
+	for _, branch := range []string{"main", "next"} {
+		if hash, err := SyncFork(forkPath, branch); err != nil || hash != second {
+			t.Errorf("SyncFork(%s) = %s, %v; want %s", branch, hash, err, second)
+		}
+	}
+
+	// Diverged branches are not overwritten
+	commitFiles(t, forkPath, "main", map[string]string{"a.txt": "fork"})
+	commitFiles(t, upstreamPath, "main", map[string]string{"a.txt": "3"})
+	if _, err := SyncFork(forkPath, "main"); !errors.Is(err, ErrNotFastForward) {
+		t.Errorf("Expected ErrNotFastForward, got %v", err)
+	}
+	if _, err := SyncFork(forkPath, "missing"); !errors.Is(err, ErrBranchNotFound) {
+		t.Errorf("Expected ErrBranchNotFound, got %v", err)
+	}
+	if _, err := SyncFork(upstreamPath, "main"); !errors.Is(err, ErrNotFork) {
+		t.Errorf("Expected ErrNotFork, got %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(forkPath, "objects", "info", "alternates")); err != nil {
+		t.Errorf("Expected alternates file: %v", err)
+	}
+}
+
+func TestForkRepoConcurrently(t *testing.T) {
+	t.Chdir(t.TempDir())
+	upstreamPath := filepath.Join("repos", "ada", "project.git")
+	if err := CreateRepo(upstreamPath, "ada", true); err != nil {
+		t.Fatalf("CreateRepo failed: %v", err)
+	}
+	head := commitFiles(t, upstreamPath, "main", map[string]string{"a.txt": "1"})
+
+	// Racing forks to the same path must not remove the one that won
+	forkPath := filepath.Join("repos", "ben", "project.git")
+	errs := make([]error, 4)
+	var wg sync.WaitGroup
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = ForkRepo(upstreamPath, forkPath, "ben")
+		}()
+	}
+	wg.Wait()
+
+	created := 0
+	for _, err := range errs {
+		if err == nil {
+			created++
+		} else if !errors.Is(err, ErrRepoExists) {
+			t.Errorf("Expected ErrRepoExists, got %v", err)
+		}
+	}
+	if created != 1 {
+		t.Errorf("Expected one fork to be created, got %d", created)
+	}
+	if c, err := GetCommitByHash(forkPath, "main"); err != nil || c.Hash != head {
+		t.Errorf("Expected fork main at %s, got %+v, %v", head, c, err)
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join("repos", "ben", ".*")); len(leftovers) != 0 {
+		t.Errorf("Expected no temporary directories, found %v", leftovers)
+	}
+}
@@ -0,0 +1,368 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// ErrNotTemplate is returned when generating from a repository that is not a template
+var ErrNotTemplate = errors.New("repository is not a template")
+
+// TemplateConfigFile lists the files of a template repository, one glob per
+// line, whose paths and contents get the placeholders ${REPO_NAME}, ${OWNER},
+// ${TEMPLATE_NAME}, ${TEMPLATE_OWNER} and ${YEAR} substituted. It is not copied
+// to generated repositories.
+const TemplateConfigFile = ".librebucket/template"
+
+// GenerateOptions describes a repository generated from a template
+type GenerateOptions struct {
+	Public             bool
+	Description        string
+	IncludeAllBranches bool // Copy all branches instead of only the default branch
+	Author             object.Signature
+}
+
+// GenerateRepo creates a repository at newPath owned by owner with the files of
+// a template repository. Every copied branch starts with a single commit that is
+// unrelated to the history of the template.
+func GenerateRepo(templatePath, newPath, owner string, opts GenerateOptions) error {
+	safeTemplatePath, err := resolveSafePath(safeRepoBaseDir, templatePath)
+	if err != nil {
+		return fmt.Errorf("invalid repo path: %w", err)
+	}
+	safeNewPath, err := resolveSafePath(safeRepoBaseDir, newPath)
+	if err != nil {
+		return fmt.Errorf("invalid repo path: %w", err)
+	}
+	newOwner, newName, err := repoKey(safeNewPath)
+	if err != nil {
+		return err
+	}
+	if newOwner != owner {
+		return fmt.Errorf("repository %s must be created below repos/%s", newPath, owner)
+	}
+	if !ValidRepoName(newName) {
+		return fmt.Errorf("%w: %s", ErrInvalidRepoName, newName)
+	}
+	if err := validateDescription(opts.Description); err != nil {
+		return err
+	}
+
+	templateMeta, err := LoadRepoMeta(safeTemplatePath)
+	if err != nil {
+		return err
+	}
+	if !templateMeta.IsTemplate {
+		return fmt.Errorf("%w: %s", ErrNotTemplate, RepoFullName(safeTemplatePath))
+	}
+	if _, err := os.Stat(safeNewPath); err == nil {
+		return fmt.Errorf("%w: %s", ErrRepoExists, RepoFullName(safeNewPath))
+	}
+	src, err := openRepo(safeTemplatePath)
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	templateOwner, templateName, _ := repoKey(safeTemplatePath)
+	g := &generator{
+		src: src,
+		placeholders: strings.NewReplacer(
+			"${REPO_NAME}", newName,
+			"${OWNER}", owner,
+			"${TEMPLATE_NAME}", templateName,
+			"${TEMPLATE_OWNER}", templateOwner,
+			"${YEAR}", strconv.Itoa(time.Now().Year()),
+		),
+		copied: make(map[plumbing.Hash]bool),
+	}
+
+	defaultBranch := templateMeta.DefaultBranch
+	if defaultBranch == "" {
+		defaultBranch = defaultBranchName
+	}
+	branches, err := g.branches(defaultBranch, opts.IncludeAllBranches)
+	if err != nil {
+		return err
+	}
+	// The globs are read from the default branch and apply to all branches
+	if tip, ok := branches[defaultBranch]; ok {
+		if g.patterns, err = readTemplatePatterns(tip); err != nil {
+			return err
+		}
+	}
+
+	// Generate next to the final path, so the rename below cannot cross
+	// filesystems and a repository created there meanwhile is never removed
+	ownerDir := filepath.Dir(safeNewPath)
+	if err := os.MkdirAll(ownerDir, 0755); err != nil {
+		return fmt.Errorf("failed to create owner directory: %w", err)
+	}
+	tmpDir, err := os.MkdirTemp(ownerDir, "."+newName+".generate-")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	g.dst, err = git.PlainInit(tmpDir, true)
+	if err != nil {
+		return fmt.Errorf("failed to create repo: %w", err)
+	}
+	if err := g.generate(branches, defaultBranch, opts.Author); err != nil {
+		return err
+	}
+
+	// Renaming fails if another repository took the path in the meantime
+	if err := os.Rename(tmpDir, safeNewPath); err != nil {
+		if _, statErr := os.Stat(safeNewPath); statErr == nil {
+			return fmt.Errorf("%w: %s", ErrRepoExists, RepoFullName(safeNewPath))
+		}
+		return fmt.Errorf("failed to move repository into place: %w", err)
+	}
+
+	now := time.Now()
+	meta := RepoMeta{
+		Owner:         owner,
+		Public:        opts.Public,
+		Description:   opts.Description,
+		Languages:     templateMeta.Languages,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+		DefaultBranch: defaultBranch,
+	}
+	if tip, ok := g.tips[defaultBranch]; ok {
+		meta.LastCommit = tip.String()
+	}
+	if err := SaveRepoMeta(safeNewPath, meta); err != nil {
+		os.RemoveAll(safeNewPath)
+		return err
+	}
+	return nil
+}
+
+// generator copies the files of a template repository into a new repository
+type generator struct {
+	src, dst     *git.Repository
+	patterns     []string
+	placeholders *strings.Replacer
+	copied       map[plumbing.Hash]bool   // Objects already copied to dst
+	tips         map[string]plumbing.Hash // New commit of each branch
+}
+
+// branches returns the tip commits of the branches to copy. An empty template
+// has none.
+func (g *generator) branches(defaultBranch string, all bool) (map[string]*object.Commit, error) {
+	branches := make(map[string]*object.Commit)
+	refs, err := g.src.Branches()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list branches: %w", err)
+	}
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		name := ref.Name().Short()
+		if name != defaultBranch && !all {
+			return nil
+		}
+		c, err := g.src.CommitObject(ref.Hash())
+		if err != nil {
+			return fmt.Errorf("failed to get commit of branch %s: %w", name, err)
+		}
+		branches[name] = c
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return branches, nil
+}
+
+// generate writes a root commit for each branch and points HEAD at the default branch
+func (g *generator) generate(branches map[string]*object.Commit, defaultBranch string, author object.Signature) error {
+	head := plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.NewBranchReferenceName(defaultBranch))
+	if err := g.dst.Storer.SetReference(head); err != nil {
+		return fmt.Errorf("failed to set HEAD: %w", err)
+	}
+
+	// All branches share the time of generation
+	if author.When.IsZero() {
+		author.When = time.Now()
+	}
+	g.tips = make(map[string]plumbing.Hash, len(branches))
+	for branch, tip := range branches {
+		treeHash, err := g.copyTree(tip)
+		if err != nil {
+			return fmt.Errorf("failed to copy branch %s: %w", branch, err)
+		}
+		hash, err := writeRootCommit(g.dst, plumbing.NewBranchReferenceName(branch), treeHash, author)
+		if err != nil {
+			return err
+		}
+		g.tips[branch] = hash
+	}
+	return nil
+}
+
+// copyTree stores the tree of a commit in the new repository, substituting
+// placeholders in the paths and contents of the files selected by the patterns
+func (g *generator) copyTree(c *object.Commit) (plumbing.Hash, error) {
+	tree, err := c.Tree()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	entries := make(map[string]object.TreeEntry)
+	add := func(name string, entry object.TreeEntry) error {
+		if _, ok := entries[name]; ok {
+			return fmt.Errorf("%w: template paths collide at %s after substitution", ErrInvalidSettings, name)
+		}
+		entries[name] = entry
+		return nil
+	}
+	walker := object.NewTreeWalker(tree, true, nil)
+	defer walker.Close()
+	for {
+		name, entry, err := walker.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		if entry.Mode == filemode.Dir || name == TemplateConfigFile {
+			continue
+		}
+
+		// Submodules point to commits of other repositories
+		if entry.Mode == filemode.Submodule {
+			if err := add(name, entry); err != nil {
+				return plumbing.ZeroHash, err
+			}
+			continue
+		}
+		if !g.selected(name) {
+			if err := g.copyObject(entry.Hash); err != nil {
+				return plumbing.ZeroHash, err
+			}
+			if err := add(name, entry); err != nil {
+				return plumbing.ZeroHash, err
+			}
+			continue
+		}
+
+		hash, err := g.substitute(entry.Hash)
+		if err != nil {
+			return plumbing.ZeroHash, fmt.Errorf("failed to substitute placeholders in %s: %w", name, err)
+		}
+		if err := add(g.placeholders.Replace(name), object.TreeEntry{Mode: entry.Mode, Hash: hash}); err != nil {
+			return plumbing.ZeroHash, err
+		}
+	}
+
+	// A file cannot also be a directory of another file
+	for name := range entries {
+		for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+			if _, ok := entries[dir]; ok {
+				return plumbing.ZeroHash, fmt.Errorf("%w: template paths collide at %s after substitution", ErrInvalidSettings, dir)
+			}
+		}
+	}
+	return writeTree(g.dst, entries)
+}
+
+// selected reports whether placeholders are substituted in a file
+func (g *generator) selected(name string) bool {
+	for _, pattern := range g.patterns {
+		if matchTemplatePattern(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// copyObject copies an object from the template to the new repository
+func (g *generator) copyObject(hash plumbing.Hash) error {
+	if g.copied[hash] {
+		return nil
+	}
+	obj, err := g.src.Storer.EncodedObject(plumbing.AnyObject, hash)
+	if err != nil {
+		return fmt.Errorf("failed to read object %s: %w", hash, err)
+	}
+	if _, err := g.dst.Storer.SetEncodedObject(obj); err != nil {
+		return fmt.Errorf("failed to copy object %s: %w", hash, err)
+	}
+	g.copied[hash] = true
+	return nil
+}
+
+// substitute stores a copy of a blob with its placeholders replaced. Binary
+// files are copied unchanged.
+func (g *generator) substitute(hash plumbing.Hash) (plumbing.Hash, error) {
+	blob, err := g.src.BlobObject(hash)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	reader, err := blob.Reader()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	content, err := io.ReadAll(reader)
+	reader.Close()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if bytes.IndexByte(content, 0) >= 0 {
+		return hash, g.copyObject(hash)
+	}
+	return storeBlob(g.dst, []byte(g.placeholders.Replace(string(content))))
+}
+
+// readTemplatePatterns reads the globs of TemplateConfigFile, skipping blank
+// lines and # comments. A template without the file has no placeholders.
+func readTemplatePatterns(c *object.Commit) ([]string, error) {
+	f, err := c.File(TemplateConfigFile)
+	if errors.Is(err, object.ErrFileNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", TemplateConfigFile, err)
+	}
+	content, err := f.Contents()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", TemplateConfigFile, err)
+	}
+
+	var patterns []string
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			patterns = append(patterns, line)
+		}
+	}
+	return patterns, scanner.Err()
+}
+
+// matchTemplatePattern matches a file path against a glob. Globs without a slash
+// match the file name in any directory, "dir/**" matches everything below dir.
+func matchTemplatePattern(pattern, name string) bool {
+	if dir, ok := strings.CutSuffix(pattern, "/**"); ok {
+		return strings.HasPrefix(name, dir+"/")
+	}
+	if !strings.Contains(pattern, "/") {
+		name = path.Base(name)
+	}
+	matched, _ := path.Match(pattern, name)
+	return matched
+}
@@ -0,0 +1,164 @@
+package git
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+func TestGenerateRepo(t *testing.T) {
+	t.Chdir(t.TempDir())
+	templatePath := filepath.Join("repos", "kai", "skeleton.git")
+	if err := CreateRepo(templatePath, "kai", true); err != nil {
+		t.Fatalf("CreateRepo failed: %v", err)
+	}
+	commitFiles(t, templatePath, "main", map[string]string{"README.md": "old"})
+	tip := commitFiles(t, templatePath, "main", map[string]string{
+		TemplateConfigFile:         "# Files with placeholders\n*.md\ncmd/**\n",
+		"README.md":                "# ${REPO_NAME} by ${OWNER}, from ${TEMPLATE_OWNER}/${TEMPLATE_NAME}",
+		"cmd/${REPO_NAME}/main.go": "package main // ${REPO_NAME}",
+		"config/settings.yaml":     "name: ${REPO_NAME}",
+	})
+	commitFiles(t, templatePath, "docs", map[string]string{"index.md": "${REPO_NAME} docs"})
+
+	newPath := filepath.Join("repos", "lou", "orders.git")
+	opts := GenerateOptions{Description: "Order service", Author: object.Signature{Name: "lou", Email: "lou@example.com"}}
+	if err := GenerateRepo(templatePath, newPath, "lou", opts); !errors.Is(err, ErrNotTemplate) {
+		t.Fatalf("Expected ErrNotTemplate, got %v", err)
+	}
+	isTemplate := true
+	if err := UpdateRepoSettings(templatePath, RepoSettings{IsTemplate: &isTemplate}); err != nil {
+		t.Fatalf("UpdateRepoSettings failed: %v", err)
+	}
+	if err := GenerateRepo(templatePath, newPath, "lou", opts); err != nil {
+		t.Fatalf("GenerateRepo failed: %v", err)
+	}
+
+	meta, err := LoadRepoMeta(newPath)
+	if err != nil || meta.Owner != "lou" || meta.Public || meta.Description != "Order service" || meta.IsTemplate {
+		t.Errorf("Unexpected metadata %+v, %v", meta, err)
+	}
+	c, err := GetCommitByHash(newPath, "main")
+	if err != nil {
+		t.Fatalf("GetCommitByHash failed: %v", err)
+	}
+	if c.Hash == tip || len(c.Parents) != 0 || c.AuthorEmail != "lou@example.com" || meta.LastCommit != c.Hash {
+		t.Errorf("Expected a single new commit, got %+v", c)
+	}
+
+	for path, want := range map[string]string{
+		"README.md":            "# orders by lou, from kai/skeleton",
+		"cmd/orders/main.go":   "package main // orders",
+		"config/settings.yaml": "name: ${REPO_NAME}",
+	} {
+		if content, err := GetFileAtCommit(newPath, path, c.Hash); err != nil || string(content) != want {
+			t.Errorf("%s = %q, %v; want %q", path, content, err, want)
+		}
+	}
+	if _, err := GetFileAtCommit(newPath, TemplateConfigFile, c.Hash); err == nil {
+		t.Errorf("Expected %s not to be copied", TemplateConfigFile)
+	}
+	if _, err := GetCommitByHash(newPath, "docs"); err == nil {
+		t.Error("Expected only the default branch to be copied")
+	}
+
+	// All branches, with the patterns of the default branch
+	allPath := filepath.Join("repos", "lou", "payments.git")
+	if err := GenerateRepo(templatePath, allPath, "lou", GenerateOptions{IncludeAllBranches: true}); err != nil {
+		t.Fatalf("GenerateRepo failed: %v", err)
+	}
+	docs, err := GetCommitByHash(allPath, "docs")
+	if err != nil {
+		t.Fatalf("Expected docs branch: %v", err)
+	}
+	if content, _ := GetFileAtCommit(allPath, "index.md", docs.Hash); string(content) != "payments docs" {
+		t.Errorf("index.md = %q", content)
+	}
+
+	if err := GenerateRepo(templatePath, allPath, "lou", opts); !errors.Is(err, ErrRepoExists) {
+		t.Errorf("Expected ErrRepoExists, got %v", err)
+	}
+}
+
+func TestGenerateRepoInvalid(t *testing.T) {
+	t.Chdir(t.TempDir())
+	templatePath := filepath.Join("repos", "hana", "skeleton.git")
+	if err := CreateRepo(templatePath, "hana", true); err != nil {
+		t.Fatalf("CreateRepo failed: %v", err)
+	}
+	isTemplate := true
+	if err := UpdateRepoSettings(templatePath, RepoSettings{IsTemplate: &isTemplate}); err != nil {
+		t.Fatalf("UpdateRepoSettings failed: %v", err)
+	}
+	commitFiles(t, templatePath, "main", map[string]string{"README.md": "skeleton"})
+
+	newPath := filepath.Join("repos", "ian", "app.git")
+	opts := GenerateOptions{Description: strings.Repeat("x", maxDescriptionLength+1)}
+	if err := GenerateRepo(templatePath, newPath, "ian", opts); !errors.Is(err, ErrInvalidSettings) {
+		t.Errorf("Expected ErrInvalidSettings for a long description, got %v", err)
+	}
+
+	// Two files, or a file and a directory, that substitute to the same path
+	for _, files := range []map[string]string{
+		{TemplateConfigFile: "*.md\n", "${REPO_NAME}.md": "a", "app.md": "b"},
+		{TemplateConfigFile: "${REPO_NAME}\n", "${REPO_NAME}": "a", "app/main.go": "b"},
+	} {
+		commitFiles(t, templatePath, "main", files)
+		if err := GenerateRepo(templatePath, newPath, "ian", GenerateOptions{}); !errors.Is(err, ErrInvalidSettings) {
+			t.Errorf("Expected ErrInvalidSettings for colliding paths %v, got %v", files, err)
+		}
+	}
+	if _, err := os.Stat(newPath); !os.IsNotExist(err) {
+		t.Errorf("Expected no repository to be generated, got %v", err)
+	}
+}
+
+func TestGenerateRepoConcurrently(t *testing.T) {
+	t.Chdir(t.TempDir())
+	templatePath := filepath.Join("repos", "cal", "skeleton.git")
+	if err := CreateRepo(templatePath, "cal", true); err != nil {
+		t.Fatalf("CreateRepo failed: %v", err)
+	}
+	commitFiles(t, templatePath, "main", map[string]string{"README.md": "skeleton"})
+	isTemplate := true
+	if err := UpdateRepoSettings(templatePath, RepoSettings{IsTemplate: &isTemplate}); err != nil {
+		t.Fatalf("UpdateRepoSettings failed: %v", err)
+	}
+
+	// Racing generations to the same path must not remove the one that won
+	newPath := filepath.Join("repos", "dee", "orders.git")
+	opts := GenerateOptions{Author: object.Signature{Name: "dee", Email: "dee@example.com"}}
+	errs := make([]error, 4)
+	var wg sync.WaitGroup
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = GenerateRepo(templatePath, newPath, "dee", opts)
+		}()
+	}
+	wg.Wait()
+
+	created := 0
+	for _, err := range errs {
+		if err == nil {
+			created++
+		} else if !errors.Is(err, ErrRepoExists) {
+			t.Errorf("Expected ErrRepoExists, got %v", err)
+		}
+	}
+	if created != 1 {
+		t.Errorf("Expected one repository to be generated, got %d", created)
+	}
+	if _, err := GetCommitByHash(newPath, "main"); err != nil {
+		t.Errorf("Expected the generated main branch, got %v", err)
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join("repos", "dee", ".*")); len(leftovers) != 0 {
+		t.Errorf("Expected no temporary directories, found %v", leftovers)
+	}
+}
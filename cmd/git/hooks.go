@@ -0,0 +1,192 @@
+package git
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// pushPolicyEnv passes the push policy of a repository to the pre-receive hook
+	pushPolicyEnv = "LIBREBUCKET_PUSH_POLICY"
+	// pushLogEnv names the file the post-receive hook records ref updates in
+	pushLogEnv = "LIBREBUCKET_PUSH_LOG"
+)
+
+// hookNames are the hooks written by InstallHooks
+var hookNames = []string{"pre-receive", "post-receive"}
+
+// ErrHooksNotInstalled is returned when pushing to a repository with a push
+// policy before InstallHooks was called, since the policy could not be enforced
+var ErrHooksNotInstalled = errors.New("git hooks are not installed")
+
+// hooksDir is the directory receive-pack runs hooks from, set by InstallHooks
+var hooksDir string
+
+// InstallHooks writes the hooks of receive-pack to dir. The hooks run the
+// current executable with "hook <name>", which must call RunHook.
+func InstallHooks(dir string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find executable for git hooks: %w", err)
+	}
+	if dir, err = filepath.Abs(dir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create git hooks directory: %w", err)
+	}
+	quoted := "'" + strings.ReplaceAll(exe, "'", `'\''`) + "'"
+	for _, name := range hookNames {
+		script := "#!/bin/sh\nexec " + quoted + " hook " + name + "\n"
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0755); err != nil {
+			return fmt.Errorf("failed to write %s hook: %w", name, err)
+		}
+	}
+	hooksDir = dir
+	return nil
+}
+
+// ReceivePack is a git receive-pack command that records the refs a push
+// updated. Close must be called once it is done.
+type ReceivePack struct {
+	*exec.Cmd
+
+	// logPath is the file the post-receive hook writes ref updates to, empty
+	// if the hooks are not installed
+	logPath string
+}
+
+// ReceivePackCommand returns the command that runs git receive-pack with
+// options on a repository. Pushes are checked against the push policy of the
+// repository by the pre-receive hook, and the post-receive hook records the
+// updated refs for RefUpdates.
+func ReceivePackCommand(repoPath string, options ...string) (*ReceivePack, error) {
+	meta, err := LoadRepoMeta(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	args := append([]string{"receive-pack"}, options...)
+	args = append(args, "--", filepath.Base(repoPath))
+	if hooksDir == "" {
+		if !meta.PushPolicy.IsZero() {
+			return nil, ErrHooksNotInstalled
+		}
+		cmd := exec.Command("git", args...)
+		cmd.Dir = filepath.Dir(repoPath)
+		return &ReceivePack{Cmd: cmd}, nil
+	}
+
+	f, err := os.CreateTemp("", "librebucket-push-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create push log: %w", err)
+	}
+	f.Close()
+	env := append(os.Environ(), pushLogEnv+"="+f.Name())
+	if !meta.PushPolicy.IsZero() {
+		policy, err := json.Marshal(meta.PushPolicy)
+		if err != nil {
+			os.Remove(f.Name())
+			return nil, err
+		}
+		env = append(env, pushPolicyEnv+"="+string(policy))
+	}
+	cmd := exec.Command("git", append([]string{"-c", "core.hooksPath=" + hooksDir}, args...)...)
+	cmd.Dir = filepath.Dir(repoPath)
+	cmd.Env = env
+	return &ReceivePack{Cmd: cmd, logPath: f.Name()}, nil
+}
+
+// RefUpdates returns the refs updated by the push once the command finished,
+// none if the push was rejected or the hooks are not installed
+func (rp *ReceivePack) RefUpdates() ([]RefUpdate, error) {
+	if rp.logPath == "" {
+		return nil, nil
+	}
+	f, err := os.Open(rp.logPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read push log: %w", err)
+	}
+	defer f.Close()
+	return ParseRefUpdates(f)
+}
+
+// Close removes the record of updated refs
+func (rp *ReceivePack) Close() error {
+	if rp.logPath == "" {
+		return nil
+	}
+	return os.Remove(rp.logPath)
+}
+
+// RunHook runs a hook installed by InstallHooks with the input git passes on
+// stdin and returns its exit code. Messages written to stderr are shown to the
+// pushing client.
+func RunHook(name string, stdin io.Reader, stderr io.Writer) int {
+	switch name {
+	case "pre-receive":
+		return runPreReceive(stdin, stderr)
+	case "post-receive":
+		return runPostReceive(stdin, stderr)
+	}
+	fmt.Fprintf(stderr, "unknown hook %q\n", name)
+	return 1
+}
+
+// runPreReceive rejects pushes that violate the push policy of the repository
+func runPreReceive(stdin io.Reader, stderr io.Writer) int {
+	value := os.Getenv(pushPolicyEnv)
+	if value == "" {
+		return 0
+	}
+	var policy PushPolicy
+	if err := json.Unmarshal([]byte(value), &policy); err != nil {
+		fmt.Fprintf(stderr, "Invalid push policy: %v\n", err)
+		return 1
+	}
+	updates, err := ParseRefUpdates(stdin)
+	if err != nil {
+		fmt.Fprintf(stderr, "Failed to read ref updates: %v\n", err)
+		return 1
+	}
+
+	// Hooks of bare repositories run in the repository
+	violations, err := CheckPush(".", policy, updates)
+	if err != nil {
+		fmt.Fprintf(stderr, "Failed to check the push policy: %v\n", err)
+		return 1
+	}
+	if len(violations) == 0 {
+		return 0
+	}
+	fmt.Fprintln(stderr, "Push rejected by the push policy of this repository:")
+	for _, v := range violations {
+		fmt.Fprintf(stderr, "  %s\n", v)
+	}
+	return 1
+}
+
+// runPostReceive appends the refs updated by a push to the push log, where
+// ReceivePack.RefUpdates reads them once receive-pack exits
+func runPostReceive(stdin io.Reader, stderr io.Writer) int {
+	logPath := os.Getenv(pushLogEnv)
+	if logPath == "" {
+		return 0
+	}
+	f, err := os.OpenFile(logPath, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		fmt.Fprintf(stderr, "Failed to record ref updates: %v\n", err)
+		return 1
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, stdin); err != nil {
+		fmt.Fprintf(stderr, "Failed to record ref updates: %v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,128 @@
+package git
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"syscall"
+	"time"
+
+	gitclient "github.com/go-git/go-git/v5/plumbing/transport/client"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+)
+
+// ErrForbiddenHost is returned for remote URLs whose host is on a local network
+var ErrForbiddenHost = errors.New("host is not allowed")
+
+// AllowedLocalHosts lists the hosts, addresses and CIDR ranges (e.g.
+// "ci.internal" or "10.1.0.0/16") that the server may connect to on behalf of
+// users although they are loopback, private, link-local or unspecified
+// addresses. It is empty by default, so users cannot reach internal services.
+var AllowedLocalHosts []string
+
+// hostLookupTimeout bounds resolving the host of a remote URL when it is saved
+const hostLookupTimeout = 5 * time.Second
+
+// Mirrors, migrations and push mirrors reach HTTP remotes through dialRemote
+func init() {
+	client := githttp.NewClient(&http.Client{Transport: remoteTransport()})
+	gitclient.InstallProtocol("http", client)
+	gitclient.InstallProtocol("https", client)
+}
+
+// remoteTransport is the default transport without proxies, dialing through
+// dialRemote
+func remoteTransport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = nil
+	t.DialContext = dialRemote
+	return t
+}
+
+// isLocalAddr reports whether ip is on the server itself or a local network
+func isLocalAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsUnspecified()
+}
+
+// isAllowedLocal reports whether AllowedLocalHosts lists host or ip
+func isAllowedLocal(host string, ip netip.Addr) bool {
+	for _, allowed := range AllowedLocalHosts {
+		if prefix, err := netip.ParsePrefix(allowed); err == nil {
+			if ip.IsValid() && prefix.Contains(ip.Unmap()) {
+				return true
+			}
+		} else if addr, err := netip.ParseAddr(allowed); err == nil {
+			if ip.IsValid() && addr.Unmap() == ip.Unmap() {
+				return true
+			}
+		} else if strings.EqualFold(allowed, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkRemoteAddr returns ErrForbiddenHost if host connects to a local ip that
+// is not allowed
+func checkRemoteAddr(host string, ip netip.Addr) error {
+	if !isLocalAddr(ip) || isAllowedLocal(host, ip) {
+		return nil
+	}
+	if host == ip.String() {
+		return fmt.Errorf("%w: %s is a local address", ErrForbiddenHost, host)
+	}
+	return fmt.Errorf("%w: %s resolves to the local address %s", ErrForbiddenHost, host, ip)
+}
+
+// CheckRemoteHost returns ErrForbiddenHost if host is, or resolves to, a local
+// address that AllowedLocalHosts does not list. Hosts that do not resolve are
+// accepted, since connections are checked again by dialRemote.
+func CheckRemoteHost(ctx context.Context, host string) error {
+	if ip, err := netip.ParseAddr(host); err == nil {
+		return checkRemoteAddr(host, ip)
+	}
+	if isAllowedLocal(host, netip.Addr{}) {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, hostLookupTimeout)
+	defer cancel()
+	ips, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil
+	}
+	for _, ip := range ips {
+		if err := checkRemoteAddr(host, ip); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dialRemote connects like net.Dialer.DialContext, but refuses local addresses
+// that AllowedLocalHosts does not list. The check runs on the address actually
+// dialed, so a DNS answer that changed since the URL was saved cannot bypass it.
+func dialRemote(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control: func(_, address string, _ syscall.RawConn) error {
+			ip, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			return checkRemoteAddr(host, ip.Addr())
+		},
+	}
+	return dialer.DialContext(ctx, network, addr)
+}
@@ -0,0 +1,220 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// ErrTemplateNotFound is returned when a .gitignore or license template does not exist
+var ErrTemplateNotFound = errors.New("template not found")
+
+// Kinds of templates for the initial commit, also the subdirectories of TemplatesDir
+const (
+	TemplateGitignore = "gitignore"
+	TemplateLicense   = "license"
+)
+
+// templateExtensions are the file extensions of the templates of each kind
+var templateExtensions = map[string]string{
+	TemplateGitignore: ".gitignore",
+	TemplateLicense:   ".txt",
+}
+
+// TemplatesDir holds the templates for the initial commit of new repositories,
+// e.g. gitignore/Go.gitignore and license/MIT.txt. Admins can add their own.
+var TemplatesDir = filepath.Join("configs", "templates")
+
+// InitOptions describes the initial commit of a new repository. No commit is
+// made unless AutoInit is set or a template is chosen.
+type InitOptions struct {
+	AutoInit          bool   // Add a README.md
+	GitignoreTemplate string // Name of a gitignore template, e.g. "Go"
+	LicenseTemplate   string // Name of a license template, e.g. "MIT"
+	DefaultBranch     string // Branch of the initial commit, "main" if empty
+	Author            object.Signature
+}
+
+// ListTemplates returns the names of the available templates of a kind
+func ListTemplates(kind string) ([]string, error) {
+	ext, ok := templateExtensions[kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown template kind %q", kind)
+	}
+	entries, err := os.ReadDir(filepath.Join(TemplatesDir, kind))
+	if errors.Is(err, os.ErrNotExist) {
+		return []string{}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to list %s templates: %w", kind, err)
+	}
+
+	names := []string{}
+	for _, entry := range entries {
+		if name, ok := strings.CutSuffix(entry.Name(), ext); ok && entry.Type().IsRegular() && name != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// loadTemplate returns the content of a template, matching its name case-insensitively
+func loadTemplate(kind, name string) ([]byte, error) {
+	names, err := ListTemplates(kind)
+	if err != nil {
+		return nil, err
+	}
+	for _, candidate := range names {
+		if strings.EqualFold(candidate, name) {
+			content, err := os.ReadFile(filepath.Join(TemplatesDir, kind, candidate+templateExtensions[kind]))
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s template %s: %w", kind, candidate, err)
+			}
+			return content, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s %q, available: %s", ErrTemplateNotFound, kind, name, strings.Join(names, ", "))
+}
+
+// initialFiles returns the files of the initial commit of a repository
+func initialFiles(name, owner string, opts InitOptions) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	if opts.AutoInit {
+		files["README.md"] = []byte("# " + name + "\n")
+	}
+	if opts.GitignoreTemplate != "" {
+		content, err := loadTemplate(TemplateGitignore, opts.GitignoreTemplate)
+		if err != nil {
+			return nil, err
+		}
+		files[".gitignore"] = content
+	}
+	if opts.LicenseTemplate != "" {
+		content, err := loadTemplate(TemplateLicense, opts.LicenseTemplate)
+		if err != nil {
+			return nil, err
+		}
+		// Placeholders as used by choosealicense.com
+		replacer := strings.NewReplacer("[year]", strconv.Itoa(time.Now().Year()), "[fullname]", owner)
+		files["LICENSE"] = []byte(replacer.Replace(string(content)))
+	}
+	return files, nil
+}
+
+// writeInitialCommit stores a root commit of files on branch
+func writeInitialCommit(r *git.Repository, branch plumbing.ReferenceName, files map[string][]byte, author object.Signature) (plumbing.Hash, error) {
+	entries := make(map[string]object.TreeEntry, len(files))
+	for name, content := range files {
+		hash, err := storeBlob(r, content)
+		if err != nil {
+			return plumbing.ZeroHash, fmt.Errorf("failed to store %s: %w", name, err)
+		}
+		entries[name] = object.TreeEntry{Mode: filemode.Regular, Hash: hash}
+	}
+	treeHash, err := writeTree(r, entries)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return writeRootCommit(r, branch, treeHash, author)
+}
+
+// writeRootCommit stores a commit without parents of the tree and points branch at it
+func writeRootCommit(r *git.Repository, branch plumbing.ReferenceName, treeHash plumbing.Hash, author object.Signature) (plumbing.Hash, error) {
+	if author.When.IsZero() {
+		author.When = time.Now()
+	}
+	commit := &object.Commit{
+		Author:    author,
+		Committer: author,
+		Message:   "Initial commit\n",
+		TreeHash:  treeHash,
+	}
+	hash, err := storeObject(r, plumbing.CommitObject, func(obj plumbing.EncodedObject) error {
+		return commit.Encode(obj)
+	})
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to store commit: %w", err)
+	}
+
+	if err := r.Storer.SetReference(plumbing.NewHashReference(branch, hash)); err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to create branch: %w", err)
+	}
+	return hash, nil
+}
+
+// writeTree stores a tree of entries keyed by their slash-separated path, with
+// subtrees for nested paths. The names of the entries are ignored.
+func writeTree(r *git.Repository, entries map[string]object.TreeEntry) (plumbing.Hash, error) {
+	tree := &object.Tree{}
+	subtrees := make(map[string]map[string]object.TreeEntry)
+	for name, entry := range entries {
+		if dir, rest, ok := strings.Cut(name, "/"); ok {
+			if subtrees[dir] == nil {
+				subtrees[dir] = make(map[string]object.TreeEntry)
+			}
+			subtrees[dir][rest] = entry
+			continue
+		}
+		entry.Name = name
+		tree.Entries = append(tree.Entries, entry)
+	}
+	for dir, sub := range subtrees {
+		hash, err := writeTree(r, sub)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		tree.Entries = append(tree.Entries, object.TreeEntry{Name: dir, Mode: filemode.Dir, Hash: hash})
+	}
+
+	// Git sorts directories as if their name ended with a slash
+	sortKey := func(e object.TreeEntry) string {
+		if e.Mode == filemode.Dir {
+			return e.Name + "/"
+		}
+		return e.Name
+	}
+	sort.Slice(tree.Entries, func(i, j int) bool {
+		return sortKey(tree.Entries[i]) < sortKey(tree.Entries[j])
+	})
+
+	hash, err := storeObject(r, plumbing.TreeObject, func(obj plumbing.EncodedObject) error {
+		return tree.Encode(obj)
+	})
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to store tree: %w", err)
+	}
+	return hash, nil
+}
+
+// storeBlob stores content as a blob
+func storeBlob(r *git.Repository, content []byte) (plumbing.Hash, error) {
+	return storeObject(r, plumbing.BlobObject, func(obj plumbing.EncodedObject) error {
+		w, err := obj.Writer()
+		if err != nil {
+			return err
+		}
+		defer w.Close()
+		_, err = w.Write(content)
+		return err
+	})
+}
+
+// storeObject stores an object of type typ written by encode
+func storeObject(r *git.Repository, typ plumbing.ObjectType, encode func(obj plumbing.EncodedObject) error) (plumbing.Hash, error) {
+	obj := r.Storer.NewEncodedObject()
+	obj.SetType(typ)
+	if err := encode(obj); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return r.Storer.SetEncodedObject(obj)
+}
@@ -0,0 +1,89 @@
+package git
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+func TestCreateRepoWithOptions(t *testing.T) {
+	t.Chdir(t.TempDir())
+	for kind, file := range map[string]string{
+		"gitignore/Go.gitignore": "*.test\n",
+		"license/MIT.txt":        "Copyright (c) [year] [fullname]\n",
+		"license/notes.md":       "not a template",
+	} {
+		path := filepath.Join(TemplatesDir, kind)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("MkdirAll failed: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(file), 0644); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+	}
+
+	if names, err := ListTemplates(TemplateLicense); err != nil || len(names) != 1 || names[0] != "MIT" {
+		t.Errorf("ListTemplates = %v, %v; want [MIT]", names, err)
+	}
+
+	repoPath := filepath.Join("repos", "ivy", "starter.git")
+	author := object.Signature{Name: "ivy", Email: "ivy@users.noreply.example.com"}
+	err := CreateRepoWithOptions(repoPath, "ivy", true, InitOptions{
+		AutoInit:          true,
+		GitignoreTemplate: "go",
+		LicenseTemplate:   "MIT",
+		DefaultBranch:     "trunk",
+		Author:            author,
+	})
+	if err != nil {
+		t.Fatalf("CreateRepoWithOptions failed: %v", err)
+	}
+
+	meta, err := LoadRepoMeta(repoPath)
+	if err != nil || meta.DefaultBranch != "trunk" || meta.LastCommit == "" {
+		t.Fatalf("Unexpected metadata %+v, %v", meta, err)
+	}
+	c, err := GetCommitByHash(repoPath, "HEAD")
+	if err != nil {
+		t.Fatalf("GetCommitByHash failed: %v", err)
+	}
+	if c.Hash != meta.LastCommit || c.AuthorEmail != author.Email || len(c.Parents) != 0 {
+		t.Errorf("Unexpected initial commit %+v", c)
+	}
+
+	year := strconv.Itoa(time.Now().Year())
+	for path, want := range map[string]string{
+		"README.md":  "# starter\n",
+		".gitignore": "*.test\n",
+		"LICENSE":    "Copyright (c) " + year + " ivy\n",
+	} {
+		content, err := GetFileAtCommit(repoPath, path, c.Hash)
+		if err != nil || string(content) != want {
+			t.Errorf("%s = %q, %v; want %q", path, content, err, want)
+		}
+	}
+
+	// Unknown templates fail before anything is created
+	emptyPath := filepath.Join("repos", "ivy", "other.git")
+	err = CreateRepoWithOptions(emptyPath, "ivy", true, InitOptions{LicenseTemplate: "WTFPL"})
+	if !errors.Is(err, ErrTemplateNotFound) || !strings.Contains(err.Error(), "MIT") {
+		t.Errorf("Expected ErrTemplateNotFound listing MIT, got %v", err)
+	}
+	if _, err := os.Stat(emptyPath); !os.IsNotExist(err) {
+		t.Errorf("Expected %s not to be created", emptyPath)
+	}
+
+	// Without options the repository stays empty
+	if err := CreateRepo(emptyPath, "ivy", true); err != nil {
+		t.Fatalf("CreateRepo failed: %v", err)
+	}
+	if _, err := GetCommitByHash(emptyPath, "HEAD"); err == nil {
+		t.Error("Expected no commit in an empty repository")
+	}
+}
@@ -0,0 +1,226 @@
+package git
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"path"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// languageExtensions maps lower-case file extensions to languages
+var languageExtensions = map[string]string{
+	".go":     "Go",
+	".js":     "JavaScript",
+	".mjs":    "JavaScript",
+	".cjs":    "JavaScript",
+	".jsx":    "JavaScript",
+	".ts":     "TypeScript",
+	".tsx":    "TypeScript",
+	".py":     "Python",
+	".rb":     "Ruby",
+	".rs":     "Rust",
+	".java":   "Java",
+	".kt":     "Kotlin",
+	".kts":    "Kotlin",
+	".scala":  "Scala",
+	".groovy": "Groovy",
+	".c":      "C",
+	".h":      "C",
+	".cc":     "C++",
+	".cpp":    "C++",
+	".cxx":    "C++",
+	".hh":     "C++",
+	".hpp":    "C++",
+	".cs":     "C#",
+	".fs":     "F#",
+	".m":      "Objective-C",
+	".swift":  "Swift",
+	".php":    "PHP",
+	".pl":     "Perl",
+	".pm":     "Perl",
+	".lua":    "Lua",
+	".r":      "R",
+	".dart":   "Dart",
+	".ex":     "Elixir",
+	".exs":    "Elixir",
+	".erl":    "Erlang",
+	".hs":     "Haskell",
+	".ml":     "OCaml",
+	".clj":    "Clojure",
+	".zig":    "Zig",
+	".nim":    "Nim",
+	".sh":     "Shell",
+	".bash":   "Shell",
+	".zsh":    "Shell",
+	".ps1":    "PowerShell",
+	".sql":    "SQL",
+	".html":   "HTML",
+	".htm":    "HTML",
+	".tmpl":   "Go Template",
+	".css":    "CSS",
+	".scss":   "SCSS",
+	".sass":   "Sass",
+	".less":   "Less",
+	".vue":    "Vue",
+	".svelte": "Svelte",
+	".proto":  "Protocol Buffer",
+	".tf":     "HCL",
+	".nix":    "Nix",
+	".cmake":  "CMake",
+}
+
+// languageFilenames maps well-known file names without a telling extension to languages
+var languageFilenames = map[string]string{
+	"Dockerfile":     "Dockerfile",
+	"Makefile":       "Makefile",
+	"makefile":       "Makefile",
+	"GNUmakefile":    "Makefile",
+	"CMakeLists.txt": "CMake",
+	"Rakefile":       "Ruby",
+	"Gemfile":        "Ruby",
+	"Jenkinsfile":    "Groovy",
+}
+
+// languageInterpreters maps shebang interpreters (without version suffix) to languages
+var languageInterpreters = map[string]string{
+	"sh":     "Shell",
+	"bash":   "Shell",
+	"zsh":    "Shell",
+	"dash":   "Shell",
+	"python": "Python",
+	"node":   "JavaScript",
+	"ruby":   "Ruby",
+	"perl":   "Perl",
+	"php":    "PHP",
+	"lua":    "Lua",
+}
+
+// vendoredDirs are directories whose files are third-party code
+var vendoredDirs = map[string]bool{
+	"vendor":           true,
+	"node_modules":     true,
+	"third_party":      true,
+	"bower_components": true,
+	"Godeps":           true,
+}
+
+// generatedSuffixes are file name suffixes of generated or minified files
+var generatedSuffixes = []string{
+	".pb.go",
+	"_generated.go",
+	".gen.go",
+	"_pb2.py",
+	".designer.cs",
+	".min.js",
+	".min.css",
+}
+
+// DetectLanguages returns the share (in percent) of each language in the tree
+// of a revision, weighted by file size. Vendored and generated files and files
+// of unknown languages are not counted.
+func DetectLanguages(repoPath, ref string) (map[string]float64, error) {
+	r, err := openRepo(repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	c, err := resolveRevision(r, ref)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := c.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tree for commit %s: %w", c.Hash, err)
+	}
+
+	sizes := make(map[string]int64)
+	var total int64
+	err = tree.Files().ForEach(func(f *object.File) error {
+		if f.Mode == filemode.Symlink || f.Size == 0 || isVendoredOrGenerated(f.Name) {
+			return nil
+		}
+		language, err := classifyFile(f)
+		if err != nil {
+			return err
+		}
+		if language != "" {
+			sizes[language] += f.Size
+			total += f.Size
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk tree: %w", err)
+	}
+
+	languages := make(map[string]float64, len(sizes))
+	for language, size := range sizes {
+		languages[language] = math.Round(float64(size)/float64(total)*10000) / 100
+	}
+	return languages, nil
+}
+
+// classifyFile returns the language of a file by name, extension or shebang, or
+// an empty string when it is unknown
+func classifyFile(f *object.File) (string, error) {
+	base := path.Base(f.Name)
+	if language, ok := languageFilenames[base]; ok {
+		return language, nil
+	}
+	if ext := path.Ext(base); ext != "" {
+		return languageExtensions[strings.ToLower(ext)], nil
+	}
+
+	reader, err := f.Reader()
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", f.Name, err)
+	}
+	defer reader.Close()
+	line, _ := bufio.NewReaderSize(reader, 256).ReadSlice('\n')
+	return shebangLanguage(string(line)), nil
+}
+
+// shebangLanguage returns the language of a "#!" interpreter line
+func shebangLanguage(line string) string {
+	line, ok := strings.CutPrefix(line, "#!")
+	if !ok {
+		return ""
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return ""
+	}
+
+	interpreter := path.Base(fields[0])
+	if interpreter == "env" {
+		// #!/usr/bin/env [-S] python3
+		interpreter = ""
+		for _, field := range fields[1:] {
+			if !strings.HasPrefix(field, "-") && !strings.Contains(field, "=") {
+				interpreter = field
+				break
+			}
+		}
+	}
+	return languageInterpreters[strings.TrimRight(interpreter, "0123456789.")]
+}
+
+// isVendoredOrGenerated reports whether a path is third-party or generated code
+func isVendoredOrGenerated(name string) bool {
+	dirs := strings.Split(path.Dir(name), "/")
+	for _, dir := range dirs {
+		if vendoredDirs[dir] {
+			return true
+		}
+	}
+	for _, suffix := range generatedSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
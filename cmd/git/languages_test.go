@@ -0,0 +1,59 @@
+package git
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDetectLanguages(t *testing.T) {
+	t.Chdir(t.TempDir())
+	repoPath := filepath.Join("repos", "kim", "languages.git")
+	if err := CreateRepo(repoPath, "kim", true); err != nil {
+		t.Fatalf("CreateRepo failed: %v", err)
+	}
+
+	commitFiles(t, repoPath, "main", map[string]string{
+		"main.go":                  strings.Repeat("g", 600),
+		"web/app.js":               strings.Repeat("j", 200),
+		"Makefile":                 strings.Repeat("m", 100),
+		"bin/deploy":               "#!/usr/bin/env python3\n" + strings.Repeat("p", 77),
+		"README.md":                strings.Repeat("r", 1000),
+		"vendor/lib/lib.go":        strings.Repeat("v", 1000),
+		"web/node_modules/x/a.js":  strings.Repeat("n", 1000),
+		"api/service.pb.go":        strings.Repeat("b", 1000),
+		"web/static/jquery.min.js": strings.Repeat("q", 1000),
+		"LICENSE":                  strings.Repeat("l", 1000),
+	})
+
+	languages, err := DetectLanguages(repoPath, "main")
+	if err != nil {
+		t.Fatalf("DetectLanguages failed: %v", err)
+	}
+	want := map[string]float64{"Go": 60, "JavaScript": 20, "Makefile": 10, "Python": 10}
+	if len(languages) != len(want) {
+		t.Errorf("Expected %v, got %v", want, languages)
+	}
+	for language, percent := range want {
+		if languages[language] != percent {
+			t.Errorf("%s: got %v%%, want %v%%", language, languages[language], percent)
+		}
+	}
+}
+
+func TestShebangLanguage(t *testing.T) {
+	tests := map[string]string{
+		"#!/bin/sh\n":                     "Shell",
+		"#!/usr/bin/env bash\n":           "Shell",
+		"#!/usr/bin/python3.11\n":         "Python",
+		"#!/usr/bin/env -S node --flag\n": "JavaScript",
+		"#!/usr/bin/env FOO=1 ruby\n":     "Ruby",
+		"#!/usr/bin/unknown\n":            "",
+		"plain text\n":                    "",
+	}
+	for line, want := range tests {
+		if got := shebangLanguage(line); got != want {
+			t.Errorf("shebangLanguage(%q) = %q, want %q", line, got, want)
+		}
+	}
+}
@@ -0,0 +1,320 @@
+package git
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"librebucket/cmd/db"
+)
+
+var (
+	// ErrInvalidRepoName is returned for repository names that cannot be used
+	ErrInvalidRepoName = errors.New("invalid repository name")
+	// ErrInvalidSettings is returned when repository settings fail validation
+	ErrInvalidSettings = errors.New("invalid repository settings")
+)
+
+// RepoRedirectTTL is how long the old name of a renamed or transferred
+// repository keeps redirecting to the new one
+const RepoRedirectTTL = 90 * 24 * time.Hour
+
+const (
+	maxDescriptionLength = 350
+	maxWebsiteLength     = 255
+	maxTopics            = 20
+)
+
+var (
+	repoNamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,100}$`)
+	topicPattern    = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,49}$`)
+)
+
+// ValidRepoName reports whether name can be used as a repository name
+func ValidRepoName(name string) bool {
+	return repoNamePattern.MatchString(name) && name != "." && name != ".." &&
+		!strings.HasSuffix(name, ".git")
+}
+
+// RepoSettings are the user-editable settings of a repository. Nil fields are
+// left unchanged.
+type RepoSettings struct {
+	Public        *bool
+	Description   *string
+	Website       *string
+	Topics        []string
+	DefaultBranch *string
+	IsTemplate    *bool
+}
+
+// UpdateRepoSettings validates and applies settings to a repository
+func UpdateRepoSettings(repoPath string, s RepoSettings) error {
+	if s.Description != nil {
+		if err := validateDescription(*s.Description); err != nil {
+			return err
+		}
+	}
+	if s.Website != nil && *s.Website != "" {
+		u, err := url.Parse(*s.Website)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || len(*s.Website) > maxWebsiteLength {
+			return fmt.Errorf("%w: website must be an http or https URL", ErrInvalidSettings)
+		}
+	}
+	var topics []string
+	if s.Topics != nil {
+		var err error
+		if topics, err = normalizeTopics(s.Topics); err != nil {
+			return err
+		}
+	}
+
+	// The default branch also moves HEAD, so it is not part of the update below
+	if s.DefaultBranch != nil {
+		if err := SetDefaultBranch(repoPath, *s.DefaultBranch); err != nil {
+			return err
+		}
+	}
+
+	return updateRepoMeta(repoPath, func(meta *RepoMeta) error {
+		if s.Public != nil {
+			meta.Public = *s.Public
+		}
+		if s.Description != nil {
+			meta.Description = *s.Description
+		}
+		if s.Website != nil {
+			meta.Website = *s.Website
+		}
+		if s.Topics != nil {
+			meta.Topics = topics
+		}
+		if s.IsTemplate != nil {
+			meta.IsTemplate = *s.IsTemplate
+		}
+		return nil
+	})
+}
+
+// validateDescription checks the length of a repository description
+func validateDescription(description string) error {
+	if len(description) > maxDescriptionLength {
+		return fmt.Errorf("%w: description is longer than %d characters", ErrInvalidSettings, maxDescriptionLength)
+	}
+	return nil
+}
+
+// normalizeTopics lower-cases, deduplicates and validates topics
+func normalizeTopics(topics []string) ([]string, error) {
+	normalized := make([]string, 0, len(topics))
+	for _, topic := range topics {
+		topic = strings.ToLower(strings.TrimSpace(topic))
+		if !topicPattern.MatchString(topic) {
+			return nil, fmt.Errorf("%w: topic %q must be lower-case letters, digits and hyphens", ErrInvalidSettings, topic)
+		}
+		if !slices.Contains(normalized, topic) {
+			normalized = append(normalized, topic)
+		}
+	}
+	if len(normalized) > maxTopics {
+		return nil, fmt.Errorf("%w: more than %d topics", ErrInvalidSettings, maxTopics)
+	}
+	return normalized, nil
+}
+
+// CheckMoveTarget returns ErrInvalidRepoName or ErrRepoExists if repoPath
+// cannot be moved to newPath, so callers can refuse before changing anything
+func CheckMoveTarget(repoPath, newPath string) error {
+	safeRepoPath, err := resolveSafePath(safeRepoBaseDir, repoPath)
+	if err != nil {
+		return fmt.Errorf("invalid repo path: %w", err)
+	}
+	safeNewPath, err := resolveSafePath(safeRepoBaseDir, newPath)
+	if err != nil {
+		return fmt.Errorf("invalid repo path: %w", err)
+	}
+	if safeNewPath == safeRepoPath {
+		return nil
+	}
+	_, newName, err := repoKey(safeNewPath)
+	if err != nil {
+		return err
+	}
+	if !ValidRepoName(newName) {
+		return fmt.Errorf("%w: %s", ErrInvalidRepoName, newName)
+	}
+	if _, err := os.Stat(safeNewPath); err == nil {
+		return fmt.Errorf("%w: %s", ErrRepoExists, RepoFullName(safeNewPath))
+	}
+	return nil
+}
+
+// MoveRepo renames a repository or transfers it to another owner by moving its
+// directory from repoPath to newPath. The metadata, stars and forks follow it,
+// and the old name redirects to the new one for RepoRedirectTTL.
+func MoveRepo(repoPath, newPath string) error {
+	safeRepoPath, err := resolveSafePath(safeRepoBaseDir, repoPath)
+	if err != nil {
+		return fmt.Errorf("invalid repo path: %w", err)
+	}
+	safeNewPath, err := resolveSafePath(safeRepoBaseDir, newPath)
+	if err != nil {
+		return fmt.Errorf("invalid repo path: %w", err)
+	}
+	if safeNewPath == safeRepoPath {
+		return nil
+	}
+
+	owner, name, err := repoKey(safeRepoPath)
+	if err != nil {
+		return err
+	}
+	newOwner, newName, err := repoKey(safeNewPath)
+	if err != nil {
+		return err
+	}
+	if !ValidRepoName(newName) {
+		return fmt.Errorf("%w: %s", ErrInvalidRepoName, newName)
+	}
+	if _, err := LoadRepoMeta(safeRepoPath); err != nil {
+		return err
+	}
+	if _, err := os.Stat(safeNewPath); err == nil {
+		return fmt.Errorf("%w: %s", ErrRepoExists, RepoFullName(safeNewPath))
+	}
+
+	if err := os.MkdirAll(filepath.Dir(safeNewPath), 0755); err != nil {
+		return fmt.Errorf("failed to create owner directory: %w", err)
+	}
+	if err := os.Rename(safeRepoPath, safeNewPath); err != nil {
+		return fmt.Errorf("failed to move repository: %w", err)
+	}
+	if err := db.RenameRepository(owner, name, newOwner, newName); err != nil {
+		os.Rename(safeNewPath, safeRepoPath)
+		return fmt.Errorf("failed to rename repo metadata: %w", err)
+	}
+
+	// Forks borrow objects by absolute path, point them to the new location
+	oldObjects := filepath.Join(safeRepoPath, "objects")
+	newObjects := filepath.Join(safeNewPath, "objects")
+	return forEachFork(newOwner+"/"+newName, func(forkPath string) error {
+		return rewriteAlternates(forkPath, func(dir string) string {
+			if dir == oldObjects {
+				return newObjects
+			}
+			return dir
+		})
+	})
+}
+
+// DeleteRepo removes a repository with its metadata, stars and redirects.
+// Forks that borrow its objects get their own copies first.
+func DeleteRepo(repoPath string) error {
+	safeRepoPath, err := resolveSafePath(safeRepoBaseDir, repoPath)
+	if err != nil {
+		return fmt.Errorf("invalid repo path: %w", err)
+	}
+	owner, name, err := repoKey(safeRepoPath)
+	if err != nil {
+		return err
+	}
+	if _, err := LoadRepoMeta(safeRepoPath); err != nil {
+		return err
+	}
+
+	// Forks of forks list the objects of this repository too
+	err = forEachFork(owner+"/"+name, func(forkPath string) error {
+		return dissociateFork(forkPath)
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := db.DeleteRepository(owner, name); err != nil {
+		return fmt.Errorf("failed to delete repo metadata: %w", err)
+	}
+	if err := os.RemoveAll(safeRepoPath); err != nil {
+		return fmt.Errorf("failed to delete repository: %w", err)
+	}
+	return nil
+}
+
+// ResolveRepoRedirect returns the path a renamed or transferred repository was
+// moved to, or false if repoPath was not moved within RepoRedirectTTL
+func ResolveRepoRedirect(repoPath string) (string, bool) {
+	owner, name, err := repoKey(repoPath)
+	if err != nil {
+		return "", false
+	}
+	target, err := db.GetRepoRedirect(owner, name, time.Now().Add(-RepoRedirectTTL))
+	if err != nil {
+		return "", false
+	}
+	return repoPathFromFullName(target), true
+}
+
+// forEachFork calls fn with the path of every direct and indirect fork of a
+// repository
+func forEachFork(fullName string, fn func(forkPath string) error) error {
+	queue := []string{fullName}
+	for len(queue) > 0 {
+		forks, err := db.ListForkedRepositories(queue[0])
+		if err != nil {
+			return fmt.Errorf("failed to list forks: %w", err)
+		}
+		queue = queue[1:]
+		for _, fork := range forks {
+			if err := fn(repoPathFromFullName(fork.FullName())); err != nil {
+				return err
+			}
+			queue = append(queue, fork.FullName())
+		}
+	}
+	return nil
+}
+
+// rewriteAlternates replaces each object directory in the alternates of a
+// repository with the result of rewrite
+func rewriteAlternates(repoPath string, rewrite func(dir string) string) error {
+	alternates := filepath.Join(repoPath, "objects", "info", "alternates")
+	data, err := os.ReadFile(alternates)
+	if err != nil {
+		return fmt.Errorf("failed to read alternates of %s: %w", RepoFullName(repoPath), err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = rewrite(line)
+	}
+	if err := os.WriteFile(alternates, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write alternates of %s: %w", RepoFullName(repoPath), err)
+	}
+	return nil
+}
+
+// dissociateFork copies the objects a fork borrows into its own pack and drops
+// its alternates, so it no longer depends on other repositories
+func dissociateFork(forkPath string) error {
+	alternates := filepath.Join(forkPath, "objects", "info", "alternates")
+	if _, err := os.Stat(alternates); err != nil {
+		return nil
+	}
+
+	// Without --local, repack -a also packs the objects found through alternates
+	cmd := exec.Command("git", "--git-dir", forkPath, "repack", "-a", "-d", "-q")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to repack %s: %w: %s", RepoFullName(forkPath), err, strings.TrimSpace(stderr.String()))
+	}
+	if err := os.Remove(alternates); err != nil {
+		return fmt.Errorf("failed to remove alternates of %s: %w", RepoFullName(forkPath), err)
+	}
+	return nil
+}
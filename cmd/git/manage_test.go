@@ -0,0 +1,187 @@
+package git
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"librebucket/cmd/db"
+)
+
+func TestUpdateRepoSettings(t *testing.T) {
+	t.Chdir(t.TempDir())
+	repoPath := filepath.Join("repos", "tia", "site.git")
+	if err := CreateRepo(repoPath, "tia", true); err != nil {
+		t.Fatalf("CreateRepo failed: %v", err)
+	}
+	commitFiles(t, repoPath, "main", map[string]string{"a.txt": "1"})
+	commitFiles(t, repoPath, "pages", map[string]string{"index.html": "<p>"})
+
+	private, description, website, branch := false, "My site", "https://example.com", "pages"
+	err := UpdateRepoSettings(repoPath, RepoSettings{
+		Public:        &private,
+		Description:   &description,
+		Website:       &website,
+		Topics:        []string{"Web", "static-site", "web"},
+		DefaultBranch: &branch,
+	})
+	if err != nil {
+		t.Fatalf("UpdateRepoSettings failed: %v", err)
+	}
+	meta, err := LoadRepoMeta(repoPath)
+	if err != nil || meta.Public || meta.Description != description || meta.Website != website ||
+		len(meta.Topics) != 2 || meta.Topics[0] != "web" || meta.DefaultBranch != "pages" {
+		t.Errorf("Unexpected metadata %+v, %v", meta, err)
+	}
+
+	// Fields that are not set stay as they are
+	if err := UpdateRepoSettings(repoPath, RepoSettings{}); err != nil {
+		t.Fatalf("UpdateRepoSettings failed: %v", err)
+	}
+	if meta, _ := LoadRepoMeta(repoPath); meta.Description != description || len(meta.Topics) != 2 {
+		t.Errorf("Expected settings to be kept, got %+v", meta)
+	}
+
+	badWebsite, longDescription := "javascript:alert(1)", string(make([]byte, maxDescriptionLength+1))
+	for name, s := range map[string]RepoSettings{
+		"website":     {Website: &badWebsite},
+		"topic":       {Topics: []string{"not a topic"}},
+		"description": {Description: &longDescription},
+	} {
+		if err := UpdateRepoSettings(repoPath, s); !errors.Is(err, ErrInvalidSettings) {
+			t.Errorf("Expected ErrInvalidSettings for invalid %s, got %v", name, err)
+		}
+	}
+}
+
+func TestMoveRepo(t *testing.T) {
+	t.Chdir(t.TempDir())
+	repoPath := filepath.Join("repos", "uma", "app.git")
+	if err := CreateRepo(repoPath, "uma", true); err != nil {
+		t.Fatalf("CreateRepo failed: %v", err)
+	}
+	first := commitFiles(t, repoPath, "main", map[string]string{"a.txt": "1"})
+	forkPath := filepath.Join("repos", "vic", "app.git")
+	if err := ForkRepo(repoPath, forkPath, "vic"); err != nil {
+		t.Fatalf("ForkRepo failed: %v", err)
+	}
+	nestedPath := filepath.Join("repos", "wes", "app.git")
+	if err := ForkRepo(forkPath, nestedPath, "wes"); err != nil {
+		t.Fatalf("ForkRepo failed: %v", err)
+	}
+	if _, err := db.StarRepo(1, "uma/app"); err != nil {
+		t.Fatalf("StarRepo failed: %v", err)
+	}
+
+	if err := MoveRepo(repoPath, forkPath); !errors.Is(err, ErrRepoExists) {
+		t.Errorf("Expected ErrRepoExists, got %v", err)
+	}
+	if err := MoveRepo(repoPath, filepath.Join("repos", "uma", "app.git.git")); !errors.Is(err, ErrInvalidRepoName) {
+		t.Errorf("Expected ErrInvalidRepoName, got %v", err)
+	}
+	if err := CheckMoveTarget(repoPath, forkPath); !errors.Is(err, ErrRepoExists) {
+		t.Errorf("CheckMoveTarget: expected ErrRepoExists, got %v", err)
+	}
+	if err := CheckMoveTarget(repoPath, filepath.Join("repos", "uma", "app.git.git")); !errors.Is(err, ErrInvalidRepoName) {
+		t.Errorf("CheckMoveTarget: expected ErrInvalidRepoName, got %v", err)
+	}
+	if err := CheckMoveTarget(repoPath, repoPath); err != nil {
+		t.Errorf("CheckMoveTarget to the same path failed: %v", err)
+	}
+
+	// Transfer and rename in one step
+	newPath := filepath.Join("repos", "xia", "tool.git")
+	if err := MoveRepo(repoPath, newPath); err != nil {
+		t.Fatalf("MoveRepo failed: %v", err)
+	}
+	if _, err := os.Stat(repoPath); !os.IsNotExist(err) {
+		t.Errorf("Expected %s to be moved", repoPath)
+	}
+	meta, err := LoadRepoMeta(newPath)
+	if err != nil || meta.Owner != "xia" || meta.ForksCount != 1 {
+		t.Errorf("Unexpected metadata %+v, %v", meta, err)
+	}
+	if starred, _ := db.IsStarred(1, "xia/tool"); !starred {
+		t.Error("Expected the star to follow the repository")
+	}
+	if meta, _ := LoadRepoMeta(forkPath); meta.ForkedFrom != "xia/tool" {
+		t.Errorf("Expected fork of xia/tool, got %q", meta.ForkedFrom)
+	}
+
+	// Forks and forks of forks still find the objects at the new location
+	for _, path := range []string{forkPath, nestedPath} {
+		if _, err := GetCommitByHash(path, first); err != nil {
+			t.Errorf("%s cannot read the moved objects: %v", path, err)
+		}
+	}
+
+	if target, ok := ResolveRepoRedirect(repoPath); !ok || target != newPath {
+		t.Errorf("ResolveRepoRedirect = %s, %v; want %s", target, ok, newPath)
+	}
+	// Moving again updates the old redirect instead of chaining
+	finalPath := filepath.Join("repos", "xia", "tool2.git")
+	if err := MoveRepo(newPath, finalPath); err != nil {
+		t.Fatalf("MoveRepo failed: %v", err)
+	}
+	if target, _ := ResolveRepoRedirect(repoPath); target != finalPath {
+		t.Errorf("Expected redirect to %s, got %s", finalPath, target)
+	}
+
+	// Taking an old name again ends its redirect
+	if err := CreateRepo(repoPath, "uma", false); err != nil {
+		t.Fatalf("CreateRepo failed: %v", err)
+	}
+	if _, ok := ResolveRepoRedirect(repoPath); ok {
+		t.Error("Expected no redirect for a name in use")
+	}
+}
+
+func TestDeleteRepo(t *testing.T) {
+	t.Chdir(t.TempDir())
+	repoPath := filepath.Join("repos", "yan", "lib.git")
+	if err := CreateRepo(repoPath, "yan", true); err != nil {
+		t.Fatalf("CreateRepo failed: %v", err)
+	}
+	first := commitFiles(t, repoPath, "main", map[string]string{"a.txt": "1"})
+	forkPath := filepath.Join("repos", "zoe", "lib.git")
+	if err := ForkRepo(repoPath, forkPath, "zoe"); err != nil {
+		t.Fatalf("ForkRepo failed: %v", err)
+	}
+	nestedPath := filepath.Join("repos", "abe", "lib.git")
+	if err := ForkRepo(forkPath, nestedPath, "abe"); err != nil {
+		t.Fatalf("ForkRepo failed: %v", err)
+	}
+
+	// Deleting a fork decrements the forks count of its parent
+	if err := DeleteRepo(nestedPath); err != nil {
+		t.Fatalf("DeleteRepo of fork failed: %v", err)
+	}
+	if meta, _ := LoadRepoMeta(forkPath); meta.ForksCount != 0 {
+		t.Errorf("Expected ForksCount 0, got %d", meta.ForksCount)
+	}
+
+	if err := DeleteRepo(repoPath); err != nil {
+		t.Fatalf("DeleteRepo failed: %v", err)
+	}
+	if _, err := os.Stat(repoPath); !os.IsNotExist(err) {
+		t.Errorf("Expected %s to be removed", repoPath)
+	}
+	if _, err := LoadRepoMeta(repoPath); !errors.Is(err, db.ErrRepositoryNotFound) {
+		t.Errorf("Expected ErrRepositoryNotFound, got %v", err)
+	}
+	if err := DeleteRepo(repoPath); !errors.Is(err, db.ErrRepositoryNotFound) {
+		t.Errorf("Expected ErrRepositoryNotFound for a deleted repository, got %v", err)
+	}
+
+	// The fork got its own copy of the objects and is no longer a fork
+	if _, err := GetCommitByHash(forkPath, first); err != nil {
+		t.Errorf("Fork lost the objects of the deleted repository: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(forkPath, "objects", "info", "alternates")); !os.IsNotExist(err) {
+		t.Error("Expected the fork to have no alternates")
+	}
+	if meta, _ := LoadRepoMeta(forkPath); meta.ForkedFrom != "" {
+		t.Errorf("Expected no parent, got %q", meta.ForkedFrom)
+	}
+}
@@ -0,0 +1,69 @@
+package git
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"librebucket/cmd/db"
+)
+
+// importedSuffix is appended to metadata files once they are imported
+const importedSuffix = ".imported"
+
+// ImportRepoMetaFiles moves the metadata of repositories created by older
+// versions from their .meta.json files into the database and returns the number
+// of imported repositories. Imported files are renamed, so running it again only
+// picks up files that are still left.
+func ImportRepoMetaFiles() (int, error) {
+	metaFiles, err := filepath.Glob(filepath.Join(safeRepoBaseDir, "*", "*.git", metadataFile))
+	if err != nil {
+		return 0, fmt.Errorf("failed to list metadata files: %w", err)
+	}
+
+	imported := 0
+	for _, metaFile := range metaFiles {
+		repoPath := filepath.Dir(metaFile)
+		if err := importRepoMetaFile(repoPath, metaFile); err != nil {
+			return imported, fmt.Errorf("failed to import %s: %w", metaFile, err)
+		}
+		imported++
+	}
+	return imported, nil
+}
+
+func importRepoMetaFile(repoPath, metaFile string) error {
+	data, err := os.ReadFile(metaFile)
+	if err != nil {
+		return err
+	}
+	var meta RepoMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return err
+	}
+
+	// Rows written since the upgrade are newer than the file
+	owner, name, err := repoKey(repoPath)
+	if err != nil {
+		return err
+	}
+	if _, err := db.GetRepository(owner, name); errors.Is(err, db.ErrRepositoryNotFound) {
+		// The owner is the directory the repository is stored in
+		meta.Owner = owner
+		if meta.UpdatedAt.IsZero() {
+			meta.UpdatedAt = meta.CreatedAt
+		}
+		if meta.DefaultBranch == "" {
+			meta.DefaultBranch = defaultBranchName
+		}
+		if err := SaveRepoMeta(repoPath, meta); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	return os.Rename(metaFile, metaFile+importedSuffix)
+}
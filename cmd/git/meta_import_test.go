@@ -0,0 +1,43 @@
+package git
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestImportRepoMetaFiles(t *testing.T) {
+	t.Chdir(t.TempDir())
+	repoPath := filepath.Join("repos", "olga", "legacy.git")
+	if err := os.MkdirAll(repoPath, 0755); err != nil {
+		t.Fatalf("MkdirAll failed: %v", err)
+	}
+	legacy := `{"public": true, "owner": "olga", "stars_count": 3, "last_commit": "abc",
+		"forks_count": 1, "languages": {"Go": 100}, "created_at": "2023-05-01T10:00:00Z"}`
+	metaFile := filepath.Join(repoPath, metadataFile)
+	if err := os.WriteFile(metaFile, []byte(legacy), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	n, err := ImportRepoMetaFiles()
+	if err != nil || n != 1 {
+		t.Fatalf("ImportRepoMetaFiles = %d, %v; want 1", n, err)
+	}
+
+	meta, err := LoadRepoMeta(repoPath)
+	if err != nil {
+		t.Fatalf("LoadRepoMeta failed: %v", err)
+	}
+	if !meta.Public || meta.Owner != "olga" || meta.StarsCount != 3 || meta.ForksCount != 1 ||
+		meta.LastCommit != "abc" || meta.Languages["Go"] != 100 || meta.DefaultBranch != "main" ||
+		meta.CreatedAt.Year() != 2023 {
+		t.Errorf("Unexpected imported metadata %+v", meta)
+	}
+
+	if _, err := os.Stat(metaFile); !os.IsNotExist(err) {
+		t.Errorf("Expected %s to be renamed after import", metaFile)
+	}
+	if n, err := ImportRepoMetaFiles(); err != nil || n != 0 {
+		t.Errorf("Second import = %d, %v; want 0", n, err)
+	}
+}
@@ -0,0 +1,149 @@
+package git
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// MigrateTimeout bounds the clone of a single migration
+const MigrateTimeout = 2 * time.Hour
+
+// cloneRefSpecs fetch the branches and tags of a remote like git clone --bare,
+// leaving out other refs such as the pull requests of hosting services
+var cloneRefSpecs = []config.RefSpec{
+	"+refs/heads/*:refs/heads/*",
+	"+refs/tags/*:refs/tags/*",
+}
+
+// MigrateOptions describes a repository imported from another git server
+type MigrateOptions struct {
+	// Username and Password authenticate to HTTP and SSH remotes. Credentials
+	// in the clone URL are used if they are empty.
+	Username    string
+	Password    string
+	Public      bool
+	Description string
+	// Progress receives the progress output of the remote, if not nil
+	Progress io.Writer
+}
+
+// CloneBareRepo clones a git repository into a new bare repository in the
+// specified directory, like CloneRepo does for a working copy. All branches and
+// tags are copied as they are and HEAD points to the default branch of the
+// remote. No remote is configured in the clone.
+func CloneBareRepo(ctx context.Context, url, directory string, auth transport.AuthMethod, progress io.Writer) (*git.Repository, error) {
+	r, err := git.PlainInit(directory, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create repo: %w", err)
+	}
+	remote := git.NewRemote(r.Storer, &config.RemoteConfig{Name: "origin", URLs: []string{url}})
+
+	head := plumbing.NewBranchReferenceName(defaultBranchName)
+	refs, err := remote.ListContext(ctx, &git.ListOptions{Auth: auth})
+	switch {
+	case errors.Is(err, transport.ErrEmptyRemoteRepository):
+	case err != nil:
+		return nil, err
+	default:
+		err = remote.FetchContext(ctx, &git.FetchOptions{
+			RefSpecs: cloneRefSpecs,
+			Auth:     auth,
+			Progress: progress,
+			Tags:     git.NoTags, // Tags are part of the refspecs
+		})
+		if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+			return nil, err
+		}
+		if name := remoteHead(refs); name != "" {
+			head = name
+		}
+	}
+
+	if err := r.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, head)); err != nil {
+		return nil, fmt.Errorf("failed to set HEAD: %w", err)
+	}
+	return r, nil
+}
+
+// MigrateRepo imports the repository at cloneURL into a new bare repository at
+// repoPath owned by owner, with all its branches and tags. The repository only
+// appears at repoPath once the clone is complete.
+func MigrateRepo(ctx context.Context, cloneURL, repoPath, owner string, opts MigrateOptions) error {
+	if err := ValidateMirrorURL(cloneURL, opts.Username, opts.Password); err != nil {
+		return err
+	}
+	safeRepoPath, err := resolveSafePath(safeRepoBaseDir, repoPath)
+	if err != nil {
+		return fmt.Errorf("invalid repo path: %w", err)
+	}
+	pathOwner, name, err := repoKey(safeRepoPath)
+	if err != nil {
+		return err
+	}
+	if pathOwner != owner {
+		return fmt.Errorf("repository %s must be created below repos/%s", repoPath, owner)
+	}
+	if !ValidRepoName(name) {
+		return fmt.Errorf("%w: %s", ErrInvalidRepoName, name)
+	}
+	if _, err := os.Stat(safeRepoPath); err == nil {
+		return fmt.Errorf("%w: %s", ErrRepoExists, RepoFullName(safeRepoPath))
+	}
+
+	// Clone next to the final path, so the rename below cannot cross filesystems
+	ownerDir := filepath.Dir(safeRepoPath)
+	if err := os.MkdirAll(ownerDir, 0755); err != nil {
+		return fmt.Errorf("failed to create owner directory: %w", err)
+	}
+	tmpDir, err := os.MkdirTemp(ownerDir, "."+name+".migrate-")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	ctx, cancel := context.WithTimeout(ctx, MigrateTimeout)
+	defer cancel()
+	cloneURL, username, password := splitURLCredentials(cloneURL, opts.Username, opts.Password)
+	r, err := CloneBareRepo(ctx, cloneURL, tmpDir, remoteAuth(cloneURL, username, password), opts.Progress)
+	if err != nil {
+		return fmt.Errorf("failed to clone %s: %w", cloneURL, err)
+	}
+
+	// The clone is read before the rename, its storage keeps the temporary path
+	now := time.Now()
+	meta := RepoMeta{
+		Owner:         owner,
+		Public:        opts.Public,
+		Description:   opts.Description,
+		Languages:     make(map[string]float64),
+		CreatedAt:     now,
+		UpdatedAt:     now,
+		DefaultBranch: headBranch(r).Short(),
+	}
+	if head, err := r.Head(); err == nil {
+		meta.LastCommit = head.Hash().String()
+	}
+
+	// Renaming fails if another repository took the path in the meantime
+	if err := os.Rename(tmpDir, safeRepoPath); err != nil {
+		if _, statErr := os.Stat(safeRepoPath); statErr == nil {
+			return fmt.Errorf("%w: %s", ErrRepoExists, RepoFullName(safeRepoPath))
+		}
+		return fmt.Errorf("failed to move repository into place: %w", err)
+	}
+	if err := SaveRepoMeta(safeRepoPath, meta); err != nil {
+		os.RemoveAll(safeRepoPath)
+		return err
+	}
+	return nil
+}
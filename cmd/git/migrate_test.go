@@ -0,0 +1,103 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+func TestMigrateRepo(t *testing.T) {
+	t.Chdir(t.TempDir())
+	AllowLocalMirrors = true
+	t.Cleanup(func() { AllowLocalMirrors = false })
+
+	upstreamPath, err := filepath.Abs("upstream.git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstream, err := git.PlainInit(upstreamPath, true)
+	if err != nil {
+		t.Fatalf("PlainInit failed: %v", err)
+	}
+	first := commitFiles(t, upstreamPath, "trunk", map[string]string{"a.txt": "1"})
+	feature := commitFiles(t, upstreamPath, "feature", map[string]string{"b.txt": "1"})
+	if err := upstream.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, "refs/heads/trunk")); err != nil {
+		t.Fatalf("SetReference failed: %v", err)
+	}
+	if _, err := CreateTag(upstreamPath, "v1", first, "", nil); err != nil {
+		t.Fatalf("CreateTag failed: %v", err)
+	}
+	if _, err := CreateTag(upstreamPath, "v2", feature, "Release 2", nil); err != nil {
+		t.Fatalf("CreateTag failed: %v", err)
+	}
+	// Other refs, like the pull requests of hosting services, are not imported
+	if err := upstream.Storer.SetReference(plumbing.NewHashReference("refs/pull/1/head", plumbing.NewHash(feature))); err != nil {
+		t.Fatalf("SetReference failed: %v", err)
+	}
+
+	repoPath := filepath.Join("repos", "quinn", "imported.git")
+	cloneURL := "file://" + upstreamPath
+	if err := MigrateRepo(context.Background(), "ftp://example.com/repo.git", repoPath, "quinn", MigrateOptions{}); !errors.Is(err, ErrInvalidMirrorURL) {
+		t.Errorf("Expected ErrInvalidMirrorURL, got %v", err)
+	}
+	if err := MigrateRepo(context.Background(), cloneURL, filepath.Join("repos", "quinn", "x.git.git"), "quinn", MigrateOptions{}); !errors.Is(err, ErrInvalidRepoName) {
+		t.Errorf("Expected ErrInvalidRepoName, got %v", err)
+	}
+
+	var progress bytes.Buffer
+	err = MigrateRepo(context.Background(), cloneURL, repoPath, "quinn", MigrateOptions{Description: "Imported", Progress: &progress})
+	if err != nil {
+		t.Fatalf("MigrateRepo failed: %v", err)
+	}
+	meta, err := LoadRepoMeta(repoPath)
+	if err != nil || meta.Owner != "quinn" || meta.Public || meta.Description != "Imported" || meta.DefaultBranch != "trunk" || meta.LastCommit != first {
+		t.Errorf("Unexpected metadata %+v, %v", meta, err)
+	}
+	r, err := git.PlainOpen(repoPath)
+	if err != nil {
+		t.Fatalf("PlainOpen failed: %v", err)
+	}
+	v2, err := upstream.Reference("refs/tags/v2", false)
+	if err != nil {
+		t.Fatalf("Reference failed: %v", err)
+	}
+	assertRefs(t, r, map[string]string{
+		"refs/heads/trunk":   first,
+		"refs/heads/feature": feature,
+		"refs/tags/v1":       first,
+		"refs/tags/v2":       v2.Hash().String(),
+	})
+	if hash, err := ResolveRevision(repoPath, "v2"); err != nil || hash != feature {
+		t.Errorf("Expected annotated tag v2 at %s, got %s, %v", feature, hash, err)
+	}
+	if remotes, err := r.Remotes(); err != nil || len(remotes) != 0 {
+		t.Errorf("Expected no remotes, got %v, %v", remotes, err)
+	}
+
+	// The temporary clone is gone and the path cannot be migrated to again
+	entries, err := os.ReadDir(filepath.Join("repos", "quinn"))
+	if err != nil || len(entries) != 1 {
+		t.Errorf("Expected only the imported repository, got %v, %v", entries, err)
+	}
+	if err := MigrateRepo(context.Background(), cloneURL, repoPath, "quinn", MigrateOptions{}); !errors.Is(err, ErrRepoExists) {
+		t.Errorf("Expected ErrRepoExists, got %v", err)
+	}
+
+	// A failed clone leaves nothing behind
+	missingPath := filepath.Join("repos", "quinn", "missing.git")
+	if err := MigrateRepo(context.Background(), cloneURL+"/missing", missingPath, "quinn", MigrateOptions{}); err == nil {
+		t.Error("Expected MigrateRepo to fail for a missing remote")
+	}
+	if _, err := os.Stat(missingPath); !os.IsNotExist(err) {
+		t.Errorf("Expected %s not to exist, got %v", missingPath, err)
+	}
+	if entries, _ := os.ReadDir(filepath.Join("repos", "quinn")); len(entries) != 1 {
+		t.Errorf("Expected the failed clone to be removed, got %v", entries)
+	}
+}
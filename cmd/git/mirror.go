@@ -0,0 +1,257 @@
+package git
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+
+	"librebucket/cmd/db"
+)
+
+var (
+	// ErrNotMirror is returned when syncing a repository that is not a pull mirror
+	ErrNotMirror = errors.New("repository is not a mirror")
+	// ErrInvalidMirrorURL is returned for remotes a mirror cannot fetch from
+	ErrInvalidMirrorURL = errors.New("invalid mirror URL")
+)
+
+const (
+	// DefaultMirrorInterval is used when a mirror is created without an interval
+	DefaultMirrorInterval = 8 * time.Hour
+	// MinMirrorInterval keeps mirrors from hammering their remotes
+	MinMirrorInterval = 10 * time.Minute
+	// MirrorSyncTimeout bounds a single sync
+	MirrorSyncTimeout = 30 * time.Minute
+)
+
+// AllowLocalMirrors permits file:// mirror URLs. They are off by default, since
+// they would let users mirror any repository on the server's disk.
+var AllowLocalMirrors = false
+
+// mirrorRefSpec fetches all refs like git clone --mirror. Refs are overwritten
+// through FetchOptions.Force, since FetchOptions.Prune deletes every ref when the
+// refspec itself is forced.
+const mirrorRefSpec = config.RefSpec("refs/*:refs/*")
+
+// mirrorLocks serializes the syncs of each mirror, keyed by repository path
+var mirrorLocks sync.Map
+
+// ValidateMirrorURL checks that a mirror or migration can fetch from rawURL with
+// username and password, or the credentials in rawURL if they are empty. Hosts
+// on local networks are refused unless AllowedLocalHosts lists them, and SSH
+// remotes need a username and password, so the server's own SSH keys are never
+// offered to a host chosen by a user.
+func ValidateMirrorURL(rawURL, username, password string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidMirrorURL, err)
+	}
+	switch u.Scheme {
+	case "http", "https", "git", "ssh":
+		if u.Host == "" {
+			return fmt.Errorf("%w: %s has no host", ErrInvalidMirrorURL, RedactMirrorURL(rawURL))
+		}
+		if err := CheckRemoteHost(context.Background(), u.Hostname()); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidMirrorURL, err)
+		}
+		_, username, password = splitURLCredentials(rawURL, username, password)
+		if u.Scheme == "ssh" && (username == "" || password == "") {
+			return fmt.Errorf("%w: ssh remotes need a username and password", ErrInvalidMirrorURL)
+		}
+	case "file":
+		if !AllowLocalMirrors {
+			return fmt.Errorf("%w: local mirrors are disabled", ErrInvalidMirrorURL)
+		}
+	default:
+		return fmt.Errorf("%w: scheme must be http, https, git or ssh", ErrInvalidMirrorURL)
+	}
+	return nil
+}
+
+// remoteAuth returns the authentication for a remote, or nil for HTTP remotes
+// without credentials. SSH remotes always authenticate with the password, so
+// go-git never falls back to the keys of the server's SSH agent.
+func remoteAuth(remoteURL, username, password string) transport.AuthMethod {
+	u, err := url.Parse(remoteURL)
+	if err != nil {
+		return nil
+	}
+	switch {
+	case u.Scheme == "ssh":
+		return &gitssh.Password{User: username, Password: password}
+	case (u.Scheme == "http" || u.Scheme == "https") && (username != "" || password != ""):
+		return &githttp.BasicAuth{Username: username, Password: password}
+	}
+	return nil
+}
+
+// RedactMirrorURL hides the password of a mirror URL
+func RedactMirrorURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Redacted()
+}
+
+// CreateMirror creates an empty pull mirror of mirrorURL at repoPath that is
+// synced every interval, or every DefaultMirrorInterval if interval is zero.
+// Credentials in the URL are moved to the encrypted MirrorUsername and
+// MirrorPassword. The first sync is left to the caller.
+func CreateMirror(repoPath, owner string, public bool, mirrorURL string, interval time.Duration) error {
+	if err := ValidateMirrorURL(mirrorURL, "", ""); err != nil {
+		return err
+	}
+	if interval == 0 {
+		interval = DefaultMirrorInterval
+	}
+	if interval < MinMirrorInterval {
+		return fmt.Errorf("%w: mirror interval must be at least %s", ErrInvalidSettings, MinMirrorInterval)
+	}
+
+	if err := CreateRepo(repoPath, owner, public); err != nil {
+		return err
+	}
+	mirrorURL, username, password := splitURLCredentials(mirrorURL, "", "")
+	return updateRepoMeta(repoPath, func(meta *RepoMeta) error {
+		meta.MirrorURL = mirrorURL
+		meta.MirrorUsername = username
+		meta.MirrorPassword = password
+		meta.MirrorInterval = interval
+		return nil
+	})
+}
+
+// SyncMirror fetches all refs of a pull mirror from its remote, deleting the refs
+// the remote no longer has, and follows the remote's default branch. The time and
+// error of the sync are recorded in the metadata; the error is also returned.
+func SyncMirror(ctx context.Context, repoPath string) error {
+	safeRepoPath, err := resolveSafePath(safeRepoBaseDir, repoPath)
+	if err != nil {
+		return fmt.Errorf("invalid repo path: %w", err)
+	}
+	meta, err := LoadRepoMeta(safeRepoPath)
+	if err != nil {
+		return err
+	}
+	if meta.MirrorURL == "" {
+		return fmt.Errorf("%w: %s", ErrNotMirror, RepoFullName(safeRepoPath))
+	}
+	// The host may resolve to a local address by now
+	if err := ValidateMirrorURL(meta.MirrorURL, meta.MirrorUsername, meta.MirrorPassword); err != nil {
+		return err
+	}
+	// Mirrors created by older versions kept the credentials in the URL. They
+	// are moved out below, so transport errors never quote them.
+	mirrorURL, username, password := splitURLCredentials(meta.MirrorURL, meta.MirrorUsername, meta.MirrorPassword)
+
+	lock, _ := mirrorLocks.LoadOrStore(safeRepoPath, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	r, err := openRepo(safeRepoPath)
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, MirrorSyncTimeout)
+	defer cancel()
+	head, syncErr := fetchMirror(ctx, r, mirrorURL, remoteAuth(mirrorURL, username, password))
+	if syncErr != nil {
+		syncErr = fmt.Errorf("failed to sync mirror %s from %s: %w", RepoFullName(safeRepoPath), mirrorURL, syncErr)
+	}
+
+	err = updateRepoMeta(safeRepoPath, func(meta *RepoMeta) error {
+		meta.MirrorURL, meta.MirrorUsername, meta.MirrorPassword = mirrorURL, username, password
+		meta.MirrorSyncedAt = time.Now()
+		meta.MirrorError = ""
+		if syncErr != nil {
+			meta.MirrorError = syncErr.Error()
+			return nil
+		}
+		if head != "" && head != headBranch(r) {
+			if err := r.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, head)); err != nil {
+				return fmt.Errorf("failed to set HEAD: %w", err)
+			}
+			meta.DefaultBranch = head.Short()
+		}
+		return nil
+	})
+	if syncErr != nil {
+		return syncErr
+	}
+	return err
+}
+
+// fetchMirror fetches all refs from mirrorURL into r with pruning and returns the
+// branch HEAD of the remote points to, if any
+func fetchMirror(ctx context.Context, r *git.Repository, mirrorURL string, auth transport.AuthMethod) (plumbing.ReferenceName, error) {
+	remote := git.NewRemote(r.Storer, &config.RemoteConfig{Name: "mirror", URLs: []string{mirrorURL}})
+	refs, err := remote.ListContext(ctx, &git.ListOptions{Auth: auth})
+	if errors.Is(err, transport.ErrEmptyRemoteRepository) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	err = remote.FetchContext(ctx, &git.FetchOptions{
+		RefSpecs: []config.RefSpec{mirrorRefSpec},
+		Auth:     auth,
+		Tags:     git.NoTags, // Tags are part of the refspec
+		Prune:    true,
+		Force:    true,
+	})
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return "", err
+	}
+	return remoteHead(refs), nil
+}
+
+// remoteHead returns the branch HEAD points to in the advertised refs. Servers
+// without the symref capability only advertise the hash of HEAD, then the first
+// branch with that hash is used.
+func remoteHead(refs []*plumbing.Reference) plumbing.ReferenceName {
+	var head *plumbing.Reference
+	for _, ref := range refs {
+		if ref.Name() == plumbing.HEAD {
+			head = ref
+		}
+	}
+	if head == nil {
+		return ""
+	}
+	if head.Type() == plumbing.SymbolicReference {
+		return head.Target()
+	}
+	for _, ref := range refs {
+		if ref.Name().IsBranch() && ref.Hash() == head.Hash() {
+			return ref.Name()
+		}
+	}
+	return ""
+}
+
+// DueMirrors returns the paths of the pull mirrors whose interval has passed
+// since their last sync
+func DueMirrors(now time.Time) ([]string, error) {
+	mirrors, err := db.ListMirrors()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list mirrors: %w", err)
+	}
+	var due []string
+	for _, m := range mirrors {
+		if m.MirrorSyncedAt.IsZero() || !now.Before(m.MirrorSyncedAt.Add(m.MirrorInterval)) {
+			due = append(due, repoPathFromFullName(m.FullName()))
+		}
+	}
+	return due, nil
+}
@@ -0,0 +1,392 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxPolicyPatterns bounds each list of patterns of a push policy
+	maxPolicyPatterns = 50
+	// maxPolicyViolations bounds the violations reported for one push
+	maxPolicyViolations = 20
+)
+
+// PushPolicy holds the rules pushes to a repository must follow. The
+// pre-receive hook checks them before receive-pack updates any ref, see
+// RunHook. The zero value allows everything.
+type PushPolicy struct {
+	// ProtectedBranches are patterns of branch names, e.g. "main" or
+	// "release/*", that cannot be deleted or force-pushed
+	ProtectedBranches []string `json:"protected_branches,omitempty"`
+	// MaxFileSize is the largest file in bytes new commits may add, 0 for no limit
+	MaxFileSize int64 `json:"max_file_size,omitempty"`
+	// ForbiddenPaths are patterns of paths new commits may not touch. Patterns
+	// without a slash match the file name in any directory, e.g. "*.pem", and
+	// patterns ending with a slash match everything below a directory.
+	ForbiddenPaths []string `json:"forbidden_paths,omitempty"`
+	// RequireSignedCommits rejects new commits without a GPG or SSH
+	// signature. Signatures are not verified against keys.
+	RequireSignedCommits bool `json:"require_signed_commits,omitempty"`
+	// CommitMessagePatterns are regular expressions the message of every new
+	// commit must match
+	CommitMessagePatterns []string `json:"commit_message_patterns,omitempty"`
+}
+
+// IsZero reports whether the policy allows everything
+func (p PushPolicy) IsZero() bool {
+	return len(p.ProtectedBranches) == 0 && p.MaxFileSize == 0 && len(p.ForbiddenPaths) == 0 &&
+		!p.RequireSignedCommits && len(p.CommitMessagePatterns) == 0
+}
+
+// normalize validates the patterns of a policy and removes empty and
+// duplicate ones
+func (p PushPolicy) normalize() (PushPolicy, error) {
+	if p.MaxFileSize < 0 {
+		return p, fmt.Errorf("%w: max_file_size must not be negative", ErrInvalidSettings)
+	}
+	var err error
+	if p.ProtectedBranches, err = normalizePatterns("protected branch", p.ProtectedBranches, func(s string) error {
+		_, err := path.Match(s, "")
+		return err
+	}); err != nil {
+		return p, err
+	}
+	if p.ForbiddenPaths, err = normalizePatterns("forbidden path", p.ForbiddenPaths, func(s string) error {
+		_, err := path.Match(strings.TrimSuffix(s, "/"), "")
+		return err
+	}); err != nil {
+		return p, err
+	}
+	if p.CommitMessagePatterns, err = normalizePatterns("commit message", p.CommitMessagePatterns, func(s string) error {
+		_, err := regexp.Compile(s)
+		return err
+	}); err != nil {
+		return p, err
+	}
+	return p, nil
+}
+
+// normalizePatterns drops empty and duplicate patterns and checks the others
+// with valid. Spaces are kept, they may be part of a pattern.
+func normalizePatterns(kind string, patterns []string, valid func(string) error) ([]string, error) {
+	var normalized []string
+	for _, s := range patterns {
+		if s == "" || slices.Contains(normalized, s) {
+			continue
+		}
+		if err := valid(s); err != nil {
+			return nil, fmt.Errorf("%w: invalid %s pattern %q: %v", ErrInvalidSettings, kind, s, err)
+		}
+		normalized = append(normalized, s)
+	}
+	if len(normalized) > maxPolicyPatterns {
+		return nil, fmt.Errorf("%w: more than %d %s patterns", ErrInvalidSettings, maxPolicyPatterns, kind)
+	}
+	return normalized, nil
+}
+
+// SetPushPolicy validates and saves the push policy of a repository
+func SetPushPolicy(repoPath string, p PushPolicy) (PushPolicy, error) {
+	p, err := p.normalize()
+	if err != nil {
+		return PushPolicy{}, err
+	}
+	err = updateRepoMeta(repoPath, func(meta *RepoMeta) error {
+		meta.PushPolicy = p
+		return nil
+	})
+	return p, err
+}
+
+// RefUpdate is a ref update requested by a push, with zero hashes for created
+// and deleted refs
+type RefUpdate struct {
+	Ref     string
+	OldHash string
+	NewHash string
+}
+
+// ParseRefUpdates reads the "<old> <new> <ref>" lines git passes to the
+// pre-receive and post-receive hooks
+func ParseRefUpdates(r io.Reader) ([]RefUpdate, error) {
+	var updates []RefUpdate
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("invalid ref update %q", scanner.Text())
+		}
+		updates = append(updates, RefUpdate{OldHash: fields[0], NewHash: fields[1], Ref: fields[2]})
+	}
+	return updates, scanner.Err()
+}
+
+// CheckPush checks ref updates of a repository against a policy and returns
+// the violations, empty if the push is allowed. Run from the pre-receive hook,
+// git sees the pushed objects that are still in quarantine.
+func CheckPush(repoPath string, p PushPolicy, updates []RefUpdate) ([]string, error) {
+	if p.IsZero() {
+		return nil, nil
+	}
+	var violations []string
+	var newTips []string
+	for _, u := range updates {
+		if !isZeroHash(u.NewHash) {
+			newTips = append(newTips, u.NewHash)
+		}
+		branch, ok := strings.CutPrefix(u.Ref, "refs/heads/")
+		if !ok || isZeroHash(u.OldHash) || !matchesAny(p.ProtectedBranches, branch) {
+			continue
+		}
+		if isZeroHash(u.NewHash) {
+			violations = append(violations, fmt.Sprintf("%s: deleting the protected branch %s is not allowed", u.Ref, branch))
+			continue
+		}
+		ff, err := isAncestor(repoPath, u.OldHash, u.NewHash)
+		if err != nil {
+			return nil, err
+		}
+		if !ff {
+			violations = append(violations, fmt.Sprintf("%s: force pushing to the protected branch %s is not allowed", u.Ref, branch))
+		}
+	}
+	if len(newTips) == 0 || (p.MaxFileSize == 0 && len(p.ForbiddenPaths) == 0 &&
+		!p.RequireSignedCommits && len(p.CommitMessagePatterns) == 0) {
+		return violations, nil
+	}
+
+	// Commits reachable from the pushed refs but from no existing ref are new
+	out, err := runGit(repoPath, nil, append([]string{"rev-list", "--reverse"}, append(newTips, "--not", "--all")...)...)
+	if err != nil {
+		return nil, err
+	}
+	commits := strings.Fields(string(out))
+	if len(commits) == 0 {
+		return violations, nil
+	}
+	if p.RequireSignedCommits || len(p.CommitMessagePatterns) > 0 {
+		found, err := checkCommitObjects(repoPath, p, commits)
+		if err != nil {
+			return nil, err
+		}
+		violations = append(violations, found...)
+	}
+	if p.MaxFileSize > 0 || len(p.ForbiddenPaths) > 0 {
+		found, err := checkCommitFiles(repoPath, p, commits)
+		if err != nil {
+			return nil, err
+		}
+		violations = append(violations, found...)
+	}
+	if len(violations) > maxPolicyViolations {
+		more := len(violations) - maxPolicyViolations
+		violations = append(violations[:maxPolicyViolations], fmt.Sprintf("and %d more", more))
+	}
+	return violations, nil
+}
+
+// checkCommitObjects checks the signatures and messages of commits
+func checkCommitObjects(repoPath string, p PushPolicy, commits []string) ([]string, error) {
+	patterns := make([]*regexp.Regexp, len(p.CommitMessagePatterns))
+	for i, s := range p.CommitMessagePatterns {
+		re, err := regexp.Compile(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid commit message pattern %q: %w", s, err)
+		}
+		patterns[i] = re
+	}
+
+	out, err := runGit(repoPath, strings.NewReader(strings.Join(commits, "\n")+"\n"), "cat-file", "--batch")
+	if err != nil {
+		return nil, err
+	}
+	var violations []string
+	r := bufio.NewReader(bytes.NewReader(out))
+	for _, c := range commits {
+		header, err := r.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("failed to read commit %s: %w", c, err)
+		}
+		fields := strings.Fields(header)
+		if len(fields) != 3 || fields[1] != "commit" {
+			return nil, fmt.Errorf("unexpected object %q", strings.TrimSpace(header))
+		}
+		size, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("unexpected object %q", strings.TrimSpace(header))
+		}
+		raw := make([]byte, size+1) // Content and newline
+		if _, err := io.ReadFull(r, raw); err != nil {
+			return nil, fmt.Errorf("failed to read commit %s: %w", c, err)
+		}
+		headers, message, _ := strings.Cut(string(raw[:size]), "\n\n")
+
+		short := c[:min(len(c), 7)]
+		if p.RequireSignedCommits && !strings.Contains("\n"+headers, "\ngpgsig") {
+			violations = append(violations, fmt.Sprintf("%s: commit is not signed", short))
+		}
+		for _, re := range patterns {
+			if !re.MatchString(strings.TrimSpace(message)) {
+				violations = append(violations, fmt.Sprintf("%s: commit message does not match %q", short, re.String()))
+			}
+		}
+	}
+	return violations, nil
+}
+
+// checkCommitFiles checks the sizes and paths of the files commits add or change
+func checkCommitFiles(repoPath string, p PushPolicy, commits []string) ([]string, error) {
+	// -m compares merges with each parent, so files merged in are checked too
+	out, err := runGit(repoPath, strings.NewReader(strings.Join(commits, "\n")+"\n"),
+		"diff-tree", "--stdin", "-r", "--root", "-m", "-z", "--no-renames")
+	if err != nil {
+		return nil, err
+	}
+
+	type change struct{ commit, path, blob string }
+	var changes []change
+	commit := ""
+	tokens := strings.Split(strings.TrimSuffix(string(out), "\x00"), "\x00")
+	for i := 0; i < len(tokens); i++ {
+		if !strings.HasPrefix(tokens[i], ":") {
+			commit = strings.Fields(tokens[i])[0]
+			continue
+		}
+		// :<old mode> <new mode> <old blob> <new blob> <status>, then the path
+		fields := strings.Fields(tokens[i])
+		if len(fields) != 5 || i+1 >= len(tokens) {
+			return nil, fmt.Errorf("unexpected diff-tree output %q", tokens[i])
+		}
+		i++
+		if fields[4] == "D" || fields[1] == "160000" {
+			// Deleted files and submodules have no content to check
+			continue
+		}
+		changes = append(changes, change{commit: commit, path: tokens[i], blob: fields[3]})
+	}
+
+	var sizes map[string]int64
+	if p.MaxFileSize > 0 && len(changes) > 0 {
+		blobs := make([]string, len(changes))
+		for i, c := range changes {
+			blobs[i] = c.blob
+		}
+		if sizes, err = blobSizes(repoPath, blobs); err != nil {
+			return nil, err
+		}
+	}
+
+	var violations []string
+	seen := make(map[string]bool)
+	for _, c := range changes {
+		short := c.commit[:min(len(c.commit), 7)]
+		if pattern, ok := matchForbiddenPath(p.ForbiddenPaths, c.path); ok && !seen[c.commit+"\x00"+c.path] {
+			violations = append(violations, fmt.Sprintf("%s: %s matches the forbidden path pattern %q", short, c.path, pattern))
+		}
+		if size := sizes[c.blob]; p.MaxFileSize > 0 && size > p.MaxFileSize && !seen[c.commit+"\x00"+c.path] {
+			violations = append(violations, fmt.Sprintf("%s: %s is %d bytes, more than the limit of %d", short, c.path, size, p.MaxFileSize))
+		}
+		seen[c.commit+"\x00"+c.path] = true
+	}
+	return violations, nil
+}
+
+// blobSizes returns the sizes of blobs by hash
+func blobSizes(repoPath string, blobs []string) (map[string]int64, error) {
+	out, err := runGit(repoPath, strings.NewReader(strings.Join(blobs, "\n")+"\n"), "cat-file", "--batch-check=%(objectname) %(objectsize)")
+	if err != nil {
+		return nil, err
+	}
+	sizes := make(map[string]int64)
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		hash, size, ok := strings.Cut(line, " ")
+		if !ok {
+			continue // "<hash> missing"
+		}
+		if n, err := strconv.ParseInt(size, 10, 64); err == nil {
+			sizes[hash] = n
+		}
+	}
+	return sizes, nil
+}
+
+// matchForbiddenPath returns the first pattern that matches a file path
+func matchForbiddenPath(patterns []string, filePath string) (string, bool) {
+	for _, pattern := range patterns {
+		if dir, ok := strings.CutSuffix(pattern, "/"); ok {
+			// Every file below the directory, wherever it is without a slash
+			for d := path.Dir(filePath); d != "."; d = path.Dir(d) {
+				if ok, _ := path.Match(dir, d); ok {
+					return pattern, true
+				}
+				if ok, _ := path.Match(dir, path.Base(d)); ok && !strings.Contains(dir, "/") {
+					return pattern, true
+				}
+			}
+			continue
+		}
+		name := filePath
+		if !strings.Contains(pattern, "/") {
+			name = path.Base(filePath)
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			return pattern, true
+		}
+	}
+	return "", false
+}
+
+// matchesAny reports whether name matches one of patterns
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// isAncestor reports whether commit a is an ancestor of commit b
+func isAncestor(repoPath, a, b string) (bool, error) {
+	cmd := exec.Command("git", "merge-base", "--is-ancestor", a, b)
+	cmd.Dir = repoPath
+	err := cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("git merge-base failed: %w", err)
+	}
+	return true, nil
+}
+
+// isZeroHash reports whether hash is the all-zero hash of created and deleted refs
+func isZeroHash(hash string) bool {
+	return strings.Trim(hash, "0") == ""
+}
+
+// runGit runs git in a repository and returns its output. The environment is
+// inherited, so in a hook git reads the objects of the push from quarantine.
+func runGit(repoPath string, stdin io.Reader, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = repoPath
+	cmd.Stdin = stdin
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s failed: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
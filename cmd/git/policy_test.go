@@ -0,0 +1,179 @@
+package git
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+func TestCheckPush(t *testing.T) {
+	t.Chdir(t.TempDir())
+	repoPath := filepath.Join("repos", "rex", "policy.git")
+	if err := CreateRepo(repoPath, "rex", false); err != nil {
+		t.Fatalf("CreateRepo failed: %v", err)
+	}
+	base := commitFiles(t, repoPath, "main", map[string]string{"a.txt": "1"})
+	r, err := git.PlainOpen(repoPath)
+	if err != nil {
+		t.Fatalf("PlainOpen failed: %v", err)
+	}
+
+	// Pushed commits are stored but not referenced yet, like in quarantine
+	big := writeTestCommit(t, r, writeTestTree(t, r, map[string]string{
+		"a.txt":           "1",
+		"big.bin":         strings.Repeat("x", 100),
+		"keys/server.pem": "key",
+		"secrets/x/y.txt": "token",
+	}), plumbing.NewHash(base)).String()
+	unrelated := writeTestCommit(t, r, writeTestTree(t, r, map[string]string{"b.txt": "2"})).String()
+	zero := plumbing.ZeroHash.String()
+
+	tests := []struct {
+		name    string
+		policy  PushPolicy
+		updates []RefUpdate
+		want    []string
+	}{
+		{
+			name:    "no policy",
+			updates: []RefUpdate{{Ref: "refs/heads/main", OldHash: base, NewHash: unrelated}},
+		},
+		{
+			name:   "protected branches",
+			policy: PushPolicy{ProtectedBranches: []string{"main", "release/*"}},
+			updates: []RefUpdate{
+				{Ref: "refs/heads/main", OldHash: base, NewHash: unrelated},
+				{Ref: "refs/heads/release/1.0", OldHash: base, NewHash: zero},
+				{Ref: "refs/heads/release/2.0", OldHash: zero, NewHash: base},
+				{Ref: "refs/heads/feature", OldHash: base, NewHash: unrelated},
+			},
+			want: []string{
+				"refs/heads/main: force pushing to the protected branch main is not allowed",
+				"refs/heads/release/1.0: deleting the protected branch release/1.0 is not allowed",
+			},
+		},
+		{
+			name:    "fast-forward of a protected branch",
+			policy:  PushPolicy{ProtectedBranches: []string{"main"}},
+			updates: []RefUpdate{{Ref: "refs/heads/main", OldHash: base, NewHash: big}},
+		},
+		{
+			name:    "files",
+			policy:  PushPolicy{MaxFileSize: 50, ForbiddenPaths: []string{"*.pem", "secrets/", "a.txt"}},
+			updates: []RefUpdate{{Ref: "refs/heads/main", OldHash: base, NewHash: big}},
+			want: []string{
+				big[:7] + ": big.bin is 100 bytes, more than the limit of 50",
+				big[:7] + ": keys/server.pem matches the forbidden path pattern \"*.pem\"",
+				big[:7] + ": secrets/x/y.txt matches the forbidden path pattern \"secrets/\"",
+			},
+		},
+		{
+			name:    "commits",
+			policy:  PushPolicy{RequireSignedCommits: true, CommitMessagePatterns: []string{"^(feat|fix): "}},
+			updates: []RefUpdate{{Ref: "refs/heads/topic", OldHash: zero, NewHash: unrelated}},
+			want: []string{
+				unrelated[:7] + ": commit is not signed",
+				unrelated[:7] + ": commit message does not match \"^(feat|fix): \"",
+			},
+		},
+	}
+	for _, tt := range tests {
+		violations, err := CheckPush(repoPath, tt.policy, tt.updates)
+		if err != nil {
+			t.Fatalf("%s: CheckPush failed: %v", tt.name, err)
+		}
+		slices.Sort(violations)
+		if !slices.Equal(violations, tt.want) {
+			t.Errorf("%s: got violations %q, want %q", tt.name, violations, tt.want)
+		}
+	}
+
+	// Signed commits with matching messages pass
+	sig := object.Signature{Name: "Test.User", Email: "test@example.com", When: time.Now()}
+	signed := &object.Commit{
+		Author:       sig,
+		Committer:    sig,
+		Message:      "feat: add b\n",
+		TreeHash:     writeTestTree(t, r, map[string]string{"b.txt": "3"}),
+		PGPSignature: "-----BEGIN PGP SIGNATURE-----\n\niQEz\n-----END PGP SIGNATURE-----\n",
+	}
+	obj := r.Storer.NewEncodedObject()
+	if err := signed.Encode(obj); err != nil {
+		t.Fatalf("Encode commit failed: %v", err)
+	}
+	hash, err := r.Storer.SetEncodedObject(obj)
+	if err != nil {
+		t.Fatalf("SetEncodedObject failed: %v", err)
+	}
+	policy := PushPolicy{RequireSignedCommits: true, CommitMessagePatterns: []string{"^(feat|fix): "}}
+	violations, err := CheckPush(repoPath, policy, []RefUpdate{{Ref: "refs/heads/topic", OldHash: zero, NewHash: hash.String()}})
+	if err != nil || len(violations) != 0 {
+		t.Errorf("Expected the signed commit to pass, got %q, %v", violations, err)
+	}
+
+	// Commits already in the repository are not checked again
+	if _, err := CreateBranch(repoPath, "big", big); err != nil {
+		t.Fatalf("CreateBranch failed: %v", err)
+	}
+	violations, err = CheckPush(repoPath, PushPolicy{MaxFileSize: 50}, []RefUpdate{{Ref: "refs/heads/main", OldHash: base, NewHash: big}})
+	if err != nil || len(violations) != 0 {
+		t.Errorf("Expected no violations for existing commits, got %q, %v", violations, err)
+	}
+}
+
+func TestSetPushPolicy(t *testing.T) {
+	t.Chdir(t.TempDir())
+	repoPath := filepath.Join("repos", "sal", "policy.git")
+	if err := CreateRepo(repoPath, "sal", true); err != nil {
+		t.Fatalf("CreateRepo failed: %v", err)
+	}
+
+	for _, invalid := range []PushPolicy{
+		{ProtectedBranches: []string{"[main"}},
+		{ForbiddenPaths: []string{"\\"}},
+		{CommitMessagePatterns: []string{"(feat"}},
+		{MaxFileSize: -1},
+	} {
+		if _, err := SetPushPolicy(repoPath, invalid); !errors.Is(err, ErrInvalidSettings) {
+			t.Errorf("Expected ErrInvalidSettings for %+v, got %v", invalid, err)
+		}
+	}
+
+	policy, err := SetPushPolicy(repoPath, PushPolicy{
+		ProtectedBranches: []string{"main", "main", ""},
+		MaxFileSize:       1024,
+	})
+	if err != nil {
+		t.Fatalf("SetPushPolicy failed: %v", err)
+	}
+	if !slices.Equal(policy.ProtectedBranches, []string{"main"}) {
+		t.Errorf("Expected normalized protected branches, got %q", policy.ProtectedBranches)
+	}
+	meta, err := LoadRepoMeta(repoPath)
+	if err != nil || !slices.Equal(meta.PushPolicy.ProtectedBranches, []string{"main"}) || meta.PushPolicy.MaxFileSize != 1024 {
+		t.Errorf("Expected the saved policy, got %+v, %v", meta.PushPolicy, err)
+	}
+
+	// Without hooks a policy cannot be enforced, so pushes fail
+	hooksDir = ""
+	if _, err := ReceivePackCommand(repoPath); !errors.Is(err, ErrHooksNotInstalled) {
+		t.Errorf("Expected ErrHooksNotInstalled, got %v", err)
+	}
+
+	if _, err := SetPushPolicy(repoPath, PushPolicy{}); err != nil {
+		t.Fatalf("SetPushPolicy failed: %v", err)
+	}
+	if meta, _ := LoadRepoMeta(repoPath); !meta.PushPolicy.IsZero() {
+		t.Errorf("Expected the policy to be cleared, got %+v", meta.PushPolicy)
+	}
+	if _, err := ReceivePackCommand(repoPath); err != nil {
+		t.Errorf("Expected receive-pack without a policy, got %v", err)
+	}
+}
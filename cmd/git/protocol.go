@@ -0,0 +1,38 @@
+package git
+
+import (
+	"strconv"
+	"strings"
+)
+
+// ParseProtocol parses the parameters a client asks git to speak the wire
+// protocol with, sent in the Git-Protocol header over HTTP or the GIT_PROTOCOL
+// variable over SSH, e.g. "version=2". It returns the highest version asked
+// for, 0 if none, and reports whether the value is safe to pass on to git in
+// GIT_PROTOCOL.
+func ParseProtocol(value string) (version int, ok bool) {
+	if value == "" {
+		return 0, false
+	}
+	for _, c := range value {
+		if !isProtocolChar(c) {
+			return 0, false
+		}
+	}
+	for _, param := range strings.Split(value, ":") {
+		key, v, found := strings.Cut(param, "=")
+		if key != "version" || !found {
+			continue
+		}
+		if n, err := strconv.Atoi(v); err == nil && n > version {
+			version = n
+		}
+	}
+	return version, true
+}
+
+// isProtocolChar reports whether c may appear in GIT_PROTOCOL
+func isProtocolChar(c rune) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		strings.ContainsRune("=:._-", c)
+}
@@ -0,0 +1,25 @@
+package git
+
+import "testing"
+
+func TestParseProtocol(t *testing.T) {
+	for value, want := range map[string]struct {
+		version int
+		ok      bool
+	}{
+		"version=2":                    {2, true},
+		"version=1":                    {1, true},
+		"object-format=sha1:version=2": {2, true},
+		"version=2:version=1":          {2, true},
+		"version=x":                    {0, true},
+		"agent=git/2.43":               {0, false},
+		"version=2\nPATH=/tmp":         {0, false},
+		"version=2 ":                   {0, false},
+		"":                             {0, false},
+	} {
+		version, ok := ParseProtocol(value)
+		if version != want.version || ok != want.ok {
+			t.Errorf("ParseProtocol(%q) = %d, %v, want %d, %v", value, version, ok, want.version, want.ok)
+		}
+	}
+}
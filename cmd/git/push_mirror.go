@@ -0,0 +1,221 @@
+package git
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+
+	"librebucket/cmd/db"
+)
+
+const (
+	// pushMirrorMinBackoff is the retry delay after the first failed push,
+	// doubling with every further failure up to pushMirrorMaxBackoff
+	pushMirrorMinBackoff = time.Minute
+	pushMirrorMaxBackoff = 6 * time.Hour
+)
+
+// pushMirrorRefSpecs force-update the branches and tags of the remote
+var pushMirrorRefSpecs = []config.RefSpec{
+	"+refs/heads/*:refs/heads/*",
+	"+refs/tags/*:refs/tags/*",
+}
+
+// pushMirrorLocks serializes the pushes to each push mirror, keyed by ID
+var pushMirrorLocks sync.Map
+
+// DuePushMirror identifies a push mirror whose interval or retry delay has passed
+type DuePushMirror struct {
+	RepoPath string
+	ID       int64
+}
+
+// AddPushMirror adds a remote that repoPath is pushed to after every push and
+// every interval, or every DefaultMirrorInterval if interval is zero. Credentials
+// in the URL are moved to username and password, which are stored encrypted.
+func AddPushMirror(repoPath, remoteURL, username, password string, interval time.Duration) (db.PushMirror, error) {
+	if err := ValidateMirrorURL(remoteURL, username, password); err != nil {
+		return db.PushMirror{}, err
+	}
+	if interval == 0 {
+		interval = DefaultMirrorInterval
+	}
+	if interval < MinMirrorInterval {
+		return db.PushMirror{}, fmt.Errorf("%w: mirror interval must be at least %s", ErrInvalidSettings, MinMirrorInterval)
+	}
+
+	remoteURL, username, password = splitURLCredentials(remoteURL, username, password)
+
+	safeRepoPath, err := resolveSafePath(safeRepoBaseDir, repoPath)
+	if err != nil {
+		return db.PushMirror{}, fmt.Errorf("invalid repo path: %w", err)
+	}
+	if _, err := LoadRepoMeta(safeRepoPath); err != nil {
+		return db.PushMirror{}, err
+	}
+	m := db.PushMirror{
+		Repo:      RepoFullName(safeRepoPath),
+		RemoteURL: remoteURL,
+		Username:  username,
+		Password:  password,
+		Interval:  interval,
+	}
+	if err := db.CreatePushMirror(&m); err != nil {
+		return db.PushMirror{}, fmt.Errorf("failed to save push mirror: %w", err)
+	}
+	return m, nil
+}
+
+// ListPushMirrors returns the push mirrors of a repository
+func ListPushMirrors(repoPath string) ([]db.PushMirror, error) {
+	mirrors, err := db.ListPushMirrors(RepoFullName(repoPath))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list push mirrors: %w", err)
+	}
+	return mirrors, nil
+}
+
+// RemovePushMirror removes a push mirror of a repository
+func RemovePushMirror(repoPath string, id int64) error {
+	return db.DeletePushMirror(RepoFullName(repoPath), id)
+}
+
+// PushToMirror replicates the branches and tags of a repository to a push
+// mirror, force-updating refs and deleting the ones that no longer exist here.
+// The outcome is recorded on the mirror; failures are retried with exponential
+// backoff by DuePushMirrors.
+func PushToMirror(ctx context.Context, repoPath string, id int64) error {
+	safeRepoPath, err := resolveSafePath(safeRepoBaseDir, repoPath)
+	if err != nil {
+		return fmt.Errorf("invalid repo path: %w", err)
+	}
+
+	lock, _ := pushMirrorLocks.LoadOrStore(id, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	// Read the mirror under the lock to see the status of the previous push
+	m, err := db.GetPushMirror(RepoFullName(safeRepoPath), id)
+	if err != nil {
+		return err
+	}
+	r, err := openRepo(safeRepoPath)
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, MirrorSyncTimeout)
+	defer cancel()
+	// The host may resolve to a local address by now, and SSH mirrors added by
+	// older versions may lack credentials
+	pushErr := ValidateMirrorURL(m.RemoteURL, m.Username, m.Password)
+	if pushErr == nil {
+		pushErr = pushMirror(ctx, r, m)
+	}
+
+	now := time.Now()
+	if pushErr == nil {
+		m.LastSyncAt = now
+		m.LastError = ""
+		m.Failures = 0
+		m.NextAttemptAt = time.Time{}
+	} else {
+		pushErr = fmt.Errorf("failed to push %s to %s: %w", m.Repo, m.RemoteURL, pushErr)
+		m.LastError = pushErr.Error()
+		m.Failures++
+		m.NextAttemptAt = now.Add(pushMirrorBackoff(m.Failures))
+	}
+	if err := db.UpdatePushMirrorStatus(m); err != nil {
+		return fmt.Errorf("failed to record push mirror status: %w", err)
+	}
+	return pushErr
+}
+
+// pushMirror pushes all branches and tags of r to the remote of m and deletes
+// the remote branches and tags that r does not have
+func pushMirror(ctx context.Context, r *git.Repository, m db.PushMirror) error {
+	const remoteName = "push-mirror"
+	remote := git.NewRemote(r.Storer, &config.RemoteConfig{Name: remoteName, URLs: []string{m.RemoteURL}})
+	auth := remoteAuth(m.RemoteURL, m.Username, m.Password)
+
+	remoteRefs, err := remote.ListContext(ctx, &git.ListOptions{Auth: auth})
+	if err != nil && !errors.Is(err, transport.ErrEmptyRemoteRepository) {
+		return err
+	}
+	// Deletions are explicit refspecs: PushOptions.Prune deletes every ref of
+	// the remote when combined with forced refspecs
+	refSpecs := slices.Clone(pushMirrorRefSpecs)
+	for _, ref := range remoteRefs {
+		if !ref.Name().IsBranch() && !ref.Name().IsTag() {
+			continue
+		}
+		if _, err := r.Reference(ref.Name(), false); errors.Is(err, plumbing.ErrReferenceNotFound) {
+			refSpecs = append(refSpecs, config.RefSpec(":"+ref.Name().String()))
+		}
+	}
+
+	err = remote.PushContext(ctx, &git.PushOptions{
+		RemoteName: remoteName,
+		RefSpecs:   refSpecs,
+		Auth:       auth,
+	})
+	if errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return nil
+	}
+	return err
+}
+
+// splitURLCredentials moves the credentials in rawURL to username and password,
+// unless those are already given
+func splitURLCredentials(rawURL, username, password string) (string, string, string) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.User == nil {
+		return rawURL, username, password
+	}
+	if username == "" {
+		username = u.User.Username()
+	}
+	if p, ok := u.User.Password(); ok && password == "" {
+		password = p
+	}
+	u.User = nil
+	return u.String(), username, password
+}
+
+// pushMirrorBackoff returns the retry delay after a number of consecutive failures
+func pushMirrorBackoff(failures int) time.Duration {
+	backoff := pushMirrorMinBackoff
+	for i := 1; i < failures && backoff < pushMirrorMaxBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, pushMirrorMaxBackoff)
+}
+
+// DuePushMirrors returns the push mirrors whose interval has passed since their
+// last successful push, or whose retry delay has passed after a failure
+func DuePushMirrors(now time.Time) ([]DuePushMirror, error) {
+	mirrors, err := db.ListAllPushMirrors()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list push mirrors: %w", err)
+	}
+	var due []DuePushMirror
+	for _, m := range mirrors {
+		next := m.LastSyncAt.Add(m.Interval)
+		if m.Failures > 0 {
+			next = m.NextAttemptAt
+		}
+		if m.LastSyncAt.IsZero() && m.Failures == 0 || !now.Before(next) {
+			due = append(due, DuePushMirror{RepoPath: repoPathFromFullName(m.Repo), ID: m.ID})
+		}
+	}
+	return due, nil
+}
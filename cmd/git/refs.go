@@ -0,0 +1,247 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+var (
+	// ErrBranchExists is returned when creating a branch that already exists
+	ErrBranchExists = errors.New("branch already exists")
+	// ErrBranchNotFound is returned when a branch does not exist
+	ErrBranchNotFound = errors.New("branch not found")
+	// ErrTagExists is returned when creating a tag that already exists
+	ErrTagExists = errors.New("tag already exists")
+	// ErrTagNotFound is returned when a tag does not exist
+	ErrTagNotFound = errors.New("tag not found")
+	// ErrDefaultBranch is returned when trying to delete the default branch
+	ErrDefaultBranch = errors.New("cannot delete the default branch")
+)
+
+// Branch is a branch with its tip commit
+type Branch struct {
+	Name    string
+	Commit  *Commit
+	Default bool // Whether HEAD points to this branch
+}
+
+// Tag is a lightweight or annotated tag with the commit it points to
+type Tag struct {
+	Name        string
+	Commit      *Commit
+	Annotated   bool
+	Message     string    // Only set for annotated tags
+	Tagger      string    // Only set for annotated tags
+	TaggerEmail string    // Only set for annotated tags
+	TaggedAt    time.Time // Only set for annotated tags
+}
+
+// ListBranches returns all branches of a repository with their tip commits
+func ListBranches(repoPath string) ([]Branch, error) {
+	r, err := openRepo(repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	head := headBranch(r)
+
+	iter, err := r.Branches()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list branches: %w", err)
+	}
+
+	branches := []Branch{}
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		c, err := r.CommitObject(ref.Hash())
+		if err != nil {
+			return fmt.Errorf("failed to get tip of branch %s: %w", ref.Name().Short(), err)
+		}
+		branches = append(branches, Branch{
+			Name:    ref.Name().Short(),
+			Commit:  newCommit(c),
+			Default: ref.Name() == head,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return branches, nil
+}
+
+// ListTags returns all tags of a repository with the commits they point to
+func ListTags(repoPath string) ([]Tag, error) {
+	r, err := openRepo(repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	iter, err := r.Tags()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tags: %w", err)
+	}
+
+	tags := []Tag{}
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		tag, err := newTag(r, ref)
+		if err != nil {
+			return err
+		}
+		// Tags pointing at trees or blobs are skipped
+		if tag != nil {
+			tags = append(tags, *tag)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+// CreateBranch creates a new branch pointing at the commit the from revision resolves to
+func CreateBranch(repoPath, name, from string) (*Branch, error) {
+	r, err := openRepo(repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	refName := plumbing.NewBranchReferenceName(name)
+	if err := refName.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid branch name %s: %w", name, err)
+	}
+	if _, err := r.Reference(refName, false); err == nil {
+		return nil, fmt.Errorf("%w: %s", ErrBranchExists, name)
+	}
+
+	c, err := resolveRevision(r, from)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.Storer.SetReference(plumbing.NewHashReference(refName, c.Hash)); err != nil {
+		return nil, fmt.Errorf("failed to create branch %s: %w", name, err)
+	}
+
+	return &Branch{
+		Name:    name,
+		Commit:  newCommit(c),
+		Default: refName == headBranch(r),
+	}, nil
+}
+
+// CreateTag creates a tag pointing at the commit the from revision resolves to.
+// The tag is annotated when a message is given and lightweight otherwise; annotated
+// tags without a tagger are attributed to LibreBucket.
+func CreateTag(repoPath, name, from, message string, tagger *object.Signature) (*Tag, error) {
+	r, err := openRepo(repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	c, err := resolveRevision(r, from)
+	if err != nil {
+		return nil, err
+	}
+
+	var opts *git.CreateTagOptions
+	if message != "" {
+		if tagger == nil {
+			tagger = &object.Signature{Name: "LibreBucket", Email: "librebucket@localhost", When: time.Now()}
+		}
+		opts = &git.CreateTagOptions{Tagger: tagger, Message: message}
+	}
+
+	ref, err := r.CreateTag(name, c.Hash, opts)
+	if errors.Is(err, git.ErrTagExists) {
+		return nil, fmt.Errorf("%w: %s", ErrTagExists, name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create tag %s: %w", name, err)
+	}
+
+	return newTag(r, ref)
+}
+
+// DeleteBranch deletes a branch. The default branch cannot be deleted.
+func DeleteBranch(repoPath, name string) error {
+	r, err := openRepo(repoPath)
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	refName := plumbing.NewBranchReferenceName(name)
+	if _, err := r.Reference(refName, false); err != nil {
+		return fmt.Errorf("%w: %s", ErrBranchNotFound, name)
+	}
+	if refName == headBranch(r) {
+		return fmt.Errorf("%w: %s", ErrDefaultBranch, name)
+	}
+
+	if err := r.Storer.RemoveReference(refName); err != nil {
+		return fmt.Errorf("failed to delete branch %s: %w", name, err)
+	}
+	return nil
+}
+
+// DeleteTag deletes a tag
+func DeleteTag(repoPath, name string) error {
+	r, err := openRepo(repoPath)
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	err = r.DeleteTag(name)
+	if errors.Is(err, git.ErrTagNotFound) {
+		return fmt.Errorf("%w: %s", ErrTagNotFound, name)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete tag %s: %w", name, err)
+	}
+	return nil
+}
+
+// headBranch returns the branch HEAD points to, or an empty name if HEAD is detached
+func headBranch(r *git.Repository) plumbing.ReferenceName {
+	head, err := r.Reference(plumbing.HEAD, false)
+	if err != nil || head.Type() != plumbing.SymbolicReference {
+		return ""
+	}
+	return head.Target()
+}
+
+// newTag builds a Tag from a tag reference, peeling annotated tags to their commit.
+// It returns nil if the tag does not point to a commit.
+func newTag(r *git.Repository, ref *plumbing.Reference) (*Tag, error) {
+	tag := &Tag{Name: ref.Name().Short()}
+	target := ref.Hash()
+
+	if to, err := r.TagObject(ref.Hash()); err == nil {
+		tag.Annotated = true
+		tag.Message = to.Message
+		tag.Tagger = to.Tagger.Name
+		tag.TaggerEmail = to.Tagger.Email
+		tag.TaggedAt = to.Tagger.When
+		if to.TargetType != plumbing.CommitObject {
+			return nil, nil
+		}
+		target = to.Target
+	}
+
+	c, err := r.CommitObject(target)
+	if errors.Is(err, plumbing.ErrObjectNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get commit of tag %s: %w", tag.Name, err)
+	}
+	tag.Commit = newCommit(c)
+	return tag, nil
+}
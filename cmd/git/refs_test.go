@@ -0,0 +1,79 @@
+package git
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+func TestBranchesAndTags(t *testing.T) {
+	t.Chdir(t.TempDir())
+	repoPath := filepath.Join("repos", "dave", "tools.git")
+	if err := CreateRepo(repoPath, "dave", true); err != nil {
+		t.Fatalf("CreateRepo failed: %v", err)
+	}
+	hash := commitFiles(t, repoPath, "main", map[string]string{"main.go": "package main"})
+
+	branch, err := CreateBranch(repoPath, "release/1.0", "main")
+	if err != nil {
+		t.Fatalf("CreateBranch failed: %v", err)
+	}
+	if branch.Commit.Hash != hash || branch.Default {
+		t.Errorf("Unexpected branch: %+v", branch)
+	}
+	if _, err := CreateBranch(repoPath, "release/1.0", hash); !errors.Is(err, ErrBranchExists) {
+		t.Errorf("Expected ErrBranchExists, got %v", err)
+	}
+
+	branches, err := ListBranches(repoPath)
+	if err != nil {
+		t.Fatalf("ListBranches failed: %v", err)
+	}
+	if len(branches) != 2 {
+		t.Fatalf("Expected 2 branches, got %d", len(branches))
+	}
+	for _, b := range branches {
+		if b.Default != (b.Name == "main") {
+			t.Errorf("Branch %s has Default=%v", b.Name, b.Default)
+		}
+	}
+
+	if _, err := CreateTag(repoPath, "v1.0.0", "release/1.0", "", nil); err != nil {
+		t.Fatalf("CreateTag (lightweight) failed: %v", err)
+	}
+	tagger := &object.Signature{Name: "dave", Email: "dave@example.com", When: time.Now()}
+	annotated, err := CreateTag(repoPath, "v1.0.1", hash[:8], "Release 1.0.1", tagger)
+	if err != nil {
+		t.Fatalf("CreateTag (annotated) failed: %v", err)
+	}
+	if !annotated.Annotated || annotated.Commit.Hash != hash || annotated.Tagger != "dave" {
+		t.Errorf("Unexpected annotated tag: %+v", annotated)
+	}
+
+	tags, err := ListTags(repoPath)
+	if err != nil {
+		t.Fatalf("ListTags failed: %v", err)
+	}
+	if len(tags) != 2 {
+		t.Fatalf("Expected 2 tags, got %d", len(tags))
+	}
+
+	if err := DeleteBranch(repoPath, "main"); !errors.Is(err, ErrDefaultBranch) {
+		t.Errorf("Expected ErrDefaultBranch, got %v", err)
+	}
+	if err := DeleteBranch(repoPath, "release/1.0"); err != nil {
+		t.Errorf("DeleteBranch failed: %v", err)
+	}
+	if err := DeleteBranch(repoPath, "release/1.0"); !errors.Is(err, ErrBranchNotFound) {
+		t.Errorf("Expected ErrBranchNotFound, got %v", err)
+	}
+	if err := DeleteTag(repoPath, "v1.0.0"); err != nil {
+		t.Errorf("DeleteTag failed: %v", err)
+	}
+	if err := DeleteTag(repoPath, "v1.0.0"); !errors.Is(err, ErrTagNotFound) {
+		t.Errorf("Expected ErrTagNotFound, got %v", err)
+	}
+}
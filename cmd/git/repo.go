@@ -3,13 +3,19 @@ package git
 import (
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
+	"github.com/go-git/go-billy/v5/osfs"
 	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+
+	"librebucket/cmd/db"
 )
 
 // RepoMeta holds metadata for a repository
@@ -21,60 +27,221 @@ type RepoMeta struct {
 	ForksCount int                `json:"forks_count"`
 	Languages  map[string]float64 `json:"languages"` // Map of language name to percent
 	CreatedAt  time.Time          `json:"created_at"`
+	// UpdatedAt is the time of the last push
+	UpdatedAt time.Time `json:"updated_at"`
+	// DefaultBranch is the branch HEAD points to
+	DefaultBranch string `json:"default_branch"`
+	// ForkedFrom is the "{owner}/{name}" of the parent of a fork
+	ForkedFrom string `json:"forked_from,omitempty"`
+	// Description, Website and Topics are shown on the repository page
+	Description string   `json:"description,omitempty"`
+	Website     string   `json:"website,omitempty"`
+	Topics      []string `json:"topics,omitempty"`
+	// IsTemplate allows generating new repositories from this one
+	IsTemplate bool `json:"is_template,omitempty"`
+	// MirrorURL is the remote a pull mirror fetches from every MirrorInterval,
+	// authenticated with MirrorUsername and MirrorPassword, which are never
+	// part of the URL
+	MirrorURL      string        `json:"mirror_url,omitempty"`
+	MirrorUsername string        `json:"-"`
+	MirrorPassword string        `json:"-"`
+	MirrorInterval time.Duration `json:"mirror_interval,omitempty"`
+	// MirrorSyncedAt and MirrorError record the last sync of a pull mirror
+	MirrorSyncedAt time.Time `json:"mirror_synced_at,omitzero"`
+	MirrorError    string    `json:"mirror_error,omitempty"`
+	// Size is the size of the repository on disk, LFSSize the size of its LFS
+	// objects. LFSSize is computed from the stored objects and never saved.
+	Size    int64 `json:"size"`
+	LFSSize int64 `json:"lfs_size"`
+	// PushPolicy holds the rules pushes must follow
+	PushPolicy PushPolicy `json:"push_policy,omitzero"`
 }
 
-// Metadata is stored in repos/{username}/{reponame}.meta.json
+// Metadata is stored in the repositories table. Older versions stored it in
+// repos/{username}/{reponame}.git/.meta.json, see ImportRepoMetaFiles.
 const (
-	metadataFile    = ".meta.json"
-	safeRepoBaseDir = "repos"
+	metadataFile      = ".meta.json"
+	safeRepoBaseDir   = "repos"
+	defaultBranchName = "main"
 )
 
+// resolveSafePath resolves a repository path inside baseDir. Paths that already
+// point inside baseDir (e.g. "repos/alice/project.git") are used as they are,
+// anything else is treated as relative to baseDir.
 func resolveSafePath(baseDir, relativePath string) (string, error) {
-	absPath, err := filepath.Abs(filepath.Join(baseDir, relativePath))
+	baseAbs, err := filepath.Abs(baseDir)
 	if err != nil {
 		return "", err
 	}
-	baseAbs, err := filepath.Abs(baseDir)
+	if absPath, err := filepath.Abs(relativePath); err == nil && isWithin(baseAbs, absPath) {
+		return absPath, nil
+	}
+	absPath, err := filepath.Abs(filepath.Join(baseDir, relativePath))
 	if err != nil {
 		return "", err
 	}
-	if !strings.HasPrefix(absPath, baseAbs) {
+	if !isWithin(baseAbs, absPath) {
 		return "", fmt.Errorf("unsafe path: %s", absPath)
 	}
 	return absPath, nil
 }
 
-// SaveRepoMeta saves metadata for a repository
-func SaveRepoMeta(repoPath string, meta RepoMeta) error {
+// isWithin reports whether path is baseDir itself or located below it
+func isWithin(baseDir, path string) bool {
+	return path == baseDir || strings.HasPrefix(path, baseDir+string(filepath.Separator))
+}
+
+// openRepo opens a repository like git.PlainOpen, but also finds objects shared
+// through objects/info/alternates (used by forks), which go-git only resolves
+// inside the repository directory by default.
+func openRepo(repoPath string) (*git.Repository, error) {
+	r, err := git.PlainOpen(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(filepath.Join(repoPath, "objects", "info", "alternates")); err != nil {
+		return r, nil
+	}
+
+	st := filesystem.NewStorageWithOptions(osfs.New(repoPath), cache.NewObjectLRUDefault(), filesystem.Options{
+		AlternatesFS: osfs.New(string(filepath.Separator)),
+	})
+	return git.Open(st, nil)
+}
+
+// repoKey returns the owner and name a repository's metadata is stored under,
+// taken from its path repos/{owner}/{name}.git
+func repoKey(repoPath string) (owner, name string, err error) {
 	safeRepoPath, err := resolveSafePath(safeRepoBaseDir, repoPath)
 	if err != nil {
-		return fmt.Errorf("invalid repo path: %w", err)
+		return "", "", fmt.Errorf("invalid repo path: %w", err)
+	}
+	owner = filepath.Base(filepath.Dir(safeRepoPath))
+	name = strings.TrimSuffix(filepath.Base(safeRepoPath), ".git")
+	return owner, name, nil
+}
+
+func newRepoMeta(r db.Repository) (RepoMeta, error) {
+	meta := RepoMeta{
+		Public:        r.Public,
+		Owner:         r.Owner,
+		Description:   r.Description,
+		Website:       r.Website,
+		Topics:        r.Topics,
+		IsTemplate:    r.IsTemplate,
+		StarsCount:    r.StarsCount,
+		LastCommit:    r.LastCommit,
+		ForksCount:    r.ForksCount,
+		Languages:     r.Languages,
+		CreatedAt:     r.CreatedAt,
+		UpdatedAt:     r.UpdatedAt,
+		DefaultBranch: r.DefaultBranch,
+		ForkedFrom:    r.ForkedFrom,
+
+		MirrorURL:      r.MirrorURL,
+		MirrorUsername: r.MirrorUsername,
+		MirrorPassword: r.MirrorPassword,
+		MirrorInterval: r.MirrorInterval,
+		MirrorSyncedAt: r.MirrorSyncedAt,
+		MirrorError:    r.MirrorError,
+		Size:           r.Size,
+		LFSSize:        r.LFSSize,
+	}
+	// A policy that cannot be read must not let every push through
+	if r.PushPolicy != "" {
+		if err := json.Unmarshal([]byte(r.PushPolicy), &meta.PushPolicy); err != nil {
+			return RepoMeta{}, fmt.Errorf("invalid push policy of %s: %w", r.FullName(), err)
+		}
+	}
+	return meta, nil
+}
+
+// applyRepoMeta copies the metadata fields into a repository row
+func applyRepoMeta(r *db.Repository, meta RepoMeta) {
+	r.Public = meta.Public
+	r.Description = meta.Description
+	r.Website = meta.Website
+	r.Topics = meta.Topics
+	r.IsTemplate = meta.IsTemplate
+	r.StarsCount = meta.StarsCount
+	r.LastCommit = meta.LastCommit
+	r.ForksCount = meta.ForksCount
+	r.Languages = meta.Languages
+	r.CreatedAt = meta.CreatedAt
+	r.UpdatedAt = meta.UpdatedAt
+	r.DefaultBranch = meta.DefaultBranch
+	r.ForkedFrom = meta.ForkedFrom
+	r.MirrorURL = meta.MirrorURL
+	r.MirrorUsername = meta.MirrorUsername
+	r.MirrorPassword = meta.MirrorPassword
+	r.MirrorInterval = meta.MirrorInterval
+	r.MirrorSyncedAt = meta.MirrorSyncedAt
+	r.MirrorError = meta.MirrorError
+	r.Size = meta.Size
+	r.PushPolicy = ""
+	if !meta.PushPolicy.IsZero() {
+		policy, _ := json.Marshal(meta.PushPolicy) // Cannot fail for strings and numbers
+		r.PushPolicy = string(policy)
 	}
-	metaFilePath := filepath.Join(safeRepoPath, metadataFile)
-	data, err := json.MarshalIndent(meta, "", "  ")
+}
+
+// updateRepoMeta loads the metadata of a repository, applies update and saves it
+// in a single database transaction
+func updateRepoMeta(repoPath string, update func(meta *RepoMeta) error) error {
+	owner, name, err := repoKey(repoPath)
 	if err != nil {
-		return fmt.Errorf("failed to marshal repo metadata: %w", err)
+		return err
+	}
+	err = db.UpdateRepository(owner, name, func(r *db.Repository) error {
+		meta, err := newRepoMeta(*r)
+		if err != nil {
+			return err
+		}
+		if err := update(&meta); err != nil {
+			return err
+		}
+		applyRepoMeta(r, meta)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update repo metadata: %w", err)
+	}
+	return nil
+}
+
+// SaveRepoMeta saves metadata for a repository. The owner is part of the
+// repository path and cannot be changed through the metadata.
+func SaveRepoMeta(repoPath string, meta RepoMeta) error {
+	owner, name, err := repoKey(repoPath)
+	if err != nil {
+		return err
+	}
+	if meta.Owner != "" && meta.Owner != owner {
+		return fmt.Errorf("owner %s does not match repository path %s/%s", meta.Owner, owner, name)
 	}
-	return ioutil.WriteFile(metaFilePath, data, 0644)
+
+	r := db.Repository{Owner: owner, Name: name}
+	applyRepoMeta(&r, meta)
+	if err := db.SaveRepository(r); err != nil {
+		return fmt.Errorf("failed to save repo metadata: %w", err)
+	}
+	return nil
 }
 
 // LoadRepoMeta loads metadata for a repository
 func LoadRepoMeta(repoPath string) (RepoMeta, error) {
-	safeRepoPath, err := resolveSafePath(safeRepoBaseDir, repoPath)
+	owner, name, err := repoKey(repoPath)
 	if err != nil {
-		return RepoMeta{}, fmt.Errorf("invalid repo path: %w", err)
+		return RepoMeta{}, err
 	}
-	metaFilePath := filepath.Join(safeRepoPath, metadataFile)
-	data, err := ioutil.ReadFile(metaFilePath)
+	r, err := db.GetRepository(owner, name)
 	if err != nil {
-		return RepoMeta{}, fmt.Errorf("failed to read repo metadata: %w", err)
+		return RepoMeta{}, fmt.Errorf("failed to load repo metadata: %w", err)
 	}
-
-	var meta RepoMeta
-	if err := json.Unmarshal(data, &meta); err != nil {
-		return RepoMeta{}, fmt.Errorf("failed to unmarshal repo metadata: %w", err)
+	meta, err := newRepoMeta(r)
+	if err != nil {
+		return RepoMeta{}, fmt.Errorf("failed to load repo metadata: %w", err)
 	}
-
 	return meta, nil
 }
 
@@ -107,64 +274,178 @@ func CloneRepo(url, directory string) error {
 
 // CreateRepo initializes a new git repository in the specified directory and saves metadata
 func CreateRepo(repoPath, owner string, public bool) error {
+	return CreateRepoWithOptions(repoPath, owner, public, InitOptions{})
+}
+
+// CreateRepoWithOptions initializes a new git repository like CreateRepo, on the
+// default branch given in opts and with an initial commit of the requested files
+func CreateRepoWithOptions(repoPath, owner string, public bool, opts InitOptions) error {
 	safeRepoPath, err := resolveSafePath(safeRepoBaseDir, repoPath)
 	if err != nil {
 		return fmt.Errorf("invalid repo path: %w", err)
 	}
+	pathOwner, name, _ := repoKey(safeRepoPath)
+	if pathOwner != owner {
+		return fmt.Errorf("repository %s must be created below repos/%s", repoPath, owner)
+	}
 
-	_, err = git.PlainInit(safeRepoPath, true)
+	defaultBranch := opts.DefaultBranch
+	if defaultBranch == "" {
+		defaultBranch = defaultBranchName
+	}
+	branchRef := plumbing.NewBranchReferenceName(defaultBranch)
+	if err := branchRef.Validate(); err != nil {
+		return fmt.Errorf("invalid branch name %s: %w", defaultBranch, err)
+	}
+	// Load the templates first, so a typo does not leave an empty repository behind
+	files, err := initialFiles(name, owner, opts)
+	if err != nil {
+		return err
+	}
+
+	r, err := git.PlainInit(safeRepoPath, true)
 	if err != nil {
 		return fmt.Errorf("failed to create repo: %w", err)
 	}
 
+	// Point HEAD at the default branch, it is created by the first push
+	head := plumbing.NewSymbolicReference(plumbing.HEAD, branchRef)
+	if err := r.Storer.SetReference(head); err != nil {
+		return fmt.Errorf("failed to set HEAD: %w", err)
+	}
+
+	now := time.Now()
 	meta := RepoMeta{
-		Owner:      owner,
-		Public:     public,
-		StarsCount: 0,
-		LastCommit: "",
-		ForksCount: 0,
-		Languages:  make(map[string]float64),
-		CreatedAt:  time.Now(),
+		Owner:         owner,
+		Public:        public,
+		StarsCount:    0,
+		LastCommit:    "",
+		ForksCount:    0,
+		Languages:     make(map[string]float64),
+		CreatedAt:     now,
+		UpdatedAt:     now,
+		DefaultBranch: defaultBranch,
+	}
+	if len(files) > 0 {
+		hash, err := writeInitialCommit(r, branchRef, files, opts.Author)
+		if err != nil {
+			os.RemoveAll(safeRepoPath)
+			return err
+		}
+		meta.LastCommit = hash.String()
 	}
 	return SaveRepoMeta(repoPath, meta)
 }
 
 // UpdateStars updates the stars count for a repo
 func UpdateStars(repoPath string, stars int) error {
-	meta, err := LoadRepoMeta(repoPath)
-	if err != nil {
-		return err
-	}
-	meta.StarsCount = stars
-	return SaveRepoMeta(repoPath, meta)
+	return updateRepoMeta(repoPath, func(meta *RepoMeta) error {
+		meta.StarsCount = stars
+		return nil
+	})
+}
+
+// RefreshStars sets the stars count for a repo to the result of count, which is
+// called inside the metadata transaction so concurrent refreshes cannot save a
+// stale count after a newer one
+func RefreshStars(repoPath string, count func() (int, error)) error {
+	return updateRepoMeta(repoPath, func(meta *RepoMeta) error {
+		stars, err := count()
+		if err != nil {
+			return fmt.Errorf("failed to count stars: %w", err)
+		}
+		meta.StarsCount = stars
+		return nil
+	})
 }
 
 // UpdateForks updates the forks count for a repo
 func UpdateForks(repoPath string, forks int) error {
-	meta, err := LoadRepoMeta(repoPath)
-	if err != nil {
-		return err
-	}
-	meta.ForksCount = forks
-	return SaveRepoMeta(repoPath, meta)
+	return updateRepoMeta(repoPath, func(meta *RepoMeta) error {
+		meta.ForksCount = forks
+		return nil
+	})
 }
 
-// UpdateLastCommit sets the last commit hash or timestamp
+// UpdateLastCommit sets the last commit hash or timestamp and marks the
+// repository as updated when it changed
 func UpdateLastCommit(repoPath, commit string) error {
-	meta, err := LoadRepoMeta(repoPath)
-	if err != nil {
-		return err
-	}
-	meta.LastCommit = commit
-	return SaveRepoMeta(repoPath, meta)
+	return updateRepoMeta(repoPath, func(meta *RepoMeta) error {
+		if meta.LastCommit != commit {
+			meta.UpdatedAt = time.Now()
+		}
+		meta.LastCommit = commit
+		return nil
+	})
 }
 
 // UpdateLanguages sets the languages map for a repo
 func UpdateLanguages(repoPath string, languages map[string]float64) error {
-	meta, err := LoadRepoMeta(repoPath)
+	return updateRepoMeta(repoPath, func(meta *RepoMeta) error {
+		meta.Languages = languages
+		return nil
+	})
+}
+
+// UpdateSize sets the size of a repository to the size of its directory
+func UpdateSize(repoPath string) error {
+	safeRepoPath, err := resolveSafePath(safeRepoBaseDir, repoPath)
 	if err != nil {
-		return err
+		return fmt.Errorf("invalid repo path: %w", err)
 	}
-	meta.Languages = languages
-	return SaveRepoMeta(repoPath, meta)
+	var size int64
+	err = filepath.WalkDir(safeRepoPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to measure repository size: %w", err)
+	}
+	return updateRepoMeta(repoPath, func(meta *RepoMeta) error {
+		meta.Size = size
+		return nil
+	})
+}
+
+// SetDefaultBranch stores the default branch in the metadata and points HEAD at it
+func SetDefaultBranch(repoPath, branch string) error {
+	refName := plumbing.NewBranchReferenceName(branch)
+	if err := refName.Validate(); err != nil {
+		return fmt.Errorf("invalid branch name %s: %w", branch, err)
+	}
+
+	safeRepoPath, err := resolveSafePath(safeRepoBaseDir, repoPath)
+	if err != nil {
+		return fmt.Errorf("invalid repo path: %w", err)
+	}
+	r, err := openRepo(safeRepoPath)
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	// An empty repository may point HEAD at a branch that is not born yet
+	if _, err := r.Reference(refName, false); err != nil {
+		branches, err := ListBranches(safeRepoPath)
+		if err != nil {
+			return err
+		}
+		if len(branches) > 0 {
+			return fmt.Errorf("%w: %s", ErrBranchNotFound, branch)
+		}
+	}
+
+	return updateRepoMeta(repoPath, func(meta *RepoMeta) error {
+		if err := r.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, refName)); err != nil {
+			return fmt.Errorf("failed to set HEAD: %w", err)
+		}
+		meta.DefaultBranch = branch
+		return nil
+	})
 }
@@ -1,15 +1,26 @@
 package git
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
 )
 
 func TestCreateAndMetaRepo(t *testing.T) {
-	dir := t.TempDir()
-	repoPath := filepath.Join(dir, "testrepo.git")
+	t.Chdir(t.TempDir())
 	owner := "alice"
+	repoPath := filepath.Join("repos", owner, "testrepo.git")
 	public := true
 
 	err := CreateRepo(repoPath, owner, public)
@@ -43,9 +54,9 @@ func TestCreateAndMetaRepo(t *testing.T) {
 }
 
 func TestCloneRepo(t *testing.T) {
-	dir := t.TempDir()
-	srcPath := filepath.Join(dir, "src.git")
-	dstPath := filepath.Join(dir, "dst.git")
+	t.Chdir(t.TempDir())
+	srcPath := filepath.Join("repos", "bob", "src.git")
+	dstPath := filepath.Join("clones", "dst")
 	owner := "bob"
 	public := false
 
@@ -54,10 +65,7 @@ func TestCloneRepo(t *testing.T) {
 	}
 
 	// Add a dummy file to srcPath to test clone
-	dummyFile := filepath.Join(srcPath, "README.md")
-	if err := os.WriteFile(dummyFile, []byte("hello world"), 0644); err != nil {
-		t.Fatalf("Failed to write dummy file: %v", err)
-	}
+	commitFiles(t, srcPath, "main", map[string]string{"README.md": "hello world"})
 
 	if err := CloneRepo(srcPath, dstPath); err != nil {
 		t.Fatalf("CloneRepo failed: %v", err)
@@ -67,3 +75,183 @@ func TestCloneRepo(t *testing.T) {
 		t.Errorf("Cloned repo missing README.md: %v", err)
 	}
 }
+
+func TestSetDefaultBranch(t *testing.T) {
+	t.Chdir(t.TempDir())
+	repoPath := filepath.Join("repos", "carol", "project.git")
+	if err := CreateRepo(repoPath, "carol", true); err != nil {
+		t.Fatalf("CreateRepo failed: %v", err)
+	}
+
+	meta, err := LoadRepoMeta(repoPath)
+	if err != nil {
+		t.Fatalf("LoadRepoMeta failed: %v", err)
+	}
+	if meta.DefaultBranch != "main" {
+		t.Errorf("Expected default branch main, got %q", meta.DefaultBranch)
+	}
+
+	// HEAD may point to an unborn branch while the repository is empty
+	if err := SetDefaultBranch(repoPath, "trunk"); err != nil {
+		t.Fatalf("SetDefaultBranch on empty repo failed: %v", err)
+	}
+
+	commitFiles(t, repoPath, "trunk", map[string]string{"README.md": "hello"})
+	commitFiles(t, repoPath, "develop", map[string]string{"README.md": "hello"})
+
+	if err := SetDefaultBranch(repoPath, "missing"); !errors.Is(err, ErrBranchNotFound) {
+		t.Errorf("Expected ErrBranchNotFound, got %v", err)
+	}
+	if err := SetDefaultBranch(repoPath, "develop"); err != nil {
+		t.Fatalf("SetDefaultBranch failed: %v", err)
+	}
+
+	r, err := git.PlainOpen(repoPath)
+	if err != nil {
+		t.Fatalf("PlainOpen failed: %v", err)
+	}
+	head, err := r.Reference(plumbing.HEAD, false)
+	if err != nil {
+		t.Fatalf("Reference(HEAD) failed: %v", err)
+	}
+	if head.Target() != plumbing.NewBranchReferenceName("develop") {
+		t.Errorf("HEAD points to %s, want refs/heads/develop", head.Target())
+	}
+	if meta, _ := LoadRepoMeta(repoPath); meta.DefaultBranch != "develop" {
+		t.Errorf("Metadata default branch is %q, want develop", meta.DefaultBranch)
+	}
+}
+
+func TestRefreshStarsConcurrent(t *testing.T) {
+	t.Chdir(t.TempDir())
+	repoPath := filepath.Join("repos", "dave", "popular.git")
+	if err := CreateRepo(repoPath, "dave", true); err != nil {
+		t.Fatalf("CreateRepo failed: %v", err)
+	}
+
+	// Every goroutine adds a star and saves the count, none may be lost
+	var stars atomic.Int64
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stars.Add(1)
+			err := RefreshStars(repoPath, func() (int, error) {
+				return int(stars.Load()), nil
+			})
+			if err != nil {
+				t.Errorf("RefreshStars failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	meta, err := LoadRepoMeta(repoPath)
+	if err != nil {
+		t.Fatalf("LoadRepoMeta failed: %v", err)
+	}
+	if meta.StarsCount != 50 {
+		t.Errorf("Expected 50 stars, got %d", meta.StarsCount)
+	}
+}
+
+// commitFiles writes a commit containing exactly the given files on top of branch
+// in a (bare) repository and returns its hash
+func commitFiles(t *testing.T, repoPath, branch string, files map[string]string) string {
+	t.Helper()
+	r, err := git.PlainOpen(repoPath)
+	if err != nil {
+		t.Fatalf("PlainOpen failed: %v", err)
+	}
+
+	refName := plumbing.NewBranchReferenceName(branch)
+	var parents []plumbing.Hash
+	if ref, err := r.Reference(refName, false); err == nil {
+		parents = append(parents, ref.Hash())
+	}
+
+	hash := writeTestCommit(t, r, writeTestTree(t, r, files), parents...)
+	if err := r.Storer.SetReference(plumbing.NewHashReference(refName, hash)); err != nil {
+		t.Fatalf("SetReference failed: %v", err)
+	}
+	return hash.String()
+}
+
+// writeTestCommit stores a commit of the tree with the given parents
+func writeTestCommit(t *testing.T, r *git.Repository, treeHash plumbing.Hash, parents ...plumbing.Hash) plumbing.Hash {
+	t.Helper()
+	sig := object.Signature{Name: "Test.User", Email: "test@example.com", When: time.Now()}
+	return writeTestCommitAs(t, r, sig, treeHash, parents...)
+}
+
+// writeTestCommitAs stores a commit of the tree authored and committed by sig
+func writeTestCommitAs(t *testing.T, r *git.Repository, sig object.Signature, treeHash plumbing.Hash, parents ...plumbing.Hash) plumbing.Hash {
+	t.Helper()
+	c := &object.Commit{
+		Author:       sig,
+		Committer:    sig,
+		Message:      "update files",
+		TreeHash:     treeHash,
+		ParentHashes: parents,
+	}
+	obj := r.Storer.NewEncodedObject()
+	if err := c.Encode(obj); err != nil {
+		t.Fatalf("Encode commit failed: %v", err)
+	}
+	hash, err := r.Storer.SetEncodedObject(obj)
+	if err != nil {
+		t.Fatalf("SetEncodedObject failed: %v", err)
+	}
+	return hash
+}
+
+// writeTestTree stores the files as a tree (with subtrees for nested paths)
+func writeTestTree(t *testing.T, r *git.Repository, files map[string]string) plumbing.Hash {
+	t.Helper()
+	subdirs := make(map[string]map[string]string)
+	var entries []object.TreeEntry
+	for name, content := range files {
+		if dir, rest, ok := strings.Cut(name, "/"); ok {
+			if subdirs[dir] == nil {
+				subdirs[dir] = make(map[string]string)
+			}
+			subdirs[dir][rest] = content
+			continue
+		}
+		obj := r.Storer.NewEncodedObject()
+		obj.SetType(plumbing.BlobObject)
+		w, _ := obj.Writer()
+		w.Write([]byte(content))
+		w.Close()
+		hash, err := r.Storer.SetEncodedObject(obj)
+		if err != nil {
+			t.Fatalf("SetEncodedObject failed: %v", err)
+		}
+		entries = append(entries, object.TreeEntry{Name: name, Mode: filemode.Regular, Hash: hash})
+	}
+	for dir, sub := range subdirs {
+		entries = append(entries, object.TreeEntry{Name: dir, Mode: filemode.Dir, Hash: writeTestTree(t, r, sub)})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return treeSortName(entries[i]) < treeSortName(entries[j])
+	})
+
+	obj := r.Storer.NewEncodedObject()
+	if err := (&object.Tree{Entries: entries}).Encode(obj); err != nil {
+		t.Fatalf("Encode tree failed: %v", err)
+	}
+	hash, err := r.Storer.SetEncodedObject(obj)
+	if err != nil {
+		t.Fatalf("SetEncodedObject failed: %v", err)
+	}
+	return hash
+}
+
+// treeSortName is the name git sorts tree entries by
+func treeSortName(e object.TreeEntry) string {
+	if e.Mode == filemode.Dir {
+		return e.Name + "/"
+	}
+	return e.Name
+}
@@ -0,0 +1,302 @@
+package git
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+var (
+	// ErrInvalidRevision is returned for revisions that are not valid (supported) rev syntax
+	ErrInvalidRevision = errors.New("invalid revision")
+	// ErrRevisionNotFound is returned when a revision does not resolve to any commit
+	ErrRevisionNotFound = errors.New("revision not found")
+	// ErrAmbiguousRevision is returned when a revision resolves to more than one commit
+	ErrAmbiguousRevision = errors.New("ambiguous revision")
+)
+
+// RevisionError describes why a revision could not be resolved.
+// It unwraps to ErrInvalidRevision, ErrRevisionNotFound or ErrAmbiguousRevision.
+type RevisionError struct {
+	Revision string
+	Err      error
+	Reason   string // Optional detail, e.g. the candidates of an ambiguous short hash
+}
+
+func (e *RevisionError) Error() string {
+	if e.Reason != "" {
+		return fmt.Sprintf("%s %q: %s", e.Err, e.Revision, e.Reason)
+	}
+	return fmt.Sprintf("%s %q", e.Err, e.Revision)
+}
+
+func (e *RevisionError) Unwrap() error {
+	return e.Err
+}
+
+// minShortHashLen is the shortest abbreviated hash that is accepted, like git
+const minShortHashLen = 4
+
+// refLookupRules are the places a short ref name is looked up, in git's order
+var refLookupRules = []string{
+	"%s",
+	"refs/%s",
+	"refs/tags/%s",
+	"refs/heads/%s",
+	"refs/remotes/%s",
+	"refs/remotes/%s/HEAD",
+}
+
+// ResolveRevision resolves a revision to a full commit hash.
+//
+// Supported syntax is a subset of gitrevisions(7): full and abbreviated hashes,
+// branch, tag and full ref names, HEAD (or @), followed by any number of
+// ^, ^N, ~, ~N, ^{commit} and ^{} suffixes. Failures are *RevisionError values.
+func ResolveRevision(repoPath, rev string) (string, error) {
+	r, err := openRepo(repoPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	c, err := resolveRevision(r, rev)
+	if err != nil {
+		return "", err
+	}
+	return c.Hash.String(), nil
+}
+
+// resolveRevision resolves a revision to a commit, see ResolveRevision
+func resolveRevision(r *git.Repository, rev string) (*object.Commit, error) {
+	base, suffix := rev, ""
+	if i := strings.IndexAny(rev, "^~"); i >= 0 {
+		base, suffix = rev[:i], rev[i:]
+	}
+	if base == "" {
+		return nil, &RevisionError{Revision: rev, Err: ErrInvalidRevision, Reason: "missing ref or hash"}
+	}
+	if base == "@" {
+		base = "HEAD"
+	}
+
+	c, err := resolveBase(r, rev, base)
+	if err != nil {
+		return nil, err
+	}
+
+	for suffix != "" {
+		op := suffix[0]
+		suffix = suffix[1:]
+
+		// ^{commit} and ^{} peel to a commit, which c already is
+		if op == '^' && strings.HasPrefix(suffix, "{") {
+			end := strings.IndexByte(suffix, '}')
+			if end < 0 {
+				return nil, &RevisionError{Revision: rev, Err: ErrInvalidRevision, Reason: "unterminated ^{"}
+			}
+			if t := suffix[1:end]; t != "" && t != "commit" {
+				return nil, &RevisionError{Revision: rev, Err: ErrInvalidRevision, Reason: "unsupported peel type " + t}
+			}
+			suffix = suffix[end+1:]
+			continue
+		}
+
+		digits := len(suffix) - len(strings.TrimLeft(suffix, "0123456789"))
+		n := 1
+		if digits > 0 {
+			n, err = strconv.Atoi(suffix[:digits])
+			if err != nil {
+				return nil, &RevisionError{Revision: rev, Err: ErrInvalidRevision, Reason: "invalid number"}
+			}
+			suffix = suffix[digits:]
+		}
+
+		switch op {
+		case '~':
+			// ~N follows the first parent N times
+			for i := 0; i < n; i++ {
+				if c, err = nthParent(r, c, 1, rev); err != nil {
+					return nil, err
+				}
+			}
+		case '^':
+			// ^N selects the Nth parent, ^0 is the commit itself
+			if n > 0 {
+				if c, err = nthParent(r, c, n, rev); err != nil {
+					return nil, err
+				}
+			}
+		default:
+			return nil, &RevisionError{Revision: rev, Err: ErrInvalidRevision, Reason: fmt.Sprintf("unexpected %q", op)}
+		}
+	}
+
+	return c, nil
+}
+
+// resolveBase resolves the part of a revision before any ^ or ~ suffix
+func resolveBase(r *git.Repository, rev, base string) (*object.Commit, error) {
+	isHex := isHexString(base)
+
+	// A full hash always names an object directly
+	if isHex && len(base) == 2*len(plumbing.ZeroHash) {
+		c, err := peelToCommit(r, plumbing.NewHash(base))
+		if err != nil {
+			return nil, &RevisionError{Revision: rev, Err: ErrRevisionNotFound}
+		}
+		return c, nil
+	}
+
+	// Refs take precedence over abbreviated hashes, like in git
+	c, err := resolveRefName(r, rev, base)
+	if c != nil || err != nil {
+		return c, err
+	}
+
+	if isHex && len(base) >= minShortHashLen {
+		return resolveShortHash(r, rev, base)
+	}
+
+	if err := plumbing.ReferenceName("refs/heads/" + base).Validate(); err != nil {
+		return nil, &RevisionError{Revision: rev, Err: ErrInvalidRevision, Reason: "invalid ref name"}
+	}
+	return nil, &RevisionError{Revision: rev, Err: ErrRevisionNotFound}
+}
+
+// resolveRefName looks a name up using git's ref lookup rules. It returns a nil
+// commit and a nil error when no ref matches. Names matching several refs that
+// point to different commits (e.g. a branch and a tag) are ambiguous.
+func resolveRefName(r *git.Repository, rev, name string) (*object.Commit, error) {
+	var found *object.Commit
+	var matches []string
+	ambiguous := false
+	for _, rule := range refLookupRules {
+		refName := plumbing.ReferenceName(fmt.Sprintf(rule, name))
+		ref, err := r.Reference(refName, true)
+		if err != nil {
+			continue
+		}
+		c, err := peelToCommit(r, ref.Hash())
+		if err != nil {
+			continue
+		}
+		if found == nil {
+			found = c
+		} else if found.Hash != c.Hash {
+			ambiguous = true
+		}
+		matches = append(matches, refName.String())
+	}
+
+	if ambiguous {
+		return nil, &RevisionError{Revision: rev, Err: ErrAmbiguousRevision, Reason: "matches " + strings.Join(matches, ", ")}
+	}
+	return found, nil
+}
+
+// resolveShortHash resolves an abbreviated hash to the only commit it can name
+func resolveShortHash(r *git.Repository, rev, prefix string) (*object.Commit, error) {
+	prefix = strings.ToLower(prefix)
+	seen := make(map[plumbing.Hash]bool)
+	var commits []*object.Commit
+	for _, h := range hashesWithPrefix(r, prefix) {
+		c, err := peelToCommit(r, h)
+		if err != nil || seen[c.Hash] {
+			continue
+		}
+		seen[c.Hash] = true
+		commits = append(commits, c)
+	}
+
+	switch len(commits) {
+	case 0:
+		return nil, &RevisionError{Revision: rev, Err: ErrRevisionNotFound}
+	case 1:
+		return commits[0], nil
+	default:
+		candidates := make([]string, len(commits))
+		for i, c := range commits {
+			candidates[i] = c.Hash.String()
+		}
+		return nil, &RevisionError{Revision: rev, Err: ErrAmbiguousRevision, Reason: "candidates " + strings.Join(candidates, ", ")}
+	}
+}
+
+// hashesWithPrefix returns the hashes of all objects starting with the hex prefix
+func hashesWithPrefix(r *git.Repository, prefix string) []plumbing.Hash {
+	evenHex := prefix[:len(prefix)&^1]
+	b, err := hex.DecodeString(evenHex)
+	if err != nil {
+		return nil
+	}
+
+	var candidates []plumbing.Hash
+	// The filesystem storage can look prefixes up without a full scan
+	type prefixLookup interface {
+		HashesWithPrefix(prefix []byte) ([]plumbing.Hash, error)
+	}
+	if pl, ok := r.Storer.(prefixLookup); ok {
+		candidates, _ = pl.HashesWithPrefix(b)
+	} else if iter, err := r.Storer.IterEncodedObjects(plumbing.AnyObject); err == nil {
+		iter.ForEach(func(obj plumbing.EncodedObject) error {
+			if h := obj.Hash(); bytes.HasPrefix(h[:], b) {
+				candidates = append(candidates, h)
+			}
+			return nil
+		})
+	}
+
+	// Check the dangling nibble of odd-length prefixes
+	var hashes []plumbing.Hash
+	for _, h := range candidates {
+		if strings.HasPrefix(h.String(), prefix) {
+			hashes = append(hashes, h)
+		}
+	}
+	return hashes
+}
+
+// peelToCommit returns the commit a commit or (nested) annotated tag hash points to
+func peelToCommit(r *git.Repository, h plumbing.Hash) (*object.Commit, error) {
+	for {
+		if c, err := r.CommitObject(h); err == nil {
+			return c, nil
+		}
+		t, err := r.TagObject(h)
+		if err != nil {
+			return nil, fmt.Errorf("%s is not a commit or tag: %w", h, err)
+		}
+		h = t.Target
+	}
+}
+
+// nthParent returns the nth (1-based) parent of a commit
+func nthParent(r *git.Repository, c *object.Commit, n int, rev string) (*object.Commit, error) {
+	if n > len(c.ParentHashes) {
+		return nil, &RevisionError{Revision: rev, Err: ErrRevisionNotFound, Reason: fmt.Sprintf("%s has no parent %d", c.Hash, n)}
+	}
+	parent, err := r.CommitObject(c.ParentHashes[n-1])
+	if err != nil {
+		return nil, fmt.Errorf("failed to get parent of %s: %w", c.Hash, err)
+	}
+	return parent, nil
+}
+
+// isHexString reports whether s only contains lowercase or uppercase hex digits
+func isHexString(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", r) {
+			return false
+		}
+	}
+	return true
+}
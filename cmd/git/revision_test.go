@@ -0,0 +1,104 @@
+package git
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+func TestResolveRevision(t *testing.T) {
+	t.Chdir(t.TempDir())
+	repoPath := filepath.Join("repos", "erin", "service.git")
+	if err := CreateRepo(repoPath, "erin", true); err != nil {
+		t.Fatalf("CreateRepo failed: %v", err)
+	}
+
+	// c1 - c2 - c3 - merge
+	//        \        /
+	//         feature
+	c1 := commitFiles(t, repoPath, "main", map[string]string{"a.txt": "1"})
+	c2 := commitFiles(t, repoPath, "main", map[string]string{"a.txt": "2"})
+	for _, name := range []string{"feature", "base"} {
+		if _, err := CreateBranch(repoPath, name, c2); err != nil {
+			t.Fatalf("CreateBranch failed: %v", err)
+		}
+	}
+	feature := commitFiles(t, repoPath, "feature", map[string]string{"a.txt": "2", "b.txt": "feature"})
+	c3 := commitFiles(t, repoPath, "main", map[string]string{"a.txt": "3"})
+
+	r, err := git.PlainOpen(repoPath)
+	if err != nil {
+		t.Fatalf("PlainOpen failed: %v", err)
+	}
+	tree := writeTestTree(t, r, map[string]string{"a.txt": "3", "b.txt": "feature"})
+	merge := writeTestCommit(t, r, tree, plumbing.NewHash(c3), plumbing.NewHash(feature)).String()
+	if err := r.Storer.SetReference(plumbing.NewHashReference("refs/heads/main", plumbing.NewHash(merge))); err != nil {
+		t.Fatalf("SetReference failed: %v", err)
+	}
+	if _, err := CreateTag(repoPath, "v1.0", c1, "first release", nil); err != nil {
+		t.Fatalf("CreateTag failed: %v", err)
+	}
+
+	tests := map[string]string{
+		merge:                 merge,
+		merge[:7]:             merge,
+		"main":                merge,
+		"refs/heads/main":     merge,
+		"HEAD":                merge,
+		"@":                   merge,
+		"main^":               c3,
+		"main^1":              c3,
+		"main^2":              feature,
+		"main~1":              c3,
+		"main~2":              c2,
+		"main^^^":             c1,
+		"main^2~1":            c2,
+		"main^0":              merge,
+		"v1.0":                c1,
+		"v1.0^{commit}":       c1,
+		"refs/tags/v1.0^{}":   c1,
+		"feature~":            c2,
+		"HEAD~3":              c1,
+		feature[:10] + "^":    c2,
+		"base":                c2,
+		"refs/heads/feature^": c2,
+	}
+	for rev, want := range tests {
+		got, err := ResolveRevision(repoPath, rev)
+		if err != nil {
+			t.Errorf("ResolveRevision(%q) failed: %v", rev, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("ResolveRevision(%q) = %s, want %s", rev, got, want)
+		}
+	}
+
+	notFound := []string{"nope", "main~10", "main^3", "deadbeef", "0000000000000000000000000000000000000000"}
+	for _, rev := range notFound {
+		_, err := ResolveRevision(repoPath, rev)
+		if !errors.Is(err, ErrRevisionNotFound) {
+			t.Errorf("ResolveRevision(%q): expected ErrRevisionNotFound, got %v", rev, err)
+		}
+	}
+
+	invalid := []string{"", "^main", "bad..name", "main@{upstream}", "main^{tree}", "main^{"}
+	for _, rev := range invalid {
+		_, err := ResolveRevision(repoPath, rev)
+		if !errors.Is(err, ErrInvalidRevision) {
+			t.Errorf("ResolveRevision(%q): expected ErrInvalidRevision, got %v", rev, err)
+		}
+	}
+
+	// A tag and a branch with the same name pointing to different commits
+	if _, err := CreateTag(repoPath, "base", c1, "", nil); err != nil {
+		t.Fatalf("CreateTag failed: %v", err)
+	}
+	var revErr *RevisionError
+	if _, err := ResolveRevision(repoPath, "base"); !errors.As(err, &revErr) || !errors.Is(err, ErrAmbiguousRevision) {
+		t.Errorf("Expected ambiguous *RevisionError, got %v", err)
+	}
+}
@@ -0,0 +1,116 @@
+package git
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+)
+
+// TreeEntry is a single entry of a directory listing
+type TreeEntry struct {
+	Name string // Base name of the entry
+	Mode string // Octal git file mode, e.g. "100644"
+	Type string // "blob", "tree", "submodule" or "symlink"
+	BlobSizeResult
+}
+
+// Tree entry types
+const (
+	EntryTypeBlob      = "blob"
+	EntryTypeTree      = "tree"
+	EntryTypeSubmodule = "submodule"
+	EntryTypeSymlink   = "symlink"
+)
+
+// SplitRefPath splits a "{ref}/{path}" as used in URLs into the ref and the
+// path below it. Since branch and tag names may contain slashes, the longest
+// leading part that resolves to a commit is taken as the ref. If no part
+// resolves, the error of the first path segment is returned.
+func SplitRefPath(repoPath, refPath string) (ref, treePath string, err error) {
+	r, err := openRepo(repoPath)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	segments := strings.Split(strings.Trim(refPath, "/"), "/")
+	for i := len(segments); i > 1; i-- {
+		ref = strings.Join(segments[:i], "/")
+		if _, err := resolveRevision(r, ref); err == nil {
+			return ref, strings.Join(segments[i:], "/"), nil
+		}
+	}
+	if _, err := resolveRevision(r, segments[0]); err != nil {
+		return "", "", err
+	}
+	return segments[0], strings.Join(segments[1:], "/"), nil
+}
+
+// GetTree lists the entries of the directory at treePath for the given ref.
+// The ref can be any revision ResolveRevision accepts; an empty treePath
+// lists the repository root.
+func GetTree(repoPath, ref, treePath string) ([]TreeEntry, error) {
+	r, err := openRepo(repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	c, err := resolveRevision(r, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	tree, err := c.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tree for commit %s: %w", c.Hash, err)
+	}
+
+	treePath = strings.Trim(treePath, "/")
+	if treePath != "" {
+		tree, err = tree.Tree(treePath)
+		if err != nil {
+			return nil, fmt.Errorf("directory %s not found at %s: %w", treePath, ref, err)
+		}
+	}
+
+	entries := make([]TreeEntry, 0, len(tree.Entries))
+	for _, e := range tree.Entries {
+		entry := TreeEntry{
+			Name: e.Name,
+			Mode: fmt.Sprintf("%06o", uint32(e.Mode)),
+			Type: entryType(e.Mode),
+			BlobSizeResult: BlobSizeResult{
+				Hash: e.Hash,
+				Path: path.Join(treePath, e.Name),
+			},
+		}
+
+		// Only blobs (regular files and symlinks) have a size in this repository
+		if entry.Type == EntryTypeBlob || entry.Type == EntryTypeSymlink {
+			size, err := r.Storer.EncodedObjectSize(e.Hash)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get size of %s: %w", entry.Path, err)
+			}
+			entry.Size = size
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// entryType maps a git file mode to a tree entry type
+func entryType(mode filemode.FileMode) string {
+	switch mode {
+	case filemode.Dir:
+		return EntryTypeTree
+	case filemode.Submodule:
+		return EntryTypeSubmodule
+	case filemode.Symlink:
+		return EntryTypeSymlink
+	default:
+		return EntryTypeBlob
+	}
+}
@@ -0,0 +1,105 @@
+package git
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+func createTestRepoWithTree(t *testing.T, dir string) (repoPath, commitHash string) {
+	repoPath, _ = createTestRepoWithCommit(t, dir)
+	r, err := git.PlainOpen(repoPath)
+	if err != nil {
+		t.Fatalf("PlainOpen failed: %v", err)
+	}
+	wt, err := r.Worktree()
+	if err != nil {
+		t.Fatalf("Worktree failed: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(repoPath, "docs"), 0755); err != nil {
+		t.Fatalf("MkdirAll failed: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(repoPath, "docs", "guide.md"), []byte("# Guide\n"), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if err := os.Symlink("file.txt", filepath.Join(repoPath, "link.txt")); err != nil {
+		t.Fatalf("Symlink failed: %v", err)
+	}
+	if _, err := wt.Add("."); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	commit, err := wt.Commit("add docs", &git.CommitOptions{
+		Author: &object.Signature{Name: "Test.User", Email: "test@example.com", When: time.Now()},
+	})
+	if err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	return repoPath, commit.String()
+}
+
+func TestGetTree(t *testing.T) {
+	dir := t.TempDir()
+	repoPath, hash := createTestRepoWithTree(t, dir)
+
+	for _, ref := range []string{hash, hash[:7], "master"} {
+		entries, err := GetTree(repoPath, ref, "")
+		if err != nil {
+			t.Fatalf("GetTree(%s) failed: %v", ref, err)
+		}
+		types := make(map[string]string)
+		for _, e := range entries {
+			types[e.Name] = e.Type
+		}
+		if types["file.txt"] != EntryTypeBlob || types["docs"] != EntryTypeTree || types["link.txt"] != EntryTypeSymlink {
+			t.Errorf("Unexpected entry types for ref %s: %v", ref, types)
+		}
+	}
+
+	entries, err := GetTree(repoPath, "master", "docs")
+	if err != nil {
+		t.Fatalf("GetTree(docs) failed: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Path != "docs/guide.md" || entries[0].Size != int64(len("# Guide\n")) {
+		t.Errorf("Unexpected docs listing: %+v", entries)
+	}
+
+	if _, err := GetTree(repoPath, "no-such-branch", ""); err == nil {
+		t.Errorf("Expected error for unknown ref")
+	}
+}
+
+func TestSplitRefPath(t *testing.T) {
+	dir := t.TempDir()
+	repoPath, hash := createTestRepoWithTree(t, dir)
+	r, err := git.PlainOpen(repoPath)
+	if err != nil {
+		t.Fatalf("PlainOpen failed: %v", err)
+	}
+	if err := r.Storer.SetReference(plumbing.NewHashReference("refs/heads/feature/x", plumbing.NewHash(hash))); err != nil {
+		t.Fatalf("SetReference failed: %v", err)
+	}
+
+	for refPath, want := range map[string][2]string{
+		"master":                 {"master", ""},
+		"master/docs":            {"master", "docs"},
+		hash + "/docs/":          {hash, "docs"},
+		"feature/x":              {"feature/x", ""},
+		"feature/x/docs":         {"feature/x", "docs"},
+		"master~1/docs/guide.md": {"master~1", "docs/guide.md"},
+	} {
+		ref, treePath, err := SplitRefPath(repoPath, refPath)
+		if err != nil || ref != want[0] || treePath != want[1] {
+			t.Errorf("SplitRefPath(%s) = %q, %q, %v, want %q, %q", refPath, ref, treePath, err, want[0], want[1])
+		}
+	}
+
+	if _, _, err := SplitRefPath(repoPath, "feature/y/docs"); !errors.Is(err, ErrRevisionNotFound) {
+		t.Errorf("Expected ErrRevisionNotFound, got %v", err)
+	}
+}
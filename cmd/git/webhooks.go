@@ -0,0 +1,319 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"librebucket/cmd/db"
+)
+
+// Events webhooks can subscribe to
+const (
+	// WebhookEventPush is sent for every ref a push updates
+	WebhookEventPush = "push"
+	// WebhookEventCreate is sent when a push creates a branch or tag
+	WebhookEventCreate = "create"
+	// WebhookEventDelete is sent when a push deletes a branch or tag
+	WebhookEventDelete = "delete"
+	// WebhookEventRepository is sent when the settings, name or owner of a
+	// repository change
+	WebhookEventRepository = "repository"
+)
+
+// WebhookEvents are the events webhooks can subscribe to
+var WebhookEvents = []string{WebhookEventPush, WebhookEventCreate, WebhookEventDelete, WebhookEventRepository}
+
+const (
+	// WebhookTimeout bounds a delivery, including reading the response
+	WebhookTimeout = 10 * time.Second
+	// maxWebhookResponse is the part of a response body recorded with a delivery
+	maxWebhookResponse = 4096
+)
+
+// webhookClient sends deliveries without following redirects, so receivers
+// cannot point them elsewhere, and without proxies to local networks
+var webhookClient = &http.Client{
+	Transport: remoteTransport(),
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// WebhookSettings holds the settings of a webhook to add or change. Nil fields
+// are left unchanged, or set to their default when adding a webhook.
+type WebhookSettings struct {
+	URL         *string
+	Secret      *string
+	ContentType *string  // "json" (default) or "form"
+	Events      []string // Defaults to push only
+	Active      *bool    // Defaults to true
+}
+
+// apply validates s and sets its fields on h
+func (s WebhookSettings) apply(h *db.Webhook) error {
+	if s.URL != nil {
+		h.URL = *s.URL
+	}
+	u, err := url.Parse(h.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%w: webhook URL must be an http or https URL", ErrInvalidSettings)
+	}
+	// Receivers on local networks could be read through the delivery log
+	if err := CheckRemoteHost(context.Background(), u.Hostname()); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSettings, err)
+	}
+	if s.Secret != nil {
+		h.Secret = *s.Secret
+	}
+	if s.ContentType != nil {
+		h.ContentType = *s.ContentType
+	}
+	if h.ContentType != "json" && h.ContentType != "form" {
+		return fmt.Errorf("%w: webhook content type must be json or form", ErrInvalidSettings)
+	}
+	if s.Events != nil {
+		h.Events = []string{}
+		for _, event := range s.Events {
+			if !slices.Contains(WebhookEvents, event) {
+				return fmt.Errorf("%w: unknown webhook event %q, must be one of %s",
+					ErrInvalidSettings, event, strings.Join(WebhookEvents, ", "))
+			}
+			if !slices.Contains(h.Events, event) {
+				h.Events = append(h.Events, event)
+			}
+		}
+	}
+	if len(h.Events) == 0 {
+		return fmt.Errorf("%w: a webhook needs at least one event", ErrInvalidSettings)
+	}
+	if s.Active != nil {
+		h.Active = *s.Active
+	}
+	return nil
+}
+
+// AddWebhook adds a webhook that events of repoPath are posted to
+func AddWebhook(repoPath string, s WebhookSettings) (db.Webhook, error) {
+	safeRepoPath, err := resolveSafePath(safeRepoBaseDir, repoPath)
+	if err != nil {
+		return db.Webhook{}, fmt.Errorf("invalid repo path: %w", err)
+	}
+	if _, err := LoadRepoMeta(safeRepoPath); err != nil {
+		return db.Webhook{}, err
+	}
+	h := db.Webhook{
+		Repo:        RepoFullName(safeRepoPath),
+		ContentType: "json",
+		Events:      []string{WebhookEventPush},
+		Active:      true,
+	}
+	if err := s.apply(&h); err != nil {
+		return db.Webhook{}, err
+	}
+	if err := db.CreateWebhook(&h); err != nil {
+		return db.Webhook{}, fmt.Errorf("failed to save webhook: %w", err)
+	}
+	return h, nil
+}
+
+// UpdateWebhook changes the settings of a webhook of a repository
+func UpdateWebhook(repoPath string, id int64, s WebhookSettings) (db.Webhook, error) {
+	h, err := db.GetWebhook(RepoFullName(repoPath), id)
+	if err != nil {
+		return db.Webhook{}, err
+	}
+	if err := s.apply(&h); err != nil {
+		return db.Webhook{}, err
+	}
+	if err := db.UpdateWebhook(&h); err != nil {
+		return db.Webhook{}, err
+	}
+	return h, nil
+}
+
+// GetWebhook returns a webhook of a repository
+func GetWebhook(repoPath string, id int64) (db.Webhook, error) {
+	return db.GetWebhook(RepoFullName(repoPath), id)
+}
+
+// ListWebhooks returns the webhooks of a repository
+func ListWebhooks(repoPath string) ([]db.Webhook, error) {
+	hooks, err := db.ListWebhooks(RepoFullName(repoPath))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhooks: %w", err)
+	}
+	return hooks, nil
+}
+
+// WebhooksForEvent returns the active webhooks of a repository that
+// subscribed to event
+func WebhooksForEvent(repoPath, event string) ([]db.Webhook, error) {
+	hooks, err := ListWebhooks(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(hooks, func(h db.Webhook) bool {
+		return !h.Active || !slices.Contains(h.Events, event)
+	}), nil
+}
+
+// RemoveWebhook removes a webhook of a repository with its deliveries
+func RemoveWebhook(repoPath string, id int64) error {
+	return db.DeleteWebhook(RepoFullName(repoPath), id)
+}
+
+// ListWebhookDeliveries returns the recorded deliveries of a webhook of a
+// repository, newest first
+func ListWebhookDeliveries(repoPath string, id int64) ([]db.WebhookDelivery, error) {
+	if _, err := db.GetWebhook(RepoFullName(repoPath), id); err != nil {
+		return nil, err
+	}
+	return db.ListWebhookDeliveries(id)
+}
+
+// GetWebhookDelivery returns a recorded delivery of a webhook of a repository
+func GetWebhookDelivery(repoPath string, id, deliveryID int64) (db.WebhookDelivery, error) {
+	if _, err := db.GetWebhook(RepoFullName(repoPath), id); err != nil {
+		return db.WebhookDelivery{}, err
+	}
+	return db.GetWebhookDelivery(id, deliveryID)
+}
+
+// NewWebhookGUID returns a random ID for the deliveries of an event
+func NewWebhookGUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40 // Version 4
+	b[8] = b[8]&0x3f | 0x80 // Variant 10
+	h := hex.EncodeToString(b)
+	return h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:], nil
+}
+
+// SignWebhookPayload returns the HMAC-SHA256 of body with secret in the
+// "sha256=<hex>" format of the X-LibreBucket-Signature-256 header
+func SignWebhookPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// DeliverWebhook posts the JSON payload of an event to a webhook of a
+// repository and records the delivery. A delivery fails unless the receiver
+// answers with a 2xx status; the caller decides whether to retry.
+func DeliverWebhook(ctx context.Context, repoPath string, id int64, event, guid string, payload []byte, attempt int) (db.WebhookDelivery, error) {
+	h, err := db.GetWebhook(RepoFullName(repoPath), id)
+	if err != nil {
+		return db.WebhookDelivery{}, err
+	}
+	d := db.WebhookDelivery{WebhookID: h.ID, GUID: guid, Event: event, Attempt: attempt, RequestBody: string(payload)}
+	err = deliverWebhook(ctx, h, &d)
+	return d, err
+}
+
+// RedeliverWebhook posts the payload of a recorded delivery to its webhook
+// again, signed with the current secret, and records the new delivery
+func RedeliverWebhook(ctx context.Context, repoPath string, id, deliveryID int64) (db.WebhookDelivery, error) {
+	h, err := db.GetWebhook(RepoFullName(repoPath), id)
+	if err != nil {
+		return db.WebhookDelivery{}, err
+	}
+	prev, err := db.GetWebhookDelivery(h.ID, deliveryID)
+	if err != nil {
+		return db.WebhookDelivery{}, err
+	}
+	d := db.WebhookDelivery{
+		WebhookID:   h.ID,
+		GUID:        prev.GUID,
+		Event:       prev.Event,
+		Attempt:     1,
+		Redelivery:  true,
+		RequestBody: prev.RequestBody,
+	}
+	err = deliverWebhook(ctx, h, &d)
+	return d, err
+}
+
+// deliverWebhook sends the payload in d.RequestBody to h and records the
+// request and response in d. Form deliveries send the payload in the
+// "payload" field.
+func deliverWebhook(ctx context.Context, h db.Webhook, d *db.WebhookDelivery) error {
+	body := []byte(d.RequestBody)
+	contentType := "application/json"
+	if h.ContentType == "form" {
+		body = []byte("payload=" + url.QueryEscape(d.RequestBody))
+		contentType = "application/x-www-form-urlencoded"
+	}
+	d.RequestHeaders = map[string]string{
+		"Content-Type":           contentType,
+		"User-Agent":             "LibreBucket-Webhook",
+		"X-LibreBucket-Event":    d.Event,
+		"X-LibreBucket-Delivery": d.GUID,
+	}
+	if h.Secret != "" {
+		// The GitHub header lets existing receivers verify deliveries
+		signature := SignWebhookPayload(h.Secret, body)
+		d.RequestHeaders["X-LibreBucket-Signature-256"] = signature
+		d.RequestHeaders["X-Hub-Signature-256"] = signature
+	}
+
+	err := postWebhook(ctx, h.URL, body, d)
+	if err != nil {
+		d.Error = err.Error()
+	}
+	if recordErr := db.CreateWebhookDelivery(d); recordErr != nil {
+		return fmt.Errorf("failed to record webhook delivery: %w", recordErr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to deliver %s event to webhook %d of %s: %w", d.Event, h.ID, h.Repo, err)
+	}
+	return nil
+}
+
+// postWebhook posts body to rawURL with the headers of d and records the
+// status, the start of the response body, the duration and the time in d
+func postWebhook(ctx context.Context, rawURL string, body []byte, d *db.WebhookDelivery) error {
+	ctx, cancel := context.WithTimeout(ctx, WebhookTimeout)
+	defer cancel()
+
+	d.DeliveredAt = time.Now()
+	defer func() { d.Duration = time.Since(d.DeliveredAt) }()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, rawURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for name, value := range d.RequestHeaders {
+		req.Header.Set(name, value)
+	}
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		// Do's error repeats the method and URL, which is part of the delivery
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+
+	d.StatusCode = resp.StatusCode
+	excerpt, err := io.ReadAll(io.LimitReader(resp.Body, maxWebhookResponse))
+	d.ResponseBody = strings.ToValidUTF8(string(excerpt), "�")
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("receiver returned %s", resp.Status)
+	}
+	return err
+}
@@ -0,0 +1,188 @@
+package git
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"librebucket/cmd/db"
+)
+
+// receivedWebhook is a request received by a test webhook receiver
+type receivedWebhook struct {
+	header http.Header
+	body   string
+}
+
+// startWebhookReceiver serves a webhook receiver that answers with the given
+// statuses in turn, the last one repeatedly. The server may reach it although
+// it is local.
+func startWebhookReceiver(t *testing.T, statuses ...int) (string, <-chan receivedWebhook) {
+	t.Helper()
+	allowLocalHosts(t, "127.0.0.1")
+	received := make(chan receivedWebhook, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- receivedWebhook{r.Header, string(body)}
+		status := statuses[0]
+		if len(statuses) > 1 {
+			statuses = statuses[1:]
+		}
+		w.WriteHeader(status)
+		io.WriteString(w, "thanks")
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL, received
+}
+
+// allowLocalHosts sets AllowedLocalHosts for the duration of a test
+func allowLocalHosts(t *testing.T, hosts ...string) {
+	t.Helper()
+	prev := AllowedLocalHosts
+	AllowedLocalHosts = hosts
+	t.Cleanup(func() { AllowedLocalHosts = prev })
+}
+
+func TestAddWebhook(t *testing.T) {
+	t.Chdir(t.TempDir())
+	repoPath := filepath.Join("repos", "tom", "project.git")
+	if err := CreateRepo(repoPath, "tom", false); err != nil {
+		t.Fatalf("CreateRepo failed: %v", err)
+	}
+	ptr := func(s string) *string { return &s }
+
+	for _, invalid := range []WebhookSettings{
+		{URL: ptr("ftp://example.com/hook")},
+		{URL: ptr("https://example.com/hook"), ContentType: ptr("xml")},
+		{URL: ptr("https://example.com/hook"), Events: []string{"push", "star"}},
+		{URL: ptr("https://example.com/hook"), Events: []string{}},
+		// Local networks are off limits
+		{URL: ptr("http://127.0.0.1:8080/hook")},
+		{URL: ptr("http://localhost/hook")},
+		{URL: ptr("http://169.254.169.254/latest/meta-data/")},
+		{URL: ptr("http://10.0.0.1/hook")},
+		{URL: ptr("http://[::1]/hook")},
+		{URL: ptr("http://0.0.0.0/hook")},
+	} {
+		if _, err := AddWebhook(repoPath, invalid); !errors.Is(err, ErrInvalidSettings) {
+			t.Errorf("Expected ErrInvalidSettings for %+v, got %v", invalid, err)
+		}
+	}
+
+	h, err := AddWebhook(repoPath, WebhookSettings{URL: ptr("https://example.com/hook"), Secret: ptr("s3cret")})
+	if err != nil {
+		t.Fatalf("AddWebhook failed: %v", err)
+	}
+	if h.ContentType != "json" || !h.Active || len(h.Events) != 1 || h.Events[0] != WebhookEventPush || h.Secret != "s3cret" {
+		t.Errorf("Expected the default settings, got %+v", h)
+	}
+
+	// Events are deduplicated and omitted settings are kept
+	inactive := false
+	h, err = UpdateWebhook(repoPath, h.ID, WebhookSettings{Events: []string{"create", "delete", "create"}, Active: &inactive})
+	if err != nil {
+		t.Fatalf("UpdateWebhook failed: %v", err)
+	}
+	if h.URL != "https://example.com/hook" || h.Secret != "s3cret" || h.Active || strings.Join(h.Events, ",") != "create,delete" {
+		t.Errorf("Unexpected updated webhook %+v", h)
+	}
+	if hooks, err := WebhooksForEvent(repoPath, WebhookEventCreate); err != nil || len(hooks) != 0 {
+		t.Errorf("Expected no active webhooks, got %+v, %v", hooks, err)
+	}
+
+	if err := RemoveWebhook(repoPath, h.ID); err != nil {
+		t.Fatalf("RemoveWebhook failed: %v", err)
+	}
+	if _, err := GetWebhook(repoPath, h.ID); !errors.Is(err, db.ErrWebhookNotFound) {
+		t.Errorf("Expected ErrWebhookNotFound, got %v", err)
+	}
+}
+
+func TestDeliverWebhook(t *testing.T) {
+	t.Chdir(t.TempDir())
+	repoPath := filepath.Join("repos", "una", "project.git")
+	if err := CreateRepo(repoPath, "una", false); err != nil {
+		t.Fatalf("CreateRepo failed: %v", err)
+	}
+	receiverURL, received := startWebhookReceiver(t, http.StatusInternalServerError, http.StatusOK)
+	secret, form := "s3cret", "form"
+	h, err := AddWebhook(repoPath, WebhookSettings{URL: &receiverURL, Secret: &secret})
+	if err != nil {
+		t.Fatalf("AddWebhook failed: %v", err)
+	}
+	payload := []byte(`{"ref":"refs/heads/main"}`)
+
+	// Failed deliveries are recorded with the response
+	d, err := DeliverWebhook(context.Background(), repoPath, h.ID, WebhookEventPush, "guid-1", payload, 1)
+	if err == nil || d.StatusCode != http.StatusInternalServerError || d.ResponseBody != "thanks" || d.Error == "" {
+		t.Errorf("Expected a failed delivery, got %+v, %v", d, err)
+	}
+	req := <-received
+	if req.body != string(payload) || req.header.Get("Content-Type") != "application/json" ||
+		req.header.Get("X-LibreBucket-Event") != "push" || req.header.Get("X-LibreBucket-Delivery") != "guid-1" {
+		t.Errorf("Unexpected request %v: %s", req.header, req.body)
+	}
+	want := SignWebhookPayload(secret, payload)
+	if req.header.Get("X-LibreBucket-Signature-256") != want || req.header.Get("X-Hub-Signature-256") != want {
+		t.Errorf("Expected signature %s, got headers %v", want, req.header)
+	}
+
+	// Redeliveries send the same payload in the current content type
+	if _, err := UpdateWebhook(repoPath, h.ID, WebhookSettings{ContentType: &form}); err != nil {
+		t.Fatalf("UpdateWebhook failed: %v", err)
+	}
+	redelivery, err := RedeliverWebhook(context.Background(), repoPath, h.ID, d.ID)
+	if err != nil || redelivery.StatusCode != http.StatusOK || !redelivery.Redelivery || redelivery.GUID != "guid-1" {
+		t.Errorf("Expected a successful redelivery, got %+v, %v", redelivery, err)
+	}
+	req = <-received
+	values, _ := url.ParseQuery(req.body)
+	if values.Get("payload") != string(payload) || req.header.Get("X-Hub-Signature-256") != SignWebhookPayload(secret, []byte(req.body)) {
+		t.Errorf("Expected a signed form request, got %v: %s", req.header, req.body)
+	}
+
+	deliveries, err := ListWebhookDeliveries(repoPath, h.ID)
+	if err != nil || len(deliveries) != 2 || deliveries[0].ID != redelivery.ID || deliveries[1].ID != d.ID {
+		t.Fatalf("Expected both deliveries, newest first, got %+v, %v", deliveries, err)
+	}
+	if got, err := GetWebhookDelivery(repoPath, h.ID, d.ID); err != nil || got.RequestHeaders["X-LibreBucket-Delivery"] != "guid-1" {
+		t.Errorf("Expected the recorded request headers, got %+v, %v", got, err)
+	}
+	if _, err := RedeliverWebhook(context.Background(), repoPath, h.ID, d.ID+100); !errors.Is(err, db.ErrWebhookDeliveryNotFound) {
+		t.Errorf("Expected ErrWebhookDeliveryNotFound, got %v", err)
+	}
+
+	// Deliveries are removed with their webhook
+	if err := RemoveWebhook(repoPath, h.ID); err != nil {
+		t.Fatalf("RemoveWebhook failed: %v", err)
+	}
+	if deliveries, err := db.ListWebhookDeliveries(h.ID); err != nil || len(deliveries) != 0 {
+		t.Errorf("Expected no deliveries, got %+v, %v", deliveries, err)
+	}
+}
+
+func TestWebhookLocalHosts(t *testing.T) {
+	t.Chdir(t.TempDir())
+	repoPath := filepath.Join("repos", "val", "project.git")
+	if err := CreateRepo(repoPath, "val", false); err != nil {
+		t.Fatalf("CreateRepo failed: %v", err)
+	}
+	receiverURL, _ := startWebhookReceiver(t, http.StatusOK)
+	h, err := AddWebhook(repoPath, WebhookSettings{URL: &receiverURL})
+	if err != nil {
+		t.Fatalf("AddWebhook failed: %v", err)
+	}
+
+	// Connections are checked again, e.g. after the host resolves differently
+	AllowedLocalHosts = []string{"10.0.0.0/8"}
+	d, err := DeliverWebhook(context.Background(), repoPath, h.ID, WebhookEventPush, "guid-1", []byte("{}"), 1)
+	if !errors.Is(err, ErrForbiddenHost) || d.StatusCode != 0 {
+		t.Errorf("Expected ErrForbiddenHost, got %+v, %v", d, err)
+	}
+}
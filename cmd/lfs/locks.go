@@ -0,0 +1,162 @@
+package lfs
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"librebucket/cmd/db"
+	"librebucket/cmd/git"
+)
+
+var (
+	// ErrLockExists is returned when locking a path that is already locked
+	ErrLockExists = db.ErrLFSLockExists
+	// ErrLockNotFound is returned when no lock matches
+	ErrLockNotFound = db.ErrLFSLockNotFound
+	// ErrLockNotOwned is returned when unlocking the lock of another user without force
+	ErrLockNotOwned = errors.New("lock is held by another user")
+	// ErrInvalidLockPath is returned for lock paths outside the repository
+	ErrInvalidLockPath = errors.New("invalid lock path")
+	// ErrInvalidCursor is returned for cursors not returned by ListLocks or VerifyLocks
+	ErrInvalidCursor = errors.New("invalid cursor")
+)
+
+const (
+	// DefaultLockLimit and MaxLockLimit bound the locks returned per page
+	DefaultLockLimit = 100
+	MaxLockLimit     = 1000
+)
+
+// LockFilter selects the locks returned by ListLocks
+type LockFilter struct {
+	ID     int64  // Only the lock with this ID, all if zero
+	Path   string // Only the lock of this path, all if empty
+	Cursor string // Next cursor of the previous page
+	Limit  int    // DefaultLockLimit if zero
+}
+
+// CreateLock locks a path of a repository for owner. If the path is already
+// locked, the existing lock is returned with ErrLockExists.
+func CreateLock(repoPath, filePath, owner string) (db.LFSLock, error) {
+	filePath, ok := cleanLockPath(filePath)
+	if !ok {
+		return db.LFSLock{}, fmt.Errorf("%w: %s", ErrInvalidLockPath, filePath)
+	}
+	repo := git.RepoFullName(repoPath)
+	l := db.LFSLock{Repo: repo, Path: filePath, Owner: owner}
+	err := db.CreateLFSLock(&l)
+	if errors.Is(err, db.ErrLFSLockExists) {
+		locks, listErr := db.ListLFSLocks(repo, db.LFSLockFilter{Path: filePath, Limit: 1})
+		if listErr != nil || len(locks) == 0 {
+			// Unlocked in the meantime, the client may retry
+			return db.LFSLock{}, err
+		}
+		return locks[0], err
+	} else if err != nil {
+		return db.LFSLock{}, fmt.Errorf("failed to save lock: %w", err)
+	}
+	return l, nil
+}
+
+// ListLocks returns a page of the locks of a repository matching f and the
+// cursor of the next page, which is empty on the last page
+func ListLocks(repoPath string, f LockFilter) ([]db.LFSLock, string, error) {
+	afterID, err := parseCursor(f.Cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	limit := f.Limit
+	if limit <= 0 {
+		limit = DefaultLockLimit
+	}
+	limit = min(limit, MaxLockLimit)
+	filePath := f.Path
+	if filePath != "" {
+		var ok bool
+		if filePath, ok = cleanLockPath(filePath); !ok {
+			return nil, "", fmt.Errorf("%w: %s", ErrInvalidLockPath, f.Path)
+		}
+	}
+
+	// One more lock than requested tells whether there is a next page
+	locks, err := db.ListLFSLocks(git.RepoFullName(repoPath), db.LFSLockFilter{
+		ID:      f.ID,
+		Path:    filePath,
+		AfterID: afterID,
+		Limit:   limit + 1,
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to list locks: %w", err)
+	}
+	next := ""
+	if len(locks) > limit {
+		locks = locks[:limit]
+		next = strconv.FormatInt(locks[limit-1].ID, 10)
+	}
+	return locks, next, nil
+}
+
+// VerifyLocks returns a page of the locks of a repository split into the ones
+// held by user and the ones held by others, and the cursor of the next page.
+// Clients verify locks before a push, so they do not push changes to files
+// locked by others.
+func VerifyLocks(repoPath, user, cursor string, limit int) (ours, theirs []db.LFSLock, next string, err error) {
+	locks, next, err := ListLocks(repoPath, LockFilter{Cursor: cursor, Limit: limit})
+	if err != nil {
+		return nil, nil, "", err
+	}
+	ours, theirs = []db.LFSLock{}, []db.LFSLock{}
+	for _, l := range locks {
+		if l.Owner == user {
+			ours = append(ours, l)
+		} else {
+			theirs = append(theirs, l)
+		}
+	}
+	return ours, theirs, next, nil
+}
+
+// Unlock removes a lock of a repository and returns it. Locks of other users
+// are only removed with force.
+func Unlock(repoPath string, id int64, user string, force bool) (db.LFSLock, error) {
+	repo := git.RepoFullName(repoPath)
+	l, err := db.GetLFSLock(repo, id)
+	if err != nil {
+		return db.LFSLock{}, err
+	}
+	if l.Owner != user && !force {
+		return db.LFSLock{}, fmt.Errorf("%w: %s is locked by %s", ErrLockNotOwned, l.Path, l.Owner)
+	}
+	if err := db.DeleteLFSLock(repo, id); err != nil {
+		return db.LFSLock{}, err
+	}
+	return l, nil
+}
+
+// cleanLockPath normalizes a repository-relative path with forward slashes
+func cleanLockPath(p string) (string, bool) {
+	p = strings.TrimPrefix(p, "/")
+	if p == "" || strings.Contains(p, "\\") {
+		return p, false
+	}
+	clean := path.Clean(p)
+	if clean == "." || clean == ".." || strings.HasPrefix(clean, "../") {
+		return p, false
+	}
+	return clean, true
+}
+
+// parseCursor returns the lock ID a cursor continues after
+func parseCursor(cursor string) (int64, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+	id, err := strconv.ParseInt(cursor, 10, 64)
+	if err != nil || id < 0 {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidCursor, cursor)
+	}
+	return id, nil
+}
@@ -0,0 +1,85 @@
+package lfs
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"librebucket/cmd/git"
+)
+
+func TestLocks(t *testing.T) {
+	t.Chdir(t.TempDir())
+	repoPath := filepath.Join("repos", "cid", "game.git")
+	if err := git.CreateRepo(repoPath, "cid", false); err != nil {
+		t.Fatalf("CreateRepo failed: %v", err)
+	}
+
+	lock, err := CreateLock(repoPath, "/art/hero.psd", "cid")
+	if err != nil {
+		t.Fatalf("CreateLock failed: %v", err)
+	}
+	if lock.Path != "art/hero.psd" || lock.Owner != "cid" || lock.ID == 0 {
+		t.Errorf("Unexpected lock %+v", lock)
+	}
+	existing, err := CreateLock(repoPath, "art/./hero.psd", "dee")
+	if !errors.Is(err, ErrLockExists) || existing.ID != lock.ID {
+		t.Errorf("Expected ErrLockExists with lock %d, got %+v, %v", lock.ID, existing, err)
+	}
+	if _, err := CreateLock(repoPath, "../outside", "cid"); !errors.Is(err, ErrInvalidLockPath) {
+		t.Errorf("Expected ErrInvalidLockPath, got %v", err)
+	}
+
+	for i := range 3 {
+		owner := "cid"
+		if i%2 == 1 {
+			owner = "dee"
+		}
+		if _, err := CreateLock(repoPath, fmt.Sprintf("maps/%d.bin", i), owner); err != nil {
+			t.Fatalf("CreateLock failed: %v", err)
+		}
+	}
+
+	// Pages continue after the cursor
+	first, next, err := ListLocks(repoPath, LockFilter{Limit: 3})
+	if err != nil || len(first) != 3 || next == "" {
+		t.Fatalf("Unexpected first page %+v, %q, %v", first, next, err)
+	}
+	second, next, err := ListLocks(repoPath, LockFilter{Limit: 3, Cursor: next})
+	if err != nil || len(second) != 1 || next != "" || second[0].Path != "maps/2.bin" {
+		t.Errorf("Unexpected second page %+v, %q, %v", second, next, err)
+	}
+	if _, _, err := ListLocks(repoPath, LockFilter{Cursor: "x"}); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("Expected ErrInvalidCursor, got %v", err)
+	}
+	byPath, _, err := ListLocks(repoPath, LockFilter{Path: "art/hero.psd"})
+	if err != nil || len(byPath) != 1 || byPath[0].ID != lock.ID {
+		t.Errorf("Unexpected locks by path %+v, %v", byPath, err)
+	}
+
+	ours, theirs, _, err := VerifyLocks(repoPath, "dee", "", 0)
+	if err != nil || len(ours) != 1 || len(theirs) != 3 {
+		t.Errorf("Expected 1 lock of dee and 3 of others, got %+v, %+v, %v", ours, theirs, err)
+	}
+
+	// Locks of other users are only removed with force
+	if _, err := Unlock(repoPath, lock.ID, "dee", false); !errors.Is(err, ErrLockNotOwned) {
+		t.Errorf("Expected ErrLockNotOwned, got %v", err)
+	}
+	if _, err := Unlock(repoPath, lock.ID, "dee", true); err != nil {
+		t.Errorf("Unlock with force failed: %v", err)
+	}
+	if _, err := Unlock(repoPath, lock.ID, "cid", false); !errors.Is(err, ErrLockNotFound) {
+		t.Errorf("Expected ErrLockNotFound, got %v", err)
+	}
+
+	// Locks follow a renamed repository
+	newPath := filepath.Join("repos", "cid", "renamed.git")
+	if err := git.MoveRepo(repoPath, newPath); err != nil {
+		t.Fatalf("MoveRepo failed: %v", err)
+	}
+	if locks, _, err := ListLocks(newPath, LockFilter{}); err != nil || len(locks) != 3 {
+		t.Errorf("Expected 3 locks after the rename, got %+v, %v", locks, err)
+	}
+}
@@ -0,0 +1,149 @@
+// Package lfs stores the Git LFS objects and file locks of repositories.
+//
+// Objects are kept once in a content-addressed store on disk, at
+// lfs/objects/{oid[0:2]}/{oid[2:4]}/{oid} next to the repos directory. A
+// repository may only read the objects that were uploaded to it or that it
+// inherited as a fork, so knowing an OID is not enough to download an object.
+package lfs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"librebucket/cmd/db"
+	"librebucket/cmd/git"
+)
+
+const (
+	storeDir   = "lfs"
+	objectsDir = "objects"
+	tmpDir     = "tmp"
+)
+
+var (
+	// ErrInvalidOID is returned for object IDs that are not a SHA-256 in hex
+	ErrInvalidOID = errors.New("invalid LFS object ID")
+	// ErrObjectNotFound is returned when a repository has no object with an OID
+	ErrObjectNotFound = db.ErrLFSObjectNotFound
+	// ErrObjectMismatch is returned when uploaded content does not match its OID or size
+	ErrObjectMismatch = errors.New("LFS object does not match its OID and size")
+	// ErrObjectTooLarge is returned for objects larger than MaxObjectSize
+	ErrObjectTooLarge = errors.New("LFS object is too large")
+)
+
+// MaxObjectSize is the largest object in bytes that can be uploaded
+var MaxObjectSize int64 = 5 << 30
+
+// ValidOID reports whether oid is a lowercase hex SHA-256
+func ValidOID(oid string) bool {
+	if len(oid) != sha256.Size*2 {
+		return false
+	}
+	for _, c := range oid {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// objectPath returns the path of an object in the store
+func objectPath(oid string) string {
+	return filepath.Join(storeDir, objectsDir, oid[0:2], oid[2:4], oid)
+}
+
+// HasObject reports whether a repository has the object with oid and size, so
+// it does not have to be uploaded again
+func HasObject(repoPath, oid string, size int64) (bool, error) {
+	if !ValidOID(oid) {
+		return false, fmt.Errorf("%w: %s", ErrInvalidOID, oid)
+	}
+	stored, err := db.GetLFSObjectSize(git.RepoFullName(repoPath), oid)
+	if errors.Is(err, db.ErrLFSObjectNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to look up LFS object: %w", err)
+	}
+	if stored != size {
+		return false, nil
+	}
+	// An object lost from the store can be uploaded again
+	if _, err := os.Stat(objectPath(oid)); err != nil {
+		return false, nil
+	}
+	return true, nil
+}
+
+// Open opens an object of a repository for reading and returns its size
+func Open(repoPath, oid string) (*os.File, int64, error) {
+	if !ValidOID(oid) {
+		return nil, 0, fmt.Errorf("%w: %s", ErrInvalidOID, oid)
+	}
+	size, err := db.GetLFSObjectSize(git.RepoFullName(repoPath), oid)
+	if err != nil {
+		return nil, 0, err
+	}
+	f, err := os.Open(objectPath(oid))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, 0, fmt.Errorf("%w: %s", ErrObjectNotFound, oid)
+	} else if err != nil {
+		return nil, 0, fmt.Errorf("failed to open LFS object: %w", err)
+	}
+	return f, size, nil
+}
+
+// Put stores an object of a repository read from r. The content must have
+// size bytes and hash to oid, otherwise nothing is stored and
+// ErrObjectMismatch is returned. Objects larger than MaxObjectSize are refused
+// with ErrObjectTooLarge before anything is read.
+func Put(repoPath, oid string, size int64, r io.Reader) error {
+	if !ValidOID(oid) {
+		return fmt.Errorf("%w: %s", ErrInvalidOID, oid)
+	}
+	if size > MaxObjectSize {
+		return fmt.Errorf("%w: %d bytes, at most %d are allowed", ErrObjectTooLarge, size, MaxObjectSize)
+	}
+	if err := os.MkdirAll(filepath.Join(storeDir, tmpDir), 0755); err != nil {
+		return fmt.Errorf("failed to create LFS store: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Join(storeDir, tmpDir), oid+"-")
+	if err != nil {
+		return fmt.Errorf("failed to create LFS object: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	// One byte more than expected is enough to notice a larger upload
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, h), io.LimitReader(r, size+1))
+	if err != nil {
+		return fmt.Errorf("failed to write LFS object: %w", err)
+	}
+	if n != size || hex.EncodeToString(h.Sum(nil)) != oid {
+		return fmt.Errorf("%w: %s", ErrObjectMismatch, oid)
+	}
+	if err := tmp.Sync(); err != nil {
+		return fmt.Errorf("failed to write LFS object: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write LFS object: %w", err)
+	}
+
+	// Objects are immutable, so replacing one uploaded concurrently is harmless
+	path := objectPath(oid)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create LFS store: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to store LFS object: %w", err)
+	}
+	if err := db.AddLFSObject(git.RepoFullName(repoPath), oid, size); err != nil {
+		return fmt.Errorf("failed to save LFS object: %w", err)
+	}
+	return nil
+}
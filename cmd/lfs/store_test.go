@@ -0,0 +1,119 @@
+package lfs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"librebucket/cmd/git"
+)
+
+func TestPut(t *testing.T) {
+	t.Chdir(t.TempDir())
+	repoPath := filepath.Join("repos", "ada", "assets.git")
+	if err := git.CreateRepo(repoPath, "ada", false); err != nil {
+		t.Fatalf("CreateRepo failed: %v", err)
+	}
+	content := "binary asset"
+	sum := sha256.Sum256([]byte(content))
+	oid := hex.EncodeToString(sum[:])
+	size := int64(len(content))
+
+	if exists, err := HasObject(repoPath, oid, size); err != nil || exists {
+		t.Errorf("Expected no object before the upload, got %v, %v", exists, err)
+	}
+	if _, err := HasObject(repoPath, "../../etc/passwd", 1); !errors.Is(err, ErrInvalidOID) {
+		t.Errorf("Expected ErrInvalidOID, got %v", err)
+	}
+
+	// Content that does not match the OID or size is rejected
+	if err := Put(repoPath, oid, size, strings.NewReader("other content")); !errors.Is(err, ErrObjectMismatch) {
+		t.Errorf("Expected ErrObjectMismatch for other content, got %v", err)
+	}
+	if err := Put(repoPath, oid, size-1, strings.NewReader(content)); !errors.Is(err, ErrObjectMismatch) {
+		t.Errorf("Expected ErrObjectMismatch for a wrong size, got %v", err)
+	}
+	if exists, _ := HasObject(repoPath, oid, size); exists {
+		t.Error("Expected a rejected upload not to be stored")
+	}
+
+	if err := Put(repoPath, oid, size, strings.NewReader(content)); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if exists, err := HasObject(repoPath, oid, size); err != nil || !exists {
+		t.Errorf("Expected the object after the upload, got %v, %v", exists, err)
+	}
+	f, gotSize, err := Open(repoPath, oid)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	got, _ := io.ReadAll(f)
+	f.Close()
+	if string(got) != content || gotSize != size {
+		t.Errorf("Expected %q of %d bytes, got %q of %d", content, size, got, gotSize)
+	}
+
+	// LFS objects count toward the size of the repository
+	meta, err := git.LoadRepoMeta(repoPath)
+	if err != nil || meta.LFSSize != size {
+		t.Errorf("Expected LFS size %d, got %d, %v", size, meta.LFSSize, err)
+	}
+
+	// Other repositories cannot read the object without uploading it
+	otherPath := filepath.Join("repos", "ada", "other.git")
+	if err := git.CreateRepo(otherPath, "ada", false); err != nil {
+		t.Fatalf("CreateRepo failed: %v", err)
+	}
+	if _, _, err := Open(otherPath, oid); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("Expected ErrObjectNotFound for another repository, got %v", err)
+	}
+
+	// Forks inherit the objects of their parent
+	forkPath := filepath.Join("repos", "bea", "assets.git")
+	if err := git.ForkRepo(repoPath, forkPath, "bea"); err != nil {
+		t.Fatalf("ForkRepo failed: %v", err)
+	}
+	if exists, err := HasObject(forkPath, oid, size); err != nil || !exists {
+		t.Errorf("Expected the fork to have the object, got %v, %v", exists, err)
+	}
+}
+
+func TestPutTooLarge(t *testing.T) {
+	t.Chdir(t.TempDir())
+	repoPath := filepath.Join("repos", "dot", "assets.git")
+	if err := git.CreateRepo(repoPath, "dot", false); err != nil {
+		t.Fatalf("CreateRepo failed: %v", err)
+	}
+	defer func(max int64) { MaxObjectSize = max }(MaxObjectSize)
+	MaxObjectSize = 4
+
+	content := "too large"
+	sum := sha256.Sum256([]byte(content))
+	oid := hex.EncodeToString(sum[:])
+	if err := Put(repoPath, oid, int64(len(content)), strings.NewReader(content)); !errors.Is(err, ErrObjectTooLarge) {
+		t.Errorf("Expected ErrObjectTooLarge, got %v", err)
+	}
+	if exists, _ := HasObject(repoPath, oid, int64(len(content))); exists {
+		t.Error("Expected an object larger than MaxObjectSize not to be stored")
+	}
+}
+
+func TestValidOID(t *testing.T) {
+	valid := strings.Repeat("ab", 32)
+	for oid, want := range map[string]bool{
+		valid:                  true,
+		strings.ToUpper(valid): false,
+		valid[:63]:             false,
+		valid[:62] + "zz":      false,
+		"../" + valid[3:]:      false,
+		"":                     false,
+	} {
+		if got := ValidOID(oid); got != want {
+			t.Errorf("ValidOID(%q) = %v, want %v", oid, got, want)
+		}
+	}
+}
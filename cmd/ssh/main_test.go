@@ -0,0 +1,25 @@
+package ssh
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"librebucket/cmd/db"
+)
+
+// TestMain sets up a temporary database for users, their keys and repositories
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "librebucket-ssh-test")
+	if err != nil {
+		log.Fatalf("Failed to create temp dir: %v", err)
+	}
+	if err := db.InitDB(filepath.Join(dir, "test.db")); err != nil {
+		log.Fatalf("Failed to initialize test DB: %v", err)
+	}
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
@@ -0,0 +1,353 @@
+// Package ssh serves git-upload-pack and git-receive-pack over SSH. Users
+// authenticate with the public keys they added to their account, whatever
+// user name they connect as, and are authorized like smart HTTP requests.
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+
+	api "librebucket/cmd/api/v1"
+	"librebucket/cmd/db"
+	"librebucket/cmd/git"
+	"librebucket/cmd/worker"
+)
+
+const (
+	// handshakeTimeout bounds the key exchange and authentication of a connection
+	handshakeTimeout = 30 * time.Second
+
+	// Permissions extensions set by the public key callback
+	userExtension  = "librebucket-user"
+	keyIDExtension = "librebucket-key-id"
+)
+
+// errUnknownKey is returned to clients offering a key no user added
+var errUnknownKey = errors.New("unknown public key")
+
+// Server is an SSH server for git
+type Server struct {
+	// AfterPush is called after a push updated refs, e.g. to publish the
+	// event to the jobs that follow a push
+	AfterPush func(worker.PushEvent)
+
+	config *gossh.ServerConfig
+}
+
+// NewServer creates a server with the host key at hostKeyPath, which is
+// generated on first use
+func NewServer(hostKeyPath string) (*Server, error) {
+	signer, err := LoadHostKey(hostKeyPath)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{}
+	s.config = &gossh.ServerConfig{
+		PublicKeyCallback: publicKeyCallback,
+		ServerVersion:     "SSH-2.0-LibreBucket",
+	}
+	s.config.AddHostKey(signer)
+	return s, nil
+}
+
+// LoadHostKey reads a PEM encoded private host key, or generates an Ed25519
+// key and saves it if the file does not exist
+func LoadHostKey(path string) (gossh.Signer, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate SSH host key: %w", err)
+		}
+		block, err := gossh.MarshalPrivateKey(priv, "")
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode SSH host key: %w", err)
+		}
+		data = pem.EncodeToMemory(block)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, fmt.Errorf("failed to save SSH host key: %w", err)
+		}
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			return nil, fmt.Errorf("failed to save SSH host key: %w", err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read SSH host key: %w", err)
+	}
+	signer, err := gossh.ParsePrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse SSH host key: %w", err)
+	}
+	return signer, nil
+}
+
+// publicKeyCallback authenticates a connection as the user who added the key
+func publicKeyCallback(_ gossh.ConnMetadata, key gossh.PublicKey) (*gossh.Permissions, error) {
+	k, err := db.GetSSHKeyByFingerprint(gossh.FingerprintSHA256(key))
+	if err != nil {
+		return nil, errUnknownKey
+	}
+	if k.PublicKey != strings.TrimSpace(string(gossh.MarshalAuthorizedKey(key))) {
+		return nil, errUnknownKey
+	}
+	return &gossh.Permissions{Extensions: map[string]string{
+		userExtension:  k.Username,
+		keyIDExtension: strconv.FormatInt(k.ID, 10),
+	}}, nil
+}
+
+// ListenAndServe listens on the TCP address addr and serves connections
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections on l until it is closed
+func (s *Server) Serve(l net.Listener) error {
+	defer l.Close()
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.handleConn(nc)
+	}
+}
+
+// handleConn authenticates a connection and serves its sessions
+func (s *Server) handleConn(nc net.Conn) {
+	defer nc.Close()
+	nc.SetDeadline(time.Now().Add(handshakeTimeout))
+	conn, chans, reqs, err := gossh.NewServerConn(nc, s.config)
+	if err != nil {
+		// Scanners and unknown keys, nothing worth logging
+		return
+	}
+	defer conn.Close()
+	nc.SetDeadline(time.Time{})
+	go gossh.DiscardRequests(reqs)
+
+	username := conn.Permissions.Extensions[userExtension]
+	if id, err := strconv.ParseInt(conn.Permissions.Extensions[keyIDExtension], 10, 64); err == nil {
+		if err := db.TouchSSHKey(id); err != nil {
+			log.Printf("Failed to update last use of SSH key %d: %v", id, err)
+		}
+	}
+
+	for nch := range chans {
+		if nch.ChannelType() != "session" {
+			nch.Reject(gossh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		ch, chReqs, err := nch.Accept()
+		if err != nil {
+			continue
+		}
+		go s.handleSession(username, ch, chReqs)
+	}
+}
+
+// handleSession runs the git command of a session, which is the only thing a
+// session can do
+func (s *Server) handleSession(username string, ch gossh.Channel, reqs <-chan *gossh.Request) {
+	defer ch.Close()
+	protocol := ""
+	for req := range reqs {
+		switch req.Type {
+		case "env":
+			// Only GIT_PROTOCOL is passed on to git, e.g. to speak protocol v2
+			var payload struct{ Name, Value string }
+			ok := gossh.Unmarshal(req.Payload, &payload) == nil && payload.Name == "GIT_PROTOCOL"
+			if ok {
+				_, ok = git.ParseProtocol(payload.Value)
+			}
+			if ok {
+				protocol = payload.Value
+			}
+			if req.WantReply {
+				req.Reply(ok, nil)
+			}
+		case "exec":
+			var payload struct{ Command string }
+			if err := gossh.Unmarshal(req.Payload, &payload); err != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			req.Reply(true, nil)
+			sendExitStatus(ch, s.runCommand(username, payload.Command, protocol, ch))
+			return
+		case "shell":
+			req.Reply(true, nil)
+			fmt.Fprintf(ch.Stderr(), "Hi %s! You've successfully authenticated, but LibreBucket does not provide shell access.\n", username)
+			sendExitStatus(ch, 1)
+			return
+		default:
+			// Terminals and forwarding are not supported
+			if req.WantReply {
+				req.Reply(false, nil)
+			}
+		}
+	}
+}
+
+// sendExitStatus tells the client the exit code of its command
+func sendExitStatus(ch gossh.Channel, code uint32) {
+	ch.SendRequest("exit-status", false, gossh.Marshal(struct{ Status uint32 }{code}))
+}
+
+// runCommand authorizes and runs a git command with the GIT_PROTOCOL asked for
+// by the client, if any, and returns its exit code
+func (s *Server) runCommand(username, command, protocol string, ch gossh.Channel) uint32 {
+	stderr := ch.Stderr()
+	service, owner, repoName, err := parseCommand(command)
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return 1
+	}
+	action := "pull"
+	if service == "receive-pack" {
+		action = "push"
+	}
+
+	repoPath := filepath.Join("repos", owner, repoName+".git")
+	if _, err := os.Stat(repoPath); os.IsNotExist(err) {
+		// Old names of moved repositories keep working, there is no redirect in SSH
+		newPath, ok := git.ResolveRepoRedirect(repoPath)
+		if !ok || !api.CheckUserRepoAuth(username, newPath, "pull") {
+			fmt.Fprintln(stderr, "Repository not found")
+			return 1
+		}
+		fmt.Fprintf(stderr, "Repository moved to %s, please update your remote\n", git.RepoFullName(newPath))
+		repoPath = newPath
+	}
+
+	if !api.CheckUserRepoAuth(username, repoPath, action) {
+		// Private repositories of others are indistinguishable from missing ones
+		if !api.CheckUserRepoAuth(username, repoPath, "pull") {
+			fmt.Fprintln(stderr, "Repository not found")
+		} else {
+			fmt.Fprintln(stderr, "Permission denied: only the repository owner can push")
+		}
+		return 1
+	}
+	if action == "push" {
+		if meta, err := git.LoadRepoMeta(repoPath); err == nil && meta.MirrorURL != "" {
+			fmt.Fprintln(stderr, "Repository is a mirror and cannot be pushed to")
+			return 1
+		}
+	}
+
+	// Pushes are checked against the push policy of the repository
+	cmd := exec.Command("git", service, "--", filepath.Base(repoPath))
+	cmd.Dir = filepath.Dir(repoPath)
+	var receivePack *git.ReceivePack
+	if action == "push" {
+		if receivePack, err = git.ReceivePackCommand(repoPath); err != nil {
+			log.Printf("Failed to prepare receive-pack for %s: %v", repoPath, err)
+			fmt.Fprintln(stderr, "Internal server error")
+			return 1
+		}
+		defer receivePack.Close()
+		cmd = receivePack.Cmd
+	}
+	if protocol != "" {
+		if cmd.Env == nil {
+			cmd.Env = os.Environ()
+		}
+		cmd.Env = append(cmd.Env, "GIT_PROTOCOL="+protocol)
+	}
+	cmd.Stdout = ch
+	cmd.Stderr = stderr
+	// Copy stdin by hand, so waiting for git does not wait for the client to
+	// close its side of the channel
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		fmt.Fprintln(stderr, "Internal server error")
+		return 1
+	}
+	if err := cmd.Start(); err != nil {
+		log.Printf("Failed to start git %s: %v", service, err)
+		fmt.Fprintln(stderr, "Internal server error")
+		return 1
+	}
+	go func() {
+		defer stdin.Close()
+		io.Copy(stdin, ch)
+	}()
+
+	if err := cmd.Wait(); err != nil {
+		log.Printf("Git command (%s) over SSH exited with error: %v", service, err)
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() > 0 {
+			return uint32(exitErr.ExitCode())
+		}
+		return 1
+	}
+
+	if receivePack != nil && s.AfterPush != nil {
+		updates, err := receivePack.RefUpdates()
+		if err != nil {
+			log.Printf("Failed to read ref updates of a push to %s: %v", repoPath, err)
+		} else if len(updates) > 0 {
+			s.AfterPush(worker.PushEvent{RepoPath: repoPath, Pusher: username, Updates: updates, PushedAt: time.Now()})
+		}
+	}
+	return 0
+}
+
+// parseCommand parses a command sent by git, e.g. git-upload-pack
+// '/owner/repo.git', and returns the git service and the repository
+func parseCommand(command string) (service, owner, repoName string, err error) {
+	name, arg, ok := strings.Cut(strings.TrimSpace(command), " ")
+	switch {
+	case !ok:
+	case name == "git-upload-pack" || name == "git-receive-pack":
+		service = strings.TrimPrefix(name, "git-")
+	case name == "git":
+		// git upload-pack, as sent by some clients
+		name, arg, ok = strings.Cut(strings.TrimSpace(arg), " ")
+		if ok && (name == "upload-pack" || name == "receive-pack") {
+			service = name
+		}
+	}
+	if service == "" {
+		return "", "", "", fmt.Errorf("unsupported command %q, only git-upload-pack and git-receive-pack are allowed", command)
+	}
+
+	arg = strings.TrimSpace(arg)
+	if len(arg) >= 2 && (arg[0] == '\'' || arg[0] == '"') && arg[len(arg)-1] == arg[0] {
+		arg = arg[1 : len(arg)-1]
+	}
+	arg = strings.TrimPrefix(strings.TrimPrefix(arg, "~"), "/")
+	owner, repoName, ok = strings.Cut(strings.TrimSuffix(arg, "/"), "/")
+	repoName = strings.TrimSuffix(repoName, ".git")
+	if !ok || !isSafeComponent(owner) || !isSafeComponent(repoName) {
+		return "", "", "", fmt.Errorf("invalid repository path %q, expected owner/repo.git", arg)
+	}
+	return service, owner, repoName, nil
+}
+
+// isSafeComponent reports whether s is a non-empty path component that cannot
+// leave the repos directory
+func isSafeComponent(s string) bool {
+	return s != "" &&
+		!strings.Contains(s, "/") &&
+		!strings.Contains(s, "\\") &&
+		!strings.Contains(s, "..")
+}
@@ -0,0 +1,186 @@
+package ssh
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	gossh "golang.org/x/crypto/ssh"
+
+	"librebucket/cmd/db"
+	"librebucket/cmd/git"
+)
+
+// startServer serves SSH on a random local port and returns its address
+func startServer(t *testing.T) string {
+	t.Helper()
+	s, err := NewServer(filepath.Join("config", "ssh_host_ed25519_key"))
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go s.Serve(l)
+	return l.Addr().String()
+}
+
+// newUserKey adds a new SSH key to a user, created if needed, and returns the key
+func newUserKey(t *testing.T, username string) (db.User, gossh.Signer) {
+	t.Helper()
+	user, err := db.GetUserByUsername(username)
+	if err != nil {
+		if user, err = db.CreateUser(username, "password", false, username+"-token"); err != nil {
+			t.Fatalf("CreateUser failed: %v", err)
+		}
+	}
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	signer, err := gossh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatalf("NewSignerFromKey failed: %v", err)
+	}
+	k := db.SSHKey{
+		UserID:      user.ID,
+		Name:        "laptop",
+		Fingerprint: gossh.FingerprintSHA256(signer.PublicKey()),
+		PublicKey:   strings.TrimSpace(string(gossh.MarshalAuthorizedKey(signer.PublicKey()))),
+	}
+	if err := db.CreateSSHKey(&k); err != nil {
+		t.Fatalf("CreateSSHKey failed: %v", err)
+	}
+	return user, signer
+}
+
+// run runs command over SSH with environment variables given as name, value
+// pairs and returns its output and exit code
+func run(t *testing.T, addr string, key gossh.Signer, command string, env ...string) (stdout, stderr string, code int) {
+	t.Helper()
+	client, err := gossh.Dial("tcp", addr, &gossh.ClientConfig{
+		User:            "git",
+		Auth:            []gossh.AuthMethod{gossh.PublicKeys(key)},
+		HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer client.Close()
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatalf("NewSession failed: %v", err)
+	}
+	defer session.Close()
+	for i := 0; i+1 < len(env); i += 2 {
+		if err := session.Setenv(env[i], env[i+1]); err != nil {
+			t.Fatalf("Setenv failed: %v", err)
+		}
+	}
+
+	var out, errOut bytes.Buffer
+	session.Stdout = &out
+	session.Stderr = &errOut
+	// A flush packet ends the negotiation right after the ref advertisement
+	session.Stdin = strings.NewReader("0000")
+	err = session.Run(command)
+	var exitErr *gossh.ExitError
+	if errors.As(err, &exitErr) {
+		code = exitErr.ExitStatus()
+	} else if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	return out.String(), errOut.String(), code
+}
+
+func TestServer(t *testing.T) {
+	t.Chdir(t.TempDir())
+	addr := startServer(t)
+	owner, ownerKey := newUserKey(t, "eve")
+	_, otherKey := newUserKey(t, "fay")
+	secretPath := filepath.Join("repos", "eve", "secret.git")
+	if err := git.CreateRepoWithOptions(secretPath, "eve", false, git.InitOptions{AutoInit: true}); err != nil {
+		t.Fatalf("CreateRepo failed: %v", err)
+	}
+	if err := git.CreateRepo(filepath.Join("repos", "eve", "open.git"), "eve", true); err != nil {
+		t.Fatalf("CreateRepo failed: %v", err)
+	}
+
+	// The owner can fetch from and push to a private repository
+	for _, command := range []string{"git-upload-pack '/eve/secret.git'", "git-receive-pack 'eve/secret'"} {
+		out, errOut, code := run(t, addr, ownerKey, command)
+		if code != 0 || !strings.Contains(out, "refs/heads/main") {
+			t.Errorf("Expected a ref advertisement for %s, got %q, %q, %d", command, out, errOut, code)
+		}
+	}
+
+	// Protocol v2 clients get the capability advertisement instead of the refs
+	if out, errOut, code := run(t, addr, ownerKey, "git-upload-pack 'eve/secret'", "GIT_PROTOCOL", "version=2"); code != 0 ||
+		!strings.Contains(out, "version 2") || !strings.Contains(out, "ls-refs") || strings.Contains(out, "refs/heads/main") {
+		t.Errorf("Expected a v2 capability advertisement, got %q, %q, %d", out, errOut, code)
+	}
+
+	// Others cannot see a private repository nor push to a public one
+	if _, errOut, code := run(t, addr, otherKey, "git-upload-pack '/eve/secret.git'"); code == 0 || !strings.Contains(errOut, "not found") {
+		t.Errorf("Expected the private repository to be hidden, got %q, %d", errOut, code)
+	}
+	if out, _, code := run(t, addr, otherKey, "git-upload-pack '/eve/open.git'"); code != 0 || out == "" {
+		t.Errorf("Expected to fetch the public repository, got %q, %d", out, code)
+	}
+	if _, errOut, code := run(t, addr, otherKey, "git-receive-pack '/eve/open.git'"); code == 0 || !strings.Contains(errOut, "Permission denied") {
+		t.Errorf("Expected the push to be denied, got %q, %d", errOut, code)
+	}
+
+	// Only git commands are allowed
+	if _, errOut, code := run(t, addr, ownerKey, "rm -rf /"); code == 0 || !strings.Contains(errOut, "unsupported command") {
+		t.Errorf("Expected an unsupported command, got %q, %d", errOut, code)
+	}
+
+	// Keys no user added are rejected during authentication
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	unknown, _ := gossh.NewSignerFromKey(priv)
+	_, err := gossh.Dial("tcp", addr, &gossh.ClientConfig{
+		User:            "git",
+		Auth:            []gossh.AuthMethod{gossh.PublicKeys(unknown)},
+		HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+	})
+	if err == nil {
+		t.Error("Expected an unknown key to be rejected")
+	}
+
+	// Using a key records when it was last used
+	k, err := db.GetSSHKeyByFingerprint(gossh.FingerprintSHA256(ownerKey.PublicKey()))
+	if err != nil || k.Username != owner.Username || k.LastUsedAt.IsZero() {
+		t.Errorf("Expected the key of %s to have been used, got %+v, %v", owner.Username, k, err)
+	}
+}
+
+func TestParseCommand(t *testing.T) {
+	for command, want := range map[string]string{
+		"git-upload-pack '/ann/repo.git'":  "upload-pack ann repo",
+		"git-receive-pack 'ann/repo'":      "receive-pack ann repo",
+		"git upload-pack '~/ann/repo.git'": "upload-pack ann repo",
+		"git-upload-archive 'ann/repo'":    "",
+		"git-upload-pack '../ann/repo'":    "",
+		"git-upload-pack 'ann/repo/x'":     "",
+		"git-upload-pack 'ann'":            "",
+		"git-upload-pack":                  "",
+		"sh -c 'git-upload-pack ann/repo'": "",
+	} {
+		service, owner, repoName, err := parseCommand(command)
+		got := ""
+		if err == nil {
+			got = service + " " + owner + " " + repoName
+		}
+		if got != want {
+			t.Errorf("parseCommand(%q) = %q, %v, want %q", command, got, err, want)
+		}
+	}
+}
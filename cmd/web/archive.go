@@ -0,0 +1,78 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"librebucket/cmd/git"
+)
+
+// archiveContentTypes maps archive formats to their MIME type
+var archiveContentTypes = map[string]string{
+	git.ArchiveTarGz: "application/gzip",
+	git.ArchiveTar:   "application/x-tar",
+	git.ArchiveZip:   "application/zip",
+}
+
+// handleArchive streams a tar.gz, tar or zip archive of a repository at a ref.
+//
+// The archive name is "{ref}.{format}" and all entries are placed in a "{repo}-{ref}/"
+// directory. Responds with 401 if the repository is private and the user may not pull it,
+// 404 if the repository, ref or format does not exist.
+func handleArchive(w http.ResponseWriter, r *http.Request) {
+	username := chi.URLParam(r, "username")
+	repoName := strings.TrimSuffix(chi.URLParam(r, "repoName"), ".git")
+
+	if !isSafeComponent(username) || !isSafeComponent(repoName) {
+		http.Error(w, "Invalid repo path", http.StatusBadRequest)
+		return
+	}
+	repoPath := filepath.Join("repos", username, repoName+".git")
+
+	if _, err := os.Stat(repoPath); os.IsNotExist(err) {
+		if !redirectMovedRepo(w, r, username, repoName) {
+			http.NotFound(w, r)
+		}
+		return
+	}
+
+	if !checkRepoAuth(r, repoPath, "pull", username) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="LibreBucket"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	ref, format, err := git.SplitArchiveName(chi.URLParam(r, "*"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Resolve the ref before writing any headers, so errors get a proper status
+	hash, err := git.ResolveRevision(repoPath, ref)
+	if err != nil {
+		status := http.StatusNotFound
+		if errors.Is(err, git.ErrInvalidRevision) || errors.Is(err, git.ErrAmbiguousRevision) {
+			status = http.StatusBadRequest
+		}
+		http.Error(w, "Failed to resolve ref: "+err.Error(), status)
+		return
+	}
+
+	prefix := git.ArchivePrefix(repoName, ref)
+	w.Header().Set("Content-Type", archiveContentTypes[format])
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", strings.TrimSuffix(prefix, "/")+"."+format))
+	w.Header().Set("ETag", fmt.Sprintf("%q", hash+"."+format))
+
+	if err := git.WriteArchive(w, repoPath, hash, format, prefix); err != nil {
+		// The response is already streaming, so the client sees a truncated archive
+		log.Printf("Failed to write archive of %s at %s: %v", repoPath, ref, err)
+	}
+}
@@ -0,0 +1,440 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	api "librebucket/cmd/api/v1"
+	"librebucket/cmd/db"
+	"librebucket/cmd/git"
+	"librebucket/cmd/lfs"
+)
+
+// lfsMediaType is the content type of all LFS API requests and responses
+const lfsMediaType = "application/vnd.git-lfs+json"
+
+// maxLFSRequest bounds the JSON bodies of LFS API requests
+const maxLFSRequest = 10 << 20
+
+// lfsRoutes registers the Git LFS batch, basic transfer and locking APIs below
+// /{username}/{repoName}.git/info/lfs. Downloads need pull access, uploads and
+// locks push access, like git-upload-pack and git-receive-pack.
+func lfsRoutes(r chi.Router) {
+	r.Post("/objects/batch", handleLFSBatch)
+	r.Get("/objects/{oid}", handleLFSDownload)
+	r.Put("/objects/{oid}", handleLFSUpload)
+	r.Post("/objects/{oid}/verify", handleLFSVerify)
+
+	r.Get("/locks", handleLFSListLocks)
+	r.Post("/locks", handleLFSCreateLock)
+	r.Post("/locks/verify", handleLFSVerifyLocks)
+	r.Post("/locks/{id}/unlock", handleLFSUnlock)
+}
+
+// lfsObject is an object in a batch request and response
+type lfsObject struct {
+	OID           string               `json:"oid"`
+	Size          int64                `json:"size"`
+	Authenticated bool                 `json:"authenticated,omitempty"`
+	Actions       map[string]lfsAction `json:"actions,omitempty"`
+	Error         *lfsObjectError      `json:"error,omitempty"`
+}
+
+// lfsAction tells the client where to transfer an object
+type lfsAction struct {
+	Href   string            `json:"href"`
+	Header map[string]string `json:"header,omitempty"`
+}
+
+type lfsObjectError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// lfsLock is the JSON representation of a db.LFSLock
+type lfsLock struct {
+	ID       string    `json:"id"`
+	Path     string    `json:"path"`
+	LockedAt time.Time `json:"locked_at"`
+	Owner    struct {
+		Name string `json:"name"`
+	} `json:"owner"`
+}
+
+func toLFSLock(l db.LFSLock) lfsLock {
+	lock := lfsLock{ID: strconv.FormatInt(l.ID, 10), Path: l.Path, LockedAt: l.CreatedAt}
+	lock.Owner.Name = l.Owner
+	return lock
+}
+
+func toLFSLocks(locks []db.LFSLock) []lfsLock {
+	resp := make([]lfsLock, len(locks))
+	for i, l := range locks {
+		resp[i] = toLFSLock(l)
+	}
+	return resp
+}
+
+// writeLFS writes an LFS API response
+func writeLFS(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", lfsMediaType)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeLFSError writes an LFS API error, asking for credentials on 401
+func writeLFSError(w http.ResponseWriter, status int, msg string) {
+	if status == http.StatusUnauthorized {
+		w.Header().Set("LFS-Authenticate", `Basic realm="LibreBucket"`)
+		w.Header().Set("WWW-Authenticate", `Basic realm="LibreBucket"`)
+	}
+	writeLFS(w, status, map[string]string{"message": msg})
+}
+
+// lfsRepo resolves the repository of an LFS request and checks that the caller
+// may pull or push it. It writes the error response and reports false otherwise.
+func lfsRepo(w http.ResponseWriter, r *http.Request, action string) (string, bool) {
+	username := chi.URLParam(r, "username")
+	repoName := strings.TrimSuffix(chi.URLParam(r, "repoName"), ".git")
+	if !isSafeComponent(username) || !isSafeComponent(repoName) {
+		writeLFSError(w, http.StatusBadRequest, "Invalid repo path")
+		return "", false
+	}
+	repoPath := filepath.Join("repos", username, repoName+".git")
+
+	if _, err := os.Stat(repoPath); os.IsNotExist(err) {
+		if !redirectMovedRepo(w, r, username, repoName) {
+			writeLFSError(w, http.StatusNotFound, "Repository not found")
+		}
+		return "", false
+	}
+	if !checkRepoAuth(r, repoPath, action, username) {
+		if _, ok := api.RequestUser(r); ok {
+			writeLFSError(w, http.StatusForbidden, "Only the repository owner can do this")
+		} else {
+			writeLFSError(w, http.StatusUnauthorized, "Authentication required")
+		}
+		return "", false
+	}
+	if action == "push" {
+		// Pull mirrors get their objects from their remote only
+		if meta, err := git.LoadRepoMeta(repoPath); err == nil && meta.MirrorURL != "" {
+			writeLFSError(w, http.StatusForbidden, "Repository is a mirror and cannot be pushed to")
+			return "", false
+		}
+	}
+	return repoPath, true
+}
+
+// lfsObjectsURL returns the absolute URL of the objects endpoint of a request
+func lfsObjectsURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	prefix, _, _ := strings.Cut(r.URL.Path, "/info/lfs/")
+	return fmt.Sprintf("%s://%s%s/info/lfs/objects", scheme, r.Host, prefix)
+}
+
+// lfsAuthHeader passes the credentials of the batch request on to the transfers
+func lfsAuthHeader(r *http.Request) map[string]string {
+	header := make(map[string]string)
+	for _, name := range []string{"Authorization", "X-Auth-Token"} {
+		if v := r.Header.Get(name); v != "" {
+			header[name] = v
+		}
+	}
+	return header
+}
+
+// handleLFSBatch answers which objects to download or upload and where
+func handleLFSBatch(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Operation string      `json:"operation"`
+		Transfers []string    `json:"transfers"`
+		Objects   []lfsObject `json:"objects"`
+		HashAlgo  string      `json:"hash_algo"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxLFSRequest)).Decode(&req); err != nil {
+		writeLFSError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	action := ""
+	switch req.Operation {
+	case "download":
+		action = "pull"
+	case "upload":
+		action = "push"
+	default:
+		writeLFSError(w, http.StatusUnprocessableEntity, "Operation must be download or upload")
+		return
+	}
+	repoPath, ok := lfsRepo(w, r, action)
+	if !ok {
+		return
+	}
+	if req.HashAlgo != "" && req.HashAlgo != "sha256" {
+		writeLFSError(w, http.StatusConflict, "Only sha256 is supported")
+		return
+	}
+
+	href := lfsObjectsURL(r)
+	header := lfsAuthHeader(r)
+	objects := make([]lfsObject, 0, len(req.Objects))
+	for _, o := range req.Objects {
+		obj := lfsObject{OID: o.OID, Size: o.Size}
+		if !lfs.ValidOID(o.OID) || o.Size < 0 {
+			obj.Error = &lfsObjectError{Code: http.StatusUnprocessableEntity, Message: "Invalid object ID or size"}
+			objects = append(objects, obj)
+			continue
+		}
+		if req.Operation == "upload" && o.Size > lfs.MaxObjectSize {
+			obj.Error = &lfsObjectError{
+				Code:    http.StatusUnprocessableEntity,
+				Message: fmt.Sprintf("Object is larger than %d bytes", lfs.MaxObjectSize),
+			}
+			objects = append(objects, obj)
+			continue
+		}
+
+		exists, err := lfs.HasObject(repoPath, o.OID, o.Size)
+		switch {
+		case err != nil:
+			log.Printf("LFS batch for %s: %v", repoPath, err)
+			obj.Error = &lfsObjectError{Code: http.StatusInternalServerError, Message: "Failed to look up object"}
+		case req.Operation == "download" && exists:
+			obj.Authenticated = true
+			obj.Actions = map[string]lfsAction{"download": {Href: href + "/" + o.OID, Header: header}}
+		case req.Operation == "download":
+			obj.Error = &lfsObjectError{Code: http.StatusNotFound, Message: "Object does not exist"}
+		case !exists:
+			// Objects the repository already has need no actions
+			obj.Authenticated = true
+			obj.Actions = map[string]lfsAction{
+				"upload": {Href: href + "/" + o.OID, Header: header},
+				"verify": {Href: href + "/" + o.OID + "/verify", Header: header},
+			}
+		}
+		objects = append(objects, obj)
+	}
+
+	writeLFS(w, http.StatusOK, map[string]any{
+		"transfer":  "basic",
+		"objects":   objects,
+		"hash_algo": "sha256",
+	})
+}
+
+// handleLFSDownload streams an object, supporting ranges to resume downloads
+func handleLFSDownload(w http.ResponseWriter, r *http.Request) {
+	repoPath, ok := lfsRepo(w, r, "pull")
+	if !ok {
+		return
+	}
+	f, _, err := lfs.Open(repoPath, chi.URLParam(r, "oid"))
+	if err != nil {
+		writeLFSError(w, lfsErrorStatus(err), err.Error())
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		writeLFSError(w, http.StatusInternalServerError, "Failed to read object")
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, "", info.ModTime(), f)
+}
+
+// handleLFSUpload stores an object. Its size is taken from Content-Length and
+// may not exceed lfs.MaxObjectSize.
+func handleLFSUpload(w http.ResponseWriter, r *http.Request) {
+	repoPath, ok := lfsRepo(w, r, "push")
+	if !ok {
+		return
+	}
+	if r.ContentLength < 0 {
+		writeLFSError(w, http.StatusLengthRequired, "Content-Length is required")
+		return
+	}
+	if r.ContentLength > lfs.MaxObjectSize {
+		writeLFSError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Object is larger than %d bytes", lfs.MaxObjectSize))
+		return
+	}
+	body := http.MaxBytesReader(w, r.Body, lfs.MaxObjectSize)
+	if err := lfs.Put(repoPath, chi.URLParam(r, "oid"), r.ContentLength, body); err != nil {
+		writeLFSError(w, lfsErrorStatus(err), err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleLFSVerify confirms that an uploaded object was stored
+func handleLFSVerify(w http.ResponseWriter, r *http.Request) {
+	repoPath, ok := lfsRepo(w, r, "push")
+	if !ok {
+		return
+	}
+	var req lfsObject
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxLFSRequest)).Decode(&req); err != nil {
+		writeLFSError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	oid := chi.URLParam(r, "oid")
+	if req.OID != "" && req.OID != oid {
+		writeLFSError(w, http.StatusUnprocessableEntity, "Object ID does not match the URL")
+		return
+	}
+	exists, err := lfs.HasObject(repoPath, oid, req.Size)
+	if err != nil {
+		writeLFSError(w, lfsErrorStatus(err), err.Error())
+		return
+	}
+	if !exists {
+		writeLFSError(w, http.StatusNotFound, "Object does not exist")
+		return
+	}
+	writeLFS(w, http.StatusOK, map[string]any{})
+}
+
+// handleLFSListLocks lists the locks of a repository, filtered by path or ID
+func handleLFSListLocks(w http.ResponseWriter, r *http.Request) {
+	repoPath, ok := lfsRepo(w, r, "pull")
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	f := lfs.LockFilter{Path: q.Get("path"), Cursor: q.Get("cursor")}
+	var err error
+	if id := q.Get("id"); id != "" {
+		if f.ID, err = strconv.ParseInt(id, 10, 64); err != nil {
+			writeLFSError(w, http.StatusBadRequest, "Invalid lock ID")
+			return
+		}
+	}
+	if limit := q.Get("limit"); limit != "" {
+		if f.Limit, err = strconv.Atoi(limit); err != nil {
+			writeLFSError(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+	}
+
+	locks, next, err := lfs.ListLocks(repoPath, f)
+	if err != nil {
+		writeLFSError(w, lfsErrorStatus(err), err.Error())
+		return
+	}
+	writeLFS(w, http.StatusOK, map[string]any{"locks": toLFSLocks(locks), "next_cursor": next})
+}
+
+// handleLFSCreateLock locks a path for the caller
+func handleLFSCreateLock(w http.ResponseWriter, r *http.Request) {
+	repoPath, ok := lfsRepo(w, r, "push")
+	if !ok {
+		return
+	}
+	user, _ := api.RequestUser(r)
+	var req struct {
+		Path string `json:"path"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxLFSRequest)).Decode(&req); err != nil || req.Path == "" {
+		writeLFSError(w, http.StatusBadRequest, "Invalid JSON or missing path")
+		return
+	}
+
+	l, err := lfs.CreateLock(repoPath, req.Path, user.Username)
+	if errors.Is(err, lfs.ErrLockExists) && l.ID != 0 {
+		writeLFS(w, http.StatusConflict, map[string]any{"lock": toLFSLock(l), "message": err.Error()})
+		return
+	} else if err != nil {
+		writeLFSError(w, lfsErrorStatus(err), err.Error())
+		return
+	}
+	writeLFS(w, http.StatusCreated, map[string]any{"lock": toLFSLock(l)})
+}
+
+// handleLFSVerifyLocks lists the locks of a repository split into the caller's
+// and other users' locks, which clients check before pushing
+func handleLFSVerifyLocks(w http.ResponseWriter, r *http.Request) {
+	repoPath, ok := lfsRepo(w, r, "push")
+	if !ok {
+		return
+	}
+	user, _ := api.RequestUser(r)
+	var req struct {
+		Cursor string `json:"cursor"`
+		Limit  int    `json:"limit"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxLFSRequest)).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeLFSError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	ours, theirs, next, err := lfs.VerifyLocks(repoPath, user.Username, req.Cursor, req.Limit)
+	if err != nil {
+		writeLFSError(w, lfsErrorStatus(err), err.Error())
+		return
+	}
+	writeLFS(w, http.StatusOK, map[string]any{"ours": toLFSLocks(ours), "theirs": toLFSLocks(theirs), "next_cursor": next})
+}
+
+// handleLFSUnlock removes a lock. Locks of other users need force.
+func handleLFSUnlock(w http.ResponseWriter, r *http.Request) {
+	repoPath, ok := lfsRepo(w, r, "push")
+	if !ok {
+		return
+	}
+	user, _ := api.RequestUser(r)
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeLFSError(w, http.StatusBadRequest, "Invalid lock ID")
+		return
+	}
+	var req struct {
+		Force bool `json:"force"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxLFSRequest)).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeLFSError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	l, err := lfs.Unlock(repoPath, id, user.Username, req.Force)
+	if err != nil {
+		writeLFSError(w, lfsErrorStatus(err), err.Error())
+		return
+	}
+	writeLFS(w, http.StatusOK, map[string]any{"lock": toLFSLock(l)})
+}
+
+// lfsErrorStatus maps lfs errors to HTTP status codes
+func lfsErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, lfs.ErrInvalidOID), errors.Is(err, lfs.ErrObjectMismatch),
+		errors.Is(err, lfs.ErrInvalidLockPath):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, lfs.ErrObjectTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, lfs.ErrInvalidCursor):
+		return http.StatusBadRequest
+	case errors.Is(err, lfs.ErrObjectNotFound), errors.Is(err, lfs.ErrLockNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, lfs.ErrLockExists):
+		return http.StatusConflict
+	case errors.Is(err, lfs.ErrLockNotOwned):
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
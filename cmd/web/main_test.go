@@ -0,0 +1,38 @@
+package web
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"librebucket/cmd/db"
+	"librebucket/cmd/git"
+)
+
+// TestMain sets up a temporary database for the repositories served over HTTP,
+// a secret key for the secrets of webhooks and installs the git hooks. The hooks run the test binary, which handles
+// them like main.
+func TestMain(m *testing.M) {
+	if len(os.Args) == 3 && os.Args[1] == "hook" {
+		os.Exit(git.RunHook(os.Args[2], os.Stdin, os.Stderr))
+	}
+
+	dir, err := os.MkdirTemp("", "librebucket-web-test")
+	if err != nil {
+		log.Fatalf("Failed to create temp dir: %v", err)
+	}
+	if err := db.InitDB(filepath.Join(dir, "test.db")); err != nil {
+		log.Fatalf("Failed to initialize test DB: %v", err)
+	}
+	if err := db.LoadSecretKey(filepath.Join(dir, "secret.key")); err != nil {
+		log.Fatalf("Failed to create test secret key: %v", err)
+	}
+	if err := git.InstallHooks(filepath.Join(dir, "hooks")); err != nil {
+		log.Fatalf("Failed to install git hooks: %v", err)
+	}
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
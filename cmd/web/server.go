@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"compress/gzip"
 	"crypto/rand"
-	"encoding/base64"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -15,6 +14,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -22,6 +22,9 @@ import (
 	api "librebucket/cmd/api/v1"
 	"librebucket/cmd/db"
 	"librebucket/cmd/git"
+	"librebucket/cmd/lfs"
+	"librebucket/cmd/ssh"
+	"librebucket/cmd/worker"
 
 	"gopkg.in/yaml.v3"
 )
@@ -36,11 +39,39 @@ func isAlpha(s string) bool {
 	return true
 }
 
+// jobs runs background work such as refreshing repository statistics after a push
+var jobs *worker.Pool
+
+// events passes push events to the jobs that follow a push
+var events *worker.EventBus
+
 // StartServer initializes and runs the LibreBucket web server, setting up API endpoints, static file serving, Git HTTP protocol handlers, and web UI routes. The server listens on the specified port and terminates with a fatal log message if it fails to start.
 func StartServer() {
 	port := flag.Int("port", 3000, "Port to listen on")
+	sshPort := flag.Int("ssh-port", 2222, "Port of the built-in SSH server, 0 to disable it")
+	sshHostKey := flag.String("ssh-host-key", filepath.Join("config", "data", "ssh_host_ed25519_key"), "SSH host key, generated if missing")
+	allowLocalHosts := flag.String("allow-local-hosts", "", "Comma-separated hosts and CIDR ranges on local networks that webhooks, mirrors and migrations may reach")
+	flag.Int64Var(&lfs.MaxObjectSize, "lfs-max-object-size", lfs.MaxObjectSize, "Largest Git LFS object in bytes that can be uploaded")
 	flag.Parse()
 
+	for _, host := range strings.Split(*allowLocalHosts, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			git.AllowedLocalHosts = append(git.AllowedLocalHosts, host)
+		}
+	}
+
+	jobs = worker.NewPool(4, 256)
+	api.SubmitJob = jobs.Submit
+	worker.ScheduleMirrors(jobs, time.Minute)
+	events = worker.NewEventBus()
+	worker.SubscribePushJobs(events, jobs)
+	worker.SubscribeWebhooks(events, jobs)
+	api.PublishRepoEvent = events.PublishRepo
+
+	if *sshPort != 0 {
+		startSSHServer(*sshPort, *sshHostKey)
+	}
+
 	r := chi.NewRouter()
 
 	// Middleware: Request logging
@@ -50,12 +81,32 @@ func StartServer() {
 	r.Post("/api/v1/users/register", api.UserRegisterHandler)
 	r.Post("/api/v1/users/login", api.UserLogInHandler)
 	// r.Post("/api/v1/users/{username}/apikeys", api.UserAPIKeyHandler)
+	r.Get("/api/v1/users/{username}/keys", api.ListSSHKeysHandler)
+	r.Post("/api/v1/users/{username}/keys", api.AddSSHKeyHandler)
+	r.Delete("/api/v1/users/{username}/keys/{id}", api.DeleteSSHKeyHandler)
+	r.Get("/api/v1/users/{username}/starred", api.UserStarredHandler)
+	r.Get("/api/v1/users/{username}/repos", api.UserReposHandler)
 	r.Post("/api/v1/git/create", api.APICreateRepoHandler)
-
-	// Commits API endpoints (mount ServeMux from api.CommitHandler)
-	commitMux := http.NewServeMux()
-	api.CommitHandler(commitMux)
-	r.Mount("/api/v1/repos", commitMux)
+	r.Get("/api/v1/templates/{kind}", api.TemplatesHandler)
+
+	// Repository API endpoints (mount ServeMux from the api handlers)
+	repoMux := http.NewServeMux()
+	api.CommitHandler(repoMux)
+	api.TreeHandler(repoMux)
+	api.RefsHandler(repoMux)
+	api.CompareHandler(repoMux)
+	api.BlameHandler(repoMux)
+	api.ForkHandler(repoMux)
+	api.StarHandler(repoMux)
+	api.SearchHandler(repoMux)
+	api.SettingsHandler(repoMux)
+	api.GenerateHandler(repoMux)
+	api.MirrorHandler(repoMux)
+	api.PushMirrorHandler(repoMux)
+	api.MigrateHandler(repoMux)
+	api.PushPolicyHandler(repoMux)
+	api.WebhookHandler(repoMux)
+	r.Mount("/api/v1/repos", api.RepoRedirects(repoMux))
 
 	// Serve static files from the cmd/web/static directory
 	fs := http.FileServer(http.Dir("cmd/web/static"))
@@ -67,20 +118,19 @@ func StartServer() {
 	r.Get("/login", loginHandler)
 
 	// Git HTTP services
-	r.Get("/{username}/{repoName}.git/info/refs", handleGitInfoRefs)
-	r.Head("/{username}/{repoName}.git/info/refs", handleGitInfoRefs)
-	r.Post("/{username}/{repoName}.git/git-upload-pack", handleGitService)
-	r.Post("/{username}/{repoName}.git/git-receive-pack", handleGitService)
+	gitRoutes(r)
 
-	r.Get("/{username}/{repoName}/info/refs", handleGitInfoRefs)
-	r.Head("/{username}/{repoName}/info/refs", handleGitInfoRefs)
-	r.Post("/{username}/{repoName}/git-upload-pack", handleGitService)
-	r.Post("/{username}/{repoName}/git-receive-pack", handleGitService)
+	// Git LFS batch, transfer and locking APIs
+	r.Route("/{username}/{repoName}.git/info/lfs", lfsRoutes)
+	r.Route("/{username}/{repoName}/info/lfs", lfsRoutes)
 
 	// Repository web UI pages
 	r.Get("/{username}/{repoName}", gitAndWebHandler)
 	r.Get("/{username}/{repoName}.git", gitAndWebHandler) // Handles paths with .git suffix
 
+	// Source archives, e.g. /{username}/{repoName}/archive/main.tar.gz
+	r.Get("/{username}/{repoName}/archive/*", handleArchive)
+
 	log.Printf("Starting server on :%d...", *port)
 	err := http.ListenAndServe(fmt.Sprintf(":%d", *port), r)
 	if err != nil {
@@ -88,6 +138,35 @@ func StartServer() {
 	}
 }
 
+// startSSHServer serves git over SSH on port in the background
+func startSSHServer(port int, hostKeyPath string) {
+	srv, err := ssh.NewServer(hostKeyPath)
+	if err != nil {
+		log.Fatalf("Failed to start SSH server: %v", err)
+	}
+	srv.AfterPush = events.PublishPush
+	go func() {
+		log.Printf("Starting SSH server on :%d...", port)
+		if err := srv.ListenAndServe(fmt.Sprintf(":%d", port)); err != nil {
+			log.Fatalf("SSH server failed: %v", err)
+		}
+	}()
+}
+
+// gitRoutes registers the Git smart HTTP endpoints, with and without the .git
+// suffix of clone URLs
+func gitRoutes(r chi.Router) {
+	r.Get("/{username}/{repoName}.git/info/refs", handleGitInfoRefs)
+	r.Head("/{username}/{repoName}.git/info/refs", handleGitInfoRefs)
+	r.Post("/{username}/{repoName}.git/git-upload-pack", handleGitService)
+	r.Post("/{username}/{repoName}.git/git-receive-pack", handleGitService)
+
+	r.Get("/{username}/{repoName}/info/refs", handleGitInfoRefs)
+	r.Head("/{username}/{repoName}/info/refs", handleGitInfoRefs)
+	r.Post("/{username}/{repoName}/git-upload-pack", handleGitService)
+	r.Post("/{username}/{repoName}/git-receive-pack", handleGitService)
+}
+
 func isSafeComponent(s string) bool {
 	return !strings.Contains(s, "/") &&
 		!strings.Contains(s, "\\") &&
@@ -113,15 +192,23 @@ func gitAndWebHandler(w http.ResponseWriter, r *http.Request) {
 
 	// If it's not a Git service request, check if the repository exists for web UI
 	if _, err := os.Stat(repoPath); os.IsNotExist(err) {
-		http.NotFound(w, r) // Repository not found, serve 404
+		if !redirectMovedRepo(w, r, username, repoName) {
+			http.NotFound(w, r) // Repository not found, serve 404
+		}
 		return
 	}
 
+	defaultBranch := "main"
+	if meta, err := git.LoadRepoMeta(repoPath); err == nil && meta.DefaultBranch != "" {
+		defaultBranch = meta.DefaultBranch
+	}
+
 	// Data to pass to the template
 	data := map[string]any{
-		"username": username,
-		"repoName": repoName,
-		"cloneUrl": fmt.Sprintf("http://%s/%s/%s.git", r.Host, username, repoName),
+		"username":      username,
+		"repoName":      repoName,
+		"cloneUrl":      fmt.Sprintf("http://%s/%s/%s.git", r.Host, username, repoName),
+		"defaultBranch": defaultBranch,
 	}
 
 	// Render the Go HTML template
@@ -146,9 +233,11 @@ func handleGitInfoRefs(w http.ResponseWriter, r *http.Request) {
 	}
 	repoPath := filepath.Join("repos", username, repoName+".git")
 
-	// Check if repository exists
+	// Check if repository exists, old names of moved repositories redirect
 	if _, err := os.Stat(repoPath); os.IsNotExist(err) {
-		http.NotFound(w, r)
+		if !redirectMovedRepo(w, r, username, repoName) {
+			http.NotFound(w, r)
+		}
 		return
 	}
 
@@ -181,6 +270,9 @@ func handleGitInfoRefs(w http.ResponseWriter, r *http.Request) {
 		// No body for 401 on Git info/refs
 		return
 	}
+	if action == "push" && rejectMirrorPush(w, repoPath) {
+		return
+	}
 
 	// Execute git command
 	// --stateless-rpc --advertise-refs is for smart HTTP protocol for info/refs
@@ -188,6 +280,7 @@ func handleGitInfoRefs(w http.ResponseWriter, r *http.Request) {
 	cmd := exec.Command("git", gitService, "--stateless-rpc", "--advertise-refs", "--", filepath.Base(repoPath))
 	// Change working directory to the parent of the repository path
 	cmd.Dir = filepath.Dir(repoPath)
+	version := setGitProtocol(cmd, r)
 
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
@@ -217,10 +310,14 @@ func handleGitInfoRefs(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Cache-Control", "no-cache")
 	w.WriteHeader(http.StatusOK)
 
-	// Write Git protocol service header and flush packet
-	serviceHeader := fmt.Sprintf("# service=%s\n", service)
-	w.Write(packetWrite(serviceHeader))
-	w.Write(packetWrite("")) // Flush packet
+	// Write Git protocol service header and flush packet. Protocol v2 starts
+	// with the capability advertisement instead, receive-pack does not speak it
+	// yet and falls back to v0.
+	if version < 2 || gitService != "upload-pack" {
+		serviceHeader := fmt.Sprintf("# service=%s\n", service)
+		w.Write(packetWrite(serviceHeader))
+		w.Write(packetWrite("")) // Flush packet
+	}
 
 	// Copy git command output to response
 	io.Copy(w, stdout)
@@ -247,9 +344,11 @@ func handleGitService(w http.ResponseWriter, r *http.Request) {
 	}
 	repoPath := filepath.Join("repos", username, repoName+".git")
 
-	// Check if repository exists
+	// Check if repository exists, old names of moved repositories redirect
 	if _, err := os.Stat(repoPath); os.IsNotExist(err) {
-		http.NotFound(w, r)
+		if !redirectMovedRepo(w, r, username, repoName) {
+			http.NotFound(w, r)
+		}
 		return
 	}
 
@@ -277,9 +376,27 @@ func handleGitService(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusUnauthorized)
 		return
 	}
+	if action == "push" && rejectMirrorPush(w, repoPath) {
+		return
+	}
 
-	cmd := exec.Command("git", gitServiceCmd, "--stateless-rpc", "--", filepath.Base(repoPath))
-	cmd.Dir = filepath.Dir(repoPath)
+	// Pushes are checked against the push policy of the repository
+	var cmd *exec.Cmd
+	var receivePack *git.ReceivePack
+	if action == "push" {
+		var err error
+		if receivePack, err = git.ReceivePackCommand(repoPath, "--stateless-rpc"); err != nil {
+			log.Printf("Failed to prepare receive-pack for %s: %v", repoPath, err)
+			http.Error(w, "Internal server error: failed to prepare git process.", http.StatusInternalServerError)
+			return
+		}
+		defer receivePack.Close()
+		cmd = receivePack.Cmd
+	} else {
+		cmd = exec.Command("git", gitServiceCmd, "--stateless-rpc", "--", filepath.Base(repoPath))
+		cmd.Dir = filepath.Dir(repoPath)
+	}
+	setGitProtocol(cmd, r)
 
 	stdin, err := cmd.StdinPipe()
 	if err != nil {
@@ -334,89 +451,104 @@ func handleGitService(w http.ResponseWriter, r *http.Request) {
 		if stderr.Len() > 0 {
 			log.Printf("Git stderr: %s", stderr.String())
 		}
+		return
 	}
-}
 
-// packetWrite formats a Git protocol packet line
-func packetWrite(s string) []byte {
-	if s == "" {
-		return []byte("0000")
+	if receivePack != nil {
+		publishPush(r, repoPath, receivePack)
 	}
-	length := len(s) + 4
-	return []byte(fmt.Sprintf("%04x%s", length, s))
-}
-
-// writeJSONError writes a JSON error response
-func writeJSONError(w http.ResponseWriter, status int, msg string) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(status)
-	json.NewEncoder(w).Encode(map[string]string{"error": msg})
 }
 
-// getBasicAuth extracts username and password from HTTP Basic Auth
-func getBasicAuth(r *http.Request) (username, password string, ok bool) {
-	auth := r.Header.Get("Authorization")
-	if !strings.HasPrefix(auth, "Basic ") {
+// publishPush publishes the refs updated by a push over HTTP. In stateless
+// RPC mode receive-pack cannot tell them, the post-receive hook records them.
+func publishPush(r *http.Request, repoPath string, receivePack *git.ReceivePack) {
+	if events == nil {
 		return
 	}
-	payload, err := base64.StdEncoding.DecodeString(auth[len("Basic "):])
+	updates, err := receivePack.RefUpdates()
 	if err != nil {
+		log.Printf("Failed to read ref updates of a push to %s: %v", repoPath, err)
 		return
 	}
-	pair := strings.SplitN(string(payload), ":", 2)
-	if len(pair) != 2 {
+	if len(updates) == 0 {
 		return
 	}
-	return pair[0], pair[1], true
+	pusher, _ := api.RequestUser(r)
+	events.PublishPush(worker.PushEvent{RepoPath: repoPath, Pusher: pusher.Username, Updates: updates, PushedAt: time.Now()})
 }
 
-// isOwnerAuthenticated checks if the request is authenticated as the repo owner using db
-func isOwnerAuthenticated(r *http.Request, meta git.RepoMeta) bool {
-	// 1. Try Basic Auth
-	if username, password, ok := getBasicAuth(r); ok {
-		user, err := db.AuthenticateUser(username, password)
-		if err == nil && user.Username == meta.Owner {
-			return true
-		}
+// setGitProtocol passes the Git-Protocol header of a request on to git in
+// GIT_PROTOCOL, so clients can use protocol v2 with its ls-refs command and
+// ref-prefix filtering instead of a full ref advertisement. It returns the
+// protocol version asked for, 0 without a valid header.
+func setGitProtocol(cmd *exec.Cmd, r *http.Request) int {
+	value := r.Header.Get("Git-Protocol")
+	version, ok := git.ParseProtocol(value)
+	if !ok {
+		return 0
+	}
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, "GIT_PROTOCOL="+value)
+	return version
+}
+
+// rejectMirrorPush refuses pushes to pull mirrors, whose refs would be
+// overwritten by the next sync. It reports whether the push was rejected.
+func rejectMirrorPush(w http.ResponseWriter, repoPath string) bool {
+	meta, err := git.LoadRepoMeta(repoPath)
+	if err != nil || meta.MirrorURL == "" {
+		return false
 	}
+	http.Error(w, "Repository is a mirror and cannot be pushed to", http.StatusForbidden)
+	return true
+}
 
-	// 2. Try API Token (from query or header)
-	token := r.URL.Query().Get("token")
-	if token == "" {
-		token = r.Header.Get("X-Auth-Token")
+// redirectMovedRepo redirects a request for the old name of a renamed or
+// transferred repository to the same path below its new name, so old clone URLs
+// keep working. It reports false if the repository was not moved.
+func redirectMovedRepo(w http.ResponseWriter, r *http.Request, username, repoName string) bool {
+	newPath, ok := git.ResolveRepoRedirect(filepath.Join("repos", username, repoName+".git"))
+	if !ok {
+		return false
 	}
-	if strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
-		token = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	// Like for existing private repositories, ask for credentials before revealing anything
+	if !checkRepoAuth(r, newPath, "pull", "") {
+		w.Header().Set("WWW-Authenticate", `Basic realm="LibreBucket"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return true
 	}
 
-	if token != "" {
-		user, err := db.GetUserByToken(token)
-		if err == nil && user.Username == meta.Owner {
-			return true
-		}
+	location := *r.URL
+	location.Path = "/" + git.RepoFullName(newPath) + strings.TrimPrefix(r.URL.Path, "/"+username+"/"+repoName)
+	status := http.StatusTemporaryRedirect
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		status = http.StatusMovedPermanently
 	}
-
-	return false
+	http.Redirect(w, r, location.RequestURI(), status)
+	return true
 }
 
-// checkRepoAuth enforces public/private and owner rules for pull/push
-func checkRepoAuth(r *http.Request, repoPath, action, expectedOwner string) bool {
-	meta, err := git.LoadRepoMeta(repoPath)
-	if err != nil {
-		// If repo meta cannot be loaded, treat as unauthorized or non-existent
-		return false
+// packetWrite formats a Git protocol packet line
+func packetWrite(s string) []byte {
+	if s == "" {
+		return []byte("0000")
 	}
+	length := len(s) + 4
+	return []byte(fmt.Sprintf("%04x%s", length, s))
+}
 
-	if action == "pull" { // Clone/Fetch (git-upload-pack)
-		if meta.Public {
-			return true // Public repos can be pulled by anyone
-		}
-		// Private repo: only owner can pull
-		return isOwnerAuthenticated(r, meta)
-	}
+// writeJSONError writes a JSON error response
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
 
-	// For push (git-receive-pack) and other write actions: only owner can push
-	return isOwnerAuthenticated(r, meta)
+// checkRepoAuth enforces public/private and owner rules for pull/push
+func checkRepoAuth(r *http.Request, repoPath, action, expectedOwner string) bool {
+	return api.CheckRepoAuth(r, repoPath, action)
 }
 
 // getLang gets language from cookie, defaults to "en"
@@ -469,6 +601,9 @@ func isValidPageName(page string) bool {
 	return true
 }
 
+// homeRepoLimit is the number of repositories listed on the home page
+const homeRepoLimit = 20
+
 // homeHandler serves the home page with translations
 func homeHandler(w http.ResponseWriter, r *http.Request) {
 	lang := getLang(r)
@@ -483,6 +618,31 @@ func homeHandler(w http.ResponseWriter, r *http.Request) {
 		"Trans": trans,
 		"Lang":  lang,
 	}
+
+	// ?user= lists the repositories of a user, most recently pushed first.
+	// Private ones are only listed for their owner.
+	viewer := ""
+	if user, ok := api.RequestUser(r); ok {
+		viewer = user.Username
+	}
+	owner := r.URL.Query().Get("user")
+	if owner == "" {
+		owner = viewer
+	}
+	if owner != "" && isSafeComponent(owner) {
+		if _, err := db.GetUserByUsername(owner); err == nil {
+			repos, _, err := db.SearchRepositories(db.RepositorySearch{
+				Owner:  owner,
+				Viewer: viewer,
+				Limit:  homeRepoLimit,
+			})
+			if err != nil {
+				log.Printf("Failed to list repositories of %s: %v", owner, err)
+			}
+			data["Username"] = owner
+			data["Repos"] = repos
+		}
+	}
 	RenderTemplate("home.tmpl", data, w)
 }
 
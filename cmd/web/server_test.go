@@ -0,0 +1,332 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"librebucket/cmd/db"
+	"librebucket/cmd/git"
+	"librebucket/cmd/lfs"
+	"librebucket/cmd/worker"
+)
+
+// startGitServer serves the Git smart HTTP endpoints and returns the URL of a
+// public repository with a branch and tags v0 to v9
+func startGitServer(t *testing.T, owner string) string {
+	t.Helper()
+	repoPath := filepath.Join("repos", owner, "tags.git")
+	if err := git.CreateRepoWithOptions(repoPath, owner, true, git.InitOptions{AutoInit: true}); err != nil {
+		t.Fatalf("CreateRepo failed: %v", err)
+	}
+	for i := range 10 {
+		if out, err := exec.Command("git", "-C", repoPath, "tag", fmt.Sprintf("v%d", i), "main").CombinedOutput(); err != nil {
+			t.Fatalf("git tag failed: %v: %s", err, out)
+		}
+	}
+
+	r := chi.NewRouter()
+	gitRoutes(r)
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+	return srv.URL + "/" + owner + "/tags.git"
+}
+
+// gitRequest sends a request to a Git endpoint with an optional Git-Protocol
+// header and returns the response body
+func gitRequest(t *testing.T, method, url, protocol, body string) string {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("NewRequest failed: %v", err)
+	}
+	if protocol != "" {
+		req.Header.Set("Git-Protocol", protocol)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, url, err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("%s %s returned %d: %s", method, url, resp.StatusCode, data)
+	}
+	return string(data)
+}
+
+func TestGitProtocol(t *testing.T) {
+	t.Chdir(t.TempDir())
+	url := startGitServer(t, "gus")
+
+	// v0 clients get the service preamble and all refs
+	v0 := gitRequest(t, http.MethodGet, url+"/info/refs?service=git-upload-pack", "", "")
+	if !strings.HasPrefix(v0, "001e# service=git-upload-pack\n0000") || !strings.Contains(v0, "refs/tags/v9") {
+		t.Errorf("Expected a v0 ref advertisement, got %q", v0)
+	}
+
+	// v2 clients get the capabilities only and ask for the refs they need
+	v2 := gitRequest(t, http.MethodGet, url+"/info/refs?service=git-upload-pack", "version=2", "")
+	if !strings.HasPrefix(v2, "000eversion 2\n") || !strings.Contains(v2, "ls-refs") || strings.Contains(v2, "refs/") {
+		t.Errorf("Expected a v2 capability advertisement, got %q", v2)
+	}
+	lsRefs := string(packetWrite("command=ls-refs\n")) + "0001" + string(packetWrite("ref-prefix refs/heads/\n")) + "0000"
+	refs := gitRequest(t, http.MethodPost, url+"/git-upload-pack", "version=2", lsRefs)
+	if !strings.Contains(refs, "refs/heads/main") || strings.Contains(refs, "refs/tags/") {
+		t.Errorf("Expected only the branches, got %q", refs)
+	}
+
+}
+
+func TestGitProtocolClients(t *testing.T) {
+	t.Chdir(t.TempDir())
+	url := startGitServer(t, "hal")
+
+	for _, version := range []string{"0", "2"} {
+		dir := "fetch-v" + version
+		if out, err := exec.Command("git", "init", "-q", dir).CombinedOutput(); err != nil {
+			t.Fatalf("git init failed: %v: %s", err, out)
+		}
+		cmd := exec.Command("git", "-C", dir, "-c", "protocol.version="+version, "fetch", "-q", "--no-tags", url, "main")
+		cmd.Env = append(os.Environ(), "GIT_TRACE_PACKET=1")
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git fetch with protocol v%s failed: %v: %s", version, err, out)
+		}
+
+		// v2 clients only receive the refs they asked for
+		usedV2 := strings.Contains(string(out), "< version 2")
+		sentTags := strings.Contains(string(out), "refs/tags/v9")
+		if version == "2" && (!usedV2 || sentTags) {
+			t.Errorf("Expected protocol v2 with filtered refs, got %s", out)
+		}
+		if version == "0" && (usedV2 || !sentTags) {
+			t.Errorf("Expected protocol v0 with all refs, got %s", out)
+		}
+		if out, err := exec.Command("git", "-C", dir, "cat-file", "-e", "FETCH_HEAD:README.md").CombinedOutput(); err != nil {
+			t.Errorf("Expected README.md in the fetch with protocol v%s: %v: %s", version, err, out)
+		}
+	}
+}
+
+func TestPushPolicy(t *testing.T) {
+	t.Chdir(t.TempDir())
+	url := startGitServer(t, "ike")
+	if _, err := db.GetUserByUsername("ike"); err != nil {
+		if _, err := db.CreateUser("ike", "secret", false, "ike-token"); err != nil {
+			t.Fatalf("CreateUser failed: %v", err)
+		}
+	}
+	url = strings.Replace(url, "://", "://ike:secret@", 1)
+	policy := git.PushPolicy{ProtectedBranches: []string{"main"}, ForbiddenPaths: []string{"*.pem"}}
+	if _, err := git.SetPushPolicy(filepath.Join("repos", "ike", "tags.git"), policy); err != nil {
+		t.Fatalf("SetPushPolicy failed: %v", err)
+	}
+
+	gitIn := func(dir string, args ...string) (string, error) {
+		cmd := exec.Command("git", append([]string{"-C", dir, "-c", "user.name=Ike", "-c", "user.email=ike@example.com"}, args...)...)
+		out, err := cmd.CombinedOutput()
+		return string(out), err
+	}
+	if out, err := exec.Command("git", "clone", "-q", url, "work").CombinedOutput(); err != nil {
+		t.Fatalf("git clone failed: %v: %s", err, out)
+	}
+	os.WriteFile(filepath.Join("work", "server.pem"), []byte("key"), 0644)
+	if out, err := gitIn("work", "add", "server.pem"); err != nil {
+		t.Fatalf("git add failed: %v: %s", err, out)
+	}
+	if out, err := gitIn("work", "commit", "-qm", "Add key"); err != nil {
+		t.Fatalf("git commit failed: %v: %s", err, out)
+	}
+
+	// The client sees why the push was rejected
+	out, err := gitIn("work", "push", "origin", "main")
+	if err == nil || !strings.Contains(out, "remote: Push rejected by the push policy") ||
+		!strings.Contains(out, "server.pem matches the forbidden path pattern") || !strings.Contains(out, "pre-receive hook declined") {
+		t.Errorf("Expected the push to be rejected, got %v: %s", err, out)
+	}
+	if out, err := gitIn("work", "push", "origin", ":main"); err == nil || !strings.Contains(out, "deleting the protected branch main") {
+		t.Errorf("Expected the deletion to be rejected, got %v: %s", err, out)
+	}
+
+	// Allowed pushes go through
+	os.WriteFile(filepath.Join("work", "notes.txt"), []byte("notes"), 0644)
+	if out, err := gitIn("work", "reset", "-q", "HEAD~1"); err != nil {
+		t.Fatalf("git reset failed: %v: %s", err, out)
+	}
+	if out, err := gitIn("work", "add", "notes.txt"); err != nil {
+		t.Fatalf("git add failed: %v: %s", err, out)
+	}
+	if out, err := gitIn("work", "commit", "-qm", "Add notes"); err != nil {
+		t.Fatalf("git commit failed: %v: %s", err, out)
+	}
+	if out, err := gitIn("work", "push", "origin", "main"); err != nil {
+		t.Errorf("Expected the push to be accepted, got %v: %s", err, out)
+	}
+}
+
+func TestPushEvents(t *testing.T) {
+	t.Chdir(t.TempDir())
+	url := startGitServer(t, "jon")
+	if _, err := db.GetUserByUsername("jon"); err != nil {
+		if _, err := db.CreateUser("jon", "secret", false, "jon-token"); err != nil {
+			t.Fatalf("CreateUser failed: %v", err)
+		}
+	}
+	url = strings.Replace(url, "://", "://jon:secret@", 1)
+
+	received := make(chan worker.PushEvent, 1)
+	events = worker.NewEventBus()
+	events.SubscribePush(func(e worker.PushEvent) { received <- e })
+	t.Cleanup(func() { events = nil })
+
+	gitIn := func(args ...string) string {
+		cmd := exec.Command("git", append([]string{"-C", "work", "-c", "user.name=Jon", "-c", "user.email=jon@example.com"}, args...)...)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %s failed: %v: %s", args[0], err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	if out, err := exec.Command("git", "clone", "-q", url, "work").CombinedOutput(); err != nil {
+		t.Fatalf("git clone failed: %v: %s", err, out)
+	}
+	oldHash := gitIn("rev-parse", "HEAD")
+	gitIn("commit", "-q", "--allow-empty", "-m", "Empty")
+	newHash := gitIn("rev-parse", "HEAD")
+
+	// The event lists every updated ref, although git runs in stateless RPC mode
+	gitIn("push", "-q", "origin", "main", ":v1", "main:topic")
+	e := <-received
+	want := []git.RefUpdate{
+		{Ref: "refs/heads/main", OldHash: oldHash, NewHash: newHash},
+		{Ref: "refs/tags/v1", OldHash: oldHash, NewHash: strings.Repeat("0", 40)},
+		{Ref: "refs/heads/topic", OldHash: strings.Repeat("0", 40), NewHash: newHash},
+	}
+	slices.SortFunc(e.Updates, func(a, b git.RefUpdate) int { return strings.Compare(a.Ref, b.Ref) })
+	slices.SortFunc(want, func(a, b git.RefUpdate) int { return strings.Compare(a.Ref, b.Ref) })
+	if e.RepoPath != filepath.Join("repos", "jon", "tags.git") || e.Pusher != "jon" || !slices.Equal(e.Updates, want) {
+		t.Errorf("Expected a push event by jon with %v, got %+v", want, e)
+	}
+
+	// Pushes that update nothing publish no event
+	gitIn("push", "-q", "origin", "main")
+	select {
+	case e := <-received:
+		t.Errorf("Expected no event, got %+v", e)
+	default:
+	}
+}
+
+func TestPushWebhooks(t *testing.T) {
+	t.Chdir(t.TempDir())
+	url := startGitServer(t, "kit")
+	if _, err := db.GetUserByUsername("kit"); err != nil {
+		if _, err := db.CreateUser("kit", "secret", false, "kit-token"); err != nil {
+			t.Fatalf("CreateUser failed: %v", err)
+		}
+	}
+	url = strings.Replace(url, "://", "://kit:secret@", 1)
+
+	received := make(chan *http.Request, 10)
+	bodies := make(chan string, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- r
+		bodies <- string(body)
+	}))
+	t.Cleanup(receiver.Close)
+	git.AllowedLocalHosts = []string{"127.0.0.1"}
+	t.Cleanup(func() { git.AllowedLocalHosts = nil })
+	secret := "s3cret"
+	repoPath := filepath.Join("repos", "kit", "tags.git")
+	hook, err := git.AddWebhook(repoPath, git.WebhookSettings{URL: &receiver.URL, Secret: &secret, Events: []string{"push", "create"}})
+	if err != nil {
+		t.Fatalf("AddWebhook failed: %v", err)
+	}
+	t.Cleanup(func() { git.RemoveWebhook(repoPath, hook.ID) })
+
+	pool := worker.NewPool(1, 16)
+	t.Cleanup(pool.Stop)
+	events = worker.NewEventBus()
+	worker.SubscribeWebhooks(events, pool)
+	t.Cleanup(func() { events = nil })
+
+	if out, err := exec.Command("git", "clone", "-q", url, "work").CombinedOutput(); err != nil {
+		t.Fatalf("git clone failed: %v: %s", err, out)
+	}
+	if out, err := exec.Command("git", "-C", "work", "push", "-q", "origin", "main:topic").CombinedOutput(); err != nil {
+		t.Fatalf("git push failed: %v: %s", err, out)
+	}
+
+	// A new branch is a push and a create event, signed with the secret
+	got := map[string]string{}
+	for range 2 {
+		r, body := <-received, <-bodies
+		if r.Header.Get("X-Hub-Signature-256") != git.SignWebhookPayload(secret, []byte(body)) {
+			t.Errorf("Expected a valid signature for %s", body)
+		}
+		got[r.Header.Get("X-LibreBucket-Event")] = body
+	}
+	if !strings.Contains(got["push"], `"ref":"refs/heads/topic"`) || !strings.Contains(got["push"], `"created":true`) ||
+		!strings.Contains(got["push"], `"pusher":{"username":"kit"}`) || !strings.Contains(got["push"], `"message":"Initial commit`) {
+		t.Errorf("Unexpected push payload %s", got["push"])
+	}
+	if !strings.Contains(got["create"], `"ref":"topic","ref_type":"branch"`) {
+		t.Errorf("Unexpected create payload %s", got["create"])
+	}
+}
+
+func TestLFSMaxObjectSize(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if _, err := db.GetUserByUsername("lev"); err != nil {
+		if _, err := db.CreateUser("lev", "secret", false, "lev-token"); err != nil {
+			t.Fatalf("CreateUser failed: %v", err)
+		}
+	}
+	if err := git.CreateRepo(filepath.Join("repos", "lev", "assets.git"), "lev", false); err != nil {
+		t.Fatalf("CreateRepo failed: %v", err)
+	}
+	defer func(max int64) { lfs.MaxObjectSize = max }(lfs.MaxObjectSize)
+	lfs.MaxObjectSize = 4
+
+	r := chi.NewRouter()
+	r.Route("/{username}/{repoName}.git/info/lfs", lfsRoutes)
+	send := func(method, url, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		req.SetBasicAuth("lev", "secret")
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec
+	}
+
+	oid := strings.Repeat("a", 64)
+	rec := send(http.MethodPost, "/lev/assets.git/info/lfs/objects/batch",
+		`{"operation": "upload", "objects": [{"oid": "`+oid+`", "size": 5}, {"oid": "`+oid+`", "size": 4}]}`)
+	var resp struct {
+		Objects []lfsObject `json:"objects"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil || len(resp.Objects) != 2 {
+		t.Fatalf("Unexpected batch response %d: %v", rec.Code, err)
+	}
+	if e := resp.Objects[0].Error; e == nil || e.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected a 422 error for the large object, got %+v", resp.Objects[0])
+	}
+	if resp.Objects[1].Error != nil || resp.Objects[1].Actions["upload"].Href == "" {
+		t.Errorf("Expected an upload action for the small object, got %+v", resp.Objects[1])
+	}
+
+	if rec := send(http.MethodPut, "/lev/assets.git/info/lfs/objects/"+oid, "12345"); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413 for a large upload, got %d: %s", rec.Code, rec.Body)
+	}
+}
@@ -19,7 +19,7 @@ func init() {
 	if err != nil {
 		log.Fatalf("Failed to parse embedded templates: %v", err)
 	}
-	log.Println("Successfully parsed all embedded template files.")
+	// No log on success, init also runs in git hooks whose output goes to the client
 }
 
 // RenderTemplate executes the specified HTML template with the provided data and writes the result to the HTTP response.
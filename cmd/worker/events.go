@@ -0,0 +1,92 @@
+package worker
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"librebucket/cmd/git"
+)
+
+// PushEvent is published after a push to a repository updated refs, over
+// HTTP or SSH
+type PushEvent struct {
+	RepoPath string
+	// Pusher is the username of the user who pushed
+	Pusher   string
+	Updates  []git.RefUpdate
+	PushedAt time.Time
+}
+
+// RepoEvent is published after the settings, name or owner of a repository
+// changed
+type RepoEvent struct {
+	RepoPath string
+	Action   string // "edited", "renamed" or "transferred"
+	// OldFullName is the "{owner}/{name}" before a rename or transfer
+	OldFullName string
+	// Sender is the username of the user who made the change
+	Sender    string
+	ChangedAt time.Time
+}
+
+// EventBus delivers events to the subscribers of their type
+type EventBus struct {
+	mu   sync.RWMutex
+	push []func(PushEvent)
+	repo []func(RepoEvent)
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{}
+}
+
+// SubscribePush calls fn with every push event. Subscribers run in the
+// publishing goroutine, so they should queue a job for anything slow.
+func (b *EventBus) SubscribePush(fn func(PushEvent)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.push = append(b.push, fn)
+}
+
+// PublishPush passes a push event to the subscribers in the order they
+// subscribed
+func (b *EventBus) PublishPush(e PushEvent) {
+	b.mu.RLock()
+	subscribers := b.push
+	b.mu.RUnlock()
+	for _, fn := range subscribers {
+		fn(e)
+	}
+}
+
+// SubscribeRepo calls fn with every repository event, like SubscribePush
+func (b *EventBus) SubscribeRepo(fn func(RepoEvent)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.repo = append(b.repo, fn)
+}
+
+// PublishRepo passes a repository event to the subscribers in the order they
+// subscribed
+func (b *EventBus) PublishRepo(e RepoEvent) {
+	b.mu.RLock()
+	subscribers := b.repo
+	b.mu.RUnlock()
+	for _, fn := range subscribers {
+		fn(e)
+	}
+}
+
+// SubscribePushJobs queues the jobs that follow every push on p: refreshing
+// the statistics of the repository and replicating it to its push mirrors
+func SubscribePushJobs(b *EventBus, p *Pool) {
+	b.SubscribePush(func(e PushEvent) {
+		if !p.Submit(&RepoStatsJob{RepoPath: e.RepoPath}) {
+			log.Printf("Job queue full, skipping stats refresh of %s", e.RepoPath)
+		}
+	})
+	b.SubscribePush(func(e PushEvent) {
+		QueuePushMirrors(p, e.RepoPath)
+	})
+}
@@ -0,0 +1,29 @@
+package worker
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"librebucket/cmd/db"
+)
+
+// TestMain sets up a temporary database for the repository metadata and a
+// secret key for the credentials of mirrors
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "librebucket-worker-test")
+	if err != nil {
+		log.Fatalf("Failed to create temp dir: %v", err)
+	}
+	if err := db.InitDB(filepath.Join(dir, "test.db")); err != nil {
+		log.Fatalf("Failed to initialize test DB: %v", err)
+	}
+	if err := db.LoadSecretKey(filepath.Join(dir, "secret.key")); err != nil {
+		log.Fatalf("Failed to create test secret key: %v", err)
+	}
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
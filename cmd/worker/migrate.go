@@ -0,0 +1,95 @@
+package worker
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"librebucket/cmd/db"
+	"librebucket/cmd/git"
+)
+
+const (
+	// migrationProgressInterval limits how often progress is saved
+	migrationProgressInterval = time.Second
+	// maxProgressLine keeps a remote without line breaks from growing a line forever
+	maxProgressLine = 200
+)
+
+// MigrateJob imports a repository from another git server and records its
+// state, progress and error on a db.RepoMigration. The credentials only live
+// in the job.
+type MigrateJob struct {
+	MigrationID int64
+	CloneURL    string
+	RepoPath    string
+	Owner       string
+	Opts        git.MigrateOptions
+}
+
+func (j *MigrateJob) Run() error {
+	m, err := db.GetRepoMigration(j.MigrationID)
+	if err != nil {
+		return err
+	}
+	m.State = db.MigrationRunning
+	m.Progress = "Cloning"
+	if err := db.UpdateRepoMigration(&m); err != nil {
+		return err
+	}
+
+	opts := j.Opts
+	opts.Progress = &migrationProgress{m: &m}
+	migrateErr := git.MigrateRepo(context.Background(), j.CloneURL, j.RepoPath, j.Owner, opts)
+	if migrateErr != nil {
+		m.State = db.MigrationFailed
+		m.Error = migrateErr.Error()
+	} else {
+		m.State = db.MigrationDone
+		m.Progress = "Done"
+	}
+	if err := db.UpdateRepoMigration(&m); err != nil {
+		return err
+	}
+	if migrateErr != nil {
+		return migrateErr
+	}
+
+	stats := &RepoStatsJob{RepoPath: j.RepoPath}
+	return stats.Run()
+}
+
+// migrationProgress saves the last line of the progress output of a remote,
+// e.g. "Receiving objects:  45% (450/1000)", at most every
+// migrationProgressInterval. Lines end with \r while they are updated.
+type migrationProgress struct {
+	m     *db.RepoMigration
+	line  []byte
+	last  string
+	saved time.Time
+}
+
+func (p *migrationProgress) Write(b []byte) (int, error) {
+	for _, c := range b {
+		if c == '\r' || c == '\n' {
+			if len(p.line) > 0 {
+				p.last = string(p.line)
+				p.line = p.line[:0]
+			}
+			continue
+		}
+		if len(p.line) < maxProgressLine {
+			p.line = append(p.line, c)
+		}
+	}
+
+	if p.last != "" && p.last != p.m.Progress && time.Since(p.saved) >= migrationProgressInterval {
+		p.m.Progress = p.last
+		p.saved = time.Now()
+		if err := db.UpdateRepoMigration(p.m); err != nil {
+			// Progress is informational, the clone goes on
+			log.Printf("Failed to save progress of migration %d: %v", p.m.ID, err)
+		}
+	}
+	return len(b), nil
+}
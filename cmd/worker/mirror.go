@@ -0,0 +1,116 @@
+package worker
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"librebucket/cmd/git"
+)
+
+// mirrorsQueued holds the paths of the mirrors with a pending MirrorSyncJob, so
+// a slow sync is not queued again on every tick
+var mirrorsQueued sync.Map
+
+// pushMirrorsQueued holds the IDs of the push mirrors with a PushMirrorJob that
+// has not started yet
+var pushMirrorsQueued sync.Map
+
+// MirrorSyncJob fetches a pull mirror from its remote and then refreshes its
+// statistics like a push would
+type MirrorSyncJob struct {
+	RepoPath string
+}
+
+func (j *MirrorSyncJob) Run() error {
+	defer mirrorsQueued.Delete(j.RepoPath)
+	if err := git.SyncMirror(context.Background(), j.RepoPath); err != nil {
+		return err
+	}
+	stats := &RepoStatsJob{RepoPath: j.RepoPath}
+	return stats.Run()
+}
+
+// PushMirrorJob replicates a repository to one of its push mirrors. Failed
+// pushes are retried with backoff by the scheduler.
+type PushMirrorJob struct {
+	RepoPath string
+	MirrorID int64
+}
+
+func (j *PushMirrorJob) Run() error {
+	// Pushes after this point queue a new job, which pushes their refs
+	pushMirrorsQueued.Delete(j.MirrorID)
+	return git.PushToMirror(context.Background(), j.RepoPath, j.MirrorID)
+}
+
+// QueuePushMirrors queues a PushMirrorJob for every push mirror of a repository,
+// e.g. after a push
+func QueuePushMirrors(p *Pool, repoPath string) {
+	mirrors, err := git.ListPushMirrors(repoPath)
+	if err != nil {
+		log.Printf("Failed to queue push mirrors of %s: %v", repoPath, err)
+		return
+	}
+	for _, m := range mirrors {
+		queuePushMirror(p, repoPath, m.ID)
+	}
+}
+
+// queuePushMirror submits a PushMirrorJob unless one is already waiting
+func queuePushMirror(p *Pool, repoPath string, id int64) {
+	if _, queued := pushMirrorsQueued.LoadOrStore(id, true); queued {
+		return
+	}
+	if !p.Submit(&PushMirrorJob{RepoPath: repoPath, MirrorID: id}) {
+		pushMirrorsQueued.Delete(id)
+		log.Printf("Job queue full, skipping push mirror %d of %s", id, repoPath)
+	}
+}
+
+// ScheduleMirrors checks for pull and push mirrors that are due every interval
+// and queues a MirrorSyncJob or PushMirrorJob for each. Calling the returned
+// function stops it.
+func ScheduleMirrors(p *Pool, interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			queueDueMirrors(p)
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// queueDueMirrors submits a job for every due mirror not already queued
+func queueDueMirrors(p *Pool) {
+	now := time.Now()
+	due, err := git.DueMirrors(now)
+	if err != nil {
+		log.Printf("Mirror scheduler: %v", err)
+	}
+	for _, repoPath := range due {
+		if _, queued := mirrorsQueued.LoadOrStore(repoPath, true); queued {
+			continue
+		}
+		if !p.Submit(&MirrorSyncJob{RepoPath: repoPath}) {
+			mirrorsQueued.Delete(repoPath)
+			log.Printf("Job queue full, skipping sync of mirror %s", repoPath)
+		}
+	}
+
+	duePush, err := git.DuePushMirrors(now)
+	if err != nil {
+		log.Printf("Mirror scheduler: %v", err)
+	}
+	for _, m := range duePush {
+		queuePushMirror(p, m.RepoPath, m.ID)
+	}
+}
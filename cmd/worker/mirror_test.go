@@ -0,0 +1,95 @@
+package worker
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"librebucket/cmd/git"
+)
+
+func TestQueueDueMirrors(t *testing.T) {
+	t.Chdir(t.TempDir())
+	mirrorPath := filepath.Join("repos", "dina", "mirror.git")
+	if err := git.CreateMirror(mirrorPath, "dina", false, "https://203.0.113.1/upstream.git", 0); err != nil {
+		t.Fatalf("CreateMirror failed: %v", err)
+	}
+	repoPath := filepath.Join("repos", "dina", "app.git")
+	if err := git.CreateRepo(repoPath, "dina", false); err != nil {
+		t.Fatalf("CreateRepo failed: %v", err)
+	}
+	// The push mirror remote rejects every push
+	prevHosts := git.AllowedLocalHosts
+	git.AllowedLocalHosts = []string{"127.0.0.1"}
+	t.Cleanup(func() { git.AllowedLocalHosts = prevHosts })
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	t.Cleanup(remote.Close)
+	m, err := git.AddPushMirror(repoPath, remote.URL+"/app.git", "dina", "secret", 0)
+	if err != nil {
+		t.Fatalf("AddPushMirror failed: %v", err)
+	}
+	t.Cleanup(func() {
+		git.RemovePushMirror(repoPath, m.ID)
+		mirrorsQueued.Delete(mirrorPath)
+		pushMirrorsQueued.Delete(m.ID)
+	})
+
+	// A pool without workers keeps the submitted jobs
+	p := &Pool{jobs: make(chan Job, 16)}
+	queueDueMirrors(p)
+	// Mirrors with a pending job are not queued again
+	queueDueMirrors(p)
+	QueuePushMirrors(p, repoPath)
+
+	syncs, pushes := 0, 0
+	for len(p.jobs) > 0 {
+		switch job := (<-p.jobs).(type) {
+		case *MirrorSyncJob:
+			if job.RepoPath == mirrorPath {
+				syncs++
+			}
+		case *PushMirrorJob:
+			if job.MirrorID == m.ID {
+				pushes++
+			}
+		}
+	}
+	if syncs != 1 || pushes != 1 {
+		t.Errorf("Expected one sync and one push job, got %d and %d", syncs, pushes)
+	}
+
+	// A failed push is retried after a backoff
+	job := &PushMirrorJob{RepoPath: repoPath, MirrorID: m.ID}
+	if err := job.Run(); err == nil {
+		t.Fatal("Expected the push to the failing remote to fail")
+	}
+	if isDue(t, time.Now(), m.ID) {
+		t.Error("Expected the failed push mirror not to be due right away")
+	}
+	if !isDue(t, time.Now().Add(time.Hour), m.ID) {
+		t.Error("Expected the failed push mirror to be due after its backoff")
+	}
+	queueDueMirrors(p)
+	if len(p.jobs) != 0 {
+		t.Errorf("Expected no jobs for mirrors that are queued or backing off, got %d", len(p.jobs))
+	}
+}
+
+// isDue reports whether DuePushMirrors returns the push mirror id at now
+func isDue(t *testing.T, now time.Time, id int64) bool {
+	t.Helper()
+	due, err := git.DuePushMirrors(now)
+	if err != nil {
+		t.Fatalf("DuePushMirrors failed: %v", err)
+	}
+	for _, d := range due {
+		if d.ID == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,41 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+
+	"librebucket/cmd/git"
+)
+
+// RepoStatsJob refreshes the size of a repository and the language statistics
+// and last commit of its default branch. It runs after every successful push.
+type RepoStatsJob struct {
+	RepoPath string
+}
+
+func (j *RepoStatsJob) Run() error {
+	if err := git.UpdateSize(j.RepoPath); err != nil {
+		return fmt.Errorf("failed to update size of %s: %w", j.RepoPath, err)
+	}
+
+	// HEAD points at the default branch
+	hash, err := git.ResolveRevision(j.RepoPath, "HEAD")
+	if errors.Is(err, git.ErrRevisionNotFound) {
+		// Nothing has been pushed to the default branch yet
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to resolve default branch of %s: %w", j.RepoPath, err)
+	}
+
+	languages, err := git.DetectLanguages(j.RepoPath, hash)
+	if err != nil {
+		return fmt.Errorf("failed to detect languages of %s: %w", j.RepoPath, err)
+	}
+	if err := git.UpdateLanguages(j.RepoPath, languages); err != nil {
+		return fmt.Errorf("failed to update languages of %s: %w", j.RepoPath, err)
+	}
+	if err := git.UpdateLastCommit(j.RepoPath, hash); err != nil {
+		return fmt.Errorf("failed to update last commit of %s: %w", j.RepoPath, err)
+	}
+	return nil
+}
@@ -0,0 +1,248 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"librebucket/cmd/db"
+	"librebucket/cmd/git"
+)
+
+// webhookMaxAttempts bounds the deliveries of an event to a webhook
+const webhookMaxAttempts = 5
+
+// webhookRetryDelay is the delay before the first retry of a failed delivery,
+// doubling with every further failure
+var webhookRetryDelay = 30 * time.Second
+
+// WebhookJob posts the payload of an event to a webhook. Failed deliveries
+// are retried with exponential backoff.
+type WebhookJob struct {
+	RepoPath string
+	HookID   int64
+	Event    string
+	GUID     string
+	Payload  []byte
+	Attempt  int // 1 for the first try
+
+	// pool runs the retries, none are made without it
+	pool *Pool
+}
+
+func (j *WebhookJob) Run() error {
+	_, err := git.DeliverWebhook(context.Background(), j.RepoPath, j.HookID, j.Event, j.GUID, j.Payload, j.Attempt)
+	if err == nil || errors.Is(err, db.ErrWebhookNotFound) || j.Attempt >= webhookMaxAttempts || j.pool == nil {
+		return err
+	}
+	retry := *j
+	retry.Attempt++
+	delay := webhookRetryDelay << (j.Attempt - 1)
+	time.AfterFunc(delay, func() {
+		if !j.pool.Submit(&retry) {
+			log.Printf("Job queue full, dropping retry of %s delivery %s", j.Event, j.GUID)
+		}
+	})
+	return fmt.Errorf("%w, retrying in %s", err, delay)
+}
+
+// QueueWebhooks queues a WebhookJob for every active webhook of a repository
+// subscribed to event
+func QueueWebhooks(p *Pool, repoPath, event string, payload any) error {
+	hooks, err := git.WebhooksForEvent(repoPath, event)
+	if err != nil || len(hooks) == 0 {
+		return err
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	guid, err := git.NewWebhookGUID()
+	if err != nil {
+		return err
+	}
+	for _, h := range hooks {
+		job := &WebhookJob{RepoPath: repoPath, HookID: h.ID, Event: event, GUID: guid, Payload: body, Attempt: 1, pool: p}
+		if !p.Submit(job) {
+			log.Printf("Job queue full, skipping %s event for webhook %d of %s", event, h.ID, h.Repo)
+		}
+	}
+	return nil
+}
+
+// SubscribeWebhooks posts push and repository events to the webhooks of
+// their repository. The payloads are built by jobs on p.
+func SubscribeWebhooks(b *EventBus, p *Pool) {
+	b.SubscribePush(func(e PushEvent) {
+		if !p.Submit(&pushWebhooksJob{event: e, pool: p}) {
+			log.Printf("Job queue full, skipping webhooks of a push to %s", e.RepoPath)
+		}
+	})
+	b.SubscribeRepo(func(e RepoEvent) {
+		if !p.Submit(&repoWebhooksJob{event: e, pool: p}) {
+			log.Printf("Job queue full, skipping webhooks of a change to %s", e.RepoPath)
+		}
+	})
+}
+
+// webhookRepository describes the repository of an event in payloads
+type webhookRepository struct {
+	FullName      string `json:"full_name"`
+	Owner         string `json:"owner"`
+	Name          string `json:"name"`
+	Description   string `json:"description"`
+	Public        bool   `json:"public"`
+	DefaultBranch string `json:"default_branch"`
+}
+
+// webhookUser describes the user who caused an event
+type webhookUser struct {
+	Username string `json:"username"`
+}
+
+// webhookCommit describes the new head of a pushed ref
+type webhookCommit struct {
+	ID        string    `json:"id"`
+	Message   string    `json:"message"`
+	Author    string    `json:"author"`
+	Email     string    `json:"email"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// pushPayload is the payload of a push event, one per updated ref
+type pushPayload struct {
+	Ref        string            `json:"ref"`
+	Before     string            `json:"before"`
+	After      string            `json:"after"`
+	Created    bool              `json:"created"`
+	Deleted    bool              `json:"deleted"`
+	HeadCommit *webhookCommit    `json:"head_commit"` // Null when deleted
+	Repository webhookRepository `json:"repository"`
+	Pusher     webhookUser       `json:"pusher"`
+}
+
+// refPayload is the payload of create and delete events
+type refPayload struct {
+	Ref        string            `json:"ref"`      // Branch or tag name
+	RefType    string            `json:"ref_type"` // "branch" or "tag"
+	Repository webhookRepository `json:"repository"`
+	Sender     webhookUser       `json:"sender"`
+}
+
+// repositoryPayload is the payload of repository events
+type repositoryPayload struct {
+	Action      string            `json:"action"`
+	OldFullName string            `json:"old_full_name,omitempty"`
+	Repository  webhookRepository `json:"repository"`
+	Sender      webhookUser       `json:"sender"`
+}
+
+// loadWebhookRepository describes a repository for payloads
+func loadWebhookRepository(repoPath string) (webhookRepository, error) {
+	meta, err := git.LoadRepoMeta(repoPath)
+	if err != nil {
+		return webhookRepository{}, err
+	}
+	fullName := git.RepoFullName(repoPath)
+	owner, name, _ := strings.Cut(fullName, "/")
+	return webhookRepository{
+		FullName:      fullName,
+		Owner:         owner,
+		Name:          name,
+		Description:   meta.Description,
+		Public:        meta.Public,
+		DefaultBranch: meta.DefaultBranch,
+	}, nil
+}
+
+// pushWebhooksJob queues the push, create and delete events of a push
+type pushWebhooksJob struct {
+	event PushEvent
+	pool  *Pool
+}
+
+func (j *pushWebhooksJob) Run() error {
+	e := j.event
+	repo, err := loadWebhookRepository(e.RepoPath)
+	if err != nil {
+		return fmt.Errorf("failed to load %s for webhooks: %w", e.RepoPath, err)
+	}
+	pusher := webhookUser{Username: e.Pusher}
+	var errs []error
+	for _, u := range e.Updates {
+		payload := pushPayload{
+			Ref:        u.Ref,
+			Before:     u.OldHash,
+			After:      u.NewHash,
+			Created:    isZeroHash(u.OldHash),
+			Deleted:    isZeroHash(u.NewHash),
+			Repository: repo,
+			Pusher:     pusher,
+		}
+		if !payload.Deleted {
+			// Tags may point to other objects than commits
+			if c, err := git.GetCommitByHash(e.RepoPath, u.NewHash); err == nil {
+				payload.HeadCommit = &webhookCommit{
+					ID:        c.Hash,
+					Message:   c.Message,
+					Author:    c.Author,
+					Email:     c.AuthorEmail,
+					Timestamp: c.AuthoredAt,
+				}
+			}
+		}
+		errs = append(errs, QueueWebhooks(j.pool, e.RepoPath, git.WebhookEventPush, payload))
+
+		refType, name, ok := splitRef(u.Ref)
+		if !ok {
+			continue
+		}
+		ref := refPayload{Ref: name, RefType: refType, Repository: repo, Sender: pusher}
+		if payload.Created {
+			errs = append(errs, QueueWebhooks(j.pool, e.RepoPath, git.WebhookEventCreate, ref))
+		} else if payload.Deleted {
+			errs = append(errs, QueueWebhooks(j.pool, e.RepoPath, git.WebhookEventDelete, ref))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// repoWebhooksJob queues the repository event of a change to a repository
+type repoWebhooksJob struct {
+	event RepoEvent
+	pool  *Pool
+}
+
+func (j *repoWebhooksJob) Run() error {
+	e := j.event
+	repo, err := loadWebhookRepository(e.RepoPath)
+	if err != nil {
+		return fmt.Errorf("failed to load %s for webhooks: %w", e.RepoPath, err)
+	}
+	return QueueWebhooks(j.pool, e.RepoPath, git.WebhookEventRepository, repositoryPayload{
+		Action:      e.Action,
+		OldFullName: e.OldFullName,
+		Repository:  repo,
+		Sender:      webhookUser{Username: e.Sender},
+	})
+}
+
+// splitRef returns the type and short name of a branch or tag
+func splitRef(ref string) (refType, name string, ok bool) {
+	if name, ok := strings.CutPrefix(ref, "refs/heads/"); ok {
+		return "branch", name, true
+	}
+	if name, ok := strings.CutPrefix(ref, "refs/tags/"); ok {
+		return "tag", name, true
+	}
+	return "", "", false
+}
+
+// isZeroHash reports whether a hash of a ref update stands for a missing ref
+func isZeroHash(hash string) bool {
+	return strings.Trim(hash, "0") == ""
+}
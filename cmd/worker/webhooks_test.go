@@ -0,0 +1,113 @@
+package worker
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"librebucket/cmd/db"
+	"librebucket/cmd/git"
+)
+
+// startWebhook adds a webhook to a new repository whose receiver fails the
+// first failures deliveries, and returns the repository, the webhook and the
+// times of the deliveries
+func startWebhook(t *testing.T, owner string, failures int) (string, int64, <-chan time.Time) {
+	t.Helper()
+	prevHosts, prevDelay := git.AllowedLocalHosts, webhookRetryDelay
+	git.AllowedLocalHosts = []string{"127.0.0.1"}
+	webhookRetryDelay = 10 * time.Millisecond
+	t.Cleanup(func() { git.AllowedLocalHosts, webhookRetryDelay = prevHosts, prevDelay })
+
+	var left atomic.Int64
+	left.Store(int64(failures))
+	received := make(chan time.Time, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- time.Now()
+		if left.Add(-1) >= 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	repoPath := filepath.Join("repos", owner, "app.git")
+	if err := git.CreateRepo(repoPath, owner, false); err != nil {
+		t.Fatalf("CreateRepo failed: %v", err)
+	}
+	h, err := git.AddWebhook(repoPath, git.WebhookSettings{URL: &srv.URL})
+	if err != nil {
+		t.Fatalf("AddWebhook failed: %v", err)
+	}
+	return repoPath, h.ID, received
+}
+
+// waitDeliveries returns the times of n deliveries and fails if more arrive
+// within quiet
+func waitDeliveries(t *testing.T, received <-chan time.Time, n int, quiet time.Duration) []time.Time {
+	t.Helper()
+	var times []time.Time
+	for range n {
+		select {
+		case at := <-received:
+			times = append(times, at)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected %d deliveries, got %d", n, len(times))
+		}
+	}
+	select {
+	case <-received:
+		t.Errorf("Expected no more than %d deliveries", n)
+	case <-time.After(quiet):
+	}
+	return times
+}
+
+func TestWebhookJobRetries(t *testing.T) {
+	t.Chdir(t.TempDir())
+	repoPath, id, received := startWebhook(t, "ana", 2)
+	p := NewPool(1, 16)
+	t.Cleanup(p.Stop)
+
+	p.Submit(&WebhookJob{RepoPath: repoPath, HookID: id, Event: "push", GUID: "retries", Payload: []byte("{}"), Attempt: 1, pool: p})
+	times := waitDeliveries(t, received, 3, 100*time.Millisecond)
+
+	// The delay doubles after every failure
+	for i, at := range times[1:] {
+		if delay := webhookRetryDelay << i; at.Sub(times[i]) < delay {
+			t.Errorf("Retry %d came after %s, expected at least %s", i+1, at.Sub(times[i]), delay)
+		}
+	}
+}
+
+func TestWebhookJobMaxAttempts(t *testing.T) {
+	t.Chdir(t.TempDir())
+	repoPath, id, received := startWebhook(t, "ben", webhookMaxAttempts+1)
+	p := NewPool(1, 16)
+	t.Cleanup(p.Stop)
+
+	p.Submit(&WebhookJob{RepoPath: repoPath, HookID: id, Event: "push", GUID: "max", Payload: []byte("{}"), Attempt: 1, pool: p})
+	waitDeliveries(t, received, webhookMaxAttempts, 2*webhookRetryDelay<<webhookMaxAttempts)
+}
+
+func TestWebhookJobNotFound(t *testing.T) {
+	t.Chdir(t.TempDir())
+	repoPath, id, _ := startWebhook(t, "cora", 0)
+	if err := git.RemoveWebhook(repoPath, id); err != nil {
+		t.Fatalf("RemoveWebhook failed: %v", err)
+	}
+
+	// A pool without workers keeps the submitted retries
+	p := &Pool{jobs: make(chan Job, 1)}
+	job := &WebhookJob{RepoPath: repoPath, HookID: id, Event: "push", GUID: "gone", Payload: []byte("{}"), Attempt: 1, pool: p}
+	if err := job.Run(); !errors.Is(err, db.ErrWebhookNotFound) {
+		t.Errorf("Expected ErrWebhookNotFound, got %v", err)
+	}
+	time.Sleep(2 * webhookRetryDelay)
+	if len(p.jobs) != 0 {
+		t.Errorf("Expected no retry of a deleted webhook, got %d", len(p.jobs))
+	}
+}
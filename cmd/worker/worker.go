@@ -38,3 +38,37 @@ func (w *Worker) Start() {
 func (w *Worker) Stop() {
 	w.Quit <- true
 }
+
+// Pool runs jobs on a fixed number of workers
+type Pool struct {
+	jobs    chan Job
+	workers []*Worker
+}
+
+// NewPool starts size workers sharing a queue of up to queueSize pending jobs
+func NewPool(size, queueSize int) *Pool {
+	p := &Pool{jobs: make(chan Job, queueSize)}
+	for i := 1; i <= size; i++ {
+		w := NewWorker(i, p.jobs)
+		w.Start()
+		p.workers = append(p.workers, w)
+	}
+	return p
+}
+
+// Submit queues a job without blocking. It returns false if the queue is full.
+func (p *Pool) Submit(job Job) bool {
+	select {
+	case p.jobs <- job:
+		return true
+	default:
+		return false
+	}
+}
+
+// Stop stops all workers after their current job
+func (p *Pool) Stop() {
+	for _, w := range p.workers {
+		w.Stop()
+	}
+}
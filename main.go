@@ -6,12 +6,18 @@ import (
 	"path/filepath"
 
 	"librebucket/cmd/db"
+	"librebucket/cmd/git"
 	"librebucket/cmd/web"
 )
 
 // main initializes the application's data directory and user database, then starts the web server.
 // It terminates execution with a fatal log if any critical setup step fails.
 func main() {
+	// git receive-pack runs the hooks installed below as "librebucket hook <name>"
+	if len(os.Args) == 3 && os.Args[1] == "hook" {
+		os.Exit(git.RunHook(os.Args[2], os.Stdin, os.Stderr))
+	}
+
 	wd, err := os.Getwd()
 	if err != nil {
 		log.Fatalf("Failed to get working directory: %v", err)
@@ -31,6 +37,33 @@ func main() {
 		return
 	}
 
+	// Secrets such as push mirror credentials are encrypted with this key
+	if err := db.LoadSecretKey(filepath.Join(dataDir, "secret.key")); err != nil {
+		log.Fatalf("Failed to load secret key: %v", err)
+	}
+
+	// Move metadata of repositories created by older versions into the database
+	imported, err := git.ImportRepoMetaFiles()
+	if err != nil {
+		log.Fatalf("Failed to import repository metadata: %v", err)
+	}
+	if imported > 0 {
+		log.Printf("Imported metadata of %d repositories", imported)
+	}
+
+	// Push policies are enforced by a pre-receive hook, and a post-receive hook
+	// records the refs pushes update for push events
+	if err := git.InstallHooks(filepath.Join(dataDir, "hooks")); err != nil {
+		log.Fatalf("Failed to install git hooks: %v", err)
+	}
+
+	// Migration jobs do not survive a restart
+	if failed, err := db.FailInterruptedMigrations(); err != nil {
+		log.Fatalf("Failed to update interrupted migrations: %v", err)
+	} else if failed > 0 {
+		log.Printf("Marked %d interrupted migrations as failed", failed)
+	}
+
 	log.Println("Working dir:", wd)
 	log.Println("DB initialized at:", dbPath)
 